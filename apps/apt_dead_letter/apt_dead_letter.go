@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/util/cli"
+	"github.com/APTrust/preservation-services/workers"
+)
+
+func main() {
+	help := false
+	topic := ""
+	showID := int64(0)
+	replayID := int64(0)
+	replayAll := false
+	wait := 5 * time.Second
+	max := 1000
+	flag.BoolVar(&help, "help", false, "Print help message")
+	flag.StringVar(&topic, "topic", "", "Topic of the worker whose dead letters you want")
+	flag.Int64Var(&showID, "show", 0, "Print the dead letters for this WorkItem ID as JSON")
+	flag.Int64Var(&replayID, "replay", 0, "Replay this WorkItem ID")
+	flag.BoolVar(&replayAll, "replay-all", false, "Replay every WorkItem in the dead-letter topic")
+	flag.DurationVar(&wait, "wait", wait, "How long to wait for more letters")
+	flag.IntVar(&max, "max", max, "Most letters to read")
+	flag.Parse()
+
+	if help {
+		printHelp()
+		os.Exit(0)
+	}
+	if topic == "" {
+		fmt.Fprintln(os.Stderr, "Option --topic is required. Use --help for more info.")
+		os.Exit(1)
+	}
+
+	box := workers.NewDeadLetterBox(common.NewContext(), topic)
+	box.Wait = wait
+	box.MaxInFlight = max
+	err := box.Open()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	exitCode := 0
+	switch {
+	case showID != 0:
+		exitCode = show(box, showID)
+	case replayID != 0:
+		exitCode = replay(box, []int64{replayID})
+	case replayAll:
+		ids := make([]int64, 0)
+		seen := make(map[int64]bool)
+		for _, letter := range box.Letters() {
+			if !seen[letter.WorkItemID] {
+				ids = append(ids, letter.WorkItemID)
+				seen[letter.WorkItemID] = true
+			}
+		}
+		exitCode = replay(box, ids)
+	default:
+		list(box)
+	}
+
+	err = box.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		exitCode = 1
+	}
+	os.Exit(exitCode)
+}
+
+func list(box *workers.DeadLetterBox) {
+	letters := box.Letters()
+	for _, letter := range letters {
+		fmt.Printf("%d\t%s\t%s/%s\t%d attempts\t%s\t%s\t%s\n",
+			letter.WorkItemID,
+			letter.DeadLetteredAt.Format(time.RFC3339),
+			letter.Action,
+			letter.Stage,
+			letter.Attempts,
+			letter.Name,
+			letter.Identifier,
+			letter.Reason)
+	}
+	fmt.Printf("%d dead letters\n", len(letters))
+}
+
+func show(box *workers.DeadLetterBox, workItemID int64) int {
+	letters := box.LettersFor(workItemID)
+	if len(letters) == 0 {
+		fmt.Fprintf(os.Stderr, "No dead letters for WorkItem %d\n", workItemID)
+		return 1
+	}
+	data, err := json.MarshalIndent(letters, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
+
+func replay(box *workers.DeadLetterBox, workItemIDs []int64) int {
+	exitCode := 0
+	for _, id := range workItemIDs {
+		workItem, topic, err := box.Replay(id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot replay WorkItem %d: %v\n", id, err)
+			exitCode = 1
+			continue
+		}
+		fmt.Printf("Replayed WorkItem %d (%s/%s) into %s\n", workItem.ID, workItem.Action, workItem.Stage, topic)
+	}
+	return exitCode
+}
+
+func printHelp() {
+	message := `
+apt_dead_letter lists, inspects and replays the WorkItems that workers
+have given up on. A worker gives up on an item when it hits a fatal
+error, when it fails more than the worker's max attempts, or when the
+WorkItem says not to retry it. The worker then publishes a dead letter
+with the WorkItem ID, stage, attempt count and errors to its dead-letter
+topic, which is the worker's topic plus "_dead_letter".
+
+Replaying a WorkItem sets its status to Pending and retry to true in
+Registry, then pushes it into the topic for its current action and
+stage. Make sure whatever caused the failure has been fixed first. Note
+that ingests that failed with fatal errors may already have gone
+through cleanup, in which case they must be resubmitted instead.
+
+Letters you don't replay stay in the dead-letter topic.
+
+Options:
+
+  --topic=<topic>    Required. The topic of the worker whose dead letters
+                     you want, e.g. ingest02_bag_validation or restore_file.
+
+  --show=<id>        Print the dead letters for WorkItem <id> as JSON.
+
+  --replay=<id>      Replay WorkItem <id>.
+
+  --replay-all       Replay every WorkItem in the dead-letter topic.
+
+  --wait=<duration>  How long to wait for more letters before deciding
+                     we've read them all. Default is 5s.
+
+  --max=<n>          Read at most this many letters. Default is 1000.
+
+With no --show or --replay option, this lists the dead letters, one per
+line: WorkItem ID, time, action/stage, attempts, name, identifier and
+reason.
+
+Examples:
+
+  $ apt_dead_letter --topic=ingest02_bag_validation
+  $ apt_dead_letter --topic=restore_file --show=1234
+  $ apt_dead_letter --topic=restore_file --replay=1234
+`
+	fmt.Println(message)
+	fmt.Println(cli.EnvMessage)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/deletion"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/util/cli"
+)
+
+func main() {
+	help := false
+	objectID := int64(0)
+	fileID := int64(0)
+	asJSON := false
+	flag.BoolVar(&help, "help", false, "Print help message")
+	flag.Int64Var(&objectID, "object-id", 0, "ID of the IntellectualObject to report on")
+	flag.Int64Var(&fileID, "file-id", 0, "ID of the GenericFile to report on")
+	flag.BoolVar(&asJSON, "json", false, "Print the full report as JSON")
+	flag.Parse()
+
+	if help {
+		printHelp()
+		os.Exit(0)
+	}
+	if (objectID == 0) == (fileID == 0) {
+		fmt.Fprintln(os.Stderr, "Specify either --object-id or --file-id. Use --help for more info.")
+		os.Exit(1)
+	}
+
+	id, itemType := objectID, constants.TypeObject
+	if fileID != 0 {
+		id, itemType = fileID, constants.TypeFile
+	}
+	manager := deletion.NewManager(common.NewContext(), 0, id, itemType, "", "", "")
+	manager.DryRun = true
+	_, errors := manager.Run()
+	for _, err := range errors {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+	if manager.Report == nil {
+		os.Exit(1)
+	}
+
+	if asJSON {
+		data, err := manager.Report.ToJSON()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(data)
+	} else {
+		for _, file := range manager.Report.Files {
+			fmt.Printf("%s (%d bytes, %s)\n", file.Identifier, file.Size, file.StorageOption)
+			for _, c := range file.Copies {
+				status := "ok"
+				if c.Error != "" {
+					status = "error: " + c.Error
+				} else if !c.Exists {
+					status = "MISSING"
+				}
+				fmt.Printf("    %s %s/%s %d bytes %s\n", c.URL, c.Provider, c.Bucket, c.Size, status)
+			}
+		}
+		fmt.Println(manager.Report.Summary())
+	}
+	if len(errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+func printHelp() {
+	message := `
+apt_delete_dry_run reports what deleting an object or file would remove
+from preservation storage, without deleting anything. It lists each
+file's preservation copies, with their URLs, buckets and sizes, checks
+that each copy exists in S3 and prints totals by storage option. It
+also reports any legal hold or retention policy that would block the
+deletion.
+
+To get the same report through the deletion worker, queue a WorkItem
+with the action "Deletion Dry Run". Like a deletion, it needs an
+institutional approver. The summary goes in the WorkItem note.
+
+Options:
+
+  --object-id=<id>   ID of the IntellectualObject to report on.
+
+  --file-id=<id>     ID of the GenericFile to report on.
+
+  --json             Print the full report as JSON.
+
+Exit codes: 0 means the report is complete, and 1 means some or all
+of it could not be produced.
+
+Example:
+
+  $ apt_delete_dry_run --object-id=1234 --json
+`
+	fmt.Println(message)
+	fmt.Println(cli.EnvMessage)
+}
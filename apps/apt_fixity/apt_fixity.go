@@ -28,7 +28,7 @@ func main() {
 	// This channel blocks until we get an interrupt,
 	// so our program does not exit without Control-C
 	// or other kill signal.
-	<-worker.NSQConsumer.StopChan
+	<-worker.NSQConsumer.StopChan()
 }
 
 func printHelp() {
@@ -37,6 +37,11 @@ apt_fixity runs as a service to calculate fixity on items in preservation
 storage. It reads generic file identifiers from the NSQ fixity queue,
 calculates fixity on a single copy of a file in S3 (or non-Glacier) storage,
 and records a PREMIS event with the result in the Registry.
+
+apt_fixity has no admin or health server, so ADMIN_SERVER_PORT and
+HEALTH_SERVER_PORT don't apply, and it doesn't dead-letter failed
+checks. Stop it with SIGTERM. apt_queue_fixity queues a file again
+when its next check is due.
 `
 	fmt.Println(message)
 	fmt.Println(cli.EnvMessage)
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/APTrust/preservation-services/util/cli"
+	"github.com/APTrust/preservation-services/workers"
+)
+
+func main() {
+	help := false
+	nsqHandoff := false
+	flag.BoolVar(&help, "help", false, "Print help message")
+	flag.BoolVar(&nsqHandoff, "nsq-handoff", false, "Pass items between stages through NSQ instead of in process")
+	flag.Parse()
+
+	if help {
+		printHelp()
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	// If anything goes wrong, this panics.
+	// Otherwise, it starts handling messages immediately.
+	all := workers.NewIngestAll(nsqHandoff)
+
+	// This blocks until we get an interrupt,
+	// so our program does not exit without Control-C
+	// or other kill signal.
+	all.Wait()
+}
+
+func printHelp() {
+	message := `
+apt_ingest_all runs every stage of ingest, from ingest_pre_fetch through
+ingest_cleanup, in a single process. It's meant for small deployments and
+for development, where running nine separate ingest services is overkill.
+
+Each stage uses the number of workers, buffer size and max attempts set
+for it in the .env file, e.g. INGEST_PRE_FETCH_WORKERS, and updates
+WorkItems in Registry exactly as the standalone services do.
+
+By default, items pass from one stage to the next in process, without a
+round trip through NSQ. Each stage still reads its NSQ topic, so items
+queued by ingest_bucket_reader, apt_dead_letter and other services are
+processed as usual. Items waiting between stages are lost if the process
+exits, and must be requeued. Use --nsq-handoff to pass items through NSQ
+instead. If QUEUE_BACKEND is memory, nothing goes through NSQ.
+
+Don't run this alongside the standalone ingest services unless you use
+--nsq-handoff, or items handed off in process will bypass them.
+
+Options:
+
+  --nsq-handoff   Pass items between stages through NSQ.
+`
+	fmt.Println(message)
+	fmt.Println(cli.EnvMessage)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/APTrust/preservation-services/inventory"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/util/cli"
+)
+
+func main() {
+	help := false
+	bucketName := ""
+	inventoryDir := ""
+	format := ""
+	alertURL := ""
+	minAge := inventory.DefaultMinAge
+	flag.BoolVar(&help, "help", false, "Print help message")
+	flag.StringVar(&bucketName, "bucket", "", "Reconcile only this preservation bucket")
+	flag.StringVar(&inventoryDir, "inventory-dir", "", "Read S3 Inventory reports from this directory instead of listing buckets")
+	flag.StringVar(&format, "format", "csv", "Report format: csv or json")
+	flag.StringVar(&alertURL, "alert-url", "", "POST a summary to this URL if discrepancies are found")
+	flag.DurationVar(&minAge, "min-age", inventory.DefaultMinAge, "Ignore objects and records newer than this")
+	flag.Parse()
+
+	if help {
+		printHelp()
+		os.Exit(0)
+	}
+	if format != "csv" && format != "json" {
+		fmt.Fprintln(os.Stderr, "Format must be csv or json")
+		os.Exit(1)
+	}
+
+	context := common.NewContext()
+	buckets := bucketsToReconcile(context, bucketName)
+
+	var lister inventory.ObjectLister = inventory.NewS3Lister(context)
+	if inventoryDir != "" {
+		lister = inventory.NewInventoryLister(inventoryDir)
+	}
+
+	reconciler := inventory.NewReconciler(context, lister, minAge)
+	report := reconciler.Run(buckets)
+	context.Logger.Info(report.Summary())
+
+	if format == "json" {
+		jsonBytes, err := report.ToJSON()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonBytes))
+	} else {
+		err := report.WriteCSV(os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if report.HasProblems() {
+		if alertURL != "" {
+			err := report.SendAlert(alertURL)
+			if err != nil {
+				context.Logger.Errorf("Error sending alert: %v", err)
+			}
+		}
+		os.Exit(2)
+	}
+}
+
+func bucketsToReconcile(context *common.Context, bucketName string) []*common.PreservationBucket {
+	if bucketName == "" {
+		return context.Config.PreservationBuckets
+	}
+	for _, bucket := range context.Config.PreservationBuckets {
+		if bucket.Bucket == bucketName {
+			return []*common.PreservationBucket{bucket}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s is not a preservation bucket\n", bucketName)
+	os.Exit(1)
+	return nil
+}
+
+func printHelp() {
+	message := `
+apt_inventory reconciles the contents of preservation buckets against
+the StorageRecords in Registry. It reports:
+
+  orphans         - objects in a preservation bucket that have no
+                    StorageRecord in Registry
+  ghosts          - StorageRecords that point to objects that do not
+                    exist in preservation storage
+  size_mismatches - objects whose size differs from the size of their
+                    GenericFile in Registry
+
+The report goes to stdout in CSV format (default) or JSON. Log messages
+go to the log file specified in the config.
+
+Options:
+
+  --bucket=<name>         Reconcile only this preservation bucket. By
+                          default, all preservation buckets are checked.
+
+  --inventory-dir=<dir>   Read S3 Inventory reports from <dir> instead of
+                          listing buckets through the S3 API. This is much
+                          faster for large buckets. <dir> should contain
+                          one subdirectory per bucket, named after the
+                          bucket, containing manifest.json and the
+                          report's data files (gzipped CSV or Parquet).
+                          ORC reports are rejected with an error, so
+                          configure the bucket's S3 Inventory to write
+                          CSV or Parquet.
+
+  --format=<csv|json>     Report format. Default is csv.
+
+  --min-age=<duration>    Ignore objects and GenericFiles created within
+                          this duration of the bucket listing, since they
+                          may belong to ingests still in progress.
+                          Default is 24h.
+
+  --alert-url=<url>       If discrepancies are found, POST a JSON summary
+                          to this URL. The summary includes a "text" field,
+                          so a Slack webhook URL works here.
+
+Exit codes: 0 means no discrepancies, 1 means the reconciler could not
+run, and 2 means discrepancies were found or a bucket could not be
+reconciled.
+
+Example:
+
+  $ apt_inventory --bucket=aptrust.preservation.storage --format=json > report.json
+`
+	fmt.Println(message)
+	fmt.Println(cli.EnvMessage)
+}
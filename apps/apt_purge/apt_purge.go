@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/APTrust/preservation-services/util/cli"
+	"github.com/APTrust/preservation-services/workers"
+)
+
+func main() {
+	help := false
+	runOnce := false
+	flag.BoolVar(&help, "help", false, "Print help message")
+	flag.BoolVar(&runOnce, "run-once", false, "Run once and exit (cron mode instead of server mode)")
+	flag.Parse()
+
+	if help {
+		printHelp()
+		os.Exit(0)
+	}
+
+	purger := workers.NewPurger()
+
+	if runOnce {
+		purger.RunOnce()
+	} else {
+		stopChan := make(chan struct{})
+		purger.RunAsService()
+		<-stopChan
+	}
+}
+
+func printHelp() {
+	message := `
+apt_purge finishes deletions that apt_delete has marked pending deletion.
+
+When DELETION_RETENTION_DAYS is greater than zero, apt_delete does not
+remove files from preservation storage right away. It tags each copy,
+records a deaccession event, marks the files (and object) pending
+deletion, and leaves the WorkItem in the "Awaiting Purge" stage.
+
+apt_purge looks for those WorkItems. Once a WorkItem's retention period
+has passed, it removes the files from preservation storage and marks
+them deleted. If an admin sets the WorkItem's status to Cancelled before
+then, apt_purge puts the files back in active state instead.
+
+If apt_purge dies while purging, the WorkItem stays Started. Once it has
+been Started for longer than PURGE_STALE_AFTER, the next scan purges it
+again. Purging is safe to repeat.
+
+When running as a service (i.e. without --run-once), this relies on the
+config setting PURGE_INTERVAL to determine how long to wait after the
+end of one scan before beginning the next.
+
+You can also run this as a one-off job with the --run-once flag. It will
+perform one scan and then exit.
+`
+	fmt.Println(message)
+	fmt.Println(cli.EnvMessage)
+}
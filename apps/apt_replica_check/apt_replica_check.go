@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/APTrust/preservation-services/inventory"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/util/cli"
+)
+
+func main() {
+	help := false
+	storageOption := ""
+	institutionID := int64(0)
+	flag.BoolVar(&help, "help", false, "Print help message")
+	flag.StringVar(&storageOption, "storage-option", "", "Check only files with this storage option")
+	flag.Int64Var(&institutionID, "institution-id", 0, "Check only files belonging to this institution")
+	flag.Parse()
+
+	if help {
+		printHelp()
+		os.Exit(0)
+	}
+
+	context := common.NewContext()
+	if storageOption != "" && len(context.Config.PreservationBucketsFor(storageOption)) == 0 {
+		fmt.Fprintf(os.Stderr, "No preservation buckets for storage option %s\n", storageOption)
+		os.Exit(1)
+	}
+
+	checker := inventory.NewReplicaChecker(context, storageOption, institutionID)
+	report := checker.Run()
+	jsonBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	fmt.Println(string(jsonBytes))
+	if report.Error != "" || len(report.Problems) > 0 {
+		os.Exit(2)
+	}
+}
+
+func printHelp() {
+	message := `
+apt_replica_check verifies that every active GenericFile has one
+StorageRecord in each preservation bucket required by its storage
+option. For example, a Standard file should have a copy in both the
+Standard VA and Standard OR buckets, while a Glacier-Deep-OH file should
+have exactly one copy in the Glacier Deep OH bucket.
+
+It reports under-replicated files (missing required copies) and
+over-replicated files (copies in buckets their storage option does not
+call for, or duplicate copies in one bucket). The report goes to stdout
+in JSON format. It does not repair anything. Restore missing copies
+from a good copy by hand.
+
+Options:
+
+  --storage-option=<option>  Check only files with this storage option.
+
+  --institution-id=<id>      Check only files belonging to this
+                             institution.
+
+Exit codes: 0 means all files are correctly replicated, 1 means the
+checker could not run, and 2 means problems were found or the check did
+not complete.
+
+Example:
+
+  $ apt_replica_check --storage-option=Standard > replicas.json
+`
+	fmt.Println(message)
+	fmt.Println(cli.EnvMessage)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/APTrust/preservation-services/util/cli"
+	"github.com/APTrust/preservation-services/workers"
+)
+
+func main() {
+	help := false
+	runOnce := false
+	flag.BoolVar(&help, "help", false, "Print help message")
+	flag.BoolVar(&runOnce, "run-once", false, "Run once and exit (cron mode instead of server mode)")
+	flag.Parse()
+
+	if help {
+		printHelp()
+		os.Exit(0)
+	}
+
+	syncer := workers.NewObjectLockSyncer()
+
+	if runOnce {
+		syncer.RunOnce()
+	} else {
+		stopChan := make(chan struct{})
+		syncer.RunAsService()
+		<-stopChan
+	}
+}
+
+func printHelp() {
+	message := `
+apt_sync_object_lock applies the legal holds and retention settings in
+Registry to the S3 Object Lock on preservation copies.
+
+When OBJECT_LOCK_RETENTION_DAYS is greater than zero, preservation copies
+are locked in governance mode at ingest. Registry records legal holds on
+objects and institutions, an object's retain_until date, and an
+institution's retention_days. apt_sync_object_lock looks for objects and
+institutions whose settings changed, and for each affected object, turns
+S3 legal hold on or off and extends S3 retention on every copy to match.
+It never shortens S3 retention.
+
+Each scan looks at changes from the last two OBJECT_LOCK_SYNC_INTERVALs.
+When running as a service (i.e. without --run-once), it waits one
+interval between scans. In cron mode, schedule it at least once per
+interval.
+`
+	fmt.Println(message)
+	fmt.Println(cli.EnvMessage)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/restoration"
+)
+
+func main() {
+	help := false
+	receiptPath := ""
+	bagPath := ""
+	publicKey := ""
+	flag.BoolVar(&help, "help", false, "Print help message")
+	flag.StringVar(&receiptPath, "receipt", "", "Path to the restoration receipt")
+	flag.StringVar(&bagPath, "bag", "", "Path to the restored bag")
+	flag.StringVar(&publicKey, "public-key", "", "Base64-encoded Ed25519 public key of the signer")
+	flag.Parse()
+
+	if help {
+		printHelp()
+		os.Exit(0)
+	}
+	if receiptPath == "" || bagPath == "" || publicKey == "" {
+		fmt.Fprintln(os.Stderr, "Params --receipt, --bag and --public-key are required. Use --help for more info.")
+		os.Exit(1)
+	}
+
+	key, err := restoration.ParseReceiptPublicKey(publicKey)
+	exitOnError(err)
+	data, err := os.ReadFile(receiptPath)
+	exitOnError(err)
+	signed, err := service.SignedRestorationReceiptFromJSON(data)
+	exitOnError(err)
+
+	receipt, err := signed.Verify(key)
+	if err != nil {
+		fmt.Printf("FAILED: %s: %v\n", receiptPath, err)
+		os.Exit(2)
+	}
+	fmt.Printf("Receipt signature is valid. %s has %d files, restored %s.\n", receipt.Identifier, len(receipt.Files), receipt.CompletedAt.Format("2006-01-02T15:04:05Z"))
+
+	problems, err := restoration.VerifyRestoredBag(receipt, bagPath)
+	exitOnError(err)
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		fmt.Printf("FAILED: %s does not match the receipt (%d problems)\n", bagPath, len(problems))
+		os.Exit(2)
+	}
+	fmt.Printf("OK: all files in %s match the receipt\n", bagPath)
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func printHelp() {
+	message := `
+apt_verify_receipt checks a restored bag against the signed receipt
+the bag restorer wrote next to it. It verifies the receipt's Ed25519
+signature, then checks that the bag contains exactly the files listed
+in the receipt, with the same sizes and digests.
+
+Options:
+
+  --receipt=<path>     Path to the receipt, usually <bag>.receipt.json.
+
+  --bag=<path>         Path to the restored bag. This can be a tar,
+                       tar.gz or zip file, or the directory of a loose
+                       bag.
+
+  --public-key=<key>   Base64-encoded Ed25519 public key for the
+                       environment that restored the bag.
+
+Exit codes: 0 means the receipt and bag are valid, 1 means the check
+could not run, and 2 means the signature is invalid or the bag does not
+match the receipt.
+
+Example:
+
+  $ apt_verify_receipt --receipt=bag.tar.receipt.json --bag=bag.tar \
+      --public-key=<environment's base64-encoded public key>
+`
+	fmt.Println(message)
+}
@@ -28,7 +28,7 @@ func main() {
 	// This channel blocks until we get an interrupt,
 	// so our program does not exit without Control-C
 	// or other kill signal.
-	<-worker.NSQConsumer.StopChan
+	<-worker.NSQConsumer.StopChan()
 }
 
 func printHelp() {
@@ -9,6 +9,7 @@ const (
 	AccessInstitution          = "institution"
 	AccessRestricted           = "restricted"
 	ActionDelete               = "Delete"
+	ActionDeletionDryRun       = "Deletion Dry Run"
 	ActionFixityCheck          = "Fixity Check"
 	ActionGlacierRestore       = "Glacier Restore"
 	ActionIngest               = "Ingest"
@@ -24,6 +25,8 @@ const (
 	BagItProfileDefault        = "aptrust-v2.3.json"
 	BagRestorer                = "bag_restorer"
 	BTRProfileIdentifier       = "https://github.com/dpscollaborative/btr_bagit_profile/releases/download/1.0/btr-bagit-profile.json"
+	DeadLetterChannel          = "dead_letter_tool"
+	DeadLetterTopicSuffix      = "_dead_letter"
 	DefaultAccess              = AccessInstitution
 	DefaultProfileIdentifier   = "https://raw.githubusercontent.com/APTrust/preservation-services/master/profiles/aptrust-v2.3.json"
 	Deleter                    = "deleter"
@@ -43,6 +46,7 @@ const (
 	EventMigration             = "migration"
 	EventNormalization         = "normalization"
 	EventReplication           = "replication"
+	EventRestoration           = "restoration"
 	EventSignatureValidation   = "digital signature validation"
 	EventValidation            = "validation"
 	EventVirusCheck            = "virus check"
@@ -75,6 +79,7 @@ const (
 	NarrowNonBreakingSpace     = " "
 	OutcomeFailure             = "Failure"
 	OutcomeSuccess             = "Success"
+	PriorityTopicSuffix        = "_priority"
 	RegionAWSUSEast1           = "us-east-1"    // AWS Virginia
 	RegionAWSUSEast2           = "us-east-2"    // AWS Ohio
 	RegionAWSUSWest1           = "us-west-1"    // AWS California
@@ -84,16 +89,23 @@ const (
 	RegionWasabiUSEast2        = "us-east-2"    // Wasabi Virginia (2)
 	RegionWasabiUSWest1        = "us-west-1"    // Wasabi Oregon
 	RestorationBaggingSoftware = "APTrust preservation-services restoration bagger"
+	RestorationFormatLoose     = "loose"
+	RestorationFormatTar       = "tar"
+	RestorationFormatTarGz     = "tar.gz"
+	RestorationFormatZip       = "zip"
 	RestorationSourceGlacier   = "glacier"
 	RestorationSourceS3        = "s3"
 	RestorationTypeFile        = "file"
 	RestorationTypeObject      = "object"
+	RestorationTypePartial     = "partial"
 	S3ClientName               = "https://github.com/minio/minio-go v7"
 	SourceIngest               = "ingest"
 	SourceManifest             = "manifest"
 	SourceRegistry             = "registry"
 	SourceTagManifest          = "tag_manifest"
 	StageAvailableInS3         = "Available in S3"
+	StageAwaitingGlacier       = "Awaiting Glacier Restore"
+	StageAwaitingPurge         = "Awaiting Purge"
 	StageCleanup               = "Cleanup"
 	StageCopyToStaging         = "Copy To Staging"
 	StageFormatIdentification  = "Format Identification"
@@ -111,6 +123,7 @@ const (
 	StageValidate              = "Validate"
 	StateActive                = "A"
 	StateDeleted               = "D"
+	StatePendingDeletion       = "P"
 	StatusCancelled            = "Cancelled"
 	StatusFailed               = "Failed"
 	StatusPending              = "Pending"
@@ -197,6 +210,16 @@ var BTRRestorationAlgorithms []string = []string{
 	AlgSha512,
 }
 
+// RestorationFormats lists the ways we can package a restored bag.
+// Tar is the default. Loose means each file in the bag is written as
+// a separate object in the restoration bucket.
+var RestorationFormats = []string{
+	RestorationFormatLoose,
+	RestorationFormatTar,
+	RestorationFormatTarGz,
+	RestorationFormatZip,
+}
+
 var StorageProviders = []string{
 	StorageProviderAWS,
 	StorageProviderLocal,
@@ -268,7 +291,7 @@ func TopicFor(action, stage, fileIdentifier string) (topic string, err error) {
 		topic = TopicObjectRestore
 	} else if action == ActionGlacierRestore {
 		topic = TopicGlacierRestore
-	} else if action == ActionDelete {
+	} else if action == ActionDelete || action == ActionDeletionDryRun {
 		topic = TopicDelete
 	}
 	if topic == "" {
@@ -277,6 +300,21 @@ func TopicFor(action, stage, fileIdentifier string) (topic string, err error) {
 	return topic, err
 }
 
+// DeadLetterTopicFor returns the name of the dead-letter topic for
+// the worker that consumes topic. Workers publish items they've given
+// up on to this topic, so apt_dead_letter can list and replay them.
+func DeadLetterTopicFor(topic string) string {
+	return topic + DeadLetterTopicSuffix
+}
+
+// PriorityTopicFor returns the name of the priority topic for the
+// worker that consumes topic. Items with a priority above zero go to
+// this topic instead, and workers process them ahead of the items in
+// topic.
+func PriorityTopicFor(topic string) string {
+	return topic + PriorityTopicSuffix
+}
+
 func IngestStageFor(topic string) (stage string, err error) {
 	for _, s := range IngestStages {
 		if s.NSQTopic == topic {
@@ -101,6 +101,12 @@ var items = []Item{
 		FileIdentifier: "test.edu/bag/data/file.txt",
 		Expected:       constants.TopicDelete,
 	},
+	Item{
+		Action:         constants.ActionDeletionDryRun,
+		Stage:          "",
+		FileIdentifier: "",
+		Expected:       constants.TopicDelete,
+	},
 }
 
 func TestTopicFor(t *testing.T) {
@@ -154,3 +160,13 @@ func TestIngestStageFor(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, constants.StageCleanup, stage)
 }
+
+func TestDeadLetterTopicFor(t *testing.T) {
+	assert.Equal(t, "ingest01_prefetch_dead_letter", constants.DeadLetterTopicFor(constants.IngestPreFetch))
+	assert.Equal(t, "delete_item_dead_letter", constants.DeadLetterTopicFor(constants.TopicDelete))
+}
+
+func TestPriorityTopicFor(t *testing.T) {
+	assert.Equal(t, "restore_file_priority", constants.PriorityTopicFor(constants.TopicFileRestore))
+	assert.Equal(t, "ingest01_prefetch_priority", constants.PriorityTopicFor(constants.IngestPreFetch))
+}
@@ -0,0 +1,186 @@
+package deletion
+
+import (
+	"bytes"
+	ctx "context"
+	"fmt"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/minio/minio-go/v7"
+)
+
+// recordDeletedCopy notes that we deleted versions versions of the copy
+// of gf in bucket, so the verification pass can confirm it's gone. We
+// save the record to Redis, so it survives if this deletion takes more
+// than one attempt.
+func (m *Manager) recordDeletedCopy(gf *registry.GenericFile, bucket *common.PreservationBucket, key string, versions int) error {
+	f := m.deletedFiles[gf.Identifier]
+	if f == nil {
+		f = &service.DeletedFile{
+			Identifier:    gf.Identifier,
+			GenericFileID: gf.ID,
+		}
+		m.deletedFiles[gf.Identifier] = f
+	}
+	f.AddCopy(bucket.URLFor(key), time.Now().UTC(), versions)
+	return m.saveDeletedFile(f)
+}
+
+func (m *Manager) saveDeletedFile(f *service.DeletedFile) error {
+	if m.Context.RedisClient == nil || m.WorkItemID == 0 {
+		return nil
+	}
+	return m.Context.RedisClient.DeletedFileSave(m.WorkItemID, f)
+}
+
+// loadDeletedFiles adds the records of copies deleted on earlier attempts
+// to those deleted on this one.
+func (m *Manager) loadDeletedFiles() error {
+	if m.Context.RedisClient == nil || m.WorkItemID == 0 {
+		return nil
+	}
+	files, err := m.Context.RedisClient.DeletedFileList(m.WorkItemID)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if m.deletedFiles[f.Identifier] == nil {
+			m.deletedFiles[f.Identifier] = f
+		}
+	}
+	return nil
+}
+
+// verifyDeletions checks that no version of any copy we've deleted
+// remains. Some providers, like Wasabi, are eventually consistent, so
+// a deleted copy may linger for a while. We check each copy once and
+// delete lingering versions again, but we don't wait around for them
+// to go. This returns a non-fatal error for each copy that's still
+// there, so the deletion will be retried and the copy checked again.
+// Once a copy has been deleted again more than VerifyAttempts times, the
+// error is fatal.
+func (m *Manager) verifyDeletions() (errors []*service.ProcessingError) {
+	err := m.loadDeletedFiles()
+	if err != nil {
+		return append(errors, m.Error(m.itemIdentifier, err, false))
+	}
+	for _, f := range m.deletedFiles {
+		if f.IsVerified() {
+			continue
+		}
+		for _, c := range f.Copies {
+			if !c.VerifiedAt.IsZero() {
+				continue
+			}
+			err := m.verifyCopy(c)
+			if err != nil {
+				errors = append(errors, m.Error(f.Identifier, err, c.Attempts-1 > m.VerifyAttempts))
+			}
+		}
+		err := m.saveDeletedFile(f)
+		if err != nil {
+			errors = append(errors, m.Error(f.Identifier, err, false))
+		}
+	}
+	return errors
+}
+
+// verifyCopy confirms that no version of the deleted copy c remains,
+// deleting any that do. It returns an error if the copy wasn't gone.
+func (m *Manager) verifyCopy(c *service.DeletedCopy) error {
+	bucket, key, err := m.Context.Config.BucketAndKeyFor(c.URL)
+	if err != nil {
+		return err
+	}
+	client := m.Context.S3Clients[bucket.Bucket]
+	if client == nil {
+		return fmt.Errorf("No S3 client for provider %s", bucket.Provider)
+	}
+	versions, err := listVersions(client, bucket.Bucket, key)
+	if err != nil {
+		return fmt.Errorf("Cannot verify deletion of %s: %v", c.URL, err)
+	}
+	if len(versions) == 0 {
+		c.VerifiedAt = time.Now().UTC()
+		return nil
+	}
+	m.Context.Logger.Warningf("Deleted item %s still has %d versions. Deleting them again.", c.URL, len(versions))
+	c.Attempts++
+	deleted, err := m.deleteFromPreservationStorage(bucket, key)
+	c.Versions += deleted
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("Deleted item %s still existed after %d deletions. Will check again on the next attempt.", c.URL, c.Attempts-1)
+}
+
+// saveCertificate writes a DeletionCertificate listing every copy this
+// deletion removed to Config.DeletionCertificateBucket, then clears the
+// records of deleted copies from Redis. It does nothing if the bucket
+// is not configured.
+func (m *Manager) saveCertificate() error {
+	certBucket := m.Context.Config.DeletionCertificateBucket
+	if certBucket == "" || m.WorkItemID == 0 {
+		m.Context.Logger.Infof("Not saving deletion certificate for %s: no certificate bucket or WorkItem", m.itemIdentifier)
+		return nil
+	}
+	identifier, err := m.identifier()
+	if err != nil {
+		return err
+	}
+	cert := &service.DeletionCertificate{
+		WorkItemID:      m.WorkItemID,
+		Identifier:      identifier,
+		ItemType:        m.ItemType,
+		RequestedBy:     m.RequestedBy,
+		InstApprover:    m.InstApprover,
+		APTrustApprover: m.APTrustApprover,
+		CompletedAt:     time.Now().UTC(),
+		Files:           make([]*service.DeletedFile, 0, len(m.deletedFiles)),
+	}
+	for _, f := range m.deletedFiles {
+		cert.Files = append(cert.Files, f)
+	}
+	cert.SortFiles()
+	data, err := cert.ToJSON()
+	if err != nil {
+		return err
+	}
+	client := m.Context.S3Clients[constants.StorageProviderAWS]
+	if client == nil {
+		return fmt.Errorf("No S3 client for provider %s", constants.StorageProviderAWS)
+	}
+	key := CertificateKey(identifier, m.WorkItemID)
+	_, err = client.PutObject(
+		ctx.Background(),
+		certBucket,
+		key,
+		bytes.NewReader(data),
+		int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/json"},
+	)
+	if err != nil {
+		return err
+	}
+	m.CertificateURL = fmt.Sprintf("s3://%s/%s", certBucket, key)
+	m.Context.Logger.Infof("Saved deletion certificate for %s to %s", identifier, m.CertificateURL)
+	if m.Context.RedisClient != nil {
+		for _, f := range m.deletedFiles {
+			err = m.Context.RedisClient.DeletedFileDelete(m.WorkItemID, f.Identifier)
+			if err != nil {
+				m.Context.Logger.Warningf("Could not clear deletion record for %s from Redis: %v", f.Identifier, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CertificateKey returns the S3 key of the deletion certificate for the
+// WorkItem that deleted the object or file with the specified identifier.
+func CertificateKey(identifier string, workItemID int64) string {
+	return fmt.Sprintf("%s/deletion-%d.json", identifier, workItemID)
+}
@@ -0,0 +1,125 @@
+package deletion
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/minio/minio-go/v7"
+)
+
+// dryRun builds a DeletionReport listing every file Run would delete,
+// with the URL, bucket and size of each of its preservation copies. It
+// checks that each copy exists in S3, but it changes nothing in storage
+// or Registry and records no PREMIS events.
+func (m *Manager) dryRun() (count int, errors []*service.ProcessingError) {
+	identifier, err := m.identifier()
+	if err != nil {
+		return 0, append(errors, m.Error(m.itemIdentifier, err, false))
+	}
+	report := service.NewDeletionReport(identifier, m.WorkItemID)
+	err = m.CheckPolicy()
+	if _, blocked := err.(*PolicyError); blocked {
+		report.Blocked = err.Error()
+	} else if err != nil {
+		return 0, append(errors, m.Error(m.itemIdentifier, err, false))
+	}
+	errors = m.listFiles(constants.StateActive, func(gf *registry.GenericFile) []*service.ProcessingError {
+		file := &service.DeletionReportFile{
+			Identifier:    gf.Identifier,
+			Size:          gf.Size,
+			StorageOption: gf.StorageOption,
+			Copies:        make([]*service.DeletionReportCopy, 0),
+		}
+		errs := m.forEachCopy(gf, func(bucket *common.PreservationBucket, key string) error {
+			file.Copies = append(file.Copies, m.statCopy(bucket, key))
+			return nil
+		})
+		if len(errs) == 0 {
+			report.AddFile(file)
+		}
+		return errs
+	})
+	m.Report = report
+	return len(report.Files), errors
+}
+
+// statCopy asks S3 whether the copy of a file in bucket exists.
+func (m *Manager) statCopy(bucket *common.PreservationBucket, key string) *service.DeletionReportCopy {
+	c := &service.DeletionReportCopy{
+		URL:          bucket.URLFor(key),
+		Provider:     bucket.Provider,
+		Bucket:       bucket.Bucket,
+		StorageClass: bucket.StorageClass,
+	}
+	info, err := m.Context.S3StatObject(bucket.Provider, bucket.Bucket, key)
+	if err == nil {
+		c.Exists = true
+		c.Size = info.Size
+	} else if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// listFiles calls fn for the file we're deleting, or for each of the
+// object's files in state. Unlike forEachFile, it pages through the
+// file list, because fn doesn't change the files' state.
+func (m *Manager) listFiles(state string, fn func(*registry.GenericFile) []*service.ProcessingError) (errors []*service.ProcessingError) {
+	if m.ItemType == constants.TypeFile {
+		resp := m.Context.RegistryClient.GenericFileByID(m.ObjOrFileID)
+		if resp.Error != nil {
+			return append(errors, m.Error(m.itemIdentifier, resp.Error, false))
+		}
+		gf := resp.GenericFile()
+		if gf == nil {
+			return append(errors, m.Error(m.itemIdentifier, fmt.Errorf("Cannot find GenericFile with id %d", m.ObjOrFileID), false))
+		}
+		return fn(gf)
+	}
+	params := url.Values{}
+	params.Set("intellectual_object_id", strconv.FormatInt(m.ObjOrFileID, 10))
+	params.Set("page", "1")
+	params.Set("state", state)
+	params.Set("per_page", "200")
+	for {
+		resp := m.Context.RegistryClient.GenericFileList(params)
+		if resp.Error != nil {
+			return append(errors, m.Error(m.itemIdentifier, resp.Error, false))
+		}
+		for _, gf := range resp.GenericFiles() {
+			errors = append(errors, fn(gf)...)
+		}
+		if !resp.HasNextPage() {
+			break
+		}
+		params = resp.ParamsForNextPage()
+	}
+	return errors
+}
+
+// identifier returns the identifier of the object or file we're deleting.
+func (m *Manager) identifier() (string, error) {
+	if m.ItemType == constants.TypeFile {
+		resp := m.Context.RegistryClient.GenericFileByID(m.ObjOrFileID)
+		if resp.Error != nil {
+			return "", resp.Error
+		}
+		if gf := resp.GenericFile(); gf != nil {
+			return gf.Identifier, nil
+		}
+		return "", fmt.Errorf("Cannot find GenericFile with id %d", m.ObjOrFileID)
+	}
+	resp := m.Context.RegistryClient.IntellectualObjectByID(m.ObjOrFileID)
+	if resp.Error != nil {
+		return "", resp.Error
+	}
+	if obj := resp.IntellectualObject(); obj != nil && obj.ID != 0 {
+		return obj.Identifier, nil
+	}
+	return "", fmt.Errorf("registry returned empty object for id %d", m.ObjOrFileID)
+}
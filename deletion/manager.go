@@ -6,17 +6,31 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/APTrust/preservation-services/constants"
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
+	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
 )
 
+// PendingDeletionTag is the S3 object tag we put on the preservation
+// copies of files that are pending deletion. Its value is the date
+// after which apt_purge may remove them.
+const PendingDeletionTag = "aptrust-purge-after"
+
 // Manager deletes files from preservation and ensures that Registry
 // IntellectualObjects, GenericFiles, StorageRecords and PremisEvents
 // are updated to reflect the changes.
+//
+// Deletion happens in two phases. Run marks files and objects pending
+// deletion, tags their preservation copies and records a deaccession
+// event. After the retention period, Purge removes the copies from
+// storage and marks the files and objects deleted. Until then, Cancel
+// undoes the first phase.
 type Manager struct {
 	// Context is the context, which includes config settings and
 	// clients to access S3 and Registry.
@@ -46,8 +60,45 @@ type Manager struct {
 	// deletion request. Normal deletion requests don't need APTrust approval.
 	APTrustApprover string
 
+	// RetentionDays is the number of days files stay in preservation
+	// storage after Run marks them pending deletion. Zero means Run
+	// purges them immediately. Anything else needs Registry to accept
+	// StatePendingDeletion, StageAwaitingPurge and WorkItem.PurgeAfter,
+	// which it doesn't yet. See the README.
+	RetentionDays int
+
+	// PurgeAfter is the time after which the files Run marked pending
+	// deletion may be purged. It's zero if Run purged them.
+	PurgeAfter time.Time
+
+	// DryRun tells Run to report what it would delete instead of
+	// deleting it. Run sets Report.
+	DryRun bool
+
+	// Report describes what Run would delete. It's set only on dry runs.
+	Report *service.DeletionReport
+
+	// VerifyAttempts is the number of times we delete a copy again
+	// because it's still there when we check, before we give up on the
+	// deletion and ask an admin to look into it. We check once per
+	// attempt, without waiting, so a lingering copy is checked again
+	// when the WorkItem is retried.
+	VerifyAttempts int
+
+	// CertificateURL is the S3 URL of the deletion certificate, once
+	// Purge, or Run without retention, has saved it.
+	CertificateURL string
+
+	// deletedFiles records the copies we've deleted, by file identifier.
+	deletedFiles map[string]*service.DeletedFile
+
 	// itemIdentifier is used for logging and error reporting
 	itemIdentifier string
+
+	// policyCleared is true once CheckPolicy has found that no legal
+	// hold or retention policy forbids deleting this object or file.
+	// Only then may we bypass Object Lock governance retention.
+	policyCleared bool
 }
 
 // NewManager creates a new deletion.Manager.
@@ -60,33 +111,120 @@ func NewManager(context *common.Context, workItemID, objOrFileID int64, itemType
 		RequestedBy:     requestedBy,
 		InstApprover:    instApprover,
 		APTrustApprover: aptrustApprover,
+		RetentionDays:   context.Config.DeletionRetentionDays,
+		VerifyAttempts:  5,
+		deletedFiles:    make(map[string]*service.DeletedFile),
 		itemIdentifier:  fmt.Sprintf("%s:%d", itemType, objOrFileID),
 	}
 }
 
-// Run deletes all copies of a single file from preservation/replication storage
-// if Manager.ItemType is constants.TypeFile. If ItemType is constants.TypeObject,
-// this deletes all copies of all of the object's files. This returns the number
-// of GenericFiles deleted. The number of copies deleted my be higher. For example,
-// deleting an object with 10 files from Standard storage deletes both the S3 and
-// the Glacier copies. That's 20 stored object representing only 10 GenericFiles.
-// This will return 10, not 20.
+// Run starts the deletion of a single file if Manager.ItemType is
+// constants.TypeFile, or of all of an object's files if ItemType is
+// constants.TypeObject. This returns the number of GenericFiles affected.
+// The number of copies may be higher. For example, an object with 10 files
+// in Standard storage has both S3 and Glacier copies. That's 20 stored
+// objects representing only 10 GenericFiles. This will return 10, not 20.
 //
 // It's up to the caller to ensure that the WorkItem has the proper approvals
-// before calling this method.
+// before calling this method. This returns a fatal error without deleting
+// anything if a legal hold or retention policy forbids the deletion.
+// See CheckPolicy.
+//
+// If RetentionDays is greater than zero, this tags all copies of each file
+// with PendingDeletionTag, creates a deaccession PREMIS event for each file,
+// and changes the state of each file from "A" (active) to "P" (pending
+// deletion). For object deletion, it also changes the object's state to "P"
+// if all files were marked. Purge finishes the job after PurgeAfter.
 //
-// After deleting files from storage, this method creates deletion PREMIS events
-// in Registry for each file, and it changes the state of each file from "A" (active)
-// to "D" (deleted). For object deletion, it also changes the Registry object's
-// state to "D" if all file deletions succeeded.
+// If RetentionDays is zero, this purges the files immediately. See Purge.
+//
+// If DryRun is true, this deletes nothing. It sets Report to describe
+// the files and preservation copies a real run would delete, and
+// returns the number of files. Dry runs don't require approval, and
+// report legal holds and retention policies rather than failing.
 func (m *Manager) Run() (count int, errors []*service.ProcessingError) {
+	if m.DryRun {
+		return m.dryRun()
+	}
 	if m.RequestedBy == "" || m.InstApprover == "" {
 		return 0, append(errors, m.Error(m.itemIdentifier, fmt.Errorf("Deletion requires email of requestor and institutional approver"), true))
 	}
-	if m.ItemType == constants.TypeFile {
-		count, errors = m.deleteSingleFile()
-	} else {
-		count, errors = m.deleteFiles()
+	if err := m.CheckPolicy(); err != nil {
+		return 0, append(errors, m.policyError(err))
+	}
+	if m.RetentionDays <= 0 {
+		return m.purge(constants.StateActive)
+	}
+	purgeAfter := time.Now().UTC().AddDate(0, 0, m.RetentionDays)
+	count, errors = m.forEachFile(constants.StateActive, func(gf *registry.GenericFile) []*service.ProcessingError {
+		return m.markFilePendingDeletion(gf, purgeAfter)
+	})
+	if len(errors) == 0 && m.ItemType == constants.TypeObject {
+		err := m.setObjectState(constants.StatePendingDeletion)
+		if err != nil {
+			errors = append(errors, m.Error(m.itemIdentifier, err, false))
+		}
+	}
+	if len(errors) == 0 {
+		m.PurgeAfter = purgeAfter
+	}
+	return count, errors
+}
+
+// Purge deletes all copies of the files that Run marked pending deletion
+// from preservation/replication storage. It creates deletion PREMIS events
+// in Registry for each file and changes the state of each file to "D"
+// (deleted). For object deletion, it also changes the object's state to
+// "D" if all file deletions succeeded. Before marking the object deleted,
+// it confirms that every deleted copy is gone and saves a deletion
+// certificate to Config.DeletionCertificateBucket.
+//
+// It's up to the caller to ensure that PurgeAfter has passed and the
+// deletion has not been cancelled. This checks legal holds and retention
+// policies again, since a hold may have been placed after Run, unless
+// the caller has just called CheckPolicy.
+func (m *Manager) Purge() (count int, errors []*service.ProcessingError) {
+	if !m.policyCleared {
+		if err := m.CheckPolicy(); err != nil {
+			return 0, append(errors, m.policyError(err))
+		}
+	}
+	return m.purge(constants.StatePendingDeletion)
+}
+
+// Cancel undoes Run for files that have not yet been purged. It removes
+// PendingDeletionTag from all copies of each file and changes the state
+// of the files and object back to "A" (active).
+func (m *Manager) Cancel() (count int, errors []*service.ProcessingError) {
+	count, errors = m.forEachFile(constants.StatePendingDeletion, m.restoreFile)
+	if len(errors) == 0 && m.ItemType == constants.TypeObject {
+		err := m.setObjectState(constants.StateActive)
+		if err != nil {
+			errors = append(errors, m.Error(m.itemIdentifier, err, false))
+		}
+	}
+	return count, errors
+}
+
+// purge deletes the files in state from preservation storage. Then
+// it checks that every copy deleted, on this attempt or an earlier one,
+// is really gone, and saves a deletion certificate.
+func (m *Manager) purge(state string) (count int, errors []*service.ProcessingError) {
+	count, errors = m.forEachFile(state, m.deleteFile)
+	if len(errors) == 0 {
+		errors = m.verifyDeletions()
+	}
+	if len(errors) == 0 {
+		err := m.saveCertificate()
+		if err != nil {
+			errors = append(errors, m.Error(m.itemIdentifier, err, false))
+		}
+	}
+	if len(errors) == 0 && m.ItemType == constants.TypeObject {
+		err := m.markObjectDeleted()
+		if err != nil {
+			errors = append(errors, m.Error(m.itemIdentifier, err, false))
+		}
 	}
 	return count, errors
 }
@@ -103,31 +241,36 @@ func (m *Manager) IngestObjectSave() error {
 	return nil
 }
 
-// deleteSingleFile is for deleting a single GenericFile. Call this when ItemType
-// is GenericFile.
-func (m *Manager) deleteSingleFile() (count int, errors []*service.ProcessingError) {
-	resp := m.Context.RegistryClient.GenericFileByID(m.ObjOrFileID)
-	if resp.Error != nil {
-		return count, append(errors, m.Error(m.itemIdentifier, resp.Error, false))
-	}
-	gf := resp.GenericFile()
-	if gf == nil {
-		return count, append(errors, m.Error(m.itemIdentifier, fmt.Errorf("Cannot find GenericFile with id %d", m.ObjOrFileID), false))
-	}
-	errs := m.deleteFile(gf)
-	if len(errs) > 0 {
-		return count, append(errors, errs...)
+// forEachFile calls fn for the file we're deleting, when ItemType is
+// GenericFile, or for each of the object's files in state, when ItemType
+// is IntellectualObject. Param fn must change the file's state.
+//
+// Because we're filtering on state, files that fn handles drop out of
+// the list, so we keep reading the same page until it has nothing new.
+// Files for which fn fails stay in the list. We don't try them again on
+// this call, and once a page holds only those, we move on to the next.
+func (m *Manager) forEachFile(state string, fn func(*registry.GenericFile) []*service.ProcessingError) (count int, errors []*service.ProcessingError) {
+	if m.ItemType == constants.TypeFile {
+		resp := m.Context.RegistryClient.GenericFileByID(m.ObjOrFileID)
+		if resp.Error != nil {
+			return count, append(errors, m.Error(m.itemIdentifier, resp.Error, false))
+		}
+		gf := resp.GenericFile()
+		if gf == nil {
+			return count, append(errors, m.Error(m.itemIdentifier, fmt.Errorf("Cannot find GenericFile with id %d", m.ObjOrFileID), false))
+		}
+		errs := fn(gf)
+		if len(errs) > 0 {
+			return count, append(errors, errs...)
+		}
+		return 1, nil
 	}
-	return 1, nil
-}
 
-// deleteFiles is for deleting all of the files belonging to an object.
-// Call this when ItemType is IntellectualObject.
-func (m *Manager) deleteFiles() (count int, errors []*service.ProcessingError) {
+	failed := make(map[int64]bool)
 	params := url.Values{}
 	params.Set("intellectual_object_id", strconv.FormatInt(m.ObjOrFileID, 10))
 	params.Set("page", "1")
-	params.Set("state", constants.StateActive)
+	params.Set("state", state)
 	params.Set("per_page", "200")
 	for {
 		resp := m.Context.RegistryClient.GenericFileList(params)
@@ -135,35 +278,33 @@ func (m *Manager) deleteFiles() (count int, errors []*service.ProcessingError) {
 			errors = append(errors, m.Error(m.itemIdentifier, resp.Error, false))
 			return count, errors
 		}
+		changed := 0
 		for _, gf := range resp.GenericFiles() {
-			if gf.State == constants.StateDeleted {
+			if gf.State == constants.StateDeleted || failed[gf.ID] {
 				continue
 			}
-			errs := m.deleteFile(gf)
+			errs := fn(gf)
 			if len(errs) > 0 {
 				errors = append(errors, errs...)
+				failed[gf.ID] = true
 			} else {
 				count++
+				changed++
 			}
 		}
-		// Because we're filtering on State="A", we can keep getting the first page.
-		// Everything that was on the first page is now marked State="D".
-		// We don't need to call resp.ParamsForNextPage()
 		if !resp.HasNextPage() {
 			break
 		}
-	}
-	if len(errors) == 0 {
-		err := m.markObjectDeleted()
-		if err != nil {
-			errors = append(errors, m.Error(m.itemIdentifier, err, false))
+		if changed == 0 {
+			params = resp.ParamsForNextPage()
 		}
 	}
 	return count, errors
 }
 
-// deleteFile tries to delete all the storage records associated with a file.
-func (m *Manager) deleteFile(gf *registry.GenericFile) (errors []*service.ProcessingError) {
+// forEachCopy calls fn with the bucket and key of each of the
+// preservation copies of gf.
+func (m *Manager) forEachCopy(gf *registry.GenericFile, fn func(*common.PreservationBucket, string) error) (errors []*service.ProcessingError) {
 	params := url.Values{}
 	params.Add("generic_file_id", strconv.FormatInt(gf.ID, 10))
 	resp := m.Context.RegistryClient.StorageRecordList(params)
@@ -177,14 +318,66 @@ func (m *Manager) deleteFile(gf *registry.GenericFile) (errors []*service.Proces
 			errors = append(errors, m.Error(gf.Identifier, err, false))
 			continue
 		}
-		err = m.deleteFromPreservationStorage(bucket, key)
+		err = fn(bucket, key)
 		if err != nil {
 			errors = append(errors, m.Error(gf.Identifier, err, false))
-			continue
 		}
 	}
+	return errors
+}
+
+// markFilePendingDeletion tags all copies of gf as pending deletion,
+// records a deaccession event and sets the file's state to "P".
+func (m *Manager) markFilePendingDeletion(gf *registry.GenericFile, purgeAfter time.Time) (errors []*service.ProcessingError) {
+	errors = m.forEachCopy(gf, func(bucket *common.PreservationBucket, key string) error {
+		return m.setPendingDeletionTag(bucket, key, purgeAfter.Format("2006-01-02"))
+	})
+	if len(errors) > 0 || gf.State == constants.StatePendingDeletion {
+		return errors
+	}
+	// Record the event first. If saving the state fails, we'll record
+	// it again on the next attempt, which is better than not at all.
+	resp := m.Context.RegistryClient.PremisEventSave(m.deaccessionEvent(gf, purgeAfter))
+	if resp.Error != nil {
+		return append(errors, m.Error(gf.Identifier, resp.Error, false))
+	}
+	gf.State = constants.StatePendingDeletion
+	resp = m.Context.RegistryClient.GenericFileSave(gf)
+	if resp.Error != nil {
+		errors = append(errors, m.Error(gf.Identifier, resp.Error, false))
+	}
+	return errors
+}
+
+// restoreFile removes the pending deletion tag from all copies of gf
+// and sets the file's state back to "A".
+func (m *Manager) restoreFile(gf *registry.GenericFile) (errors []*service.ProcessingError) {
+	errors = m.forEachCopy(gf, func(bucket *common.PreservationBucket, key string) error {
+		return m.setPendingDeletionTag(bucket, key, "")
+	})
+	if len(errors) > 0 || gf.State != constants.StatePendingDeletion {
+		return errors
+	}
+	gf.State = constants.StateActive
+	resp := m.Context.RegistryClient.GenericFileSave(gf)
+	if resp.Error != nil {
+		errors = append(errors, m.Error(gf.Identifier, resp.Error, false))
+	}
+	return errors
+}
+
+// deleteFile tries to delete all the storage records associated with a file,
+// and records the deleted copies for verification.
+func (m *Manager) deleteFile(gf *registry.GenericFile) (errors []*service.ProcessingError) {
+	errors = m.forEachCopy(gf, func(bucket *common.PreservationBucket, key string) error {
+		versions, err := m.deleteFromPreservationStorage(bucket, key)
+		if err == nil {
+			err = m.recordDeletedCopy(gf, bucket, key, versions)
+		}
+		return err
+	})
 	if len(errors) == 0 {
-		resp = m.Context.RegistryClient.GenericFileDelete(gf.ID)
+		resp := m.Context.RegistryClient.GenericFileDelete(gf.ID)
 		if resp.Error != nil {
 			errors = append(errors, m.Error(gf.Identifier, resp.Error, false))
 		}
@@ -192,37 +385,158 @@ func (m *Manager) deleteFile(gf *registry.GenericFile) (errors []*service.Proces
 	return errors
 }
 
-// deleteFromPreservationStroage deletes the copy of the file located
-// in this S3/Glacier bucket. Note that a file may be saved in multiple
-// buckets. This deletes from just one of those buckets.
-func (m *Manager) deleteFromPreservationStorage(bucket *common.PreservationBucket, key string) error {
+// deleteFromPreservationStorage deletes every version of the copy of
+// the file located in this S3/Glacier bucket, including noncurrent
+// versions and delete markers, so nothing of it remains in a versioned
+// bucket. It returns the number of versions deleted. Note that a file
+// may be saved in multiple buckets. This deletes from just one of
+// those buckets.
+func (m *Manager) deleteFromPreservationStorage(bucket *common.PreservationBucket, key string) (int, error) {
 	client := m.Context.S3Clients[bucket.Bucket]
 	if client == nil {
-		return fmt.Errorf("No S3 client for provider %s", bucket.Provider)
+		return 0, fmt.Errorf("No S3 client for provider %s", bucket.Provider)
 	}
-	err := client.RemoveObject(ctx.Background(), bucket.Bucket, key, minio.RemoveObjectOptions{})
-
-	// We can ignore this message because the item may have been deleted
-	// on a prior attempt.
+	versions, err := listVersions(client, bucket.Bucket, key)
 	if err != nil {
-		if strings.Contains(err.Error(), "key does not exist") {
-			m.Context.Logger.Warningf("Item %s %s/%s does not exist. May have been deleted in prior run.", bucket.Provider, bucket.Bucket, key)
-			return nil
+		m.Context.Logger.Errorf("Cannot list versions of item %s %s/%s: %v", bucket.Provider, bucket.Bucket, key, err)
+		return 0, err
+	}
+
+	// The item may have been deleted on a prior attempt.
+	if len(versions) == 0 {
+		m.Context.Logger.Warningf("Item %s %s/%s does not exist. May have been deleted in prior run.", bucket.Provider, bucket.Bucket, key)
+		return 0, nil
+	}
+
+	// If preservation copies are under Object Lock, we bypass
+	// governance retention only once CheckPolicy has confirmed that
+	// our own holds and retention policies allow this deletion.
+	deleted := 0
+	for _, versionID := range versions {
+		opts := minio.RemoveObjectOptions{
+			GovernanceBypass: m.Context.Config.ObjectLockRetentionDays > 0 && m.policyCleared,
+			VersionID:        versionID,
+		}
+		err = client.RemoveObject(ctx.Background(), bucket.Bucket, key, opts)
+		code := minio.ToErrorResponse(err).Code
+		if code == "NoSuchKey" || code == "NoSuchVersion" {
+			continue
 		}
-		if err.Error() == "Access Denied" && strings.Contains(bucket.Host, "wasabi") {
-			err = fmt.Errorf("%v - Note that Wasabi has a minimum storage period of 30 days. Deletions before then will be denied.", err)
+		if err != nil {
+			if err.Error() == "Access Denied" && strings.Contains(bucket.Host, "wasabi") {
+				err = fmt.Errorf("%v - Note that Wasabi has a minimum storage period of 30 days. Deletions before then will be denied.", err)
+			}
+			// Other errors are permission denied, bucket does not
+			// exist, conflict, request limit. These need to be
+			// reported.
+			m.Context.Logger.Errorf("Attempt to delete version %s of item %s %s/%s failed. Provider returned: %v", versionID, bucket.Provider, bucket.Bucket, key, err)
+			return deleted, err
 		}
+		deleted++
+	}
+	m.Context.Logger.Infof("Deleted %d versions of item %s %s/%s", deleted, bucket.Provider, bucket.Bucket, key)
+	return deleted, nil
+}
 
-		m.Context.Logger.Errorf("Attempt to delete item %s %s/%s failed. Provider returned: %v", bucket.Provider, bucket.Bucket, key, err)
-	} else {
-		m.Context.Logger.Infof("Delete item %s %s/%s", bucket.Provider, bucket.Bucket, key)
+// listVersions returns the version IDs of every version of key in
+// bucket, including delete markers. In a bucket that has never had
+// versioning, the only version's ID is "null".
+func listVersions(client *minio.Client, bucket, key string) ([]string, error) {
+	listCtx, cancel := ctx.WithCancel(ctx.Background())
+	defer cancel()
+	versions := make([]string, 0)
+	opts := minio.ListObjectsOptions{Prefix: key, WithVersions: true}
+	for obj := range client.ListObjects(listCtx, bucket, opts) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		// Prefix matches other keys that start with this one.
+		if obj.Key == key {
+			versions = append(versions, obj.VersionID)
+		}
 	}
+	return versions, nil
+}
 
-	// Other errors are permission denied, bucket does not exist, conflict,
-	// request limit. These need to be reported.
+// setPendingDeletionTag sets PendingDeletionTag on the copy of a file
+// in bucket, keeping any other tags. An empty value removes the tag.
+func (m *Manager) setPendingDeletionTag(bucket *common.PreservationBucket, key, value string) error {
+	client := m.Context.S3Clients[bucket.Bucket]
+	if client == nil {
+		return fmt.Errorf("No S3 client for provider %s", bucket.Provider)
+	}
+	current, err := client.GetObjectTagging(ctx.Background(), bucket.Bucket, key, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			m.Context.Logger.Warningf("Item %s %s/%s does not exist. Cannot tag it.", bucket.Provider, bucket.Bucket, key)
+			return nil
+		}
+		return err
+	}
+	tagMap := current.ToMap()
+	if value == "" {
+		delete(tagMap, PendingDeletionTag)
+	} else {
+		tagMap[PendingDeletionTag] = value
+	}
+	if len(tagMap) == 0 {
+		return client.RemoveObjectTagging(ctx.Background(), bucket.Bucket, key, minio.RemoveObjectTaggingOptions{})
+	}
+	newTags, err := tags.NewTags(tagMap, true)
+	if err != nil {
+		return err
+	}
+	err = client.PutObjectTagging(ctx.Background(), bucket.Bucket, key, newTags, minio.PutObjectTaggingOptions{})
+	if err == nil {
+		m.Context.Logger.Infof("Set %s=%s on item %s %s/%s", PendingDeletionTag, value, bucket.Provider, bucket.Bucket, key)
+	}
 	return err
 }
 
+// setObjectState sets the state of the object we're deleting.
+func (m *Manager) setObjectState(state string) error {
+	resp := m.Context.RegistryClient.IntellectualObjectByID(m.ObjOrFileID)
+	if resp.Error != nil {
+		return resp.Error
+	}
+	obj := resp.IntellectualObject()
+	if obj == nil || obj.ID == 0 {
+		return fmt.Errorf("registry returned empty object for id %d", m.ObjOrFileID)
+	}
+	if obj.State == state {
+		return nil
+	}
+	obj.State = state
+	resp = m.Context.RegistryClient.IntellectualObjectSave(obj)
+	return resp.Error
+}
+
+// deaccessionEvent returns a PremisEvent saying that gf has been
+// deaccessioned and will be purged from storage after purgeAfter.
+func (m *Manager) deaccessionEvent(gf *registry.GenericFile, purgeAfter time.Time) *registry.PremisEvent {
+	now := time.Now().UTC()
+	approvers := m.InstApprover
+	if m.APTrustApprover != "" {
+		approvers += ", " + m.APTrustApprover
+	}
+	return &registry.PremisEvent{
+		Agent:                "https://github.com/APTrust/preservation-services",
+		DateTime:             now,
+		Detail:               "File marked for deletion",
+		EventType:            constants.EventDeaccession,
+		GenericFileID:        gf.ID,
+		Identifier:           uuid.New().String(),
+		InstitutionID:        gf.InstitutionID,
+		IntellectualObjectID: gf.IntellectualObjectID,
+		Object:               "APTrust preservation services",
+		Outcome:              constants.StatusSuccess,
+		OutcomeDetail:        m.RequestedBy,
+		OutcomeInformation:   fmt.Sprintf("Deletion requested by %s, approved by %s. Preservation copies will be purged after %s unless the deletion is cancelled.", m.RequestedBy, approvers, purgeAfter.Format(time.RFC3339)),
+		CreatedAt:            now,
+		UpdatedAt:            now,
+	}
+}
+
 // markObjectDeleted tells Registry that this object has been deleted in its
 // entirety (all files deleted).
 func (m *Manager) markObjectDeleted() error {
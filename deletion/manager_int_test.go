@@ -5,6 +5,7 @@ package deletion_test
 
 import (
 	ctx "context"
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"strconv"
@@ -16,6 +17,7 @@ import (
 	"github.com/APTrust/preservation-services/deletion"
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
 	"github.com/APTrust/preservation-services/util"
 	"github.com/APTrust/preservation-services/util/testutil"
 	"github.com/google/uuid"
@@ -60,6 +62,92 @@ func TestNewManager(t *testing.T) {
 	assert.Equal(t, "some-admin@aptrust.org", manager.APTrustApprover)
 }
 
+func TestRun_PendingDeletionAndCancel(t *testing.T) {
+	context := common.NewContext()
+	prepareForTest(t, context)
+	gf := savedFiles[0] // doc1
+
+	resp := context.RegistryClient.GenericFilePrepareForDelete(gf.ID)
+	require.Nil(t, resp.Error)
+	workItem := resp.WorkItem()
+	require.True(t, workItem.ID > 0)
+
+	manager := deletion.NewManager(
+		context,
+		workItem.ID,
+		gf.ID,
+		constants.TypeFile,
+		"requestor@example.com",
+		"approver@example.com",
+		"some-admin@aptrust.org",
+	)
+	manager.RetentionDays = 30
+	count, errors := manager.Run()
+	assert.Equal(t, 1, count)
+	assert.Empty(t, errors)
+	assert.True(t, manager.PurgeAfter.After(time.Now().AddDate(0, 0, 29)))
+
+	// Phase one marks the file and tags its copies, but leaves
+	// them in storage.
+	resp = context.RegistryClient.GenericFileByID(gf.ID)
+	require.Nil(t, resp.Error)
+	assert.Equal(t, constants.StatePendingDeletion, resp.GenericFile().State)
+	testPendingDeletionTags(t, context, gf.ID, manager.PurgeAfter.Format("2006-01-02"))
+
+	values := url.Values{}
+	values.Set("generic_file_id", strconv.FormatInt(gf.ID, 10))
+	values.Set("event_type", constants.EventDeaccession)
+	resp = context.RegistryClient.PremisEventList(values)
+	require.Nil(t, resp.Error)
+	assert.Equal(t, 1, len(resp.PremisEvents()))
+
+	// Cancel undoes phase one.
+	count, errors = manager.Cancel()
+	assert.Equal(t, 1, count)
+	assert.Empty(t, errors)
+	resp = context.RegistryClient.GenericFileByID(gf.ID)
+	require.Nil(t, resp.Error)
+	assert.Equal(t, constants.StateActive, resp.GenericFile().State)
+	testPendingDeletionTags(t, context, gf.ID, "")
+}
+
+func TestRun_DryRun(t *testing.T) {
+	context := common.NewContext()
+	prepareForTest(t, context)
+
+	// Dry runs need no approval.
+	manager := deletion.NewManager(context, 0, savedObj.ID, constants.TypeObject, "", "", "")
+	manager.DryRun = true
+	count, errors := manager.Run()
+	assert.Empty(t, errors)
+	assert.Equal(t, len(fileNames), count)
+	require.NotNil(t, manager.Report)
+	assert.Equal(t, objIdentifier, manager.Report.Identifier)
+	assert.Empty(t, manager.Report.Blocked)
+	require.Equal(t, len(fileNames), len(manager.Report.Files))
+	for _, file := range manager.Report.Files {
+		assert.NotEmpty(t, file.Copies)
+		for _, c := range file.Copies {
+			assert.True(t, c.Exists, c.URL)
+			assert.Empty(t, c.Error)
+		}
+	}
+	assert.Contains(t, manager.Report.Summary(), "Dry run: deleting "+objIdentifier)
+
+	// Nothing was deleted or marked.
+	for _, gf := range savedFiles {
+		resp := context.RegistryClient.GenericFileByID(gf.ID)
+		require.Nil(t, resp.Error)
+		assert.Equal(t, constants.StateActive, resp.GenericFile().State)
+		values := url.Values{}
+		values.Set("generic_file_id", strconv.FormatInt(gf.ID, 10))
+		values.Set("event_type", constants.EventDeaccession)
+		resp = context.RegistryClient.PremisEventList(values)
+		require.Nil(t, resp.Error)
+		assert.Empty(t, resp.PremisEvents())
+	}
+}
+
 func TestRun_SingleFile(t *testing.T) {
 	context := common.NewContext()
 	prepareForTest(t, context)
@@ -86,6 +174,7 @@ func TestRun_SingleFile(t *testing.T) {
 	testItemMarkedDeleted(t, context, constants.TypeFile, gf.ID)
 	testStorageRecordsRemoved(t, context, gf.ID)
 	testFileDeletionEvents(t, context, gf.ID)
+	testDeletionCertificate(t, context, manager, gf.Identifier, 1)
 }
 
 func TestRun_Object(t *testing.T) {
@@ -127,6 +216,35 @@ func TestRun_Object(t *testing.T) {
 	// IntellectualObject
 	testItemMarkedDeleted(t, context, constants.TypeObject, savedObj.ID)
 	testObjectDeletionEvent(t, context)
+	testDeletionCertificate(t, context, manager, objIdentifier, 2)
+}
+
+func testDeletionCertificate(t *testing.T, context *common.Context, manager *deletion.Manager, identifier string, fileCount int) {
+	key := deletion.CertificateKey(identifier, manager.WorkItemID)
+	assert.Equal(t, fmt.Sprintf("s3://%s/%s", context.Config.DeletionCertificateBucket, key), manager.CertificateURL)
+	obj, err := context.S3Clients[constants.StorageProviderAWS].GetObject(
+		ctx.Background(),
+		context.Config.DeletionCertificateBucket,
+		key,
+		minio.GetObjectOptions{},
+	)
+	require.Nil(t, err)
+	defer obj.Close()
+	cert := &service.DeletionCertificate{}
+	require.Nil(t, json.NewDecoder(obj).Decode(cert))
+	assert.Equal(t, identifier, cert.Identifier)
+	assert.Equal(t, manager.WorkItemID, cert.WorkItemID)
+	assert.Equal(t, "requestor@example.com", cert.RequestedBy)
+	assert.Equal(t, "approver@example.com", cert.InstApprover)
+	assert.Equal(t, "some-admin@aptrust.org", cert.APTrustApprover)
+	require.Equal(t, fileCount, len(cert.Files))
+	for _, f := range cert.Files {
+		assert.NotEmpty(t, f.Copies)
+		assert.True(t, f.IsVerified(), f.Identifier)
+		for _, c := range f.Copies {
+			assert.True(t, c.Versions > 0, c.URL)
+		}
+	}
 }
 
 func testItemMarkedDeleted(t *testing.T, context *common.Context, itemType string, itemID int64) {
@@ -141,6 +259,21 @@ func testItemMarkedDeleted(t *testing.T, context *common.Context, itemType strin
 	}
 }
 
+func testPendingDeletionTags(t *testing.T, context *common.Context, gfID int64, expected string) {
+	values := url.Values{}
+	values.Add("generic_file_id", strconv.FormatInt(gfID, 10))
+	resp := context.RegistryClient.StorageRecordList(values)
+	require.Nil(t, resp.Error)
+	require.NotEmpty(t, resp.StorageRecords())
+	for _, sr := range resp.StorageRecords() {
+		bucket, key, err := context.Config.BucketAndKeyFor(sr.URL)
+		require.Nil(t, err)
+		objTags, err := context.S3Clients[bucket.Bucket].GetObjectTagging(ctx.Background(), bucket.Bucket, key, minio.GetObjectTaggingOptions{})
+		require.Nil(t, err, sr.URL)
+		assert.Equal(t, expected, objTags.ToMap()[deletion.PendingDeletionTag], sr.URL)
+	}
+}
+
 func testStorageRecordsRemoved(t *testing.T, context *common.Context, gfID int64) {
 	values := url.Values{}
 	values.Add("generic_file_id", strconv.FormatInt(gfID, 10))
@@ -0,0 +1,292 @@
+package deletion_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/deletion"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/util"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const versionedKey = "8f3e2d1c-0000-4000-8000-000000000001"
+
+// versionedStore is an S3 bucket holding the versions of one key. It
+// lists them, including delete markers, and deletes them by version
+// ID. Deleting a version in lingering is recorded but has no effect,
+// the way a deleted copy can linger on an eventually consistent
+// provider.
+type versionedStore struct {
+	mutex     sync.Mutex
+	versions  []string
+	markers   []string
+	lingering map[string]bool
+	deletes   []string
+}
+
+func (s *versionedStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && query.Has("versions"):
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListVersionsResult><Name>bucket</Name><IsTruncated>false</IsTruncated>`)
+		for _, id := range s.versions {
+			fmt.Fprintf(w, `<Version><Key>%s</Key><VersionId>%s</VersionId><IsLatest>false</IsLatest><LastModified>2024-01-01T00:00:00Z</LastModified><Size>4</Size><ETag>"x"</ETag></Version>`, versionedKey, id)
+		}
+		for _, id := range s.markers {
+			fmt.Fprintf(w, `<DeleteMarker><Key>%s</Key><VersionId>%s</VersionId><IsLatest>true</IsLatest><LastModified>2024-02-01T00:00:00Z</LastModified></DeleteMarker>`, versionedKey, id)
+		}
+		// A different key that shares the prefix.
+		fmt.Fprintf(w, `<Version><Key>%s-other</Key><VersionId>other</VersionId><IsLatest>true</IsLatest><LastModified>2024-01-01T00:00:00Z</LastModified><Size>4</Size><ETag>"x"</ETag></Version>`, versionedKey)
+		fmt.Fprint(w, `</ListVersionsResult>`)
+	case r.Method == http.MethodDelete:
+		versionID := query.Get("versionId")
+		s.deletes = append(s.deletes, versionID)
+		if !s.lingering[versionID] {
+			s.versions = remove(s.versions, versionID)
+			s.markers = remove(s.markers, versionID)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *versionedStore) deleted() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.deletes...)
+}
+
+func remove(list []string, item string) []string {
+	result := make([]string, 0, len(list))
+	for _, s := range list {
+		if s != item {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// fileRegistry serves the one file, its object and institution, and
+// its storage record to the deletion manager.
+type fileRegistry struct {
+	gf        *registry.GenericFile
+	recordURL string
+}
+
+func (f *fileRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.Contains(r.URL.Path, "/files/show/"):
+		json.NewEncoder(w).Encode(f.gf)
+	case strings.Contains(r.URL.Path, "/files/delete/"):
+		w.WriteHeader(http.StatusNoContent)
+	case strings.Contains(r.URL.Path, "/objects/show/"):
+		json.NewEncoder(w).Encode(&registry.IntellectualObject{ID: f.gf.IntellectualObjectID, Identifier: "test.edu/bag", InstitutionID: 1, LegalHold: hold(false)})
+	case strings.Contains(r.URL.Path, "/institutions/show/"):
+		json.NewEncoder(w).Encode(&registry.Institution{ID: 1, Identifier: "test.edu", LegalHold: hold(false)})
+	case strings.HasSuffix(r.URL.Path, "/storage_records"):
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"count":   1,
+			"next":    nil,
+			"results": []*registry.StorageRecord{{GenericFileID: f.gf.ID, URL: f.recordURL}},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func versionedTestManager(t *testing.T, store *versionedStore) (*deletion.Manager, func()) {
+	s3Server := httptest.NewServer(store)
+	u, err := url.Parse(s3Server.URL)
+	require.Nil(t, err)
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4("key", "secret", ""),
+		Region:       "us-east-1",
+		BucketLookup: minio.BucketLookupPath,
+		MaxRetries:   1,
+	})
+	require.Nil(t, err)
+
+	config := common.NewConfig()
+	config.DeletionCertificateBucket = ""
+	primary := config.PreservationBucketsFor(constants.StorageStandard)[0]
+	gf := &registry.GenericFile{
+		ID:                   55,
+		Identifier:           "test.edu/bag/data/file.txt",
+		IntellectualObjectID: 5,
+		State:                constants.StatePendingDeletion,
+		UUID:                 versionedKey,
+	}
+	registryServer := httptest.NewServer(&fileRegistry{gf: gf, recordURL: primary.URLFor(versionedKey)})
+	log := logger.DiscardLogger("manager_test")
+	registryClient, err := network.NewRegistryClient(registryServer.URL, "v3", "user", "key", constants.AdminAPIPrefix, log)
+	require.Nil(t, err)
+
+	context := &common.Context{
+		Config:         config,
+		Logger:         log,
+		RegistryClient: registryClient,
+		S3Clients:      map[string]*minio.Client{primary.Bucket: client},
+	}
+	manager := deletion.NewManager(context, 0, gf.ID, constants.TypeFile, "requester@test.edu", "approver@test.edu", "")
+	return manager, func() {
+		s3Server.Close()
+		registryServer.Close()
+	}
+}
+
+func TestPurgeDeletesAllVersions(t *testing.T) {
+	store := &versionedStore{versions: []string{"v1", "v2"}, markers: []string{"m1"}}
+	manager, cleanUp := versionedTestManager(t, store)
+	defer cleanUp()
+
+	count, errors := manager.Purge()
+	assert.Empty(t, errors)
+	assert.Equal(t, 1, count)
+	assert.ElementsMatch(t, []string{"v1", "v2", "m1"}, store.deleted())
+}
+
+func TestPurgeDoesNotWaitForLingeringCopies(t *testing.T) {
+	store := &versionedStore{versions: []string{"v1", "v2"}, lingering: map[string]bool{"v2": true}}
+	manager, cleanUp := versionedTestManager(t, store)
+	defer cleanUp()
+	manager.VerifyAttempts = 1
+
+	// The lingering version is deleted again, and we leave the next
+	// check to the next attempt instead of waiting for it.
+	start := time.Now()
+	_, errors := manager.Purge()
+	assert.True(t, time.Since(start) < 5*time.Second)
+	require.Equal(t, 1, len(errors))
+	assert.False(t, errors[0].IsFatal)
+	assert.Contains(t, errors[0].Message, "still existed")
+	assert.Equal(t, []string{"v1", "v2", "v2"}, store.deleted())
+
+	// Once it's been deleted more than VerifyAttempts times, we give up.
+	_, errors = manager.Purge()
+	require.Equal(t, 1, len(errors))
+	assert.True(t, errors[0].IsFatal)
+}
+
+// objectRegistry serves an object's files a page at a time, filtered
+// by state, and saves their new states. Saving a file in failing
+// returns an error.
+type objectRegistry struct {
+	mutex   sync.Mutex
+	files   []*registry.GenericFile
+	failing map[int64]bool
+}
+
+func (o *objectRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/files"):
+		query := r.URL.Query()
+		page, _ := strconv.Atoi(query.Get("page"))
+		perPage, _ := strconv.Atoi(query.Get("per_page"))
+		matches := make([]*registry.GenericFile, 0)
+		for _, gf := range o.files {
+			if gf.State == query.Get("state") {
+				matches = append(matches, gf)
+			}
+		}
+		start := util.Min((page-1)*perPage, len(matches))
+		end := util.Min(start+perPage, len(matches))
+		var next *string
+		if end < len(matches) {
+			query.Set("page", strconv.Itoa(page+1))
+			nextURL := "http://localhost/files?" + query.Encode()
+			next = &nextURL
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"count":   len(matches),
+			"next":    next,
+			"results": matches[start:end],
+		})
+	case strings.Contains(r.URL.Path, "/files/update/"):
+		saved := &registry.GenericFile{}
+		json.NewDecoder(r.Body).Decode(saved)
+		if o.failing[saved.ID] {
+			http.Error(w, "save failed", http.StatusInternalServerError)
+			return
+		}
+		for _, gf := range o.files {
+			if gf.ID == saved.ID {
+				gf.State = saved.State
+			}
+		}
+		json.NewEncoder(w).Encode(saved)
+	case strings.HasSuffix(r.URL.Path, "/storage_records"):
+		json.NewEncoder(w).Encode(map[string]interface{}{"count": 0, "next": nil, "results": []*registry.StorageRecord{}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Regression test: files that couldn't be changed stayed on the first
+// page, which forEachFile kept reading. Once the first page held only
+// those files, it read that page forever.
+func TestCancelSkipsPastFailedFiles(t *testing.T) {
+	standIn := &objectRegistry{failing: make(map[int64]bool)}
+	for i := int64(1); i <= 250; i++ {
+		standIn.files = append(standIn.files, &registry.GenericFile{
+			ID:                   i,
+			Identifier:           fmt.Sprintf("test.edu/bag/data/file%d.txt", i),
+			IntellectualObjectID: 5,
+			State:                constants.StatePendingDeletion,
+		})
+		standIn.failing[i] = i <= 200
+	}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	log := logger.DiscardLogger("manager_test")
+	registryClient, err := network.NewRegistryClient(server.URL, "v3", "user", "key", constants.AdminAPIPrefix, log)
+	require.Nil(t, err)
+	context := &common.Context{
+		Config:         common.NewConfig(),
+		Logger:         log,
+		RegistryClient: registryClient,
+	}
+	manager := deletion.NewManager(context, 0, 5, constants.TypeObject, "requester@test.edu", "approver@test.edu", "")
+
+	done := make(chan bool)
+	var count int
+	var errors []*service.ProcessingError
+	go func() {
+		count, errors = manager.Cancel()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "Cancel kept reading the same page")
+	}
+	assert.Equal(t, 50, count)
+	assert.Equal(t, 200, len(errors))
+	for _, gf := range standIn.files {
+		if gf.ID > 200 {
+			assert.Equal(t, constants.StateActive, gf.State, gf.Identifier)
+		}
+	}
+}
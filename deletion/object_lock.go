@@ -0,0 +1,130 @@
+package deletion
+
+import (
+	ctx "context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/minio/minio-go/v7"
+)
+
+// ObjectLockFor returns the S3 Object Lock settings that match the
+// legal holds and retention settings of inst and obj at time now:
+// whether preservation copies should be under legal hold, and the
+// date until which they must be retained. The date is zero if no
+// retention period applies. Param inst may be nil. A nil LegalHold
+// counts as no hold.
+func ObjectLockFor(inst *registry.Institution, obj *registry.IntellectualObject, now time.Time) (legalHold bool, retainUntil time.Time) {
+	legalHold = (obj.LegalHold != nil && *obj.LegalHold) || (inst != nil && inst.LegalHold != nil && *inst.LegalHold)
+	if obj.RetainUntil != nil {
+		retainUntil = *obj.RetainUntil
+	}
+	if inst != nil && inst.RetentionDays > 0 {
+		instRetainUntil := obj.CreatedAt.AddDate(0, 0, inst.RetentionDays)
+		if instRetainUntil.After(retainUntil) {
+			retainUntil = instRetainUntil
+		}
+	}
+	if !retainUntil.After(now) {
+		retainUntil = time.Time{}
+	}
+	return legalHold, retainUntil
+}
+
+// ApplyObjectLock sets S3 legal hold and governance retention on every
+// preservation copy of obj's files to match Registry's legal holds and
+// retention settings. See ObjectLockFor. It turns legal hold off when
+// Registry's hold has been lifted. It extends retention but never
+// shortens it, since shortening governance retention would need the
+// bypass we reserve for deletions that CheckPolicy has cleared.
+//
+// This returns the number of copies updated. The preservation buckets
+// must have Object Lock enabled. It returns an error without changing
+// anything if Registry didn't return obj's legal hold setting, since
+// turning holds off might release copies Registry wants held.
+func ApplyObjectLock(context *common.Context, inst *registry.Institution, obj *registry.IntellectualObject) (count int, err error) {
+	if obj.LegalHold == nil || (inst != nil && inst.LegalHold == nil) {
+		return count, fmt.Errorf("Registry did not return legal hold settings for %s", obj.Identifier)
+	}
+	legalHold, retainUntil := ObjectLockFor(inst, obj, time.Now().UTC())
+	params := url.Values{}
+	params.Set("intellectual_object_id", strconv.FormatInt(obj.ID, 10))
+	params.Set("page", "1")
+	params.Set("per_page", "200")
+	for {
+		resp := context.RegistryClient.GenericFileList(params)
+		if resp.Error != nil {
+			return count, resp.Error
+		}
+		for _, gf := range resp.GenericFiles() {
+			if gf.State == constants.StateDeleted {
+				continue
+			}
+			n, err := applyObjectLockToFile(context, gf, legalHold, retainUntil)
+			count += n
+			if err != nil {
+				return count, err
+			}
+		}
+		if !resp.HasNextPage() {
+			break
+		}
+		params = resp.ParamsForNextPage()
+	}
+	return count, nil
+}
+
+// applyObjectLockToFile sets legal hold and retention on each of the
+// preservation copies of gf.
+func applyObjectLockToFile(context *common.Context, gf *registry.GenericFile, legalHold bool, retainUntil time.Time) (count int, err error) {
+	params := url.Values{}
+	params.Add("generic_file_id", strconv.FormatInt(gf.ID, 10))
+	resp := context.RegistryClient.StorageRecordList(params)
+	if resp.Error != nil {
+		return count, resp.Error
+	}
+	for _, sr := range resp.StorageRecords() {
+		bucket, key, err := context.Config.BucketAndKeyFor(sr.URL)
+		if err != nil {
+			return count, err
+		}
+		client := context.S3Clients[bucket.Bucket]
+		if client == nil {
+			return count, fmt.Errorf("No S3 client for provider %s", bucket.Provider)
+		}
+		err = applyObjectLockToCopy(client, bucket.Bucket, key, legalHold, retainUntil)
+		if err != nil {
+			return count, fmt.Errorf("Cannot set Object Lock on %s: %v", sr.URL, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func applyObjectLockToCopy(client *minio.Client, bucket, key string, legalHold bool, retainUntil time.Time) error {
+	status := minio.LegalHoldDisabled
+	if legalHold {
+		status = minio.LegalHoldEnabled
+	}
+	err := client.PutObjectLegalHold(ctx.Background(), bucket, key, minio.PutObjectLegalHoldOptions{Status: &status})
+	if err != nil || retainUntil.IsZero() {
+		return err
+	}
+	_, current, err := client.GetObjectRetention(ctx.Background(), bucket, key, "")
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchObjectLockConfiguration" {
+		return err
+	}
+	if current != nil && !current.Before(retainUntil) {
+		return nil
+	}
+	mode := minio.Governance
+	return client.PutObjectRetention(ctx.Background(), bucket, key, minio.PutObjectRetentionOptions{
+		Mode:            &mode,
+		RetainUntilDate: &retainUntil,
+	})
+}
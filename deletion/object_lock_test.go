@@ -0,0 +1,45 @@
+package deletion_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/deletion"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectLockFor(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	inst := &registry.Institution{Identifier: "test.edu", LegalHold: hold(false)}
+	obj := &registry.IntellectualObject{
+		Identifier: "test.edu/bag",
+		CreatedAt:  now.AddDate(0, 0, -30),
+		LegalHold:  hold(false),
+	}
+	legalHold, retainUntil := deletion.ObjectLockFor(inst, obj, now)
+	assert.False(t, legalHold)
+	assert.True(t, retainUntil.IsZero())
+
+	// Either hold puts copies under legal hold.
+	obj.LegalHold = hold(true)
+	legalHold, _ = deletion.ObjectLockFor(nil, obj, now)
+	assert.True(t, legalHold)
+	obj.LegalHold = hold(false)
+	inst.LegalHold = hold(true)
+	legalHold, _ = deletion.ObjectLockFor(inst, obj, now)
+	assert.True(t, legalHold)
+
+	// The later of the two retention dates wins.
+	inst.RetentionDays = 60
+	_, retainUntil = deletion.ObjectLockFor(inst, obj, now)
+	assert.Equal(t, now.AddDate(0, 0, 30), retainUntil)
+	objRetainUntil := now.AddDate(1, 0, 0)
+	obj.RetainUntil = &objRetainUntil
+	_, retainUntil = deletion.ObjectLockFor(inst, obj, now)
+	assert.Equal(t, objRetainUntil, retainUntil)
+
+	// Retention that has already ended doesn't apply.
+	_, retainUntil = deletion.ObjectLockFor(inst, obj, objRetainUntil)
+	assert.True(t, retainUntil.IsZero())
+}
@@ -0,0 +1,119 @@
+package deletion
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+)
+
+// PolicyError means a retention policy or legal hold forbids deleting
+// an object or file. Deletions blocked by policy should fail without
+// retry. They can go ahead once the hold is lifted or the retention
+// period ends.
+type PolicyError struct {
+	Identifier string
+	Reason     string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("Deletion of %s is blocked: %s", e.Identifier, e.Reason)
+}
+
+// CheckPolicy returns a PolicyError if the legal holds and retention
+// settings of inst or obj forbid deleting obj, or any of its files,
+// at time now. It returns nil if deletion may proceed. Param inst may
+// be nil if the object's institution is unknown.
+//
+// Holds take precedence over retention periods, and object settings
+// over institution settings, so the error names the most specific
+// reason deletion is blocked.
+//
+// A nil LegalHold means Registry didn't return the legal_hold field,
+// which it won't until it has been migrated to support holds and
+// retention. We can't tell whether such an object may be deleted, so
+// this blocks the deletion rather than clearing it.
+func CheckPolicy(inst *registry.Institution, obj *registry.IntellectualObject, now time.Time) error {
+	if obj.LegalHold == nil || (inst != nil && inst.LegalHold == nil) {
+		return &PolicyError{Identifier: obj.Identifier, Reason: "Registry did not return legal hold settings, so it may not support legal holds and retention"}
+	}
+	if *obj.LegalHold {
+		return &PolicyError{Identifier: obj.Identifier, Reason: holdReason("object is under legal hold", obj.LegalHoldReason)}
+	}
+	if inst != nil && *inst.LegalHold {
+		return &PolicyError{Identifier: obj.Identifier, Reason: holdReason(fmt.Sprintf("institution %s is under legal hold", inst.Identifier), inst.LegalHoldReason)}
+	}
+	if obj.RetainUntil != nil && now.Before(*obj.RetainUntil) {
+		return &PolicyError{Identifier: obj.Identifier, Reason: fmt.Sprintf("object must be retained until %s", obj.RetainUntil.Format(time.RFC3339))}
+	}
+	if inst != nil && inst.RetentionDays > 0 {
+		retainUntil := obj.CreatedAt.AddDate(0, 0, inst.RetentionDays)
+		if now.Before(retainUntil) {
+			return &PolicyError{Identifier: obj.Identifier, Reason: fmt.Sprintf("institution %s retains objects for %d days after ingest, until %s", inst.Identifier, inst.RetentionDays, retainUntil.Format(time.RFC3339))}
+		}
+	}
+	return nil
+}
+
+func holdReason(message, reason string) string {
+	if reason != "" {
+		return fmt.Sprintf("%s (%s)", message, reason)
+	}
+	return message
+}
+
+// CheckPolicy returns a PolicyError if a legal hold or retention policy
+// forbids this deletion. For file deletions, the policy of the file's
+// parent object applies. It returns some other error if it can't get
+// the object or institution from Registry.
+//
+// Once this returns nil, the manager may bypass S3 Object Lock
+// governance retention when it deletes this object's copies.
+func (m *Manager) CheckPolicy() error {
+	m.policyCleared = false
+	objID := m.ObjOrFileID
+	identifier := ""
+	if m.ItemType == constants.TypeFile {
+		resp := m.Context.RegistryClient.GenericFileByID(m.ObjOrFileID)
+		if resp.Error != nil {
+			return resp.Error
+		}
+		gf := resp.GenericFile()
+		if gf == nil {
+			return fmt.Errorf("Cannot find GenericFile with id %d", m.ObjOrFileID)
+		}
+		objID = gf.IntellectualObjectID
+		identifier = gf.Identifier
+	}
+	resp := m.Context.RegistryClient.IntellectualObjectByID(objID)
+	if resp.Error != nil {
+		return resp.Error
+	}
+	obj := resp.IntellectualObject()
+	if obj == nil || obj.ID == 0 {
+		return fmt.Errorf("registry returned empty object for id %d", objID)
+	}
+	resp = m.Context.RegistryClient.InstitutionByID(obj.InstitutionID)
+	if resp.Error != nil {
+		return resp.Error
+	}
+	err := CheckPolicy(resp.Institution(), obj, time.Now().UTC())
+	if err != nil {
+		if identifier != "" {
+			err.(*PolicyError).Identifier = identifier
+		}
+		return err
+	}
+	m.policyCleared = true
+	return nil
+}
+
+// policyError returns a ProcessingError for a failed policy check.
+// It's fatal if the policy blocks deletion, since retrying won't help.
+func (m *Manager) policyError(err error) *service.ProcessingError {
+	var policyErr *PolicyError
+	return m.Error(m.itemIdentifier, err, errors.As(err, &policyErr))
+}
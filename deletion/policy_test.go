@@ -0,0 +1,75 @@
+package deletion_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/deletion"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	inst := &registry.Institution{Identifier: "test.edu", LegalHold: hold(false)}
+	obj := &registry.IntellectualObject{
+		Identifier: "test.edu/bag",
+		CreatedAt:  now.AddDate(0, 0, -30),
+		LegalHold:  hold(false),
+	}
+	assert.Nil(t, deletion.CheckPolicy(inst, obj, now))
+	assert.Nil(t, deletion.CheckPolicy(nil, obj, now))
+
+	// Retention ends 30 days after ingest, which is now.
+	inst.RetentionDays = 31
+	err := deletion.CheckPolicy(inst, obj, now)
+	require.NotNil(t, err)
+	assert.Equal(t, "Deletion of test.edu/bag is blocked: institution test.edu retains objects for 31 days after ingest, until 2024-06-02T00:00:00Z", err.Error())
+	inst.RetentionDays = 30
+	assert.Nil(t, deletion.CheckPolicy(inst, obj, now))
+
+	retainUntil := now.AddDate(1, 0, 0)
+	obj.RetainUntil = &retainUntil
+	err = deletion.CheckPolicy(inst, obj, now)
+	require.NotNil(t, err)
+	assert.Equal(t, "Deletion of test.edu/bag is blocked: object must be retained until 2025-06-01T00:00:00Z", err.Error())
+	assert.Nil(t, deletion.CheckPolicy(inst, obj, retainUntil))
+
+	// Holds come first, and the object's hold before the institution's.
+	inst.LegalHold = hold(true)
+	err = deletion.CheckPolicy(inst, obj, now)
+	require.NotNil(t, err)
+	assert.Equal(t, "Deletion of test.edu/bag is blocked: institution test.edu is under legal hold", err.Error())
+
+	obj.LegalHold = hold(true)
+	obj.LegalHoldReason = "litigation 2024-17"
+	err = deletion.CheckPolicy(inst, obj, now)
+	require.NotNil(t, err)
+	assert.Equal(t, "Deletion of test.edu/bag is blocked: object is under legal hold (litigation 2024-17)", err.Error())
+	_, isPolicyError := err.(*deletion.PolicyError)
+	assert.True(t, isPolicyError)
+}
+
+func TestCheckPolicyWithoutRegistrySupport(t *testing.T) {
+	// A Registry that doesn't support legal holds omits legal_hold.
+	// We can't tell whether such objects are held, so they must not
+	// be deleted.
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	obj, err := registry.IntellectualObjectFromJSON([]byte(`{"identifier": "test.edu/bag"}`))
+	require.Nil(t, err)
+	inst, err := registry.InstitutionFromJSON([]byte(`{"identifier": "test.edu", "legal_hold": false}`))
+	require.Nil(t, err)
+	err = deletion.CheckPolicy(inst, obj, now)
+	require.NotNil(t, err)
+	assert.Equal(t, "Deletion of test.edu/bag is blocked: Registry did not return legal hold settings, so it may not support legal holds and retention", err.Error())
+
+	obj.LegalHold = hold(false)
+	assert.Nil(t, deletion.CheckPolicy(inst, obj, now))
+	inst.LegalHold = nil
+	assert.NotNil(t, deletion.CheckPolicy(inst, obj, now))
+}
+
+func hold(onHold bool) *bool {
+	return &onHold
+}
@@ -1,10 +1,7 @@
 package fixity
 
 import (
-	ctx "context"
-	"crypto/sha256"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -16,7 +13,6 @@ import (
 	"github.com/APTrust/preservation-services/network"
 	"github.com/APTrust/preservation-services/restoration"
 	"github.com/google/uuid"
-	"github.com/minio/minio-go/v7"
 )
 
 type Checker struct {
@@ -71,6 +67,12 @@ func (c *Checker) Run() (count int, errors []*service.ProcessingError) {
 		errors = append(errors, c.Error(_err, true))
 		return 0, errors
 	}
+	if AwaitingChecksumReview(c.Context, gf.ID, checksum.Digest) {
+		err = fmt.Errorf("Skipping file %s (%d) because its Registry checksum %s is suspect and awaiting review", gf.Identifier, gf.ID, checksum.Digest)
+		c.Context.Logger.Warningf("%v", err)
+		errors = append(errors, c.Error(err, true))
+		return 0, errors
+	}
 
 	actualFixity, url, err := c.CalculateFixity(gf)
 	if err != nil {
@@ -79,19 +81,20 @@ func (c *Checker) Run() (count int, errors []*service.ProcessingError) {
 		return 0, errors
 	}
 	c.Context.Logger.Infof("Preservation file %s (%d) has fixity %s", gf.Identifier, gf.ID, actualFixity)
-	fixityMatched, err := c.RecordFixityEvent(gf, url, checksum.Digest, actualFixity)
+
+	// Don't record a failure until we've confirmed it. Registry
+	// generates depositor alerts from failed fixity events, and a
+	// bad read or a bad Registry checksum shouldn't cause alarm.
+	if actualFixity != checksum.Digest {
+		return c.HandleMismatch(gf, url, checksum.Digest, actualFixity)
+	}
+	_, err = c.RecordFixityEvent(gf, url, checksum.Digest, actualFixity)
 	if err != nil {
 		errors = append(errors, c.Error(err, true))
 		return 0, errors
 	}
-	count = 1
-	if !fixityMatched {
-		err = fmt.Errorf("Fixity mismatch for %s (%d) in %s. Expected %s, got %s.", gf.Identifier, gf.ID, url, checksum.Digest, actualFixity)
-		errors = append(errors, c.Error(err, true))
-	} else {
-		c.Context.Logger.Infof("Fixity matched for %s (%d)", gf.Identifier, gf.ID)
-	}
-	return count, errors
+	c.Context.Logger.Infof("Fixity matched for %s (%d)", gf.Identifier, gf.ID)
+	return 1, errors
 }
 
 func (c *Checker) GetGenericFile() (*registry.GenericFile, error) {
@@ -108,46 +111,59 @@ func (c *Checker) IsGlacierOnlyFile(gf *registry.GenericFile) bool {
 	return strings.HasPrefix(gf.StorageOption, "Glacier")
 }
 
+// CalculateFixity calculates the sha256 digest of the most accessible
+// copy of gf, returning the digest and the URL of the copy. If that copy
+// can't be read, it tries the next copy in restore-priority order, and
+// so on. Copies in Glacier are skipped.
 func (c *Checker) CalculateFixity(gf *registry.GenericFile) (fixity, url string, err error) {
-	// TODO: Stream S3 download through sha256 hash.
-	preservationBucket, storageRecord, err := restoration.BestRestorationSource(c.Context, gf)
-	if err != nil {
-		c.Context.Logger.Errorf("Could not find restoration source for %s (%d): %v", gf.Identifier, gf.ID, err)
-		return "", "", err
+	err = fmt.Errorf("Could not find any readable copy of %s (%d). (%d preservation URLS)", gf.Identifier, gf.ID, len(gf.StorageRecords))
+	for _, source := range restoration.RestorationSources(c.Context, gf) {
+		if !IsReadableNow(source.Bucket) {
+			continue
+		}
+		fixity, err = c.CalculateFixityAt(gf, source.Bucket, source.StorageRecord.URL)
+		if err == nil {
+			return fixity, source.StorageRecord.URL, nil
+		}
+		c.Context.Logger.Warningf("Could not check fixity of %s (%d) at %s. Trying next copy. Error: %v", gf.Identifier, gf.ID, source.StorageRecord.URL, err)
 	}
+	c.Context.Logger.Errorf("Could not calculate fixity for %s (%d): %v", gf.Identifier, gf.ID, err)
+	return "", "", err
+}
+
+// CalculateFixityAt returns the sha256 digest of the copy of gf stored
+// in preservationBucket. Param url is the StorageRecord URL of that
+// copy, which identifies saved progress in the working store.
+//
+// The object is read in ranged chunks, so a transient network error
+// costs us at most a retry, and a check interrupted by a worker restart
+// resumes from the last completed chunk.
+func (c *Checker) CalculateFixityAt(gf *registry.GenericFile, preservationBucket *common.PreservationBucket, url string) (string, error) {
 	client := c.Context.S3Clients[preservationBucket.Bucket]
 	if client == nil {
-		err = fmt.Errorf("Cannot find S3 client for provider %s", preservationBucket.Provider)
+		err := fmt.Errorf("Cannot find S3 client for provider %s", preservationBucket.Provider)
 		c.Context.Logger.Error(err.Error())
-		return "", "", err
+		return "", err
 	}
 	c.Context.Logger.Infof("Checking %s for file %s (%d) with UUID %s", preservationBucket.Bucket, gf.Identifier, gf.ID, gf.UUID)
-	obj, err := client.GetObject(
-		ctx.Background(),
-		preservationBucket.Bucket,
-		gf.UUID,
-		minio.GetObjectOptions{},
-	)
+	hasher := NewResumableHasher(client, preservationBucket.Bucket, gf.UUID, gf.ID, url, c.Context.RedisClient, c.Context.ComputeChunkSize)
+	hasher.Logger = c.Context.Logger
+	digest, err := hasher.Sum()
 	if err != nil {
-		err = fmt.Errorf("Error getting %s (%d) from S3 (%s): %v", gf.Identifier, gf.ID, storageRecord.URL, err)
-		return "", storageRecord.URL, err
+		return "", fmt.Errorf("Error streaming S3 file %s/%s through hash function: %v", preservationBucket.Bucket, gf.UUID, err)
 	}
-	defer obj.Close()
-
-	sha256Hash := sha256.New()
-	_, err = io.Copy(sha256Hash, obj)
-	if err != nil {
-		err = fmt.Errorf("Error streaming S3 file %s/%s through hash function: %v", preservationBucket.Bucket, gf.UUID, err)
-		return "", storageRecord.URL, err
-	}
-	fixity = fmt.Sprintf("%x", sha256Hash.Sum(nil))
-	return fixity, storageRecord.URL, err
+	return digest, nil
 }
 
 func (c *Checker) RecordFixityEvent(gf *registry.GenericFile, url, expectedFixity, actualFixity string) (fixityMatched bool, err error) {
 	fixityMatched = expectedFixity == actualFixity
 	event := c.GetFixityEvent(gf, url, expectedFixity, actualFixity)
+	return fixityMatched, c.SaveEvent(event)
+}
 
+// SaveEvent saves a PremisEvent to Registry, retrying if Registry is
+// temporarily unavailable.
+func (c *Checker) SaveEvent(event *registry.PremisEvent) error {
 	// Still need to work out 502s between nginx and Pharos when Pharos is busy
 	// TODO: Does this problem exist in Registry? Will have to test and see.
 	var resp *network.RegistryResponse
@@ -158,7 +174,7 @@ func (c *Checker) RecordFixityEvent(gf *registry.GenericFile, url, expectedFixit
 		}
 		time.Sleep(1 * time.Second)
 	}
-	return fixityMatched, resp.Error
+	return resp.Error
 }
 
 func (c *Checker) GetFixityEvent(gf *registry.GenericFile, url, expectedFixity, actualFixity string) *registry.PremisEvent {
@@ -0,0 +1,243 @@
+package fixity_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/fixity"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/APTrust/preservation-services/util/testutil"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bucketServer serves the same object from every bucket and records
+// which buckets were read.
+type bucketServer struct {
+	sync.Mutex
+	data  []byte
+	reads []string
+}
+
+func (s *bucketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	w.Header().Set("ETag", `"abc123"`)
+	w.Header().Set("Last-Modified", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.data)))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	s.Lock()
+	s.reads = append(s.reads, bucket)
+	s.Unlock()
+	start, end := 0, len(s.data)-1
+	if spec := strings.TrimPrefix(r.Header.Get("Range"), "bytes="); spec != "" {
+		parts := strings.SplitN(spec, "-", 2)
+		start, _ = strconv.Atoi(parts[0])
+		if n, err := strconv.Atoi(parts[1]); err == nil && n < end {
+			end = n
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(s.data)))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(s.data[start : end+1])
+}
+
+func (s *bucketServer) bucketsRead() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.reads...)
+}
+
+// checkerRegistry serves one GenericFile and records the events and
+// alert requests the checker sends.
+type checkerRegistry struct {
+	sync.Mutex
+	gf     *registry.GenericFile
+	events []*registry.PremisEvent
+	alerts int
+}
+
+func (r *checkerRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	defer r.Unlock()
+	switch {
+	case strings.Contains(req.URL.Path, "/files/show/"):
+		data, _ := json.Marshal(r.gf)
+		w.Write(data)
+	case strings.HasSuffix(req.URL.Path, "/events/create"):
+		event := &registry.PremisEvent{}
+		json.NewDecoder(req.Body).Decode(event)
+		r.events = append(r.events, event)
+		data, _ := json.Marshal(event)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(data)
+	case strings.HasSuffix(req.URL.Path, "/generate_failed_fixity_alerts"):
+		r.alerts++
+		w.Write([]byte(`{"count":0,"next":null,"previous":null,"results":[]}`))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+type checkerTest struct {
+	context  *common.Context
+	s3       *bucketServer
+	registry *checkerRegistry
+	va       *common.PreservationBucket
+	or       *common.PreservationBucket
+}
+
+// newCheckerTest sets up a file with copies in the Virginia standard
+// bucket and in Wasabi Oregon, both of which can be read without a
+// Glacier restore. Both copies contain data, and Registry's checksum
+// is registryDigest.
+func newCheckerTest(t *testing.T, data []byte, registryDigest string) *checkerTest {
+	config := common.NewConfig()
+	ct := &checkerTest{
+		s3: &bucketServer{data: data},
+		va: config.PreservationBucketsFor(constants.StorageStandard)[0],
+		or: config.PreservationBucketsFor(constants.StorageWasabiOR)[0],
+	}
+	ct.registry = &checkerRegistry{
+		gf: &registry.GenericFile{
+			ID:            testGFID,
+			Identifier:    "test.edu/bag/data/file.txt",
+			UUID:          testKey,
+			Size:          int64(len(data)),
+			State:         constants.StateActive,
+			StorageOption: constants.StorageStandard,
+			Checksums: []*registry.Checksum{
+				{Algorithm: constants.AlgSha256, Digest: registryDigest, DateTime: time.Now().UTC()},
+			},
+			StorageRecords: []*registry.StorageRecord{
+				{URL: ct.va.URLFor(testKey)},
+				{URL: ct.or.URLFor(testKey)},
+			},
+		},
+	}
+
+	s3Server := httptest.NewServer(ct.s3)
+	t.Cleanup(s3Server.Close)
+	registryServer := httptest.NewServer(ct.registry)
+	t.Cleanup(registryServer.Close)
+	fakeRedis, err := testutil.NewFakeRedis()
+	require.Nil(t, err)
+	t.Cleanup(func() { fakeRedis.Close() })
+
+	u, err := url.Parse(s3Server.URL)
+	require.Nil(t, err)
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4("key", "secret", ""),
+		Region:       "us-east-1",
+		BucketLookup: minio.BucketLookupPath,
+		MaxRetries:   1,
+	})
+	require.Nil(t, err)
+
+	log := logger.DiscardLogger("checker_test")
+	registryClient, err := network.NewRegistryClient(registryServer.URL, "v3", "user", "key", constants.AdminAPIPrefix, log)
+	require.Nil(t, err)
+	ct.context = &common.Context{
+		Config:         config,
+		Logger:         log,
+		RedisClient:    network.NewRedisClient(fakeRedis.Addr(), "", 0),
+		RegistryClient: registryClient,
+		S3Clients: map[string]*minio.Client{
+			ct.va.Bucket: client,
+			ct.or.Bucket: client,
+		},
+	}
+	return ct
+}
+
+func TestCheckerSkipsQuarantinedCopy(t *testing.T) {
+	data := testData()
+	ct := newCheckerTest(t, data, expectedDigest(data))
+	require.Nil(t, ct.context.RedisClient.QuarantineSave(&service.QuarantinedReplica{
+		GenericFileID: testGFID,
+		URL:           ct.va.URLFor(testKey),
+		Verdict:       fixity.VerdictReplicaCorrupt,
+	}))
+
+	count, errors := fixity.NewChecker(ct.context, testGFID).Run()
+	require.Empty(t, errors)
+	assert.Equal(t, 1, count)
+
+	// The quarantined copy is in the more accessible bucket, but
+	// the checker reads the other one.
+	require.NotEmpty(t, ct.s3.bucketsRead())
+	for _, bucket := range ct.s3.bucketsRead() {
+		assert.Equal(t, ct.or.Bucket, bucket)
+	}
+	require.Equal(t, 1, len(ct.registry.events))
+	assert.Equal(t, string(constants.StatusSuccess), ct.registry.events[0].Outcome)
+	assert.Contains(t, ct.registry.events[0].OutcomeInformation, ct.or.URLFor(testKey))
+}
+
+func TestCheckerSuspectChecksum(t *testing.T) {
+	data := testData()
+	ct := newCheckerTest(t, data, strings.Repeat("0", 64))
+
+	// Both copies agree with each other but not with Registry. That's
+	// not a fixity failure, so there's no failed event, no depositor
+	// alert and no quarantine.
+	count, errors := fixity.NewChecker(ct.context, testGFID).Run()
+	assert.Equal(t, 0, count)
+	require.Equal(t, 1, len(errors))
+	assert.True(t, errors[0].IsFatal)
+	assert.Contains(t, errors[0].Message, "Registry checksum")
+	assert.Empty(t, ct.registry.events)
+	assert.Equal(t, 0, ct.registry.alerts)
+	for _, sr := range ct.registry.gf.StorageRecords {
+		quarantined, err := ct.context.RedisClient.IsQuarantined(testGFID, sr.URL)
+		require.Nil(t, err)
+		assert.False(t, quarantined)
+	}
+	assert.ElementsMatch(t, []string{ct.va.Bucket, ct.or.Bucket}, uniqueBuckets(ct.s3.bucketsRead()))
+
+	// Until someone fixes the Registry checksum, the file is skipped
+	// without reading it again.
+	readsBefore := len(ct.s3.bucketsRead())
+	count, errors = fixity.NewChecker(ct.context, testGFID).Run()
+	assert.Equal(t, 0, count)
+	require.Equal(t, 1, len(errors))
+	assert.Contains(t, errors[0].Message, "awaiting review")
+	assert.Equal(t, readsBefore, len(ct.s3.bucketsRead()))
+
+	// Once it's fixed, the file is checked as usual.
+	ct.registry.gf.Checksums[0].Digest = expectedDigest(data)
+	count, errors = fixity.NewChecker(ct.context, testGFID).Run()
+	require.Empty(t, errors)
+	assert.Equal(t, 1, count)
+}
+
+func uniqueBuckets(buckets []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0)
+	for _, bucket := range buckets {
+		if !seen[bucket] {
+			seen[bucket] = true
+			unique = append(unique, bucket)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,207 @@
+package fixity
+
+import (
+	ctx "context"
+	"crypto/sha256"
+	"encoding"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/minio/minio-go/v7"
+	"github.com/op/go-logging"
+)
+
+// ProgressStore saves and retrieves the progress of fixity checks
+// on large files. network.RedisClient implements this.
+type ProgressStore interface {
+	FixityProgressGet(genericFileID int64, url string) (*service.FixityProgress, error)
+	FixityProgressSave(progress *service.FixityProgress) error
+	FixityProgressDelete(genericFileID int64, url string) error
+}
+
+// ResumableHasher calculates the sha256 digest of an S3 object using a
+// series of ranged GET requests. If a request fails partway through,
+// the hasher retries from the last byte it successfully hashed rather
+// than from the start of the file. After each chunk, it saves the
+// digest state to Store, so a check that is interrupted entirely
+// (e.g. by a worker restart) can resume on the next attempt.
+//
+// This matters for multi-terabyte files, where a single network blip
+// would otherwise mean starting a many-hour download over from zero.
+type ResumableHasher struct {
+	Client        *minio.Client
+	Bucket        string
+	Key           string
+	GenericFileID int64
+	URL           string
+
+	// Store persists progress between attempts. It may be nil, in
+	// which case the hasher still retries within a single call to Sum,
+	// but cannot resume after the process exits.
+	Store ProgressStore
+
+	// ChunkSizeFor returns the size of each ranged request for an
+	// object of the given size.
+	ChunkSizeFor func(objectSize int64) int64
+
+	// MaxRetries is the number of times to retry a chunk that fails
+	// without making any progress. Retries that make some progress
+	// before failing again don't count against the limit.
+	MaxRetries int
+
+	// RetryDelay is the pause between retries.
+	RetryDelay time.Duration
+
+	Logger *logging.Logger
+}
+
+// NewResumableHasher returns a ResumableHasher with sensible defaults
+// for retries. Param url is the StorageRecord URL of the object, which
+// identifies its progress record in store.
+func NewResumableHasher(client *minio.Client, bucket, key string, genericFileID int64, url string, store ProgressStore, chunkSizeFor func(int64) int64) *ResumableHasher {
+	return &ResumableHasher{
+		Client:        client,
+		Bucket:        bucket,
+		Key:           key,
+		GenericFileID: genericFileID,
+		URL:           url,
+		Store:         store,
+		ChunkSizeFor:  chunkSizeFor,
+		MaxRetries:    5,
+		RetryDelay:    5 * time.Second,
+	}
+}
+
+// Sum returns the hex-encoded sha256 digest of the object.
+func (h *ResumableHasher) Sum() (string, error) {
+	info, err := h.Client.StatObject(ctx.Background(), h.Bucket, h.Key, minio.StatObjectOptions{})
+	if err != nil {
+		return "", fmt.Errorf("Error getting info for %s/%s: %v", h.Bucket, h.Key, err)
+	}
+	sha256Hash, progress := h.loadProgress(info)
+	chunkSize := h.ChunkSizeFor(info.Size)
+
+	for progress.Offset < info.Size {
+		end := progress.Offset + chunkSize - 1
+		if end >= info.Size {
+			end = info.Size - 1
+		}
+		err = h.hashChunk(sha256Hash, progress, end)
+		if err != nil {
+			return "", err
+		}
+		h.saveProgress(sha256Hash, progress)
+	}
+
+	if h.Store != nil {
+		err = h.Store.FixityProgressDelete(h.GenericFileID, h.URL)
+		if err != nil {
+			h.warn("Could not delete fixity progress for %s: %v", h.URL, err)
+		}
+	}
+	return fmt.Sprintf("%x", sha256Hash.Sum(nil)), nil
+}
+
+// hashChunk hashes bytes progress.Offset through end, inclusive,
+// advancing progress.Offset as it goes.
+func (h *ResumableHasher) hashChunk(sha256Hash hash.Hash, progress *service.FixityProgress, end int64) error {
+	failures := 0
+	for progress.Offset <= end {
+		n, err := h.copyRange(sha256Hash, progress.Offset, end)
+		progress.Offset += n
+		if err == nil && progress.Offset > end {
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("response ended early at byte %d", progress.Offset)
+		}
+		if n == 0 {
+			failures++
+		}
+		if failures > h.MaxRetries {
+			return fmt.Errorf("Error reading %s/%s at byte %d after %d retries: %v", h.Bucket, h.Key, progress.Offset, h.MaxRetries, err)
+		}
+		h.warn("Error reading %s/%s at byte %d (retry %d of %d): %v", h.Bucket, h.Key, progress.Offset, failures, h.MaxRetries, err)
+		time.Sleep(h.RetryDelay)
+	}
+	return nil
+}
+
+// copyRange copies bytes start through end of the object into w. It
+// returns the number of bytes copied, which may be non-zero even when
+// there's an error.
+func (h *ResumableHasher) copyRange(w io.Writer, start, end int64) (int64, error) {
+	opts := minio.GetObjectOptions{}
+	err := opts.SetRange(start, end)
+	if err != nil {
+		return 0, err
+	}
+	obj, err := h.Client.GetObject(ctx.Background(), h.Bucket, h.Key, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer obj.Close()
+	return io.Copy(w, io.LimitReader(obj, end-start+1))
+}
+
+// loadProgress returns a hash and progress record, restored from the
+// store if there's usable saved progress for this exact object.
+func (h *ResumableHasher) loadProgress(info minio.ObjectInfo) (hash.Hash, *service.FixityProgress) {
+	sha256Hash := sha256.New()
+	fresh := &service.FixityProgress{
+		GenericFileID: h.GenericFileID,
+		URL:           h.URL,
+		ETag:          info.ETag,
+		Size:          info.Size,
+	}
+	if h.Store == nil {
+		return sha256Hash, fresh
+	}
+	saved, err := h.Store.FixityProgressGet(h.GenericFileID, h.URL)
+	if err != nil || saved == nil {
+		return sha256Hash, fresh
+	}
+	if saved.ETag != info.ETag || saved.Size != info.Size || saved.Offset > info.Size {
+		h.info("Discarding saved fixity progress for %s because the object changed", h.URL)
+		return sha256Hash, fresh
+	}
+	err = sha256Hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(saved.HashState)
+	if err != nil {
+		h.warn("Discarding unreadable fixity progress for %s: %v", h.URL, err)
+		return sha256.New(), fresh
+	}
+	h.info("Resuming fixity check of %s at byte %d of %d", h.URL, saved.Offset, saved.Size)
+	return sha256Hash, saved
+}
+
+func (h *ResumableHasher) saveProgress(sha256Hash hash.Hash, progress *service.FixityProgress) {
+	if h.Store == nil {
+		return
+	}
+	state, err := sha256Hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		h.warn("Could not save fixity progress for %s: %v", h.URL, err)
+		return
+	}
+	progress.HashState = state
+	progress.UpdatedAt = time.Now().UTC()
+	err = h.Store.FixityProgressSave(progress)
+	if err != nil {
+		h.warn("Could not save fixity progress for %s: %v", h.URL, err)
+	}
+}
+
+func (h *ResumableHasher) info(format string, args ...interface{}) {
+	if h.Logger != nil {
+		h.Logger.Infof(format, args...)
+	}
+}
+
+func (h *ResumableHasher) warn(format string, args ...interface{}) {
+	if h.Logger != nil {
+		h.Logger.Warningf(format, args...)
+	}
+}
@@ -0,0 +1,307 @@
+package fixity_test
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/fixity"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testBucket    = "preservation-test"
+	testKey       = "2b4e3a5c-5f4f-4f8b-9a4b-000000000001"
+	testURL       = "https://example.com/preservation-test/2b4e3a5c-5f4f-4f8b-9a4b-000000000001"
+	testGFID      = int64(1234)
+	testChunkSize = int64(1000)
+	testDataSize  = 10500
+)
+
+// faultyS3 is a minimal S3 server that supports HEAD and ranged GET
+// on a single object. Its fault function decides, for each GET, how
+// many bytes of the requested range to send before dropping the
+// connection. A negative value means no fault.
+type faultyS3 struct {
+	sync.Mutex
+	data     []byte
+	etag     string
+	fault    func(requestNum int, start int64) int
+	requests []int64
+}
+
+func newFaultyS3(data []byte) *faultyS3 {
+	return &faultyS3{
+		data:  data,
+		etag:  fmt.Sprintf("%x", sha256.Sum256(data))[:32],
+		fault: func(int, int64) int { return -1 },
+	}
+}
+
+func (s *faultyS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/"+testBucket+"/"+testKey {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", `"`+s.etag+`"`)
+	w.Header().Set("Last-Modified", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.data)))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	start, end := s.parseRange(r.Header.Get("Range"))
+
+	s.Lock()
+	requestNum := len(s.requests)
+	s.requests = append(s.requests, start)
+	sendBytes := s.fault(requestNum, start)
+	s.Unlock()
+
+	if sendBytes == 0 {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>InternalError</Code><Message>Injected fault</Message></Error>`)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(s.data)))
+	w.WriteHeader(http.StatusPartialContent)
+	body := s.data[start : end+1]
+	if sendBytes > 0 && sendBytes < len(body) {
+		w.Write(body[:sendBytes])
+		w.(http.Flusher).Flush()
+		// Drop the connection mid-response.
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(body)
+}
+
+func (s *faultyS3) parseRange(header string) (int64, int64) {
+	start, end := int64(0), int64(len(s.data)-1)
+	spec := strings.TrimPrefix(header, "bytes=")
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) == 2 {
+		if n, err := strconv.ParseInt(parts[0], 10, 64); err == nil {
+			start = n
+		}
+		if n, err := strconv.ParseInt(parts[1], 10, 64); err == nil && n < end {
+			end = n
+		}
+	}
+	return start, end
+}
+
+func (s *faultyS3) requestStarts() []int64 {
+	s.Lock()
+	defer s.Unlock()
+	return append([]int64{}, s.requests...)
+}
+
+// memoryProgressStore is an in-memory fixity.ProgressStore.
+type memoryProgressStore struct {
+	items map[string]*service.FixityProgress
+	saves int
+}
+
+func newMemoryProgressStore() *memoryProgressStore {
+	return &memoryProgressStore{items: make(map[string]*service.FixityProgress)}
+}
+
+func (m *memoryProgressStore) FixityProgressGet(gfID int64, url string) (*service.FixityProgress, error) {
+	p := m.items[fmt.Sprintf("%d:%s", gfID, url)]
+	if p == nil {
+		return nil, nil
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (m *memoryProgressStore) FixityProgressSave(progress *service.FixityProgress) error {
+	copied := *progress
+	m.items[fmt.Sprintf("%d:%s", progress.GenericFileID, progress.URL)] = &copied
+	m.saves++
+	return nil
+}
+
+func (m *memoryProgressStore) FixityProgressDelete(gfID int64, url string) error {
+	delete(m.items, fmt.Sprintf("%d:%s", gfID, url))
+	return nil
+}
+
+func testData() []byte {
+	data := make([]byte, testDataSize)
+	rand.New(rand.NewSource(42)).Read(data)
+	return data
+}
+
+func newTestHasher(t *testing.T, serverURL string, store fixity.ProgressStore) *fixity.ResumableHasher {
+	u, err := url.Parse(serverURL)
+	require.Nil(t, err)
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4("key", "secret", ""),
+		Secure:       false,
+		Region:       "us-east-1",
+		BucketLookup: minio.BucketLookupPath,
+		MaxRetries:   1,
+	})
+	require.Nil(t, err)
+	hasher := fixity.NewResumableHasher(client, testBucket, testKey, testGFID, testURL, store, func(int64) int64 { return testChunkSize })
+	hasher.RetryDelay = 0
+	return hasher
+}
+
+func expectedDigest(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+func TestResumableHasherNoFaults(t *testing.T) {
+	data := testData()
+	s3 := newFaultyS3(data)
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	store := newMemoryProgressStore()
+	digest, err := newTestHasher(t, server.URL, store).Sum()
+	require.Nil(t, err)
+	assert.Equal(t, expectedDigest(data), digest)
+
+	// One request per chunk, in order
+	starts := s3.requestStarts()
+	require.Equal(t, 11, len(starts))
+	for i, start := range starts {
+		assert.Equal(t, int64(i)*testChunkSize, start)
+	}
+
+	// Progress is saved after each chunk and cleared at the end
+	assert.Equal(t, 11, store.saves)
+	assert.Empty(t, store.items)
+}
+
+func TestResumableHasherRetriesMidChunk(t *testing.T) {
+	data := testData()
+	s3 := newFaultyS3(data)
+
+	// Drop every other connection partway through the response.
+	s3.fault = func(requestNum int, start int64) int {
+		if requestNum%2 == 0 {
+			return 300
+		}
+		return -1
+	}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	digest, err := newTestHasher(t, server.URL, newMemoryProgressStore()).Sum()
+	require.Nil(t, err)
+	assert.Equal(t, expectedDigest(data), digest)
+
+	// Each retry picks up where the dropped response left off,
+	// rather than at the start of the chunk.
+	starts := s3.requestStarts()
+	assert.Equal(t, int64(0), starts[0])
+	assert.Equal(t, int64(300), starts[1])
+	assert.Equal(t, int64(1000), starts[2])
+	assert.Equal(t, int64(1300), starts[3])
+}
+
+func TestResumableHasherGivesUp(t *testing.T) {
+	data := testData()
+	s3 := newFaultyS3(data)
+	s3.fault = func(requestNum int, start int64) int {
+		if start >= 5000 {
+			return 0
+		}
+		return -1
+	}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	store := newMemoryProgressStore()
+	hasher := newTestHasher(t, server.URL, store)
+	hasher.MaxRetries = 2
+	_, err := hasher.Sum()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "at byte 5000 after 2 retries")
+
+	// Five good chunks, then three failed attempts at the sixth.
+	assert.Equal(t, 8, len(s3.requestStarts()))
+
+	// Progress through the last good chunk is saved.
+	progress, err := store.FixityProgressGet(testGFID, testURL)
+	require.Nil(t, err)
+	require.NotNil(t, progress)
+	assert.Equal(t, int64(5000), progress.Offset)
+	assert.Equal(t, int64(testDataSize), progress.Size)
+	assert.Equal(t, s3.etag, progress.ETag)
+
+	// When the fault clears, a new hasher (as after a worker restart)
+	// resumes from the saved offset.
+	s3.Lock()
+	s3.fault = func(int, int64) int { return -1 }
+	s3.requests = nil
+	s3.Unlock()
+
+	digest, err := newTestHasher(t, server.URL, store).Sum()
+	require.Nil(t, err)
+	assert.Equal(t, expectedDigest(data), digest)
+	starts := s3.requestStarts()
+	require.Equal(t, 6, len(starts))
+	assert.Equal(t, int64(5000), starts[0])
+	assert.Empty(t, store.items)
+}
+
+func TestResumableHasherRestartsWhenObjectChanged(t *testing.T) {
+	data := testData()
+	s3 := newFaultyS3(data)
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	partial := sha256.New()
+	partial.Write(data[:4000])
+	state, err := partial.(encoding.BinaryMarshaler).MarshalBinary()
+	require.Nil(t, err)
+
+	store := newMemoryProgressStore()
+	store.FixityProgressSave(&service.FixityProgress{
+		GenericFileID: testGFID,
+		URL:           testURL,
+		ETag:          "some-other-etag",
+		Size:          testDataSize,
+		Offset:        4000,
+		HashState:     state,
+	})
+	digest, err := newTestHasher(t, server.URL, store).Sum()
+	require.Nil(t, err)
+	assert.Equal(t, expectedDigest(data), digest)
+	assert.Equal(t, int64(0), s3.requestStarts()[0])
+
+	// Same progress with the right ETag resumes at 4000.
+	s3.requests = nil
+	store.FixityProgressSave(&service.FixityProgress{
+		GenericFileID: testGFID,
+		URL:           testURL,
+		ETag:          s3.etag,
+		Size:          testDataSize,
+		Offset:        4000,
+		HashState:     state,
+	})
+	digest, err = newTestHasher(t, server.URL, store).Sum()
+	require.Nil(t, err)
+	assert.Equal(t, expectedDigest(data), digest)
+	assert.Equal(t, int64(4000), s3.requestStarts()[0])
+}
@@ -0,0 +1,280 @@
+package fixity
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/restoration"
+)
+
+const (
+	// VerdictTransient means a second read of the same copy matched
+	// Registry, so the original mismatch was a bad read.
+	VerdictTransient = "transient"
+
+	// VerdictReplicaCorrupt means an independent replica matched
+	// Registry, so the copy that failed is bad and should be repaired
+	// from the good one.
+	VerdictReplicaCorrupt = "replica_corrupt"
+
+	// VerdictRegistryChecksumSuspect means all replicas agree with each
+	// other but not with Registry. The stored data is probably fine and
+	// the Registry checksum is probably wrong. This is not reported as
+	// a fixity failure. See HandleMismatch.
+	VerdictRegistryChecksumSuspect = "registry_checksum_suspect"
+
+	// VerdictReplicasDisagree means no replica matched Registry and the
+	// replicas don't match each other either.
+	VerdictReplicasDisagree = "replicas_disagree"
+
+	// VerdictSingleCopyMismatch means the file has no independent
+	// replica we can read right now, and a second read of the same copy
+	// produced the same wrong digest.
+	VerdictSingleCopyMismatch = "single_copy_mismatch"
+
+	// VerdictInconclusive means we could not read any copy a second
+	// time, so the mismatch could not be confirmed or ruled out.
+	VerdictInconclusive = "inconclusive"
+)
+
+// IsConfirmedFailure returns true if verdict describes a real fixity
+// problem with stored data that should be recorded in Registry and
+// reported to the depositor.
+func IsConfirmedFailure(verdict string) bool {
+	return verdict != VerdictTransient &&
+		verdict != VerdictInconclusive &&
+		verdict != VerdictRegistryChecksumSuspect
+}
+
+// DetermineVerdict decides what a fixity mismatch means, based on the
+// digests in comparison. The first replica in comparison is the copy
+// on which the mismatch was detected. The others are either
+// independent replicas or a re-read of the first.
+func DetermineVerdict(comparison *service.FixityComparison) string {
+	primary := comparison.Replicas[0]
+	independent := make([]*service.ReplicaDigest, 0)
+	var reread *service.ReplicaDigest
+	for _, replica := range comparison.Replicas[1:] {
+		if replica.Error != "" {
+			continue
+		}
+		if replica.Reread {
+			reread = replica
+		} else {
+			independent = append(independent, replica)
+		}
+	}
+	if len(independent) == 0 {
+		if reread == nil {
+			return VerdictInconclusive
+		}
+		if reread.Matches {
+			return VerdictTransient
+		}
+		return VerdictSingleCopyMismatch
+	}
+	allAgree := true
+	for _, replica := range independent {
+		if replica.Matches {
+			return VerdictReplicaCorrupt
+		}
+		if replica.Digest != primary.Digest {
+			allAgree = false
+		}
+	}
+	if allAgree {
+		return VerdictRegistryChecksumSuspect
+	}
+	return VerdictReplicasDisagree
+}
+
+// ReVerify re-checks a file whose digest at url did not match the
+// Registry checksum. It reads every other copy that can be read
+// without a Glacier restore. If there are none, it reads the original
+// copy a second time. The result is saved to the working store.
+func (c *Checker) ReVerify(gf *registry.GenericFile, url, expectedFixity, actualFixity string) *service.FixityComparison {
+	comparison := &service.FixityComparison{
+		GenericFileID:         gf.ID,
+		GenericFileIdentifier: gf.Identifier,
+		Algorithm:             constants.AlgSha256,
+		ExpectedDigest:        expectedFixity,
+		ComparedAt:            time.Now().UTC(),
+		Replicas: []*service.ReplicaDigest{
+			{
+				URL:     url,
+				Digest:  actualFixity,
+				Matches: false,
+			},
+		},
+	}
+	primaryBucket, _, err := c.Context.Config.BucketAndKeyFor(url)
+	if err == nil {
+		comparison.Replicas[0].Bucket = primaryBucket.Bucket
+	}
+	for _, sr := range gf.StorageRecords {
+		if sr.URL == url || restoration.IsQuarantined(c.Context, gf, sr.URL) {
+			continue
+		}
+		bucket, _, err := c.Context.Config.BucketAndKeyFor(sr.URL)
+		if err != nil || !IsReadableNow(bucket) {
+			continue
+		}
+		comparison.Replicas = append(comparison.Replicas, c.replicaDigest(gf, bucket, sr.URL, expectedFixity, false))
+	}
+	if len(comparison.Replicas) == 1 && primaryBucket != nil {
+		c.Context.Logger.Infof("%s (%d) has no independent replica to compare. Re-reading %s.", gf.Identifier, gf.ID, url)
+		comparison.Replicas = append(comparison.Replicas, c.replicaDigest(gf, primaryBucket, url, expectedFixity, true))
+	}
+	comparison.Verdict = DetermineVerdict(comparison)
+	c.Context.Logger.Warningf("Fixity re-verification for %s (%d): %s", gf.Identifier, gf.ID, comparison.Verdict)
+	err = c.Context.RedisClient.FixityComparisonSave(comparison)
+	if err != nil {
+		c.Context.Logger.Errorf("Could not save fixity comparison for %s (%d): %v", gf.Identifier, gf.ID, err)
+	}
+	return comparison
+}
+
+func (c *Checker) replicaDigest(gf *registry.GenericFile, bucket *common.PreservationBucket, url, expectedFixity string, reread bool) *service.ReplicaDigest {
+	replica := &service.ReplicaDigest{
+		Bucket: bucket.Bucket,
+		URL:    url,
+		Reread: reread,
+	}
+	digest, err := c.CalculateFixityAt(gf, bucket, url)
+	if err != nil {
+		c.Context.Logger.Warningf("Re-verification of %s at %s failed: %v", gf.Identifier, url, err)
+		replica.Error = err.Error()
+		return replica
+	}
+	replica.Digest = digest
+	replica.Matches = digest == expectedFixity
+	return replica
+}
+
+// IsReadableNow returns true if objects in bucket can be read directly,
+// without first requesting a Glacier restore.
+func IsReadableNow(bucket *common.PreservationBucket) bool {
+	return !restoration.IsGlacierBucket(bucket)
+}
+
+// HandleMismatch runs the failure workflow for a file whose digest at
+// url did not match Registry. It re-verifies the file and then acts on
+// the verdict:
+//
+// A transient mismatch is recorded as a successful check of the
+// second read. An inconclusive check returns a non-fatal error so the
+// worker will retry later. A suspect Registry checksum goes to APTrust
+// staff instead of the depositor. See reportSuspectChecksum. Confirmed
+// failures are recorded in Registry with the verdict in the outcome
+// information, and bad copies are quarantined in the working store.
+// Finally, we ask Registry to generate failed fixity alerts.
+func (c *Checker) HandleMismatch(gf *registry.GenericFile, url, expectedFixity, actualFixity string) (count int, errors []*service.ProcessingError) {
+	comparison := c.ReVerify(gf, url, expectedFixity, actualFixity)
+
+	if comparison.Verdict == VerdictTransient {
+		reread := comparison.Replicas[len(comparison.Replicas)-1]
+		event := c.GetFixityEvent(gf, reread.URL, expectedFixity, reread.Digest)
+		event.OutcomeInformation += fmt.Sprintf(". First read returned %s; second read matched.", actualFixity)
+		err := c.SaveEvent(event)
+		if err != nil {
+			errors = append(errors, c.Error(err, true))
+			return 0, errors
+		}
+		return 1, errors
+	}
+
+	if comparison.Verdict == VerdictInconclusive {
+		err := fmt.Errorf("Fixity mismatch for %s (%d) in %s could not be confirmed because no copy could be re-read. Will retry.", gf.Identifier, gf.ID, url)
+		errors = append(errors, c.Error(err, false))
+		return 0, errors
+	}
+
+	if comparison.Verdict == VerdictRegistryChecksumSuspect {
+		errors = append(errors, c.reportSuspectChecksum(gf, comparison))
+		return 0, errors
+	}
+
+	for _, replica := range comparison.Replicas {
+		if replica.Error != "" || replica.Reread {
+			continue
+		}
+		event := c.GetFixityEvent(gf, replica.URL, expectedFixity, replica.Digest)
+		event.OutcomeInformation += fmt.Sprintf(". Re-verification verdict: %s", comparison.Verdict)
+		err := c.SaveEvent(event)
+		if err != nil {
+			errors = append(errors, c.Error(err, true))
+			return 0, errors
+		}
+	}
+	c.quarantineBadReplicas(gf, comparison)
+
+	resp := c.Context.RegistryClient.GenerateFailedFixityAlerts()
+	if resp.Error != nil {
+		c.Context.Logger.Errorf("Error generating failed fixity alerts: %v", resp.Error)
+	}
+
+	err := fmt.Errorf("Fixity mismatch for %s (%d) in %s confirmed (%s). Expected %s, got %s.", gf.Identifier, gf.ID, url, comparison.Verdict, expectedFixity, actualFixity)
+	errors = append(errors, c.Error(err, true))
+	return 1, errors
+}
+
+// reportSuspectChecksum handles a file whose copies all agree with
+// each other but not with Registry. The data is probably fine, so we
+// don't record a failed fixity event, which would alert the depositor,
+// and we don't quarantine anything. Instead, we log it for APTrust
+// staff, who can find the comparison in the working store, and return
+// a fatal error so the file is not retried. Until someone fixes the
+// Registry checksum, AwaitingChecksumReview tells the checker to skip
+// this file and QueueFixity not to queue it.
+func (c *Checker) reportSuspectChecksum(gf *registry.GenericFile, comparison *service.FixityComparison) *service.ProcessingError {
+	err := fmt.Errorf("Registry checksum for %s (%d) is suspect. All %d copies have sha256 %s, but Registry says %s. No fixity failure was recorded. APTrust staff should review the Registry checksum.",
+		gf.Identifier, gf.ID, len(comparison.Replicas), comparison.Replicas[0].Digest, comparison.ExpectedDigest)
+	c.Context.Logger.Error(err.Error())
+	return c.Error(err, true)
+}
+
+// AwaitingChecksumReview returns true if an earlier check found that
+// Registry's checksum registryDigest for the file is suspect.
+// Registry's digest is part of the test, so once staff correct the
+// checksum, the file is checked as usual. The fixity checker skips
+// these files, and QueueFixity doesn't queue them.
+func AwaitingChecksumReview(context *common.Context, genericFileID int64, registryDigest string) bool {
+	if context.RedisClient == nil {
+		return false
+	}
+	comparison, err := context.RedisClient.FixityComparisonGet(genericFileID)
+	if err != nil || comparison == nil {
+		return false
+	}
+	return comparison.Verdict == VerdictRegistryChecksumSuspect &&
+		comparison.ExpectedDigest == registryDigest
+}
+
+// quarantineBadReplicas marks each copy that failed re-verification
+// as quarantined, so restoration and later fixity checks skip it.
+func (c *Checker) quarantineBadReplicas(gf *registry.GenericFile, comparison *service.FixityComparison) {
+	for _, replica := range comparison.Replicas {
+		if replica.Error != "" || replica.Reread || replica.Matches {
+			continue
+		}
+		quarantined := &service.QuarantinedReplica{
+			GenericFileID:         gf.ID,
+			GenericFileIdentifier: gf.Identifier,
+			URL:                   replica.URL,
+			ExpectedDigest:        comparison.ExpectedDigest,
+			ActualDigest:          replica.Digest,
+			Verdict:               comparison.Verdict,
+			QuarantinedAt:         time.Now().UTC(),
+		}
+		err := c.Context.RedisClient.QuarantineSave(quarantined)
+		if err != nil {
+			c.Context.Logger.Errorf("Could not quarantine %s: %v", replica.URL, err)
+		} else {
+			c.Context.Logger.Warningf("Quarantined %s (%s)", replica.URL, comparison.Verdict)
+		}
+	}
+}
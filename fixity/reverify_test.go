@@ -0,0 +1,72 @@
+package fixity_test
+
+import (
+	"testing"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/fixity"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/stretchr/testify/assert"
+)
+
+const expected = "1111"
+
+func comparisonWith(replicas ...*service.ReplicaDigest) *service.FixityComparison {
+	primary := &service.ReplicaDigest{URL: "https://example.com/va/uuid", Digest: "2222"}
+	return &service.FixityComparison{
+		ExpectedDigest: expected,
+		Replicas:       append([]*service.ReplicaDigest{primary}, replicas...),
+	}
+}
+
+func replica(digest string) *service.ReplicaDigest {
+	return &service.ReplicaDigest{
+		URL:     "https://example.com/or/uuid",
+		Digest:  digest,
+		Matches: digest == expected,
+	}
+}
+
+func TestDetermineVerdict(t *testing.T) {
+	// Another copy matches Registry
+	assert.Equal(t, fixity.VerdictReplicaCorrupt, fixity.DetermineVerdict(comparisonWith(replica(expected))))
+	assert.Equal(t, fixity.VerdictReplicaCorrupt, fixity.DetermineVerdict(comparisonWith(replica("3333"), replica(expected))))
+
+	// All copies agree, but not with Registry
+	assert.Equal(t, fixity.VerdictRegistryChecksumSuspect, fixity.DetermineVerdict(comparisonWith(replica("2222"))))
+
+	// Nothing agrees with anything
+	assert.Equal(t, fixity.VerdictReplicasDisagree, fixity.DetermineVerdict(comparisonWith(replica("3333"))))
+
+	// Only a re-read of the same copy
+	reread := replica(expected)
+	reread.Reread = true
+	assert.Equal(t, fixity.VerdictTransient, fixity.DetermineVerdict(comparisonWith(reread)))
+	reread = replica("2222")
+	reread.Reread = true
+	assert.Equal(t, fixity.VerdictSingleCopyMismatch, fixity.DetermineVerdict(comparisonWith(reread)))
+
+	// Couldn't read anything else. Errored replicas don't count.
+	failed := replica("")
+	failed.Error = "connection reset"
+	assert.Equal(t, fixity.VerdictInconclusive, fixity.DetermineVerdict(comparisonWith(failed)))
+	assert.Equal(t, fixity.VerdictInconclusive, fixity.DetermineVerdict(comparisonWith()))
+	assert.Equal(t, fixity.VerdictReplicaCorrupt, fixity.DetermineVerdict(comparisonWith(failed, replica(expected))))
+}
+
+func TestIsConfirmedFailure(t *testing.T) {
+	assert.False(t, fixity.IsConfirmedFailure(fixity.VerdictTransient))
+	assert.False(t, fixity.IsConfirmedFailure(fixity.VerdictInconclusive))
+	assert.True(t, fixity.IsConfirmedFailure(fixity.VerdictReplicaCorrupt))
+	assert.False(t, fixity.IsConfirmedFailure(fixity.VerdictRegistryChecksumSuspect))
+	assert.True(t, fixity.IsConfirmedFailure(fixity.VerdictReplicasDisagree))
+	assert.True(t, fixity.IsConfirmedFailure(fixity.VerdictSingleCopyMismatch))
+}
+
+func TestIsReadableNow(t *testing.T) {
+	assert.True(t, fixity.IsReadableNow(&common.PreservationBucket{StorageClass: constants.StorageClassStandard}))
+	assert.True(t, fixity.IsReadableNow(&common.PreservationBucket{StorageClass: constants.StorageClassWasabi}))
+	assert.False(t, fixity.IsReadableNow(&common.PreservationBucket{StorageClass: constants.StorageClassGlacier}))
+	assert.False(t, fixity.IsReadableNow(&common.PreservationBucket{StorageClass: constants.StorageClassGlacierDeep}))
+}
@@ -0,0 +1,201 @@
+package fixity
+
+import (
+	"sort"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/registry"
+)
+
+const (
+	// ReasonPriorFailure means the file failed a fixity check recently.
+	ReasonPriorFailure = "prior failure"
+
+	// ReasonReingested means the file's record was updated (usually by
+	// a reingest) after its last fixity check was already due.
+	ReasonReingested = "reingested"
+
+	// ReasonOverdue means the file is simply past its fixity interval.
+	ReasonOverdue = "overdue"
+)
+
+// reasonRank orders reasons from most to least urgent.
+var reasonRank = map[string]int{
+	ReasonPriorFailure: 0,
+	ReasonReingested:   1,
+	ReasonOverdue:      2,
+}
+
+// Candidate is a GenericFile that is due for a fixity check, along
+// with the reason it was selected.
+type Candidate struct {
+	GenericFile *registry.GenericFile
+	Reason      string
+}
+
+// MoreUrgentThan returns true if this candidate should be checked
+// before other. Prior failures come first, then reingests, then
+// files with the oldest fixity checks.
+func (c *Candidate) MoreUrgentThan(other *Candidate) bool {
+	if reasonRank[c.Reason] != reasonRank[other.Reason] {
+		return reasonRank[c.Reason] < reasonRank[other.Reason]
+	}
+	return c.GenericFile.LastFixityCheck.Before(other.GenericFile.LastFixityCheck)
+}
+
+// CandidateFetcher returns the next batch of candidates from some
+// source, such as a page of Registry results. It returns an empty
+// slice when the source is exhausted.
+type CandidateFetcher func() ([]*Candidate, error)
+
+// InstitutionQueue supplies fixity candidates for a single
+// institution. It draws from its fetchers in order, so callers should
+// add the most urgent sources first. Files that appear in more than
+// one source are returned only once.
+type InstitutionQueue struct {
+	InstitutionID         int64
+	InstitutionIdentifier string
+
+	// Overdue is the number of this institution's files that are
+	// past their fixity interval, as reported by Registry.
+	Overdue int
+
+	// OldestCheck is the oldest last fixity check date among this
+	// institution's overdue files.
+	OldestCheck time.Time
+
+	Queued      int
+	QueuedBytes int64
+
+	fetchers []CandidateFetcher
+	buffer   []*Candidate
+	seen     map[int64]bool
+	err      error
+}
+
+// NewInstitutionQueue returns a new InstitutionQueue.
+func NewInstitutionQueue(institutionID int64, identifier string) *InstitutionQueue {
+	return &InstitutionQueue{
+		InstitutionID:         institutionID,
+		InstitutionIdentifier: identifier,
+		fetchers:              make([]CandidateFetcher, 0),
+		buffer:                make([]*Candidate, 0),
+		seen:                  make(map[int64]bool),
+	}
+}
+
+// AddFetcher adds a source of candidates to this queue.
+func (q *InstitutionQueue) AddFetcher(fetcher CandidateFetcher) {
+	q.fetchers = append(q.fetchers, fetcher)
+}
+
+// Err returns the first error this queue encountered while fetching
+// candidates. Once a queue has an error, it returns no more candidates.
+func (q *InstitutionQueue) Err() error {
+	return q.err
+}
+
+// Peek returns the next candidate without removing it from the queue,
+// or nil if the queue is empty.
+func (q *InstitutionQueue) Peek() *Candidate {
+	for len(q.buffer) == 0 || q.seen[q.buffer[0].GenericFile.ID] {
+		if len(q.buffer) > 0 {
+			q.buffer = q.buffer[1:]
+			continue
+		}
+		if q.err != nil || len(q.fetchers) == 0 {
+			return nil
+		}
+		batch, err := q.fetchers[0]()
+		if err != nil {
+			q.err = err
+			return nil
+		}
+		if len(batch) == 0 {
+			q.fetchers = q.fetchers[1:]
+			continue
+		}
+		q.buffer = append(q.buffer, batch...)
+	}
+	return q.buffer[0]
+}
+
+// Next removes and returns the next candidate, or nil if the queue
+// is empty.
+func (q *InstitutionQueue) Next() *Candidate {
+	c := q.Peek()
+	if c != nil {
+		q.seen[c.GenericFile.ID] = true
+		q.buffer = q.buffer[1:]
+	}
+	return c
+}
+
+// Scheduler chooses which files to queue for fixity checks in a
+// single run. It takes files from each institution in turn so that one
+// institution with millions of overdue files can't starve the others.
+// Institutions that have fewer overdue files than their fair share
+// leave the rest of the run's capacity to everyone else.
+//
+// MaxItems caps the number of files per run. MaxBytes caps the total
+// size of the files per run. Zero means no byte limit. When an
+// institution's next file doesn't fit in the remaining byte budget,
+// that institution is done for this run. That file will still be at
+// the front of its queue next time. A file larger than the entire
+// byte budget is queued only if it's the first file chosen in a run.
+// Because institutions are visited in order of their most urgent
+// file, such a file will eventually come first.
+type Scheduler struct {
+	MaxItems int
+	MaxBytes int64
+}
+
+// NewScheduler returns a new Scheduler.
+func NewScheduler(maxItems int, maxBytes int64) *Scheduler {
+	return &Scheduler{
+		MaxItems: maxItems,
+		MaxBytes: maxBytes,
+	}
+}
+
+// Select returns the candidates to be queued in this run.
+func (s *Scheduler) Select(queues []*InstitutionQueue) []*Candidate {
+	active := make([]*InstitutionQueue, 0, len(queues))
+	for _, q := range queues {
+		if q.Peek() != nil {
+			active = append(active, q)
+		}
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		return active[i].Peek().MoreUrgentThan(active[j].Peek())
+	})
+
+	selected := make([]*Candidate, 0)
+	var totalBytes int64
+	for len(active) > 0 && len(selected) < s.MaxItems {
+		stillActive := active[:0]
+		for _, q := range active {
+			if len(selected) >= s.MaxItems {
+				break
+			}
+			c := q.Peek()
+			if c == nil {
+				continue
+			}
+			size := c.GenericFile.Size
+			if s.MaxBytes > 0 && totalBytes+size > s.MaxBytes {
+				if !(len(selected) == 0 && size > s.MaxBytes) {
+					continue
+				}
+			}
+			q.Next()
+			q.Queued++
+			q.QueuedBytes += size
+			totalBytes += size
+			selected = append(selected, c)
+			stillActive = append(stillActive, q)
+		}
+		active = stillActive
+	}
+	return selected
+}
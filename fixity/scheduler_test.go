@@ -0,0 +1,153 @@
+package fixity_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/fixity"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var baseTime = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// makeCandidates returns count candidates for the given institution,
+// with ascending IDs and last fixity check dates.
+func makeCandidates(instID int64, startID int64, count int, size int64, reason string) []*fixity.Candidate {
+	candidates := make([]*fixity.Candidate, count)
+	for i := 0; i < count; i++ {
+		id := startID + int64(i)
+		candidates[i] = &fixity.Candidate{
+			GenericFile: &registry.GenericFile{
+				ID:              id,
+				Identifier:      fmt.Sprintf("inst%d/bag/data/file%d.txt", instID, id),
+				InstitutionID:   instID,
+				LastFixityCheck: baseTime.Add(time.Duration(id) * time.Minute),
+				Size:            size,
+			},
+			Reason: reason,
+		}
+	}
+	return candidates
+}
+
+// pagedFetcher returns candidates in pages of pageSize.
+func pagedFetcher(candidates []*fixity.Candidate, pageSize int) fixity.CandidateFetcher {
+	return func() ([]*fixity.Candidate, error) {
+		if len(candidates) == 0 {
+			return nil, nil
+		}
+		n := pageSize
+		if n > len(candidates) {
+			n = len(candidates)
+		}
+		page := candidates[:n]
+		candidates = candidates[n:]
+		return page, nil
+	}
+}
+
+func countByInstitution(selected []*fixity.Candidate) map[int64]int {
+	counts := make(map[int64]int)
+	for _, c := range selected {
+		counts[c.GenericFile.InstitutionID]++
+	}
+	return counts
+}
+
+func TestSchedulerFairness(t *testing.T) {
+	big := fixity.NewInstitutionQueue(1, "big.edu")
+	big.AddFetcher(pagedFetcher(makeCandidates(1, 1, 10000, 10, fixity.ReasonOverdue), 50))
+	small := fixity.NewInstitutionQueue(2, "small.edu")
+	small.AddFetcher(pagedFetcher(makeCandidates(2, 20000, 5, 10, fixity.ReasonOverdue), 50))
+	medium := fixity.NewInstitutionQueue(3, "medium.edu")
+	medium.AddFetcher(pagedFetcher(makeCandidates(3, 30000, 100, 10, fixity.ReasonOverdue), 50))
+
+	scheduler := fixity.NewScheduler(100, 0)
+	selected := scheduler.Select([]*fixity.InstitutionQueue{big, small, medium})
+	require.Equal(t, 100, len(selected))
+
+	// Small gets everything it has. Big and medium split the rest.
+	counts := countByInstitution(selected)
+	assert.Equal(t, 5, counts[2])
+	assert.InDelta(t, 47, counts[1], 1)
+	assert.InDelta(t, 47, counts[3], 1)
+	assert.Equal(t, 5, small.Queued)
+	assert.EqualValues(t, 50, small.QueuedBytes)
+
+	// Within an institution, oldest checks come first.
+	var lastBig time.Time
+	for _, c := range selected {
+		if c.GenericFile.InstitutionID == 1 {
+			assert.True(t, c.GenericFile.LastFixityCheck.After(lastBig))
+			lastBig = c.GenericFile.LastFixityCheck
+		}
+	}
+}
+
+func TestSchedulerPriorityAndDedup(t *testing.T) {
+	inst := fixity.NewInstitutionQueue(1, "test.edu")
+	failures := makeCandidates(1, 500, 2, 10, fixity.ReasonPriorFailure)
+	reingests := makeCandidates(1, 600, 2, 10, fixity.ReasonReingested)
+	overdue := makeCandidates(1, 1, 5, 10, fixity.ReasonOverdue)
+	// The overdue list also includes one of the failures. It should
+	// only be returned once.
+	overdue = append(overdue, makeCandidates(1, 500, 1, 10, fixity.ReasonOverdue)...)
+	inst.AddFetcher(pagedFetcher(failures, 10))
+	inst.AddFetcher(pagedFetcher(reingests, 10))
+	inst.AddFetcher(pagedFetcher(overdue, 10))
+
+	selected := fixity.NewScheduler(100, 0).Select([]*fixity.InstitutionQueue{inst})
+	require.Equal(t, 9, len(selected))
+	assert.EqualValues(t, 500, selected[0].GenericFile.ID)
+	assert.EqualValues(t, 501, selected[1].GenericFile.ID)
+	assert.Equal(t, fixity.ReasonReingested, selected[2].Reason)
+	assert.Equal(t, fixity.ReasonReingested, selected[3].Reason)
+	for _, c := range selected[4:] {
+		assert.Equal(t, fixity.ReasonOverdue, c.Reason)
+		assert.NotEqual(t, int64(500), c.GenericFile.ID)
+	}
+}
+
+func TestSchedulerByteBudget(t *testing.T) {
+	inst1 := fixity.NewInstitutionQueue(1, "one.edu")
+	inst1.AddFetcher(pagedFetcher(makeCandidates(1, 1, 10, 100, fixity.ReasonOverdue), 10))
+	inst2 := fixity.NewInstitutionQueue(2, "two.edu")
+	inst2.AddFetcher(pagedFetcher(makeCandidates(2, 100, 10, 300, fixity.ReasonOverdue), 10))
+
+	selected := fixity.NewScheduler(100, 1000).Select([]*fixity.InstitutionQueue{inst1, inst2})
+	var total int64
+	for _, c := range selected {
+		total += c.GenericFile.Size
+	}
+	// inst2 drops out when its next 300-byte file no longer fits,
+	// leaving the remaining budget to inst1.
+	assert.EqualValues(t, 1000, total)
+	assert.Equal(t, 4, inst1.Queued)
+	assert.Equal(t, 2, inst2.Queued)
+}
+
+func TestSchedulerOversizeFile(t *testing.T) {
+	// A file larger than the whole budget goes if it's most urgent.
+	huge := fixity.NewInstitutionQueue(1, "huge.edu")
+	huge.AddFetcher(pagedFetcher(makeCandidates(1, 1, 2, 5000, fixity.ReasonOverdue), 10))
+	other := fixity.NewInstitutionQueue(2, "other.edu")
+	other.AddFetcher(pagedFetcher(makeCandidates(2, 100, 5, 10, fixity.ReasonOverdue), 10))
+
+	selected := fixity.NewScheduler(100, 1000).Select([]*fixity.InstitutionQueue{other, huge})
+	require.Equal(t, 1, len(selected))
+	assert.EqualValues(t, 1, selected[0].GenericFile.ID)
+}
+
+func TestInstitutionQueueError(t *testing.T) {
+	inst := fixity.NewInstitutionQueue(1, "test.edu")
+	inst.AddFetcher(func() ([]*fixity.Candidate, error) {
+		return nil, fmt.Errorf("registry is down")
+	})
+	assert.Nil(t, inst.Next())
+	assert.NotNil(t, inst.Err())
+	selected := fixity.NewScheduler(100, 0).Select([]*fixity.InstitutionQueue{inst})
+	assert.Empty(t, selected)
+}
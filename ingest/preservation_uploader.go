@@ -126,6 +126,10 @@ func (uploader *PreservationUploader) CopyToPreservationServerSide(ingestFile *s
 		Bucket: preservationBucket.Bucket,
 		Object: ingestFile.UUID,
 	}
+	if retainUntil := uploader.objectLockRetainUntil(); !retainUntil.IsZero() {
+		destOpts.Mode = minio.Governance
+		destOpts.RetainUntilDate = retainUntil
+	}
 
 	// CopyObject handles objects only up to 5GB.
 	if ingestFile.Size <= constants.MaxServerSideCopySize {
@@ -199,6 +203,10 @@ func (uploader *PreservationUploader) CopyToPreservation(ingestFile *service.Ing
 	} else {
 		delete(putOptions.UserMetadata, "bagpath-encoded") // not necessary for other cases
 	}
+	if retainUntil := uploader.objectLockRetainUntil(); !retainUntil.IsZero() {
+		putOptions.Mode = minio.Governance
+		putOptions.RetainUntilDate = retainUntil
+	}
 
 	uploader.Context.Logger.Infof("Copying %s (%s) from %s to %s using PutObject()", ingestFile.Identifier(), ingestFile.UUID, uploader.Context.Config.StagingBucket, preservationBucket.Bucket)
 
@@ -221,6 +229,19 @@ func (uploader *PreservationUploader) CopyToPreservation(ingestFile *service.Ing
 	return nil
 }
 
+// objectLockRetainUntil returns the date until which S3 Object Lock
+// should protect new preservation copies, or a zero time if
+// Config.ObjectLockRetentionDays says not to lock them. We use
+// governance mode, so the deletion manager can still remove copies
+// once legal holds and retention policies allow it.
+func (uploader *PreservationUploader) objectLockRetainUntil() time.Time {
+	days := uploader.Context.Config.ObjectLockRetentionDays
+	if days <= 0 {
+		return time.Time{}
+	}
+	return time.Now().UTC().AddDate(0, 0, days)
+}
+
 func (uploader *PreservationUploader) getS3Client(providerOrBucket string) (*minio.Client, error) {
 	client := uploader.Context.S3Clients[providerOrBucket]
 	if client == nil {
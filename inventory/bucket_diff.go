@@ -0,0 +1,122 @@
+package inventory
+
+import (
+	"sort"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/registry"
+)
+
+// listedObject is the minimal info we keep in memory for each object
+// in a bucket listing. Preservation buckets can hold tens of millions
+// of objects, so we don't keep the full StoredObject.
+type listedObject struct {
+	size         int64
+	lastModified time.Time
+	seen         bool
+}
+
+// BucketDiff compares the contents of a single preservation bucket
+// against the StorageRecords in Registry. Call AddObject for each
+// object in the bucket listing, then CheckRecord for each Registry
+// StorageRecord that points into the bucket, then Finish.
+//
+// Objects modified and GenericFiles created less than MinAge before
+// the listing are ignored, since they may belong to ingests or
+// deletions that were in progress when the listing was taken.
+type BucketDiff struct {
+	Report  *BucketReport
+	MinAge  time.Duration
+	objects map[string]*listedObject
+}
+
+// NewBucketDiff returns a new BucketDiff for the named bucket.
+func NewBucketDiff(bucket string, minAge time.Duration) *BucketDiff {
+	return &BucketDiff{
+		Report:  NewBucketReport(bucket),
+		MinAge:  minAge,
+		objects: make(map[string]*listedObject),
+	}
+}
+
+// SetListedAt records the time at which the bucket listing was taken.
+func (d *BucketDiff) SetListedAt(listedAt time.Time) {
+	d.Report.ListedAt = listedAt
+}
+
+// AddObject adds an object from the bucket listing.
+func (d *BucketDiff) AddObject(obj *StoredObject) {
+	d.Report.ObjectsListed++
+	d.Report.BytesListed += obj.Size
+	d.objects[obj.Key] = &listedObject{
+		size:         obj.Size,
+		lastModified: obj.LastModified,
+	}
+}
+
+// CheckRecord checks a Registry StorageRecord against the bucket
+// listing. Param key is the S3 key from the StorageRecord URL.
+func (d *BucketDiff) CheckRecord(gf *registry.GenericFile, sr *registry.StorageRecord, key string) {
+	d.Report.RecordsChecked++
+	obj, found := d.objects[key]
+	if !found {
+		if d.isTooRecent(gf.CreatedAt) {
+			return
+		}
+		d.Report.Ghosts = append(d.Report.Ghosts, &Discrepancy{
+			Problem:               ProblemGhost,
+			Bucket:                d.Report.Bucket,
+			Key:                   key,
+			GenericFileID:         gf.ID,
+			GenericFileIdentifier: gf.Identifier,
+			RegistrySize:          gf.Size,
+			StorageRecordURL:      sr.URL,
+		})
+		return
+	}
+	obj.seen = true
+	if obj.size != gf.Size {
+		d.Report.SizeMismatches = append(d.Report.SizeMismatches, &Discrepancy{
+			Problem:               ProblemSizeMismatch,
+			Bucket:                d.Report.Bucket,
+			Key:                   key,
+			StoredSize:            obj.size,
+			StoredLastModified:    obj.lastModified,
+			GenericFileID:         gf.ID,
+			GenericFileIdentifier: gf.Identifier,
+			RegistrySize:          gf.Size,
+			StorageRecordURL:      sr.URL,
+		})
+	}
+}
+
+// Finish reports all listed objects that no StorageRecord claimed as
+// orphans. Orphans are sorted by key so reports are stable from one
+// run to the next.
+func (d *BucketDiff) Finish() *BucketReport {
+	for key, obj := range d.objects {
+		if obj.seen || d.isTooRecent(obj.lastModified) {
+			continue
+		}
+		d.Report.Orphans = append(d.Report.Orphans, &Discrepancy{
+			Problem:            ProblemOrphan,
+			Bucket:             d.Report.Bucket,
+			Key:                key,
+			StoredSize:         obj.size,
+			StoredLastModified: obj.lastModified,
+		})
+	}
+	sort.Slice(d.Report.Orphans, func(i, j int) bool {
+		return d.Report.Orphans[i].Key < d.Report.Orphans[j].Key
+	})
+	return d.Report
+}
+
+// isTooRecent returns true if timestamp falls within MinAge of the
+// listing time. Zero timestamps are never too recent.
+func (d *BucketDiff) isTooRecent(timestamp time.Time) bool {
+	if timestamp.IsZero() || d.Report.ListedAt.IsZero() {
+		return false
+	}
+	return timestamp.After(d.Report.ListedAt.Add(-d.MinAge))
+}
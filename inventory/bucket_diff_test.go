@@ -0,0 +1,106 @@
+package inventory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/inventory"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var listedAt = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+var longAgo = listedAt.Add(-30 * 24 * time.Hour)
+
+func getDiff() *inventory.BucketDiff {
+	diff := inventory.NewBucketDiff("test-bucket", 24*time.Hour)
+	diff.SetListedAt(listedAt)
+	objects := []*inventory.StoredObject{
+		{Bucket: "test-bucket", Key: "uuid-ok", Size: 100, LastModified: longAgo},
+		{Bucket: "test-bucket", Key: "uuid-wrong-size", Size: 200, LastModified: longAgo},
+		{Bucket: "test-bucket", Key: "uuid-orphan", Size: 300, LastModified: longAgo},
+		{Bucket: "test-bucket", Key: "uuid-new", Size: 400, LastModified: listedAt.Add(-1 * time.Hour)},
+	}
+	for _, obj := range objects {
+		diff.AddObject(obj)
+	}
+	return diff
+}
+
+func record(id int64, key string, size int64, createdAt time.Time) (*registry.GenericFile, *registry.StorageRecord, string) {
+	gf := &registry.GenericFile{
+		ID:         id,
+		Identifier: "test.edu/bag/data/" + key,
+		Size:       size,
+		CreatedAt:  createdAt,
+	}
+	sr := &registry.StorageRecord{
+		GenericFileID: id,
+		URL:           "https://s3.us-east-1.amazonaws.com/test-bucket/" + key,
+	}
+	return gf, sr, key
+}
+
+func TestBucketDiff(t *testing.T) {
+	diff := getDiff()
+	diff.CheckRecord(record(1, "uuid-ok", 100, longAgo))
+	diff.CheckRecord(record(2, "uuid-wrong-size", 222, longAgo))
+	diff.CheckRecord(record(3, "uuid-ghost", 500, longAgo))
+	diff.CheckRecord(record(4, "uuid-ghost-too-new", 600, listedAt.Add(-2*time.Hour)))
+	report := diff.Finish()
+
+	assert.EqualValues(t, 4, report.ObjectsListed)
+	assert.EqualValues(t, 1000, report.BytesListed)
+	assert.EqualValues(t, 4, report.RecordsChecked)
+	assert.Equal(t, 3, report.DiscrepancyCount())
+
+	// uuid-new is not an orphan because it's within MinAge
+	require.Equal(t, 1, len(report.Orphans))
+	assert.Equal(t, inventory.ProblemOrphan, report.Orphans[0].Problem)
+	assert.Equal(t, "uuid-orphan", report.Orphans[0].Key)
+	assert.EqualValues(t, 300, report.Orphans[0].StoredSize)
+
+	// uuid-ghost-too-new is not a ghost because it's within MinAge
+	require.Equal(t, 1, len(report.Ghosts))
+	assert.Equal(t, inventory.ProblemGhost, report.Ghosts[0].Problem)
+	assert.Equal(t, "uuid-ghost", report.Ghosts[0].Key)
+	assert.EqualValues(t, 3, report.Ghosts[0].GenericFileID)
+	assert.Equal(t, "https://s3.us-east-1.amazonaws.com/test-bucket/uuid-ghost", report.Ghosts[0].StorageRecordURL)
+
+	require.Equal(t, 1, len(report.SizeMismatches))
+	assert.Equal(t, inventory.ProblemSizeMismatch, report.SizeMismatches[0].Problem)
+	assert.EqualValues(t, 200, report.SizeMismatches[0].StoredSize)
+	assert.EqualValues(t, 222, report.SizeMismatches[0].RegistrySize)
+}
+
+func TestBucketDiffNoMinAge(t *testing.T) {
+	diff := getDiff()
+	diff.MinAge = 0
+	diff.CheckRecord(record(1, "uuid-ok", 100, longAgo))
+	diff.CheckRecord(record(2, "uuid-wrong-size", 200, longAgo))
+	report := diff.Finish()
+	require.Equal(t, 2, len(report.Orphans))
+	assert.Equal(t, "uuid-new", report.Orphans[0].Key)
+	assert.Equal(t, "uuid-orphan", report.Orphans[1].Key)
+	assert.Empty(t, report.Ghosts)
+	assert.Empty(t, report.SizeMismatches)
+}
+
+func TestReportOutput(t *testing.T) {
+	diff := getDiff()
+	diff.CheckRecord(record(3, "uuid-ghost", 500, longAgo))
+	report := inventory.NewReport()
+	report.Buckets = append(report.Buckets, diff.Finish())
+	assert.True(t, report.HasProblems())
+	assert.Equal(t, 4, len(report.Discrepancies()))
+	assert.Contains(t, report.Summary(), "test-bucket: 4 objects, 1 records, 3 orphans, 1 ghosts, 0 size mismatches")
+
+	jsonBytes, err := report.ToJSON()
+	require.Nil(t, err)
+	assert.Contains(t, string(jsonBytes), `"key": "uuid-ghost"`)
+
+	clean := inventory.NewReport()
+	clean.Buckets = append(clean.Buckets, inventory.NewBucketReport("empty-bucket"))
+	assert.False(t, clean.HasProblems())
+}
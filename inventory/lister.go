@@ -0,0 +1,355 @@
+package inventory
+
+import (
+	"compress/gzip"
+	ctx "context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/minio/minio-go/v7"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// StoredObject describes a single object found in a preservation bucket,
+// either by listing the bucket directly or by reading an S3 Inventory
+// report.
+type StoredObject struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// ObjectLister lists all of the objects in a preservation bucket,
+// calling fn once for each object. ListObjects returns the time at
+// which the listing was taken. For live listings, that's now. For
+// S3 Inventory reports, it's the time the report was generated.
+// Reconciliation uses this timestamp to avoid flagging objects and
+// records that were created after the listing.
+type ObjectLister interface {
+	ListObjects(bucket *common.PreservationBucket, fn func(*StoredObject)) (listedAt time.Time, err error)
+}
+
+// S3Lister lists preservation bucket contents through the S3
+// ListObjects API. This is accurate up to the second, but it can take
+// hours on buckets with tens of millions of objects.
+type S3Lister struct {
+	Context *common.Context
+}
+
+// NewS3Lister returns a new S3Lister.
+func NewS3Lister(context *common.Context) *S3Lister {
+	return &S3Lister{Context: context}
+}
+
+// ListObjects lists all objects in the specified preservation bucket.
+func (l *S3Lister) ListObjects(bucket *common.PreservationBucket, fn func(*StoredObject)) (time.Time, error) {
+	listedAt := time.Now().UTC()
+	client := l.Context.S3Clients[bucket.Bucket]
+	if client == nil {
+		client = l.Context.S3Clients[bucket.Provider]
+	}
+	if client == nil {
+		return listedAt, fmt.Errorf("No S3 client for provider %s or bucket %s", bucket.Provider, bucket.Bucket)
+	}
+	l.Context.Logger.Infof("Listing objects in %s", bucket.Bucket)
+	for obj := range client.ListObjects(
+		ctx.Background(),
+		bucket.Bucket,
+		minio.ListObjectsOptions{
+			Prefix:    "",
+			Recursive: true,
+		}) {
+		if obj.Err != nil {
+			return listedAt, fmt.Errorf("Error listing %s: %v", bucket.Bucket, obj.Err)
+		}
+		fn(&StoredObject{
+			Bucket:       bucket.Bucket,
+			Key:          obj.Key,
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+		})
+	}
+	return listedAt, nil
+}
+
+// InventoryManifest is the manifest.json file that S3 Inventory writes
+// alongside each inventory report. See
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/storage-inventory-location.html
+type InventoryManifest struct {
+	SourceBucket      string                   `json:"sourceBucket"`
+	DestinationBucket string                   `json:"destinationBucket"`
+	Version           string                   `json:"version"`
+	CreationTimestamp string                   `json:"creationTimestamp"`
+	FileFormat        string                   `json:"fileFormat"`
+	FileSchema        string                   `json:"fileSchema"`
+	Files             []*InventoryManifestFile `json:"files"`
+}
+
+// InventoryManifestFile describes one data file in an S3 Inventory report.
+type InventoryManifestFile struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	MD5Checksum string `json:"MD5checksum"`
+}
+
+// CreatedAt returns the time at which S3 generated the inventory.
+// CreationTimestamp is milliseconds since the epoch, as a string.
+func (m *InventoryManifest) CreatedAt() time.Time {
+	ms, err := strconv.ParseInt(m.CreationTimestamp, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
+
+// InventoryLister reads S3 Inventory reports that have been downloaded
+// to a local directory. This is much faster than S3Lister for large
+// buckets, though the data may be up to a day old.
+//
+// Dir should contain one subdirectory per preservation bucket, named
+// after the bucket, and each of those should contain the report's
+// manifest.json. Data files are resolved relative to the bucket
+// directory, first by their full manifest key and then by base name
+// under a "data" subdirectory.
+//
+// CSV and Parquet inventories are supported. ListObjects returns an
+// error for ORC reports. Configure the inventory with at least the
+// Bucket, Key and Size fields.
+type InventoryLister struct {
+	Dir string
+}
+
+// NewInventoryLister returns a new InventoryLister that reads reports
+// from dir.
+func NewInventoryLister(dir string) *InventoryLister {
+	return &InventoryLister{Dir: dir}
+}
+
+// ListObjects lists all objects in the inventory report for the
+// specified preservation bucket.
+func (l *InventoryLister) ListObjects(bucket *common.PreservationBucket, fn func(*StoredObject)) (time.Time, error) {
+	bucketDir := filepath.Join(l.Dir, bucket.Bucket)
+	manifest, err := l.ReadManifest(filepath.Join(bucketDir, "manifest.json"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if manifest.SourceBucket != "" && manifest.SourceBucket != bucket.Bucket {
+		return time.Time{}, fmt.Errorf("Inventory in %s describes bucket %s, not %s", bucketDir, manifest.SourceBucket, bucket.Bucket)
+	}
+	var readDataFile func(filePath string) error
+	switch {
+	case strings.EqualFold(manifest.FileFormat, "CSV"):
+		columns, err := parseFileSchema(manifest.FileSchema)
+		if err != nil {
+			return time.Time{}, err
+		}
+		readDataFile = func(filePath string) error {
+			return readInventoryCSV(filePath, bucket.Bucket, columns, fn)
+		}
+	case strings.EqualFold(manifest.FileFormat, "Parquet"):
+		readDataFile = func(filePath string) error {
+			return readInventoryParquet(filePath, bucket.Bucket, fn)
+		}
+	default:
+		return time.Time{}, fmt.Errorf("Inventory for %s is in %s format. Only CSV and Parquet inventories are supported. Configure the bucket's S3 Inventory to write CSV or Parquet.", bucket.Bucket, manifest.FileFormat)
+	}
+	for _, dataFile := range manifest.Files {
+		err = readDataFile(l.dataFilePath(bucketDir, dataFile.Key))
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return manifest.CreatedAt(), nil
+}
+
+// ReadManifest parses an S3 Inventory manifest.json file.
+func (l *InventoryLister) ReadManifest(manifestPath string) (*InventoryManifest, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &InventoryManifest{}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse inventory manifest %s: %v", manifestPath, err)
+	}
+	return manifest, nil
+}
+
+func (l *InventoryLister) dataFilePath(bucketDir, key string) string {
+	fullPath := filepath.Join(bucketDir, filepath.FromSlash(key))
+	if _, err := os.Stat(fullPath); err == nil {
+		return fullPath
+	}
+	return filepath.Join(bucketDir, "data", path.Base(key))
+}
+
+// inventoryColumns maps the fields we care about to their column
+// index in the inventory CSV files.
+type inventoryColumns struct {
+	key          int
+	size         int
+	lastModified int
+}
+
+func parseFileSchema(schema string) (*inventoryColumns, error) {
+	columns := &inventoryColumns{key: -1, size: -1, lastModified: -1}
+	for i, field := range strings.Split(schema, ",") {
+		switch strings.TrimSpace(field) {
+		case "Key":
+			columns.key = i
+		case "Size":
+			columns.size = i
+		case "LastModifiedDate":
+			columns.lastModified = i
+		}
+	}
+	if columns.key < 0 || columns.size < 0 {
+		return nil, fmt.Errorf("Inventory schema '%s' must include Key and Size", schema)
+	}
+	return columns, nil
+}
+
+func readInventoryCSV(filePath, bucketName string, columns *inventoryColumns, fn func(*StoredObject)) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(filePath, ".gz") {
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("Cannot decompress %s: %v", filePath, err)
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %v", filePath, err)
+		}
+		obj, err := objectFromRecord(record, bucketName, columns)
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %v", filePath, err)
+		}
+		fn(obj)
+	}
+	return nil
+}
+
+func objectFromRecord(record []string, bucketName string, columns *inventoryColumns) (*StoredObject, error) {
+	if len(record) <= columns.key || len(record) <= columns.size {
+		return nil, fmt.Errorf("inventory line has only %d fields", len(record))
+	}
+	size, err := strconv.ParseInt(record[columns.size], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid size '%s' for key %s", record[columns.size], record[columns.key])
+	}
+	// S3 Inventory URL-encodes keys in CSV reports.
+	key, err := url.QueryUnescape(record[columns.key])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key '%s': %v", record[columns.key], err)
+	}
+	obj := &StoredObject{
+		Bucket: bucketName,
+		Key:    key,
+		Size:   size,
+	}
+	if columns.lastModified >= 0 && len(record) > columns.lastModified {
+		obj.LastModified, _ = time.Parse(time.RFC3339, record[columns.lastModified])
+	}
+	return obj, nil
+}
+
+// readInventoryParquet reads a Parquet inventory data file. Parquet
+// reports name their columns in snake case (key, size,
+// last_modified_date) and, unlike CSV reports, don't URL-encode keys.
+func readInventoryParquet(filePath, bucketName string, fn func(*StoredObject)) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	parquetFile, err := parquet.OpenFile(file, stat.Size())
+	if err != nil {
+		return fmt.Errorf("Cannot open %s: %v", filePath, err)
+	}
+	schema := parquetFile.Schema()
+	keyColumn, hasKey := schema.Lookup("key")
+	sizeColumn, hasSize := schema.Lookup("size")
+	if !hasKey || !hasSize {
+		return fmt.Errorf("Inventory file %s must include key and size columns", filePath)
+	}
+	lastModifiedColumn, hasLastModified := schema.Lookup("last_modified_date")
+	timeUnit := time.Millisecond
+	if hasLastModified {
+		if logicalType := lastModifiedColumn.Node.Type().LogicalType(); logicalType != nil {
+			timestamp, ok := logicalType.Value.(*format.TimestampType)
+			if ok && timestamp.Unit.Value != nil {
+				timeUnit = timestamp.Unit.Value.Duration()
+			}
+		}
+	}
+
+	reader := parquet.NewReader(parquetFile)
+	defer reader.Close()
+	rows := make([]parquet.Row, 256)
+	for {
+		n, err := reader.ReadRows(rows)
+		for _, row := range rows[:n] {
+			obj := &StoredObject{Bucket: bucketName}
+			hasKeyValue, hasSizeValue := false, false
+			for _, value := range row {
+				if value.IsNull() {
+					continue
+				}
+				switch value.Column() {
+				case keyColumn.ColumnIndex:
+					obj.Key = string(value.ByteArray())
+					hasKeyValue = true
+				case sizeColumn.ColumnIndex:
+					obj.Size = value.Int64()
+					hasSizeValue = true
+				case lastModifiedColumn.ColumnIndex:
+					if hasLastModified {
+						obj.LastModified = time.Unix(0, value.Int64()*int64(timeUnit)).UTC()
+					}
+				}
+			}
+			if !hasKeyValue || !hasSizeValue {
+				return fmt.Errorf("Error reading %s: row is missing key or size", filePath)
+			}
+			fn(obj)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %v", filePath, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package inventory_test
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/inventory"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const manifestJSON = `{
+  "sourceBucket": "test-bucket",
+  "destinationBucket": "arn:aws:s3:::inventory-bucket",
+  "version": "2016-11-30",
+  "creationTimestamp": "1772366400000",
+  "fileFormat": "CSV",
+  "fileSchema": "Bucket, Key, Size, LastModifiedDate",
+  "files": [
+    {
+      "key": "test-bucket/daily/data/part-0001.csv.gz",
+      "size": 100,
+      "MD5checksum": "00000000000000000000000000000000"
+    }
+  ]
+}`
+
+const inventoryCSV = `"test-bucket","uuid-1","1234","2026-02-01T10:00:00.000Z"
+"test-bucket","uuid-2","5678","2026-02-02T10:00:00.000Z"
+"test-bucket","bag%2Fdata%2Fmy+file%26more.txt","42","2026-02-03T10:00:00.000Z"
+`
+
+func writeInventory(t *testing.T, manifest string) string {
+	dir := t.TempDir()
+	bucketDir := filepath.Join(dir, "test-bucket")
+	require.Nil(t, os.MkdirAll(filepath.Join(bucketDir, "data"), 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(bucketDir, "manifest.json"), []byte(manifest), 0644))
+
+	file, err := os.Create(filepath.Join(bucketDir, "data", "part-0001.csv.gz"))
+	require.Nil(t, err)
+	gzWriter := gzip.NewWriter(file)
+	_, err = gzWriter.Write([]byte(inventoryCSV))
+	require.Nil(t, err)
+	require.Nil(t, gzWriter.Close())
+	require.Nil(t, file.Close())
+	return dir
+}
+
+func TestInventoryListerListObjects(t *testing.T) {
+	dir := writeInventory(t, manifestJSON)
+	lister := inventory.NewInventoryLister(dir)
+	bucket := &common.PreservationBucket{Bucket: "test-bucket"}
+	objects := make([]*inventory.StoredObject, 0)
+	listedAt, err := lister.ListObjects(bucket, func(obj *inventory.StoredObject) {
+		objects = append(objects, obj)
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "2026-03-01T12:00:00Z", listedAt.Format("2006-01-02T15:04:05Z07:00"))
+	require.Equal(t, 3, len(objects))
+	assert.Equal(t, "test-bucket", objects[0].Bucket)
+	assert.Equal(t, "uuid-1", objects[0].Key)
+	assert.EqualValues(t, 1234, objects[0].Size)
+	assert.Equal(t, 2026, objects[0].LastModified.Year())
+	assert.Equal(t, "uuid-2", objects[1].Key)
+	assert.EqualValues(t, 5678, objects[1].Size)
+	assert.Equal(t, "bag/data/my file&more.txt", objects[2].Key)
+}
+
+// parquetInventoryRow has the columns S3 Inventory writes to
+// Parquet reports that we read.
+type parquetInventoryRow struct {
+	Bucket           string    `parquet:"bucket"`
+	Key              string    `parquet:"key"`
+	Size             *int64    `parquet:"size,optional"`
+	LastModifiedDate time.Time `parquet:"last_modified_date,timestamp(millisecond)"`
+}
+
+func TestInventoryListerParquet(t *testing.T) {
+	dir := t.TempDir()
+	bucketDir := filepath.Join(dir, "test-bucket")
+	require.Nil(t, os.MkdirAll(filepath.Join(bucketDir, "data"), 0755))
+	manifest := `{
+  "sourceBucket": "test-bucket",
+  "creationTimestamp": "1772366400000",
+  "fileFormat": "Parquet",
+  "fileSchema": "message s3.inventory { required binary bucket (STRING); required binary key (STRING); optional int64 size; optional int64 last_modified_date (TIMESTAMP(MILLIS,true)); }",
+  "files": [{"key": "test-bucket/daily/data/part-0001.parquet"}]
+}`
+	require.Nil(t, os.WriteFile(filepath.Join(bucketDir, "manifest.json"), []byte(manifest), 0644))
+
+	size1, size2 := int64(1234), int64(5678)
+	rows := []parquetInventoryRow{
+		{"test-bucket", "uuid-1", &size1, time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)},
+		{"test-bucket", "bag/data/my file.txt", &size2, time.Date(2026, 2, 2, 10, 0, 0, 0, time.UTC)},
+	}
+	file, err := os.Create(filepath.Join(bucketDir, "data", "part-0001.parquet"))
+	require.Nil(t, err)
+	writer := parquet.NewGenericWriter[parquetInventoryRow](file)
+	_, err = writer.Write(rows)
+	require.Nil(t, err)
+	require.Nil(t, writer.Close())
+	require.Nil(t, file.Close())
+
+	lister := inventory.NewInventoryLister(dir)
+	objects := make([]*inventory.StoredObject, 0)
+	listedAt, err := lister.ListObjects(&common.PreservationBucket{Bucket: "test-bucket"}, func(obj *inventory.StoredObject) {
+		objects = append(objects, obj)
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 2026, listedAt.Year())
+	require.Equal(t, 2, len(objects))
+	assert.Equal(t, "test-bucket", objects[0].Bucket)
+	assert.Equal(t, "uuid-1", objects[0].Key)
+	assert.EqualValues(t, 1234, objects[0].Size)
+	assert.Equal(t, rows[0].LastModifiedDate, objects[0].LastModified)
+	assert.Equal(t, "bag/data/my file.txt", objects[1].Key)
+	assert.EqualValues(t, 5678, objects[1].Size)
+	assert.Equal(t, rows[1].LastModifiedDate, objects[1].LastModified)
+}
+
+func TestInventoryListerErrors(t *testing.T) {
+	bucket := &common.PreservationBucket{Bucket: "test-bucket"}
+	noop := func(obj *inventory.StoredObject) {}
+
+	// No manifest
+	lister := inventory.NewInventoryLister(t.TempDir())
+	_, err := lister.ListObjects(bucket, noop)
+	assert.NotNil(t, err)
+
+	// ORC isn't supported
+	dir := writeInventory(t, `{"sourceBucket": "test-bucket", "fileFormat": "ORC"}`)
+	lister = inventory.NewInventoryLister(dir)
+	_, err = lister.ListObjects(bucket, noop)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Only CSV and Parquet inventories are supported")
+
+	// Wrong bucket
+	otherBucket := &common.PreservationBucket{Bucket: "other-bucket"}
+	require.Nil(t, os.Rename(filepath.Join(dir, "test-bucket"), filepath.Join(dir, "other-bucket")))
+	_, err = lister.ListObjects(otherBucket, noop)
+	require.NotNil(t, err)
+
+	// Missing Size column
+	dir = writeInventory(t, `{"sourceBucket": "test-bucket", "fileFormat": "CSV", "fileSchema": "Bucket, Key"}`)
+	lister = inventory.NewInventoryLister(dir)
+	_, err = lister.ListObjects(bucket, noop)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "must include Key and Size")
+}
@@ -0,0 +1,132 @@
+package inventory
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+)
+
+// DefaultMinAge is the default grace period for objects and records
+// that were created shortly before a bucket listing. Ingests write to
+// preservation storage well before the recorder creates the
+// StorageRecords, so very recent objects often look like orphans.
+const DefaultMinAge = 24 * time.Hour
+
+// Reconciler compares the contents of preservation buckets against
+// the StorageRecords in Registry. It reports orphans (objects in
+// storage that Registry knows nothing about), ghosts (StorageRecords
+// pointing to objects that don't exist) and size mismatches.
+type Reconciler struct {
+	Context *common.Context
+	Lister  ObjectLister
+	MinAge  time.Duration
+	PerPage int
+}
+
+// NewReconciler returns a new Reconciler that lists bucket contents
+// with lister.
+func NewReconciler(context *common.Context, lister ObjectLister, minAge time.Duration) *Reconciler {
+	return &Reconciler{
+		Context: context,
+		Lister:  lister,
+		MinAge:  minAge,
+		PerPage: 500,
+	}
+}
+
+// Run reconciles each of the specified buckets and returns a report.
+// A failure in one bucket is recorded in that bucket's report and does
+// not stop reconciliation of the others.
+func (r *Reconciler) Run(buckets []*common.PreservationBucket) *Report {
+	report := NewReport()
+	for _, bucket := range buckets {
+		report.Buckets = append(report.Buckets, r.ReconcileBucket(bucket))
+	}
+	report.FinishedAt = time.Now().UTC()
+	return report
+}
+
+// ReconcileBucket reconciles a single preservation bucket.
+func (r *Reconciler) ReconcileBucket(bucket *common.PreservationBucket) *BucketReport {
+	diff := NewBucketDiff(bucket.Bucket, r.MinAge)
+	listedAt, err := r.Lister.ListObjects(bucket, diff.AddObject)
+	if err != nil {
+		r.Context.Logger.Errorf("Cannot list %s: %v", bucket.Bucket, err)
+		diff.Report.Error = err.Error()
+		return diff.Report
+	}
+	diff.SetListedAt(listedAt)
+	r.Context.Logger.Infof("Listed %d objects in %s as of %s", diff.Report.ObjectsListed, bucket.Bucket, listedAt.Format(time.RFC3339))
+
+	err = r.checkRegistryRecords(bucket, diff)
+	if err != nil {
+		// If we couldn't get all of the StorageRecords, every object
+		// we didn't get to would show up as an orphan, so don't
+		// report any of them.
+		r.Context.Logger.Errorf("Cannot reconcile %s: %v", bucket.Bucket, err)
+		diff.Report.Error = err.Error()
+		return diff.Report
+	}
+	bucketReport := diff.Finish()
+	r.Context.Logger.Infof("%s: %d orphans, %d ghosts, %d size mismatches", bucket.Bucket, len(bucketReport.Orphans), len(bucketReport.Ghosts), len(bucketReport.SizeMismatches))
+	return bucketReport
+}
+
+// checkRegistryRecords pages through all active GenericFiles whose
+// storage option puts them in bucket and checks each of their
+// StorageRecords in that bucket against the listing.
+func (r *Reconciler) checkRegistryRecords(bucket *common.PreservationBucket, diff *BucketDiff) error {
+	params := url.Values{}
+	params.Set("storage_option", bucket.OptionName)
+	params.Set("state", constants.StateActive)
+	params.Set("sort", "id")
+	params.Set("page", "1")
+	params.Set("per_page", strconv.Itoa(r.PerPage))
+	for {
+		resp := r.Context.RegistryClient.GenericFileList(params)
+		if resp.Error != nil {
+			return fmt.Errorf("Error getting GenericFile list from Registry: %v", resp.Error)
+		}
+		for _, gf := range resp.GenericFiles() {
+			storageRecords, err := storageRecordsFor(r.Context, gf)
+			if err != nil {
+				return err
+			}
+			for _, sr := range storageRecords {
+				if !bucket.HostsURL(sr.URL) {
+					continue
+				}
+				_, key, err := r.Context.Config.BucketAndKeyFor(sr.URL)
+				if err != nil {
+					return fmt.Errorf("Bad storage record URL %s for %s: %v", sr.URL, gf.Identifier, err)
+				}
+				diff.CheckRecord(gf, sr, key)
+			}
+		}
+		if !resp.HasNextPage() {
+			break
+		}
+		params = resp.ParamsForNextPage()
+	}
+	return nil
+}
+
+// storageRecordsFor returns the GenericFile's StorageRecords, fetching
+// them from Registry if they weren't included in the file list.
+func storageRecordsFor(context *common.Context, gf *registry.GenericFile) ([]*registry.StorageRecord, error) {
+	if len(gf.StorageRecords) > 0 {
+		return gf.StorageRecords, nil
+	}
+	params := url.Values{}
+	params.Set("generic_file_id", strconv.FormatInt(gf.ID, 10))
+	resp := context.RegistryClient.StorageRecordList(params)
+	if resp.Error != nil {
+		return nil, fmt.Errorf("Error getting storage records for %s: %v", gf.Identifier, resp.Error)
+	}
+	return resp.StorageRecords(), nil
+}
@@ -0,0 +1,186 @@
+package inventory
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+)
+
+// ReplicaProblem describes a GenericFile whose StorageRecords don't
+// match the preservation buckets required by its storage option.
+// A file can be both under- and over-replicated, for example, if one
+// of its copies went to the wrong bucket.
+type ReplicaProblem struct {
+	GenericFileID         int64    `json:"generic_file_id"`
+	GenericFileIdentifier string   `json:"generic_file_identifier"`
+	InstitutionID         int64    `json:"institution_id"`
+	StorageOption         string   `json:"storage_option"`
+	MissingBuckets        []string `json:"missing_buckets"`
+	ExtraURLs             []string `json:"extra_urls"`
+}
+
+// IsUnderReplicated returns true if the file is missing one or more
+// required copies.
+func (p *ReplicaProblem) IsUnderReplicated() bool {
+	return len(p.MissingBuckets) > 0
+}
+
+// IsOverReplicated returns true if the file has copies beyond those
+// its storage option requires.
+func (p *ReplicaProblem) IsOverReplicated() bool {
+	return len(p.ExtraURLs) > 0
+}
+
+// CheckReplicas compares the GenericFile's StorageRecords against the
+// preservation buckets required for its storage option. It returns nil
+// if the file has exactly one copy in each required bucket.
+func CheckReplicas(config *common.Config, gf *registry.GenericFile, storageRecords []*registry.StorageRecord) *ReplicaProblem {
+	problem := &ReplicaProblem{
+		GenericFileID:         gf.ID,
+		GenericFileIdentifier: gf.Identifier,
+		InstitutionID:         gf.InstitutionID,
+		StorageOption:         gf.StorageOption,
+		MissingBuckets:        make([]string, 0),
+		ExtraURLs:             make([]string, 0),
+	}
+	required := make(map[string]bool)
+	for _, bucket := range config.PreservationBucketsFor(gf.StorageOption) {
+		required[bucket.Bucket] = false
+	}
+	for _, sr := range storageRecords {
+		bucket := config.PreservationBucketForUrl(sr.URL)
+		if bucket == nil {
+			problem.ExtraURLs = append(problem.ExtraURLs, sr.URL)
+			continue
+		}
+		alreadyFound, isRequired := required[bucket.Bucket]
+		if !isRequired || alreadyFound {
+			problem.ExtraURLs = append(problem.ExtraURLs, sr.URL)
+			continue
+		}
+		required[bucket.Bucket] = true
+	}
+	// Iterate over the config list rather than the map so that
+	// missing buckets are always reported in the same order.
+	for _, bucket := range config.PreservationBucketsFor(gf.StorageOption) {
+		if !required[bucket.Bucket] {
+			problem.MissingBuckets = append(problem.MissingBuckets, bucket.Bucket)
+		}
+	}
+	if !problem.IsUnderReplicated() && !problem.IsOverReplicated() {
+		return nil
+	}
+	return problem
+}
+
+// ReplicaReport is the result of a ReplicaChecker run.
+type ReplicaReport struct {
+	StartedAt       time.Time         `json:"started_at"`
+	FinishedAt      time.Time         `json:"finished_at"`
+	FilesChecked    int64             `json:"files_checked"`
+	UnderReplicated int64             `json:"under_replicated"`
+	OverReplicated  int64             `json:"over_replicated"`
+	Problems        []*ReplicaProblem `json:"problems"`
+	Error           string            `json:"error,omitempty"`
+}
+
+// ReplicaChecker pages through active GenericFiles in Registry and
+// verifies that each one has the StorageRecords its storage option
+// calls for. For example, a Standard file should have one copy in the
+// Standard VA bucket and one in the Standard OR bucket.
+//
+// The checker only reports problems. We don't yet have a worker that
+// repairs replicas, so under-replicated files have to be fixed by hand.
+type ReplicaChecker struct {
+	Context       *common.Context
+	StorageOption string
+	InstitutionID int64
+	PerPage       int
+}
+
+// NewReplicaChecker returns a new ReplicaChecker. Params storageOption
+// and institutionID are optional filters. Pass empty string and zero
+// to check all files.
+func NewReplicaChecker(context *common.Context, storageOption string, institutionID int64) *ReplicaChecker {
+	return &ReplicaChecker{
+		Context:       context,
+		StorageOption: storageOption,
+		InstitutionID: institutionID,
+		PerPage:       500,
+	}
+}
+
+// Run checks all matching GenericFiles and returns a report.
+func (c *ReplicaChecker) Run() *ReplicaReport {
+	report := &ReplicaReport{
+		StartedAt: time.Now().UTC(),
+		Problems:  make([]*ReplicaProblem, 0),
+	}
+	err := c.checkAll(report)
+	if err != nil {
+		c.Context.Logger.Errorf("Replica check did not complete: %v", err)
+		report.Error = err.Error()
+	}
+	report.FinishedAt = time.Now().UTC()
+	c.Context.Logger.Infof("Checked %d files: %d under-replicated, %d over-replicated",
+		report.FilesChecked, report.UnderReplicated, report.OverReplicated)
+	return report
+}
+
+func (c *ReplicaChecker) checkAll(report *ReplicaReport) error {
+	params := url.Values{}
+	params.Set("state", constants.StateActive)
+	params.Set("sort", "id")
+	params.Set("page", "1")
+	params.Set("per_page", strconv.Itoa(c.PerPage))
+	if c.StorageOption != "" {
+		params.Set("storage_option", c.StorageOption)
+	}
+	if c.InstitutionID > 0 {
+		params.Set("institution_id", strconv.FormatInt(c.InstitutionID, 10))
+	}
+	for {
+		resp := c.Context.RegistryClient.GenericFileList(params)
+		if resp.Error != nil {
+			return fmt.Errorf("Error getting GenericFile list from Registry: %v", resp.Error)
+		}
+		for _, gf := range resp.GenericFiles() {
+			err := c.checkFile(gf, report)
+			if err != nil {
+				return err
+			}
+		}
+		if !resp.HasNextPage() {
+			break
+		}
+		params = resp.ParamsForNextPage()
+	}
+	return nil
+}
+
+func (c *ReplicaChecker) checkFile(gf *registry.GenericFile, report *ReplicaReport) error {
+	storageRecords, err := storageRecordsFor(c.Context, gf)
+	if err != nil {
+		return err
+	}
+	report.FilesChecked++
+	problem := CheckReplicas(c.Context.Config, gf, storageRecords)
+	if problem == nil {
+		return nil
+	}
+	if problem.IsUnderReplicated() {
+		report.UnderReplicated++
+		c.Context.Logger.Warningf("%s (%d) is missing copies in %v", gf.Identifier, gf.ID, problem.MissingBuckets)
+	}
+	if problem.IsOverReplicated() {
+		report.OverReplicated++
+		c.Context.Logger.Warningf("%s (%d) has unexpected copies at %v", gf.Identifier, gf.ID, problem.ExtraURLs)
+	}
+	report.Problems = append(report.Problems, problem)
+	return nil
+}
@@ -0,0 +1,72 @@
+package inventory_test
+
+import (
+	"testing"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/inventory"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getReplicaTestFile(storageOption string, urls ...string) (*registry.GenericFile, []*registry.StorageRecord) {
+	gf := &registry.GenericFile{
+		ID:            99,
+		Identifier:    "test.edu/bag/data/file.txt",
+		StorageOption: storageOption,
+	}
+	records := make([]*registry.StorageRecord, len(urls))
+	for i, u := range urls {
+		records[i] = &registry.StorageRecord{GenericFileID: 99, URL: u}
+	}
+	return gf, records
+}
+
+func TestCheckReplicas(t *testing.T) {
+	config := common.NewConfig()
+	standard := config.PreservationBucketsFor(constants.StorageStandard)
+	require.Equal(t, 2, len(standard))
+	glacierVA := config.PreservationBucketsFor(constants.StorageGlacierVA)
+	require.Equal(t, 1, len(glacierVA))
+
+	// Correctly replicated
+	gf, records := getReplicaTestFile(constants.StorageStandard,
+		standard[0].URLFor("uuid"), standard[1].URLFor("uuid"))
+	assert.Nil(t, inventory.CheckReplicas(config, gf, records))
+
+	// Missing one copy
+	gf, records = getReplicaTestFile(constants.StorageStandard, standard[0].URLFor("uuid"))
+	problem := inventory.CheckReplicas(config, gf, records)
+	require.NotNil(t, problem)
+	assert.True(t, problem.IsUnderReplicated())
+	assert.False(t, problem.IsOverReplicated())
+	assert.Equal(t, []string{standard[1].Bucket}, problem.MissingBuckets)
+	assert.EqualValues(t, 99, problem.GenericFileID)
+
+	// Missing both copies
+	gf, records = getReplicaTestFile(constants.StorageStandard)
+	problem = inventory.CheckReplicas(config, gf, records)
+	require.NotNil(t, problem)
+	assert.Equal(t, []string{standard[0].Bucket, standard[1].Bucket}, problem.MissingBuckets)
+
+	// Duplicate copy in one bucket
+	gf, records = getReplicaTestFile(constants.StorageGlacierVA,
+		glacierVA[0].URLFor("uuid-1"), glacierVA[0].URLFor("uuid-2"))
+	problem = inventory.CheckReplicas(config, gf, records)
+	require.NotNil(t, problem)
+	assert.False(t, problem.IsUnderReplicated())
+	assert.True(t, problem.IsOverReplicated())
+	assert.Equal(t, []string{glacierVA[0].URLFor("uuid-2")}, problem.ExtraURLs)
+
+	// Copy in the wrong bucket, plus one in an unknown bucket
+	gf, records = getReplicaTestFile(constants.StorageGlacierVA,
+		standard[0].URLFor("uuid"), "https://example.com/not-a-bucket/uuid")
+	problem = inventory.CheckReplicas(config, gf, records)
+	require.NotNil(t, problem)
+	assert.True(t, problem.IsUnderReplicated())
+	assert.True(t, problem.IsOverReplicated())
+	assert.Equal(t, []string{glacierVA[0].Bucket}, problem.MissingBuckets)
+	assert.Equal(t, 2, len(problem.ExtraURLs))
+}
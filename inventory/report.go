@@ -0,0 +1,224 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// ProblemOrphan describes an object that exists in a preservation
+	// bucket but has no StorageRecord in Registry.
+	ProblemOrphan = "orphan"
+
+	// ProblemGhost describes a StorageRecord in Registry that points to
+	// an object that does not exist in the preservation bucket.
+	ProblemGhost = "ghost"
+
+	// ProblemSizeMismatch describes an object whose size in the
+	// preservation bucket differs from its GenericFile size in Registry.
+	ProblemSizeMismatch = "size_mismatch"
+)
+
+// Discrepancy describes a single problem found while reconciling a
+// preservation bucket against Registry.
+type Discrepancy struct {
+	Problem               string    `json:"problem"`
+	Bucket                string    `json:"bucket"`
+	Key                   string    `json:"key"`
+	StoredSize            int64     `json:"stored_size"`
+	StoredLastModified    time.Time `json:"stored_last_modified,omitempty"`
+	GenericFileID         int64     `json:"generic_file_id,omitempty"`
+	GenericFileIdentifier string    `json:"generic_file_identifier,omitempty"`
+	RegistrySize          int64     `json:"registry_size"`
+	StorageRecordURL      string    `json:"storage_record_url,omitempty"`
+}
+
+// CsvHeaders are the column headers for Report.WriteCSV.
+var CsvHeaders = []string{
+	"Problem",
+	"Bucket",
+	"Key",
+	"StoredSize",
+	"StoredLastModified",
+	"GenericFileID",
+	"GenericFileIdentifier",
+	"RegistrySize",
+	"StorageRecordURL",
+}
+
+// CsvValues returns the values of this discrepancy in the order of
+// CsvHeaders.
+func (d *Discrepancy) CsvValues() []string {
+	lastModified := ""
+	if !d.StoredLastModified.IsZero() {
+		lastModified = d.StoredLastModified.Format(time.RFC3339)
+	}
+	return []string{
+		d.Problem,
+		d.Bucket,
+		d.Key,
+		strconv.FormatInt(d.StoredSize, 10),
+		lastModified,
+		strconv.FormatInt(d.GenericFileID, 10),
+		d.GenericFileIdentifier,
+		strconv.FormatInt(d.RegistrySize, 10),
+		d.StorageRecordURL,
+	}
+}
+
+// BucketReport summarizes the reconciliation of a single
+// preservation bucket.
+type BucketReport struct {
+	Bucket         string         `json:"bucket"`
+	ListedAt       time.Time      `json:"listed_at"`
+	ObjectsListed  int64          `json:"objects_listed"`
+	BytesListed    int64          `json:"bytes_listed"`
+	RecordsChecked int64          `json:"records_checked"`
+	Orphans        []*Discrepancy `json:"orphans"`
+	Ghosts         []*Discrepancy `json:"ghosts"`
+	SizeMismatches []*Discrepancy `json:"size_mismatches"`
+	Error          string         `json:"error,omitempty"`
+}
+
+// NewBucketReport returns a new, empty BucketReport.
+func NewBucketReport(bucket string) *BucketReport {
+	return &BucketReport{
+		Bucket:         bucket,
+		Orphans:        make([]*Discrepancy, 0),
+		Ghosts:         make([]*Discrepancy, 0),
+		SizeMismatches: make([]*Discrepancy, 0),
+	}
+}
+
+// DiscrepancyCount returns the total number of problems found in
+// this bucket.
+func (r *BucketReport) DiscrepancyCount() int {
+	return len(r.Orphans) + len(r.Ghosts) + len(r.SizeMismatches)
+}
+
+// Report is the result of an inventory reconciliation run.
+type Report struct {
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Buckets    []*BucketReport `json:"buckets"`
+}
+
+// NewReport returns a new, empty Report.
+func NewReport() *Report {
+	return &Report{
+		StartedAt: time.Now().UTC(),
+		Buckets:   make([]*BucketReport, 0),
+	}
+}
+
+// HasProblems returns true if any bucket has discrepancies or could
+// not be reconciled.
+func (r *Report) HasProblems() bool {
+	for _, b := range r.Buckets {
+		if b.DiscrepancyCount() > 0 || b.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Discrepancies returns all discrepancies from all buckets.
+func (r *Report) Discrepancies() []*Discrepancy {
+	list := make([]*Discrepancy, 0)
+	for _, b := range r.Buckets {
+		list = append(list, b.Orphans...)
+		list = append(list, b.Ghosts...)
+		list = append(list, b.SizeMismatches...)
+	}
+	return list
+}
+
+// Summary returns a short, human-readable description of the report,
+// suitable for log messages and alerts.
+func (r *Report) Summary() string {
+	buf := bytes.Buffer{}
+	for _, b := range r.Buckets {
+		if b.Error != "" {
+			buf.WriteString(fmt.Sprintf("%s: not reconciled: %s\n", b.Bucket, b.Error))
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("%s: %d objects, %d records, %d orphans, %d ghosts, %d size mismatches\n",
+			b.Bucket, b.ObjectsListed, b.RecordsChecked, len(b.Orphans), len(b.Ghosts), len(b.SizeMismatches)))
+	}
+	return buf.String()
+}
+
+// ToJSON returns the report in JSON format.
+func (r *Report) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// WriteCSV writes all discrepancies to w in CSV format.
+func (r *Report) WriteCSV(w io.Writer) error {
+	csvWriter := csv.NewWriter(w)
+	err := csvWriter.Write(CsvHeaders)
+	if err != nil {
+		return err
+	}
+	for _, d := range r.Discrepancies() {
+		err = csvWriter.Write(d.CsvValues())
+		if err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// alertBody is the JSON document posted by SendAlert. It includes
+// counts, not the full discrepancy lists, which may be very large.
+type alertBody struct {
+	Text    string          `json:"text"`
+	Buckets []*bucketCounts `json:"buckets"`
+}
+
+type bucketCounts struct {
+	Bucket         string `json:"bucket"`
+	Orphans        int    `json:"orphans"`
+	Ghosts         int    `json:"ghosts"`
+	SizeMismatches int    `json:"size_mismatches"`
+	Error          string `json:"error,omitempty"`
+}
+
+// SendAlert posts a JSON summary of this report to alertURL. The body
+// includes a "text" field, so it works with Slack-style incoming
+// webhooks, as well as per-bucket counts for other consumers.
+func (r *Report) SendAlert(alertURL string) error {
+	body := &alertBody{
+		Text:    "Preservation inventory discrepancies found:\n" + r.Summary(),
+		Buckets: make([]*bucketCounts, len(r.Buckets)),
+	}
+	for i, b := range r.Buckets {
+		body.Buckets[i] = &bucketCounts{
+			Bucket:         b.Bucket,
+			Orphans:        len(b.Orphans),
+			Ghosts:         len(b.Ghosts),
+			SizeMismatches: len(b.SizeMismatches),
+			Error:          b.Error,
+		}
+	}
+	jsonBytes, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(alertURL, "application/json", bytes.NewReader(jsonBytes))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Alert URL %s returned status %d", alertURL, resp.StatusCode)
+	}
+	return nil
+}
@@ -8,16 +8,19 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/network/queue"
 	"github.com/APTrust/preservation-services/util"
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
+	AdminServerPort            int
 	APTQueueInterval           time.Duration
 	BaseWorkingDir             string
 	BucketGlacierDeepOH        string
@@ -33,18 +36,31 @@ type Config struct {
 	BucketWasabiVA             string
 	ConfigFilePath             string
 	ConfigName                 string
+	DeletionCertificateBucket  string
+	DeletionRetentionDays      int
+	HealthServerAddress        string
+	HealthServerPort           int
 	IngestBucketReaderInterval time.Duration
 	IngestTempDir              string
+	InstitutionLimits          map[string]int // Per worker process. See InstitutionLimitFor.
+	InstitutionMaxInFlight     int            // Per worker process. See InstitutionLimitFor.
+	InstitutionRequeueDelay    time.Duration
 	LogDir                     string
 	LogLevel                   logging.Level
 	MaxDaysSinceFixityCheck    int
 	MaxFileSize                int64
+	MaxFixityBytesPerRun       int64
 	MaxFixityItemsPerRun       int
 	MaxWorkerAttempts          int
 	NsqLookupd                 string
 	NsqURL                     string
+	ObjectLockRetentionDays    int
+	ObjectLockSyncInterval     time.Duration
 	PreservationBuckets        []*PreservationBucket
 	ProfilesDir                string
+	PurgeInterval              time.Duration
+	PurgeStaleAfter            time.Duration
+	QueueBackend               string
 	QueueFixityInterval        time.Duration
 	RedisDefaultDB             int
 	RedisPassword              string `json:"-"`
@@ -57,6 +73,8 @@ type Config struct {
 	RegistryAPIVersion         string
 	RegistryURL                string
 	RestoreDir                 string
+	RestoreTargetRoot          string
+	RestorationReceiptKey      string `json:"-"`
 	S3AWSHost                  string
 	S3Credentials              map[string]*S3Credentials `json:"-"`
 	S3LocalHost                string
@@ -119,6 +137,7 @@ func loadConfig() *Config {
 		util.PrintAndExit(fmt.Sprintf("Fatal error config file: %v \n", err))
 	}
 	return &Config{
+		AdminServerPort:            v.GetInt("ADMIN_SERVER_PORT"),
 		APTQueueInterval:           v.GetDuration("APT_QUEUE_INTERVAL"),
 		BaseWorkingDir:             v.GetString("BASE_WORKING_DIR"),
 		BucketGlacierDeepOH:        v.GetString("BUCKET_GLACIER_DEEP_OH"),
@@ -134,17 +153,30 @@ func loadConfig() *Config {
 		BucketWasabiVA:             v.GetString("BUCKET_WASABI_VA"),
 		ConfigFilePath:             path.Join(configDir, configFile),
 		ConfigName:                 strings.Replace(configFile, ".env.", "", 1),
+		DeletionCertificateBucket:  v.GetString("DELETION_CERTIFICATE_BUCKET"),
+		DeletionRetentionDays:      v.GetInt("DELETION_RETENTION_DAYS"),
+		HealthServerAddress:        v.GetString("HEALTH_SERVER_ADDRESS"),
+		HealthServerPort:           v.GetInt("HEALTH_SERVER_PORT"),
 		IngestBucketReaderInterval: v.GetDuration("INGEST_BUCKET_READER_INTERVAL"),
 		IngestTempDir:              v.GetString("INGEST_TEMP_DIR"),
+		InstitutionLimits:          getInstitutionLimits(v.GetString("INSTITUTION_LIMITS")),
+		InstitutionMaxInFlight:     v.GetInt("INSTITUTION_MAX_IN_FLIGHT"),
+		InstitutionRequeueDelay:    v.GetDuration("INSTITUTION_REQUEUE_DELAY"),
 		LogDir:                     v.GetString("LOG_DIR"),
 		LogLevel:                   getLogLevel(v.GetString("LOG_LEVEL")),
 		MaxDaysSinceFixityCheck:    v.GetInt("MAX_DAYS_SINCE_LAST_FIXITY"),
 		MaxFileSize:                v.GetInt64("MAX_FILE_SIZE"),
+		MaxFixityBytesPerRun:       v.GetInt64("MAX_FIXITY_BYTES_PER_RUN"),
 		MaxFixityItemsPerRun:       v.GetInt("MAX_FIXITY_ITEMS_PER_RUN"),
 		MaxWorkerAttempts:          v.GetInt("MAX_WORKER_ATTEMPTS"),
 		NsqLookupd:                 v.GetString("NSQ_LOOKUPD"),
 		NsqURL:                     v.GetString("NSQ_URL"),
+		ObjectLockRetentionDays:    v.GetInt("OBJECT_LOCK_RETENTION_DAYS"),
+		ObjectLockSyncInterval:     v.GetDuration("OBJECT_LOCK_SYNC_INTERVAL"),
 		ProfilesDir:                v.GetString("PROFILES_DIR"),
+		PurgeInterval:              v.GetDuration("PURGE_INTERVAL"),
+		PurgeStaleAfter:            v.GetDuration("PURGE_STALE_AFTER"),
+		QueueBackend:               v.GetString("QUEUE_BACKEND"),
 		QueueFixityInterval:        v.GetDuration("QUEUE_FIXITY_INTERVAL"),
 		RedisDefaultDB:             v.GetInt("REDIS_DEFAULT_DB"),
 		RedisPassword:              v.GetString("REDIS_PASSWORD"),
@@ -157,6 +189,8 @@ func loadConfig() *Config {
 		RegistryAPIVersion:         v.GetString("PRESERV_REGISTRY_API_VERSION"),
 		RegistryURL:                v.GetString("PRESERV_REGISTRY_URL"),
 		RestoreDir:                 v.GetString("RESTORE_DIR"),
+		RestoreTargetRoot:          v.GetString("RESTORE_TARGET_ROOT"),
+		RestorationReceiptKey:      getRestorationReceiptKey(v, configFile),
 		S3AWSHost:                  v.GetString("S3_AWS_HOST"),
 		S3Credentials: map[string]*S3Credentials{
 			constants.StorageProviderAWS: {
@@ -282,6 +316,46 @@ func (config *Config) GetWorkerSettings(workerName string, bufSizeArg, numWorker
 	return bufSize, numWorkers, maxAttempts
 }
 
+// InstitutionLimitFor returns the maximum number of items from the
+// institution with the specified identifier that each worker should
+// have in process at once. That's the institution's own limit from
+// InstitutionLimits, if it has one, or InstitutionMaxInFlight if not.
+// Zero means no limit.
+//
+// Limits are per worker process. Each process counts only the items it
+// has in flight, so with three processes consuming a topic, an
+// institution may have three times its limit in flight across the
+// cluster.
+func (config *Config) InstitutionLimitFor(identifier string) int {
+	if limit, ok := config.InstitutionLimits[identifier]; ok {
+		return limit
+	}
+	return config.InstitutionMaxInFlight
+}
+
+// ParseInstitutionLimits parses a list of per-institution limits, such
+// as "test.edu=2,example.edu=10", into a map of institution identifier
+// to limit.
+func ParseInstitutionLimits(value string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("Invalid institution limit '%s'. Use identifier=limit.", entry)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("Invalid institution limit '%s'. Limit must be zero or more.", entry)
+		}
+		limits[strings.TrimSpace(parts[0])] = limit
+	}
+	return limits, nil
+}
+
 // CredentialsForS3Host returns the credentials for the specifed
 // S3 host, or nil if no credentials exist for that host.
 func (config *Config) CredentialsForS3Host(host string) (credentials *S3Credentials) {
@@ -320,6 +394,17 @@ func (config *Config) PreservationBucketForUrl(bucketUrl string) *PreservationBu
 	return nil
 }
 
+// CheckRestorationReceiptKey returns an error if no restoration
+// receipt key is configured, unless this is a dev or test config.
+// Receipts are how auditors verify restored bags, so outside of dev
+// and test we don't restore without signing them.
+func (config *Config) CheckRestorationReceiptKey() error {
+	if config.RestorationReceiptKey == "" && config.ConfigName != "dev" && config.ConfigName != "test" {
+		return fmt.Errorf("RESTORATION_RECEIPT_KEY is required in the %s config", config.ConfigName)
+	}
+	return nil
+}
+
 // IsE2ETest returns true if the environment variable APT_E2E is set to "true".
 // This is set only during end-to-end (E2E) tests so we can queue up some
 // items for testing.
@@ -327,6 +412,25 @@ func (config *Config) IsE2ETest() bool {
 	return os.Getenv("APT_E2E") == "true"
 }
 
+// getRestorationReceiptKey returns RESTORATION_RECEIPT_KEY. Dev and
+// test configs may use RESTORATION_RECEIPT_TEST_KEY instead, which is
+// public, so other configs never read it.
+func getRestorationReceiptKey(v *viper.Viper, configFile string) string {
+	key := v.GetString("RESTORATION_RECEIPT_KEY")
+	if key == "" && (configFile == ".env.dev" || configFile == ".env.test") {
+		key = v.GetString("RESTORATION_RECEIPT_TEST_KEY")
+	}
+	return key
+}
+
+func getInstitutionLimits(value string) map[string]int {
+	limits, err := ParseInstitutionLimits(value)
+	if err != nil {
+		util.PrintAndExit(fmt.Sprintf("Fatal error in INSTITUTION_LIMITS: %v", err))
+	}
+	return limits
+}
+
 func getLogLevel(level string) logging.Level {
 	if level == "" {
 		level = "INFO"
@@ -341,6 +445,7 @@ func (config *Config) expandPaths() {
 	config.LogDir = expandPath(config.LogDir)
 	config.ProfilesDir = expandPath(config.ProfilesDir)
 	config.RestoreDir = expandPath(config.RestoreDir)
+	config.RestoreTargetRoot = expandPath(config.RestoreTargetRoot)
 }
 
 func expandPath(dirName string) string {
@@ -505,6 +610,9 @@ func (config *Config) checkBasicSettings() {
 	if config.VolumeServiceURL == "" {
 		util.PrintAndExit("Config is missing VolumeServiceURL")
 	}
+	if config.QueueBackend != "" && config.QueueBackend != queue.BackendNSQ && config.QueueBackend != queue.BackendMemory {
+		util.PrintAndExit(fmt.Sprintf("Config setting for QueueBackend should be %s or %s", queue.BackendNSQ, queue.BackendMemory))
+	}
 }
 
 func (config *Config) checkS3Providers() {
@@ -19,6 +19,7 @@ func TestNewConfig(t *testing.T) {
 	tempDir, _ := util.ExpandTilde("~/tmp/pres-serv/ingest")
 	logDir, _ := util.ExpandTilde("~/tmp/logs")
 	restoreDir, _ := util.ExpandTilde("~/tmp/pres-serv/restore")
+	restoreTargetRoot, _ := util.ExpandTilde("~/tmp/pres-serv/restore-targets")
 
 	config := common.NewConfig()
 	assert.Equal(t, workingDir, config.BaseWorkingDir)
@@ -56,6 +57,7 @@ func TestNewConfig(t *testing.T) {
 	assert.Equal(t, "v3", config.RegistryAPIVersion)
 	assert.Equal(t, "http://localhost:8080", config.RegistryURL)
 	assert.Equal(t, restoreDir, config.RestoreDir)
+	assert.Equal(t, restoreTargetRoot, config.RestoreTargetRoot)
 	assert.Equal(t, "staging", config.StagingBucket)
 	assert.Equal(t, time.Duration(250*time.Millisecond), config.StagingUploadRetryMs)
 	assert.Equal(t, "http://localhost:8898", config.VolumeServiceURL)
@@ -132,6 +134,47 @@ func TestGetWorkerSettings(t *testing.T) {
 	assert.Equal(t, 3, maxAttempts) // REINGEST_MANAGER_MAX_ATTEMPTS
 }
 
+func TestInstitutionLimitFor(t *testing.T) {
+	config := common.NewConfig()
+	assert.Equal(t, 0, config.InstitutionLimitFor("test.edu"))
+	assert.Equal(t, 30*time.Second, config.InstitutionRequeueDelay)
+
+	config.InstitutionMaxInFlight = 5
+	config.InstitutionLimits = map[string]int{"test.edu": 2, "example.edu": 0}
+	assert.Equal(t, 2, config.InstitutionLimitFor("test.edu"))
+	assert.Equal(t, 0, config.InstitutionLimitFor("example.edu"))
+	assert.Equal(t, 5, config.InstitutionLimitFor("other.edu"))
+}
+
+func TestCheckRestorationReceiptKey(t *testing.T) {
+	// The test config gets the public test key.
+	config := common.NewConfig()
+	assert.NotEmpty(t, config.RestorationReceiptKey)
+	assert.Nil(t, config.CheckRestorationReceiptKey())
+
+	config.RestorationReceiptKey = ""
+	assert.Nil(t, config.CheckRestorationReceiptKey())
+	config.ConfigName = "staging"
+	err := config.CheckRestorationReceiptKey()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "RESTORATION_RECEIPT_KEY")
+}
+
+func TestParseInstitutionLimits(t *testing.T) {
+	limits, err := common.ParseInstitutionLimits(" test.edu=2, example.edu = 10,")
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int{"test.edu": 2, "example.edu": 10}, limits)
+
+	limits, err = common.ParseInstitutionLimits("")
+	require.Nil(t, err)
+	assert.Empty(t, limits)
+
+	for _, value := range []string{"test.edu", "=2", "test.edu=two", "test.edu=-1"} {
+		_, err = common.ParseInstitutionLimits(value)
+		assert.NotNil(t, err, value)
+	}
+}
+
 func TestToJson(t *testing.T) {
 	config := common.NewConfig()
 	jsonString := config.ToJSON()
@@ -8,6 +8,7 @@ import (
 
 	"github.com/APTrust/preservation-services/constants"
 	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/network/queue"
 	"github.com/APTrust/preservation-services/util/logger"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -26,6 +27,11 @@ type Context struct {
 	RedisClient    *network.RedisClient
 	RegistryClient *network.RegistryClient
 
+	// Queue is the work queue that connects the workers. It's NSQ
+	// unless Config.QueueBackend says otherwise. NSQClient remains for
+	// NSQ-specific operations, like getting stats.
+	Queue queue.Queue
+
 	// S3Clients is a map of S3 clients, where key
 	// is either a generic host name like "s3.amazonaws.com"
 	// or a specific bucket name, like "aptrust.preservation.oregon".
@@ -42,6 +48,7 @@ func NewContext() *Context {
 		Config:         config,
 		Logger:         _logger,
 		NSQClient:      getNsqClient(config),
+		Queue:          getQueue(config),
 		RedisClient:    getRedisClient(config),
 		RegistryClient: getRegistryClient(config, _logger),
 		S3Clients:      getS3Clients(config, _logger),
@@ -57,6 +64,15 @@ func getNsqClient(config *Config) *network.NSQClient {
 	return network.NewNSQClient(config.NsqURL)
 }
 
+// getQueue returns the queue named in config.QueueBackend. All workers
+// in a process share the same in-process queue.
+func getQueue(config *Config) queue.Queue {
+	if config.QueueBackend == queue.BackendMemory {
+		return queue.SharedMemory()
+	}
+	return queue.NewNSQ(config.NsqURL, config.NsqLookupd)
+}
+
 func getRedisClient(config *Config) *network.RedisClient {
 	return network.NewRedisClient(
 		config.RedisURL,
@@ -8,13 +8,19 @@ import (
 type Institution struct {
 	CreatedAt           time.Time `json:"created_at"`
 	DeactivatedAt       time.Time `json:"deactivated_at,omitempty"`
+	GlacierRestoreDays  int       `json:"glacier_restore_days,omitempty"`
+	GlacierRestoreTier  string    `json:"glacier_restore_tier,omitempty"`
 	ID                  int64     `json:"id"`
 	Identifier          string    `json:"identifier"`
+	LegalHold           *bool     `json:"legal_hold,omitempty"`
+	LegalHoldReason     string    `json:"legal_hold_reason,omitempty"`
 	MemberInstitutionID int64     `json:"member_institution_id"`
 	Name                string    `json:"name"`
 	OTPEnabled          bool      `json:"otp_enabled"`
 	ReceivingBucket     string    `json:"receiving_bucket"`
 	RestoreBucket       string    `json:"restore_bucket"`
+	RestoreBuckets      []string  `json:"restore_buckets,omitempty"`
+	RetentionDays       int       `json:"retention_days,omitempty"`
 	State               string    `json:"state"`
 	Type                string    `json:"type"`
 	UpdatedAt           time.Time `json:"updated_at"`
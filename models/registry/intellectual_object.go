@@ -8,29 +8,32 @@ import (
 )
 
 type IntellectualObject struct {
-	Access                    string    `json:"access"`
-	AltIdentifier             string    `json:"alt_identifier"`
-	BagGroupIdentifier        string    `json:"bag_group_identifier"`
-	BagItProfileIdentifier    string    `json:"bagit_profile_identifier"`
-	BagName                   string    `json:"bag_name"`
-	CreatedAt                 time.Time `json:"created_at"`
-	Description               string    `json:"description"`
-	ETag                      string    `json:"etag"`
-	FileCount                 int64     `json:"file_count"`
-	Size                      int64     `json:"size"`
-	ID                        int64     `json:"id"`
-	Identifier                string    `json:"identifier"`
-	InternalSenderDescription string    `json:"internal_sender_description"`
-	InternalSenderIdentifier  string    `json:"internal_sender_identifier"`
-	InstitutionIdentifier     string    `json:"institution_identifier"`
-	InstitutionID             int64     `json:"institution_id"`
-	PayloadFileCount          int64     `json:"payload_file_count"`
-	PayloadSize               int64     `json:"payload_size"`
-	SourceOrganization        string    `json:"source_organization"`
-	State                     string    `json:"state"`
-	StorageOption             string    `json:"storage_option"`
-	Title                     string    `json:"title"`
-	UpdatedAt                 time.Time `json:"updated_at"`
+	Access                    string     `json:"access"`
+	AltIdentifier             string     `json:"alt_identifier"`
+	BagGroupIdentifier        string     `json:"bag_group_identifier"`
+	BagItProfileIdentifier    string     `json:"bagit_profile_identifier"`
+	BagName                   string     `json:"bag_name"`
+	CreatedAt                 time.Time  `json:"created_at"`
+	Description               string     `json:"description"`
+	ETag                      string     `json:"etag"`
+	FileCount                 int64      `json:"file_count"`
+	Size                      int64      `json:"size"`
+	ID                        int64      `json:"id"`
+	Identifier                string     `json:"identifier"`
+	InternalSenderDescription string     `json:"internal_sender_description"`
+	InternalSenderIdentifier  string     `json:"internal_sender_identifier"`
+	InstitutionIdentifier     string     `json:"institution_identifier"`
+	InstitutionID             int64      `json:"institution_id"`
+	LegalHold                 *bool      `json:"legal_hold,omitempty"`
+	LegalHoldReason           string     `json:"legal_hold_reason,omitempty"`
+	PayloadFileCount          int64      `json:"payload_file_count"`
+	PayloadSize               int64      `json:"payload_size"`
+	RetainUntil               *time.Time `json:"retain_until,omitempty"`
+	SourceOrganization        string     `json:"source_organization"`
+	State                     string     `json:"state"`
+	StorageOption             string     `json:"storage_option"`
+	Title                     string     `json:"title"`
+	UpdatedAt                 time.Time  `json:"updated_at"`
 }
 
 func IntellectualObjectFromJSON(jsonData []byte) (*IntellectualObject, error) {
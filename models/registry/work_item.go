@@ -38,6 +38,49 @@ type WorkItem struct {
 	UpdatedAt         time.Time `json:"updated_at,omitempty"`
 	User              string    `json:"user"`
 
+	// RestoreIncludePatterns and RestoreExcludePatterns are glob
+	// patterns over the PathInBag of payload files. When either is set
+	// on an object restoration request, we restore only the matching
+	// payload files.
+	RestoreIncludePatterns []string `json:"restore_include_patterns,omitempty"`
+	RestoreExcludePatterns []string `json:"restore_exclude_patterns,omitempty"`
+
+	// RestoreAsOf asks for a historical restoration of the object or
+	// file as it was at this time. Nil means restore the current version.
+	RestoreAsOf *time.Time `json:"restore_as_of,omitempty"`
+
+	// RestorationFormat is the packaging the depositor requested for
+	// a restored bag. See constants.RestorationFormats. Empty means tar.
+	RestorationFormat string `json:"restoration_format,omitempty"`
+
+	// GlacierRestoreTier and GlacierRestoreDays set the retrieval tier
+	// (Expedited, Standard or Bulk) and the number of days restored
+	// copies stay in S3 for a Glacier restoration. Empty or zero means
+	// use the institution's default.
+	GlacierRestoreTier string `json:"glacier_restore_tier,omitempty"`
+	GlacierRestoreDays int    `json:"glacier_restore_days,omitempty"`
+
+	// RestoreTargetProvider, RestoreTargetBucket and RestoreTargetPrefix
+	// override the institution's default restoration bucket on AWS.
+	// RestoreTargetDir, set by an operator, restores to a directory on
+	// the worker's local disk instead of to S3.
+	RestoreTargetProvider string `json:"restore_target_provider,omitempty"`
+	RestoreTargetBucket   string `json:"restore_target_bucket,omitempty"`
+	RestoreTargetPrefix   string `json:"restore_target_prefix,omitempty"`
+	RestoreTargetDir      string `json:"restore_target_dir,omitempty"`
+
+	// PurgeAfter is set on deletion requests once the files are marked
+	// pending deletion. apt_purge removes them from preservation storage
+	// after this time, unless an admin cancels the deletion first.
+	PurgeAfter *time.Time `json:"purge_after,omitempty"`
+
+	// Priority lets urgent work, such as a restoration a depositor needs
+	// right away, skip ahead of other items. Items with a priority above
+	// zero go to their worker's priority topic, which workers process
+	// first, and workers never hold them back to keep an institution
+	// under its in-flight limit. Zero is normal priority.
+	Priority int `json:"priority,omitempty"`
+
 	// GenericFileIdentifier is read-only, from view.
 	GenericFileIdentifier string `json:"generic_file_identifier"`
 	// GenericFileID is read-only, from view.
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/registry"
+)
+
+// DeadLetter describes a WorkItem a worker has given up on, either
+// because processing hit a fatal error, because it failed too many
+// times, or because the WorkItem says not to retry it. Workers publish
+// these to their dead-letter topic (see constants.DeadLetterTopicFor),
+// where apt_dead_letter can list, inspect and replay them.
+type DeadLetter struct {
+	WorkItemID int64  `json:"work_item_id"`
+	Action     string `json:"action"`
+	Stage      string `json:"stage"`
+	Status     string `json:"status"`
+	Name       string `json:"name"`
+
+	// Identifier is the identifier of the object or file the WorkItem
+	// pertains to. It's empty for ingests that haven't been recorded.
+	Identifier string `json:"identifier,omitempty"`
+
+	// Topic is the topic of the worker that gave up on the item.
+	Topic string `json:"topic"`
+
+	// Reason says why the worker gave up.
+	Reason string `json:"reason"`
+
+	// Attempts is the number of times the worker tried to process
+	// the item.
+	Attempts int `json:"attempts"`
+
+	// Errors are the errors from the WorkResult of the last attempt.
+	Errors []*ProcessingError `json:"errors"`
+
+	Host           string    `json:"host"`
+	DeadLetteredAt time.Time `json:"dead_lettered_at"`
+}
+
+// NewDeadLetter returns a DeadLetter describing workItem, which the
+// worker consuming topic has given up on for the specified reason.
+// Param workResult may be nil.
+func NewDeadLetter(workItem *registry.WorkItem, workResult *WorkResult, topic, reason string) *DeadLetter {
+	identifier := workItem.ObjectIdentifier
+	if workItem.GenericFileIdentifier != "" {
+		identifier = workItem.GenericFileIdentifier
+	}
+	host, _ := os.Hostname()
+	letter := &DeadLetter{
+		WorkItemID:     workItem.ID,
+		Action:         workItem.Action,
+		Stage:          workItem.Stage,
+		Status:         workItem.Status,
+		Name:           workItem.Name,
+		Identifier:     identifier,
+		Topic:          topic,
+		Reason:         reason,
+		Errors:         make([]*ProcessingError, 0),
+		Host:           host,
+		DeadLetteredAt: time.Now().UTC(),
+	}
+	if workResult != nil {
+		letter.Attempts = workResult.Attempt
+		letter.Errors = append(letter.Errors, workResult.Errors...)
+	}
+	return letter
+}
+
+// DeadLetterFromJSON converts the JSON representation of a DeadLetter
+// to an actual object.
+func DeadLetterFromJSON(jsonData string) (*DeadLetter, error) {
+	letter := &DeadLetter{}
+	err := json.Unmarshal([]byte(jsonData), letter)
+	if err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
+
+// ToJSON converts this object to its JSON representation.
+func (letter *DeadLetter) ToJSON() (string, error) {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,53 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetter(t *testing.T) {
+	workItem := &registry.WorkItem{
+		ID:                    5150,
+		Action:                constants.ActionRestoreFile,
+		Stage:                 constants.StageRequested,
+		Status:                constants.StatusStarted,
+		Name:                  "bag.tar",
+		ObjectIdentifier:      "test.edu/bag",
+		GenericFileIdentifier: "test.edu/bag/data/file.txt",
+	}
+	workResult := service.NewWorkResult(constants.TopicFileRestore)
+	workResult.Attempt = 3
+	workResult.AddError(service.NewProcessingError(5150, "test.edu/bag/data/file.txt", "no copies left", true))
+
+	letter := service.NewDeadLetter(workItem, workResult, constants.TopicFileRestore, "fatal error")
+	assert.Equal(t, int64(5150), letter.WorkItemID)
+	assert.Equal(t, constants.ActionRestoreFile, letter.Action)
+	assert.Equal(t, constants.StageRequested, letter.Stage)
+	assert.Equal(t, constants.StatusStarted, letter.Status)
+	assert.Equal(t, "test.edu/bag/data/file.txt", letter.Identifier)
+	assert.Equal(t, constants.TopicFileRestore, letter.Topic)
+	assert.Equal(t, "fatal error", letter.Reason)
+	assert.Equal(t, 3, letter.Attempts)
+	require.Equal(t, 1, len(letter.Errors))
+	assert.Equal(t, "no copies left", letter.Errors[0].Message)
+	assert.NotEmpty(t, letter.Host)
+	assert.False(t, letter.DeadLetteredAt.IsZero())
+
+	data, err := letter.ToJSON()
+	require.Nil(t, err)
+	parsed, err := service.DeadLetterFromJSON(data)
+	require.Nil(t, err)
+	assert.Equal(t, letter, parsed)
+
+	// No WorkResult, as when ShouldRetry rejects an item.
+	workItem.GenericFileIdentifier = ""
+	letter = service.NewDeadLetter(workItem, nil, constants.TopicObjectRestore, "retry is false")
+	assert.Equal(t, "test.edu/bag", letter.Identifier)
+	assert.Equal(t, 0, letter.Attempts)
+	assert.Empty(t, letter.Errors)
+}
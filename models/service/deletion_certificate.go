@@ -0,0 +1,114 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// DeletionCertificate is the compliance record of a completed deletion.
+// It lists every preservation copy we deleted, with the number of its
+// versions we removed, when we deleted it, when we confirmed that no
+// version of it remained, and who requested and approved the deletion.
+type DeletionCertificate struct {
+	WorkItemID      int64          `json:"work_item_id"`
+	Identifier      string         `json:"identifier"`
+	ItemType        string         `json:"item_type"`
+	RequestedBy     string         `json:"requested_by"`
+	InstApprover    string         `json:"inst_approver"`
+	APTrustApprover string         `json:"aptrust_approver,omitempty"`
+	CompletedAt     time.Time      `json:"completed_at"`
+	Files           []*DeletedFile `json:"files"`
+}
+
+// DeletedFile records the deletion of all of a file's preservation
+// copies. We keep these in Redis while a deletion is in progress, so
+// the verification pass can check every copy, even those deleted on an
+// earlier attempt.
+type DeletedFile struct {
+	Identifier    string         `json:"identifier"`
+	GenericFileID int64          `json:"generic_file_id"`
+	Copies        []*DeletedCopy `json:"copies"`
+}
+
+// DeletedCopy records the deletion of one preservation copy of a file.
+type DeletedCopy struct {
+	URL string `json:"url"`
+
+	// DeletedAt is when we first deleted the copy.
+	DeletedAt time.Time `json:"deleted_at"`
+
+	// VerifiedAt is when S3 confirmed the copy was gone. It's zero
+	// until then.
+	VerifiedAt time.Time `json:"verified_at"`
+
+	// Attempts is the number of times we've asked S3 to delete the
+	// copy. It's more than one if the copy lingered after deletion.
+	Attempts int `json:"attempts"`
+
+	// Versions is the number of object versions, including delete
+	// markers, that we removed. We delete every version, so no
+	// noncurrent version of the copy remains in a versioned bucket.
+	Versions int `json:"versions"`
+}
+
+// AddCopy records the deletion of versions versions of the copy at url.
+// If we've already recorded it, this counts another attempt.
+func (f *DeletedFile) AddCopy(url string, deletedAt time.Time, versions int) {
+	for _, c := range f.Copies {
+		if c.URL == url {
+			c.Attempts++
+			c.Versions += versions
+			return
+		}
+	}
+	f.Copies = append(f.Copies, &DeletedCopy{
+		URL:       url,
+		DeletedAt: deletedAt,
+		Attempts:  1,
+		Versions:  versions,
+	})
+}
+
+// IsVerified returns true if S3 has confirmed that all copies are gone.
+func (f *DeletedFile) IsVerified() bool {
+	for _, c := range f.Copies {
+		if c.VerifiedAt.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// DeletedFileFromJSON converts the JSON representation of a DeletedFile
+// to an actual object.
+func DeletedFileFromJSON(jsonData string) (*DeletedFile, error) {
+	f := &DeletedFile{}
+	err := json.Unmarshal([]byte(jsonData), f)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ToJSON converts this object to its JSON representation.
+func (f *DeletedFile) ToJSON() (string, error) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// SortFiles sorts the certificate's files by identifier, so certificates
+// for the same deletion list files in the same order.
+func (c *DeletionCertificate) SortFiles() {
+	sort.Slice(c.Files, func(i, j int) bool {
+		return c.Files[i].Identifier < c.Files[j].Identifier
+	})
+}
+
+// ToJSON converts this certificate to its indented JSON representation.
+func (c *DeletionCertificate) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(c, "", "  ")
+}
@@ -0,0 +1,57 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeletedFile(t *testing.T) {
+	deletedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	f := &service.DeletedFile{Identifier: "test.edu/bag/data/file.txt", GenericFileID: 55}
+	assert.True(t, f.IsVerified())
+
+	f.AddCopy("https://s3.amazonaws.com/preservation-va/uuid", deletedAt, 1)
+	f.AddCopy("https://s3.wasabisys.com/wasabi-or/uuid", deletedAt, 3)
+	f.AddCopy("https://s3.wasabisys.com/wasabi-or/uuid", deletedAt.Add(time.Minute), 1)
+	require.Equal(t, 2, len(f.Copies))
+	assert.Equal(t, 1, f.Copies[0].Attempts)
+	assert.Equal(t, 2, f.Copies[1].Attempts)
+	assert.Equal(t, 1, f.Copies[0].Versions)
+	assert.Equal(t, 4, f.Copies[1].Versions)
+	assert.Equal(t, deletedAt, f.Copies[1].DeletedAt)
+	assert.False(t, f.IsVerified())
+
+	f.Copies[0].VerifiedAt = deletedAt
+	assert.False(t, f.IsVerified())
+	f.Copies[1].VerifiedAt = deletedAt
+	assert.True(t, f.IsVerified())
+
+	data, err := f.ToJSON()
+	require.Nil(t, err)
+	parsed, err := service.DeletedFileFromJSON(data)
+	require.Nil(t, err)
+	assert.Equal(t, f, parsed)
+}
+
+func TestDeletionCertificate(t *testing.T) {
+	cert := &service.DeletionCertificate{
+		WorkItemID:   1234,
+		Identifier:   "test.edu/bag",
+		RequestedBy:  "requestor@example.com",
+		InstApprover: "approver@example.com",
+		Files: []*service.DeletedFile{
+			{Identifier: "test.edu/bag/data/b.txt"},
+			{Identifier: "test.edu/bag/data/a.txt"},
+		},
+	}
+	cert.SortFiles()
+	assert.Equal(t, "test.edu/bag/data/a.txt", cert.Files[0].Identifier)
+	data, err := cert.ToJSON()
+	require.Nil(t, err)
+	assert.Contains(t, string(data), `"inst_approver": "approver@example.com"`)
+	assert.NotContains(t, string(data), "aptrust_approver")
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/APTrust/preservation-services/util"
+)
+
+// DeletionReport describes what a deletion would remove from
+// preservation storage. The deletion manager produces one in dry-run
+// mode, so admins can review a deletion before approving it.
+type DeletionReport struct {
+	// Identifier is the identifier of the object or file to be deleted.
+	Identifier string `json:"identifier"`
+
+	// WorkItemID is the ID of the deletion WorkItem, if the dry run
+	// was requested through one.
+	WorkItemID int64 `json:"work_item_id,omitempty"`
+
+	// GeneratedAt is when we produced the report.
+	GeneratedAt time.Time `json:"generated_at"`
+
+	// Blocked describes the legal hold or retention policy that would
+	// block this deletion. It's empty if deletion may proceed.
+	Blocked string `json:"blocked,omitempty"`
+
+	// Files lists the files that would be deleted.
+	Files []*DeletionReportFile `json:"files"`
+
+	// Totals sums up Files by storage option.
+	Totals map[string]*DeletionReportTotal `json:"totals"`
+}
+
+// DeletionReportFile describes one file a deletion would remove.
+type DeletionReportFile struct {
+	Identifier    string                `json:"identifier"`
+	Size          int64                 `json:"size"`
+	StorageOption string                `json:"storage_option"`
+	Copies        []*DeletionReportCopy `json:"copies"`
+}
+
+// DeletionReportCopy describes one preservation copy of a file, as
+// recorded in a Registry StorageRecord and confirmed by S3.
+type DeletionReportCopy struct {
+	URL          string `json:"url"`
+	Provider     string `json:"provider"`
+	Bucket       string `json:"bucket"`
+	StorageClass string `json:"storage_class"`
+
+	// Exists is true if S3 says the copy exists. Size is the size S3
+	// reports. Error describes what went wrong if we couldn't check.
+	Exists bool   `json:"exists"`
+	Size   int64  `json:"size"`
+	Error  string `json:"error,omitempty"`
+}
+
+// DeletionReportTotal sums up the files and copies a deletion would
+// remove from one storage option.
+type DeletionReportTotal struct {
+	Files         int   `json:"files"`
+	Bytes         int64 `json:"bytes"`
+	Copies        int   `json:"copies"`
+	CopyBytes     int64 `json:"copy_bytes"`
+	MissingCopies int   `json:"missing_copies"`
+}
+
+// NewDeletionReport returns an empty report for a deletion of the object
+// or file with the specified identifier.
+func NewDeletionReport(identifier string, workItemID int64) *DeletionReport {
+	return &DeletionReport{
+		Identifier:  identifier,
+		WorkItemID:  workItemID,
+		GeneratedAt: time.Now().UTC(),
+		Files:       make([]*DeletionReportFile, 0),
+		Totals:      make(map[string]*DeletionReportTotal),
+	}
+}
+
+// AddFile adds file to the report and its totals.
+func (r *DeletionReport) AddFile(file *DeletionReportFile) {
+	r.Files = append(r.Files, file)
+	total := r.Totals[file.StorageOption]
+	if total == nil {
+		total = &DeletionReportTotal{}
+		r.Totals[file.StorageOption] = total
+	}
+	total.Files++
+	total.Bytes += file.Size
+	for _, c := range file.Copies {
+		if c.Exists {
+			total.Copies++
+			total.CopyBytes += c.Size
+		} else {
+			total.MissingCopies++
+		}
+	}
+}
+
+// Summary returns a description of the report for the WorkItem note.
+func (r *DeletionReport) Summary() string {
+	options := make([]string, 0, len(r.Totals))
+	for option := range r.Totals {
+		options = append(options, option)
+	}
+	sort.Strings(options)
+	var files, copies, missing int
+	var bytes, copyBytes int64
+	parts := make([]string, len(options))
+	for i, option := range options {
+		total := r.Totals[option]
+		files += total.Files
+		bytes += total.Bytes
+		copies += total.Copies
+		copyBytes += total.CopyBytes
+		missing += total.MissingCopies
+		parts[i] = fmt.Sprintf("%s: %d files, %d copies (%s)", option, total.Files, total.Copies, util.ToHumanSize(total.CopyBytes))
+	}
+	summary := fmt.Sprintf("Dry run: deleting %s would remove %d files (%s) stored as %d copies (%s).",
+		r.Identifier, files, util.ToHumanSize(bytes), copies, util.ToHumanSize(copyBytes))
+	if len(parts) > 0 {
+		summary += " " + strings.Join(parts, "; ") + "."
+	}
+	if missing > 0 {
+		summary += fmt.Sprintf(" %d copies listed in Registry could not be found in storage.", missing)
+	}
+	if r.Blocked != "" {
+		summary += " " + r.Blocked + "."
+	}
+	return summary
+}
+
+// ToJSON converts this report to its JSON representation.
+func (r *DeletionReport) ToJSON() (string, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,58 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeletionReport(t *testing.T) {
+	report := service.NewDeletionReport("test.edu/bag", 1234)
+	report.AddFile(&service.DeletionReportFile{
+		Identifier:    "test.edu/bag/data/file1.txt",
+		Size:          1000,
+		StorageOption: constants.StorageStandard,
+		Copies: []*service.DeletionReportCopy{
+			{Bucket: "preservation-va", Exists: true, Size: 1000},
+			{Bucket: "preservation-or", Exists: true, Size: 1000},
+		},
+	})
+	report.AddFile(&service.DeletionReportFile{
+		Identifier:    "test.edu/bag/data/file2.txt",
+		Size:          500,
+		StorageOption: constants.StorageGlacierOH,
+		Copies: []*service.DeletionReportCopy{
+			{Bucket: "glacier-oh", Exists: false},
+		},
+	})
+
+	standard := report.Totals[constants.StorageStandard]
+	require.NotNil(t, standard)
+	assert.Equal(t, 1, standard.Files)
+	assert.EqualValues(t, 1000, standard.Bytes)
+	assert.Equal(t, 2, standard.Copies)
+	assert.EqualValues(t, 2000, standard.CopyBytes)
+	assert.Equal(t, 0, standard.MissingCopies)
+	glacier := report.Totals[constants.StorageGlacierOH]
+	require.NotNil(t, glacier)
+	assert.Equal(t, 1, glacier.Files)
+	assert.Equal(t, 0, glacier.Copies)
+	assert.Equal(t, 1, glacier.MissingCopies)
+
+	summary := report.Summary()
+	assert.Contains(t, summary, "Dry run: deleting test.edu/bag would remove 2 files")
+	assert.Contains(t, summary, "stored as 2 copies")
+	assert.Contains(t, summary, "Glacier-OH: 1 files, 0 copies")
+	assert.Contains(t, summary, "Standard: 1 files, 2 copies")
+	assert.Contains(t, summary, "1 copies listed in Registry could not be found in storage.")
+
+	report.Blocked = "Deletion of test.edu/bag is blocked: object is under legal hold"
+	assert.Contains(t, report.Summary(), "object is under legal hold.")
+
+	data, err := report.ToJSON()
+	require.Nil(t, err)
+	assert.Contains(t, data, `"missing_copies": 1`)
+}
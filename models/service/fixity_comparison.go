@@ -0,0 +1,123 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ReplicaDigest describes the digest calculated for one preservation
+// copy of a file during fixity re-verification.
+type ReplicaDigest struct {
+	Bucket  string `json:"bucket"`
+	URL     string `json:"url"`
+	Digest  string `json:"digest"`
+	Error   string `json:"error,omitempty"`
+	Matches bool   `json:"matches"`
+
+	// Reread is true if this digest came from a second read of the
+	// same copy, rather than from an independent replica.
+	Reread bool `json:"reread"`
+}
+
+// FixityComparison records the outcome of re-verifying a file after
+// a fixity mismatch. The first replica is the copy on which the
+// mismatch was originally detected.
+type FixityComparison struct {
+	GenericFileID         int64            `json:"generic_file_id"`
+	GenericFileIdentifier string           `json:"generic_file_identifier"`
+	Algorithm             string           `json:"algorithm"`
+	ExpectedDigest        string           `json:"expected_digest"`
+	Replicas              []*ReplicaDigest `json:"replicas"`
+	Verdict               string           `json:"verdict"`
+	ComparedAt            time.Time        `json:"compared_at"`
+}
+
+// FixityComparisonFromJSON converts the JSON representation of a
+// FixityComparison to an actual object.
+func FixityComparisonFromJSON(jsonData string) (*FixityComparison, error) {
+	obj := &FixityComparison{}
+	err := json.Unmarshal([]byte(jsonData), obj)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ToJSON converts this object to its JSON representation.
+func (obj *FixityComparison) ToJSON() (string, error) {
+	bytes, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// QuarantinedReplica describes a preservation copy that failed fixity
+// re-verification. Restoration and fixity workers should not treat a
+// quarantined copy as a good source until it has been repaired.
+type QuarantinedReplica struct {
+	GenericFileID         int64     `json:"generic_file_id"`
+	GenericFileIdentifier string    `json:"generic_file_identifier"`
+	URL                   string    `json:"url"`
+	ExpectedDigest        string    `json:"expected_digest"`
+	ActualDigest          string    `json:"actual_digest"`
+	Verdict               string    `json:"verdict"`
+	QuarantinedAt         time.Time `json:"quarantined_at"`
+}
+
+// QuarantinedReplicaFromJSON converts the JSON representation of a
+// QuarantinedReplica to an actual object.
+func QuarantinedReplicaFromJSON(jsonData string) (*QuarantinedReplica, error) {
+	obj := &QuarantinedReplica{}
+	err := json.Unmarshal([]byte(jsonData), obj)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ToJSON converts this object to its JSON representation.
+func (obj *QuarantinedReplica) ToJSON() (string, error) {
+	bytes, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// FixityProgress records how far a fixity check has gotten through a
+// large file, so that a check interrupted by a network error or a
+// worker restart can pick up where it left off.
+//
+// HashState is the binary-marshaled state of the digest after Offset
+// bytes. ETag and Size let us detect an object that changed since the
+// last attempt, in which case we start over.
+type FixityProgress struct {
+	GenericFileID int64     `json:"generic_file_id"`
+	URL           string    `json:"url"`
+	ETag          string    `json:"etag"`
+	Size          int64     `json:"size"`
+	Offset        int64     `json:"offset"`
+	HashState     []byte    `json:"hash_state"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// FixityProgressFromJSON converts the JSON representation of a
+// FixityProgress to an actual object.
+func FixityProgressFromJSON(jsonData string) (*FixityProgress, error) {
+	obj := &FixityProgress{}
+	err := json.Unmarshal([]byte(jsonData), obj)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ToJSON converts this object to its JSON representation.
+func (obj *FixityProgress) ToJSON() (string, error) {
+	bytes, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
@@ -0,0 +1,64 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixityComparisonJSON(t *testing.T) {
+	comparison := &service.FixityComparison{
+		GenericFileID:         88,
+		GenericFileIdentifier: "test.edu/bag/data/file.txt",
+		Algorithm:             "sha256",
+		ExpectedDigest:        "1111",
+		Verdict:               "replica_corrupt",
+		ComparedAt:            time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+		Replicas: []*service.ReplicaDigest{
+			{Bucket: "va", URL: "https://example.com/va/uuid", Digest: "2222"},
+			{Bucket: "or", URL: "https://example.com/or/uuid", Digest: "1111", Matches: true},
+		},
+	}
+	jsonStr, err := comparison.ToJSON()
+	require.Nil(t, err)
+	copied, err := service.FixityComparisonFromJSON(jsonStr)
+	require.Nil(t, err)
+	assert.Equal(t, comparison, copied)
+}
+
+func TestQuarantinedReplicaJSON(t *testing.T) {
+	replica := &service.QuarantinedReplica{
+		GenericFileID:         88,
+		GenericFileIdentifier: "test.edu/bag/data/file.txt",
+		URL:                   "https://example.com/va/uuid",
+		ExpectedDigest:        "1111",
+		ActualDigest:          "2222",
+		Verdict:               "replica_corrupt",
+		QuarantinedAt:         time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	jsonStr, err := replica.ToJSON()
+	require.Nil(t, err)
+	copied, err := service.QuarantinedReplicaFromJSON(jsonStr)
+	require.Nil(t, err)
+	assert.Equal(t, replica, copied)
+}
+
+func TestFixityProgressJSON(t *testing.T) {
+	progress := &service.FixityProgress{
+		GenericFileID: 88,
+		URL:           "https://example.com/va/uuid",
+		ETag:          "abc123",
+		Size:          5000000,
+		Offset:        2000000,
+		HashState:     []byte{0x01, 0x02, 0x03},
+		UpdatedAt:     time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	jsonStr, err := progress.ToJSON()
+	require.Nil(t, err)
+	copied, err := service.FixityProgressFromJSON(jsonStr)
+	require.Nil(t, err)
+	assert.Equal(t, progress, copied)
+}
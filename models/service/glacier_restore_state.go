@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/APTrust/preservation-services/util"
+)
+
+// GlacierRestoreState tracks the Glacier restore request for one file
+// in a restoration, so we can check on it with a HEAD request instead
+// of asking Glacier to restore it again.
+type GlacierRestoreState struct {
+	// GenericFileIdentifier is the identifier of the file being
+	// restored.
+	GenericFileIdentifier string `json:"generic_file_identifier"`
+
+	// URL is the URL of the Glacier copy. VersionID is set for
+	// historical restorations.
+	URL       string `json:"url"`
+	VersionID string `json:"version_id,omitempty"`
+
+	// Tier is the retrieval tier we requested. Days is how long the
+	// restored copy stays in S3.
+	Tier string `json:"tier,omitempty"`
+	Days int    `json:"days,omitempty"`
+
+	// StorageClass and Size describe the Glacier copy. EstimatedCost
+	// is the approximate cost of our restore request, in US dollars.
+	StorageClass  string  `json:"storage_class,omitempty"`
+	Size          int64   `json:"size,omitempty"`
+	EstimatedCost float64 `json:"estimated_cost,omitempty"`
+
+	// RequestedAt is when Glacier accepted our restore request. It's
+	// zero if we haven't made one, or if it was made elsewhere.
+	RequestedAt time.Time `json:"requested_at"`
+
+	// NextCheckAt is the earliest time it's worth checking on the
+	// request again.
+	NextCheckAt time.Time `json:"next_check_at"`
+
+	// CompletedAt is when we learned the restored copy was available.
+	// ExpiresAt is when S3 will delete it.
+	CompletedAt time.Time `json:"completed_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+
+	// Checks is the number of status checks we've made.
+	Checks int `json:"checks"`
+}
+
+// GlacierRestoreEstimate sums up the Glacier restore requests for a
+// restoration, so we can tell depositors and admins what they cost and
+// when the files should be available.
+type GlacierRestoreEstimate struct {
+	Files       int       `json:"files"`
+	Bytes       int64     `json:"bytes"`
+	Cost        float64   `json:"cost"`
+	Tiers       []string  `json:"tiers"`
+	AvailableAt time.Time `json:"available_at"`
+}
+
+// Add adds a restore request to the estimate. Param availableAt is when
+// the request is expected to complete.
+func (e *GlacierRestoreEstimate) Add(state *GlacierRestoreState, availableAt time.Time) {
+	e.Files++
+	e.Bytes += state.Size
+	e.Cost += state.EstimatedCost
+	if availableAt.After(e.AvailableAt) {
+		e.AvailableAt = availableAt
+	}
+	for _, tier := range e.Tiers {
+		if tier == state.Tier {
+			return
+		}
+	}
+	e.Tiers = append(e.Tiers, state.Tier)
+	sort.Strings(e.Tiers)
+}
+
+// String returns a description of the estimate for the WorkItem note.
+func (e *GlacierRestoreEstimate) String() string {
+	return fmt.Sprintf("Glacier retrieval of %d files (%s) at %s tier: estimated cost $%.2f, expected to be available by %s.",
+		e.Files, util.ToHumanSize(e.Bytes), strings.Join(e.Tiers, "/"), e.Cost, e.AvailableAt.Format(time.RFC3339))
+}
+
+// IsAvailable returns true if the restored copy was available when we
+// last checked and has not expired.
+func (s *GlacierRestoreState) IsAvailable(now time.Time) bool {
+	return !s.CompletedAt.IsZero() && (s.ExpiresAt.IsZero() || s.ExpiresAt.After(now))
+}
+
+// GlacierRestoreStateFromJSON converts the JSON representation of a
+// GlacierRestoreState to an actual object.
+func GlacierRestoreStateFromJSON(jsonData string) (*GlacierRestoreState, error) {
+	obj := &GlacierRestoreState{}
+	err := json.Unmarshal([]byte(jsonData), obj)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ToJSON converts this object to its JSON representation.
+func (s *GlacierRestoreState) ToJSON() (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
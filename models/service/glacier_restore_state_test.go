@@ -0,0 +1,48 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlacierRestoreState(t *testing.T) {
+	now := time.Date(2026, 5, 6, 7, 8, 9, 0, time.UTC)
+	state := &service.GlacierRestoreState{
+		GenericFileIdentifier: "test.edu/bag/data/file.txt",
+		URL:                   "https://s3.amazonaws.com/glacier-va/uuid",
+		Tier:                  "Standard",
+		RequestedAt:           now,
+		NextCheckAt:           now.Add(5 * time.Hour),
+		Checks:                1,
+	}
+	assert.False(t, state.IsAvailable(now))
+
+	data, err := state.ToJSON()
+	require.Nil(t, err)
+	restored, err := service.GlacierRestoreStateFromJSON(data)
+	require.Nil(t, err)
+	assert.Equal(t, state, restored)
+
+	state.CompletedAt = now.Add(4 * time.Hour)
+	assert.True(t, state.IsAvailable(now.Add(4*time.Hour)))
+	state.ExpiresAt = now.Add(24 * time.Hour)
+	assert.True(t, state.IsAvailable(now.Add(23*time.Hour)))
+	assert.False(t, state.IsAvailable(now.Add(25*time.Hour)))
+}
+
+func TestGlacierRestoreEstimate(t *testing.T) {
+	now := time.Date(2026, 5, 6, 7, 8, 9, 0, time.UTC)
+	estimate := &service.GlacierRestoreEstimate{}
+	estimate.Add(&service.GlacierRestoreState{Tier: "Standard", Size: 1024, EstimatedCost: 0.25}, now.Add(5*time.Hour))
+	estimate.Add(&service.GlacierRestoreState{Tier: "Bulk", Size: 2048, EstimatedCost: 0.5}, now.Add(12*time.Hour))
+	estimate.Add(&service.GlacierRestoreState{Tier: "Standard", Size: 1024, EstimatedCost: 0.25}, now.Add(5*time.Hour))
+	assert.Equal(t, 3, estimate.Files)
+	assert.Equal(t, int64(4096), estimate.Bytes)
+	assert.Equal(t, []string{"Bulk", "Standard"}, estimate.Tiers)
+	assert.Equal(t, now.Add(12*time.Hour), estimate.AvailableAt)
+	assert.Equal(t, "Glacier retrieval of 3 files (4.00 KB) at Bulk/Standard tier: estimated cost $1.00, expected to be available by 2026-05-06T19:08:09Z.", estimate.String())
+}
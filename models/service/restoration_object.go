@@ -10,6 +10,12 @@ import (
 )
 
 type RestorationObject struct {
+	// AsOf, if set, means restore the object as it was at this time,
+	// using the versions of its files and the checksums that were
+	// current then. This requires versioning on the preservation
+	// buckets. See restoration/as_of.go.
+	AsOf *time.Time `json:"as_of,omitempty"`
+
 	// AllFilesRestored will be true when all files have been downloaded
 	// from preservation to local disk. When this is true, we are ready
 	// to create the bag.
@@ -23,6 +29,24 @@ type RestorationObject struct {
 	// completing.
 	ErrorMessage string `json:"error_message"`
 
+	// FailedSources lists the URLs of preservation copies that failed
+	// digest verification on an earlier attempt at this restoration.
+	// Later attempts skip these copies.
+	FailedSources []string `json:"failed_sources,omitempty"`
+
+	// Failovers describes times the restorer gave up on one preservation
+	// copy and moved on to the next. Workers copy these into the WorkItem
+	// note. We keep only the first few, since a bag whose primary copy
+	// is gone may fail over on every file. FailoverCount has the total.
+	Failovers     []string `json:"failovers,omitempty"`
+	FailoverCount int      `json:"failover_count,omitempty"`
+
+	// IncludePatterns and ExcludePatterns are glob patterns over
+	// GenericFile.PathInBag that select the payload files to restore in
+	// a partial restoration. See restoration.PathFilter.
+	IncludePatterns []string `json:"include_patterns,omitempty"`
+	ExcludePatterns []string `json:"exclude_patterns,omitempty"`
+
 	// Identifier is the identifier of the IntellectionObject or GenericFile
 	// (from Registry) to be restored.
 	Identifier string `json:"identifier"`
@@ -36,6 +60,16 @@ type RestorationObject struct {
 	// the payload. The final bag may be ~ 1% - 10% larger than ObjectSize.
 	ObjectSize int64 `json:"object_size"`
 
+	// Progress describes how far we got on an earlier attempt at a
+	// bag restoration. It's nil until we start restoring a bag.
+	Progress *RestorationProgress `json:"progress,omitempty"`
+
+	// RestorationFormat describes how to package a restored bag. It
+	// should be one of constants.RestorationFormats. If empty, we
+	// restore bags as tar files. This doesn't apply to single-file
+	// restorations.
+	RestorationFormat string `json:"restoration_format,omitempty"`
+
 	// RestorationSource describes whether the item being restored is from
 	// S3 or Glacier. S3 includes any AWS or Wasabi S3 bucket. Glacier
 	// includes any Glacier or Glacier Deep Archive bucket. Items in S3
@@ -46,15 +80,52 @@ type RestorationObject struct {
 	// and constants.RestorationSourceS3
 	RestorationSource string `json:"restoration_source"`
 
+	// NeedsGlacierRestore is true when none of the copies in S3 or
+	// Wasabi could be restored, and the remaining copies are in Glacier
+	// and have to be moved back to S3 before we can restore them.
+	NeedsGlacierRestore bool `json:"needs_glacier_restore,omitempty"`
+
+	// NextGlacierCheck is the earliest time any pending Glacier restore
+	// request for this item is expected to complete. The Glacier
+	// restoration worker requeues the item to be checked then.
+	NextGlacierCheck *time.Time `json:"next_glacier_check,omitempty"`
+
+	// GlacierTier and GlacierRestoreDays are the retrieval tier and the
+	// number of days restored copies should stay in S3. Empty or zero
+	// means use the Glacier client's defaults.
+	GlacierTier        string `json:"glacier_tier,omitempty"`
+	GlacierRestoreDays int    `json:"glacier_restore_days,omitempty"`
+
+	// GlacierEstimate describes the Glacier restore requests we've
+	// made for this item, with their estimated cost.
+	GlacierEstimate *GlacierRestoreEstimate `json:"glacier_estimate,omitempty"`
+
 	// RestorationTarget is the name of the depositor's bucket to which
 	// the bag should be restored.
 	RestorationTarget string `json:"restoration_target"`
 
-	// RestorationType will be either constants.RestorationTypeFile or
-	// constants.RestorationTypeObject. Single file restorations require
-	// only a single be copied from preservation to the depositor's restoration
-	// bucket. Object restorations require downloading and bagging all of the
-	// object's files before copying to the restoration bucket.
+	// RestorationTargetProvider is the S3 provider that hosts the
+	// RestorationTarget bucket. It should be one of
+	// constants.StorageProviders. If empty, we use AWS.
+	RestorationTargetProvider string `json:"restoration_target_provider,omitempty"`
+
+	// RestorationTargetPrefix is an optional key prefix within the
+	// RestorationTarget bucket.
+	RestorationTargetPrefix string `json:"restoration_target_prefix,omitempty"`
+
+	// RestorationTargetDir is an absolute path on the worker's local
+	// disk. If set, we restore there instead of to S3. Operators use
+	// this for offline restorations.
+	RestorationTargetDir string `json:"restoration_target_dir,omitempty"`
+
+	// RestorationType will be constants.RestorationTypeFile,
+	// constants.RestorationTypeObject or constants.RestorationTypePartial.
+	// Single file restorations require only a single be copied from
+	// preservation to the depositor's restoration bucket. Object
+	// restorations require downloading and bagging all of the object's
+	// files before copying to the restoration bucket. Partial restorations
+	// are object restorations that include only the payload files matching
+	// IncludePatterns and ExcludePatterns.
 	RestorationType string `json:"restoration_type"`
 
 	// RestoredAt describes when the restored bag was copied to the depositor's
@@ -62,7 +133,8 @@ type RestorationObject struct {
 	RestoredAt time.Time `json:"restored_at"`
 
 	// URL is the URL of the restored bag in the depositor's restoration
-	// bucket.
+	// bucket. For loose restorations, this is the URL of the prefix
+	// under which we wrote the bag's files.
 	URL string `json:"url"`
 }
 
@@ -115,3 +187,73 @@ func (obj *RestorationObject) ManifestAlgorithms() []string {
 	}
 	return constants.BTRRestorationAlgorithms
 }
+
+// maxFailoverNotes is the maximum number of failover descriptions we
+// keep for the WorkItem note.
+const maxFailoverNotes = 20
+
+// AddFailover records that we could not restore the file gfIdentifier
+// from the preservation copy at url, and moved on to the next copy.
+func (obj *RestorationObject) AddFailover(gfIdentifier, url string, err error) {
+	obj.FailoverCount++
+	if len(obj.Failovers) < maxFailoverNotes {
+		obj.Failovers = append(obj.Failovers, fmt.Sprintf("%s from %s: %v", gfIdentifier, url, err))
+	}
+}
+
+// AddFailedSource records that the preservation copy at url failed
+// digest verification, so future attempts will skip it.
+func (obj *RestorationObject) AddFailedSource(url string) {
+	if !obj.HasFailedSource(url) {
+		obj.FailedSources = append(obj.FailedSources, url)
+	}
+}
+
+// HasFailedSource returns true if the preservation copy at url failed
+// digest verification on this or an earlier attempt.
+func (obj *RestorationObject) HasFailedSource(url string) bool {
+	for _, failed := range obj.FailedSources {
+		if failed == url {
+			return true
+		}
+	}
+	return false
+}
+
+// FailoverNote describes failovers for the WorkItem note. It returns
+// an empty string if there were no failovers.
+func (obj *RestorationObject) FailoverNote() string {
+	if obj.FailoverCount == 0 {
+		return ""
+	}
+	note := fmt.Sprintf("Failed over to another preservation copy %d time(s): %s", obj.FailoverCount, strings.Join(obj.Failovers, "; "))
+	if obj.FailoverCount > len(obj.Failovers) {
+		note += fmt.Sprintf("; and %d more", obj.FailoverCount-len(obj.Failovers))
+	}
+	return note + "."
+}
+
+// IsBagRestoration returns true if this restoration produces a bag,
+// which is the case for whole-object and partial restorations.
+func (obj *RestorationObject) IsBagRestoration() bool {
+	return obj.RestorationType == constants.RestorationTypeObject ||
+		obj.RestorationType == constants.RestorationTypePartial
+}
+
+// Format returns the format in which to package a restored bag,
+// defaulting to constants.RestorationFormatTar.
+func (obj *RestorationObject) Format() string {
+	if obj.RestorationFormat == "" {
+		return constants.RestorationFormatTar
+	}
+	return obj.RestorationFormat
+}
+
+// TargetProvider returns the S3 provider of the restoration target
+// bucket, defaulting to constants.StorageProviderAWS.
+func (obj *RestorationObject) TargetProvider() string {
+	if obj.RestorationTargetProvider == "" {
+		return constants.StorageProviderAWS
+	}
+	return obj.RestorationTargetProvider
+}
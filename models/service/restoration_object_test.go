@@ -1,6 +1,8 @@
 package service_test
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/APTrust/preservation-services/constants"
@@ -68,3 +70,41 @@ func TestRestorationObj_ManifestAlgorithms(t *testing.T) {
 }
 
 const RestorationObjectJSON = `{"all_files_restored":true,"BagItProfileIdentifier":"https://raw.githubusercontent.com/APTrust/preservation-services/master/profiles/aptrust-v2.3.json","error_message":"No error","identifier":"test.edu/bag-name.tar","item_id":111222333444,"object_size":333000,"restoration_source":"s3","restoration_target":"aptrust.restore.test.edu","restoration_type":"object","restored_at":"1904-06-16T15:04:05Z","url":"https://s3.example.com/restore-bucket/bag-name.tar"}`
+
+func TestRestorationObj_Failovers(t *testing.T) {
+	obj := &service.RestorationObject{}
+	assert.Equal(t, "", obj.FailoverNote())
+
+	obj.AddFailover("test.edu/bag/data/file.txt", "https://example.com/va/uuid", fmt.Errorf("not found"))
+	assert.Equal(t, "Failed over to another preservation copy 1 time(s): test.edu/bag/data/file.txt from https://example.com/va/uuid: not found.", obj.FailoverNote())
+
+	for i := 0; i < 30; i++ {
+		obj.AddFailover("test.edu/bag/data/file.txt", "https://example.com/va/uuid", fmt.Errorf("not found"))
+	}
+	assert.Equal(t, 31, obj.FailoverCount)
+	assert.Equal(t, 20, len(obj.Failovers))
+	assert.True(t, strings.HasSuffix(obj.FailoverNote(), "; and 11 more."))
+
+	assert.False(t, obj.HasFailedSource("https://example.com/va/uuid"))
+	obj.AddFailedSource("https://example.com/va/uuid")
+	obj.AddFailedSource("https://example.com/va/uuid")
+	assert.True(t, obj.HasFailedSource("https://example.com/va/uuid"))
+	assert.Equal(t, 1, len(obj.FailedSources))
+}
+
+func TestRestorationObj_IsBagRestoration(t *testing.T) {
+	obj := &service.RestorationObject{}
+	for _, restorationType := range []string{constants.RestorationTypeObject, constants.RestorationTypePartial} {
+		obj.RestorationType = restorationType
+		assert.True(t, obj.IsBagRestoration())
+	}
+	obj.RestorationType = constants.RestorationTypeFile
+	assert.False(t, obj.IsBagRestoration())
+}
+
+func TestRestorationObj_Format(t *testing.T) {
+	obj := &service.RestorationObject{}
+	assert.Equal(t, constants.RestorationFormatTar, obj.Format())
+	obj.RestorationFormat = constants.RestorationFormatZip
+	assert.Equal(t, constants.RestorationFormatZip, obj.Format())
+}
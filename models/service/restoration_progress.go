@@ -0,0 +1,84 @@
+package service
+
+import (
+	"time"
+)
+
+// RestorationProgress records how far we got restoring a bag, so that
+// if the worker is interrupted, the next attempt can pick up where this
+// one left off instead of reading everything from preservation again.
+type RestorationProgress struct {
+	// StartedAt is when the first attempt at this restoration began.
+	// We use it for the Bagging-Date tag and the timestamps in tar
+	// headers, so the bytes we regenerate when we resume match the
+	// bytes we already uploaded.
+	StartedAt time.Time `json:"started_at"`
+
+	// Key is the key of the restored bag in the restoration target.
+	Key string `json:"key"`
+
+	// Format is the format of the restored bag. We can resume only
+	// tar files uploaded to S3 and loose bags.
+	Format string `json:"format"`
+
+	// UploadID is the ID of the S3 multipart upload of a tar file.
+	UploadID string `json:"upload_id,omitempty"`
+
+	// PartSize is the size of each part of the multipart upload,
+	// except the last.
+	PartSize int64 `json:"part_size,omitempty"`
+
+	// Parts are the parts of the multipart upload that S3 has
+	// accepted.
+	Parts []*UploadedPart `json:"parts,omitempty"`
+
+	// ResumePoint is the latest point at which we can resume. Every
+	// byte of the bag before this point has been uploaded.
+	ResumePoint *ResumePoint `json:"resume_point,omitempty"`
+
+	// Uploaded is true once the whole bag is in the restoration
+	// target. If we fail after that, for example while writing the
+	// receipt, the next attempt writes only the receipt.
+	Uploaded bool `json:"uploaded,omitempty"`
+
+	// FileCount is the number of files in the uploaded bag.
+	FileCount int `json:"file_count,omitempty"`
+}
+
+// UploadedBytes returns the total size of the uploaded parts.
+func (p *RestorationProgress) UploadedBytes() int64 {
+	total := int64(0)
+	for _, part := range p.Parts {
+		total += part.Size
+	}
+	return total
+}
+
+// UploadedPart describes one part of a multipart upload.
+type UploadedPart struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size"`
+}
+
+// ResumePoint describes the start of an entry in a restored bag. An
+// entry is bagit.txt, a preserved file, or a manifest, in the order in
+// which we write them.
+type ResumePoint struct {
+	// EntryIndex is the number of entries that precede this one.
+	EntryIndex int `json:"entry_index"`
+
+	// Identifier identifies the entry. For preserved files, this is
+	// the GenericFile identifier. For files we generate, it's the
+	// file name. We check this on resume to be sure the bag's file
+	// list hasn't changed.
+	Identifier string `json:"identifier"`
+
+	// Offset is the offset in the serialized bag at which this entry
+	// begins. For loose bags, it's always zero.
+	Offset int64 `json:"offset"`
+
+	// ManifestSizes are the sizes of the manifests and tag manifests
+	// on local disk before we added this entry, keyed by path.
+	ManifestSizes map[string]int64 `json:"manifest_sizes,omitempty"`
+}
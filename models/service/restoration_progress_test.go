@@ -0,0 +1,38 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestorationProgress(t *testing.T) {
+	progress := &service.RestorationProgress{
+		StartedAt: time.Date(2026, 5, 6, 7, 8, 9, 0, time.UTC),
+		Key:       "test.edu/bag.tar",
+		Format:    "tar",
+		UploadID:  "upload-1",
+		PartSize:  100,
+		Parts: []*service.UploadedPart{
+			{PartNumber: 1, ETag: "a", Size: 100},
+			{PartNumber: 2, ETag: "b", Size: 100},
+		},
+		ResumePoint: &service.ResumePoint{
+			EntryIndex:    12,
+			Identifier:    "test.edu/bag/data/file.txt",
+			Offset:        150,
+			ManifestSizes: map[string]int64{"/tmp/manifest-md5.txt": 400},
+		},
+	}
+	assert.Equal(t, int64(200), progress.UploadedBytes())
+
+	restObj := &service.RestorationObject{Identifier: "test.edu/bag", Progress: progress}
+	data, err := restObj.ToJSON()
+	require.Nil(t, err)
+	restored, err := service.RestorationObjectFromJSON(data)
+	require.Nil(t, err)
+	assert.Equal(t, progress, restored.Progress)
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RestorationReceipt is a chain-of-custody record for a restored bag.
+// It lists every file we wrote into the bag, with the digests we
+// calculated as we wrote it and, for files from preservation storage,
+// the Registry checksums we compared them to.
+type RestorationReceipt struct {
+	// Identifier is the identifier of the restored IntellectualObject.
+	Identifier string `json:"identifier"`
+
+	// WorkItemID is the ID of the restoration WorkItem.
+	WorkItemID int64 `json:"work_item_id"`
+
+	// URL is the URL of the restored bag.
+	URL string `json:"url"`
+
+	// Format is the format of the restored bag, which is one of
+	// constants.RestorationFormats.
+	Format string `json:"format"`
+
+	// AsOf is set for historical restorations. See
+	// RestorationObject.AsOf.
+	AsOf *time.Time `json:"as_of,omitempty"`
+
+	// StartedAt is when the restoration began. CompletedAt is when we
+	// finished uploading the bag.
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+
+	// Files lists every file in the restored bag, in the order we
+	// wrote them.
+	Files []*ReceiptFile `json:"files"`
+}
+
+// ReceiptFile describes one file in a restored bag.
+type ReceiptFile struct {
+	// Path is the file's path within the restored bag, including the
+	// bag's top-level directory, as in the tar header.
+	Path string `json:"path"`
+
+	// Identifier is the GenericFile identifier. It's empty for files
+	// we generate during restoration, like bagit.txt and manifests.
+	Identifier string `json:"identifier,omitempty"`
+
+	// Size is the size of the file in bytes.
+	Size int64 `json:"size"`
+
+	// SourceURL is the preservation copy we restored from. It's empty
+	// for generated files. If the restorer had to fail over partway
+	// through the file, it lists each copy read, in order, separated by
+	// commas.
+	SourceURL string `json:"source_url,omitempty"`
+
+	// RegistryChecksums are the Registry digests we compared to
+	// Digests, keyed by algorithm. These are empty for generated files
+	// and for bag-info.txt, which we rewrite during restoration.
+	RegistryChecksums map[string]string `json:"registry_checksums,omitempty"`
+
+	// Digests are the digests we calculated as we wrote the file,
+	// keyed by algorithm.
+	Digests map[string]string `json:"digests"`
+
+	// WrittenAt is when we finished writing the file.
+	WrittenAt time.Time `json:"written_at"`
+}
+
+// SignedRestorationReceipt is a RestorationReceipt with an Ed25519
+// signature. The signature covers the compact JSON bytes of Receipt,
+// so verifiers don't have to reproduce our JSON serialization. Only
+// whitespace may change, as when we indent the receipt for humans.
+type SignedRestorationReceipt struct {
+	Receipt json.RawMessage `json:"receipt"`
+
+	// PublicKey is the base64-encoded public key of the signer. It's
+	// here to identify the key. Verifiers should use a key they trust,
+	// not this one.
+	PublicKey string `json:"public_key,omitempty"`
+
+	// Signature is the base64-encoded Ed25519 signature of Receipt.
+	// It's empty if the receipt is unsigned.
+	Signature string `json:"signature,omitempty"`
+}
+
+// NewSignedRestorationReceipt serializes receipt and signs it with key.
+// If key is nil, the receipt is unsigned.
+func NewSignedRestorationReceipt(receipt *RestorationReceipt, key ed25519.PrivateKey) (*SignedRestorationReceipt, error) {
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, err
+	}
+	signed := &SignedRestorationReceipt{Receipt: data}
+	if key != nil {
+		signed.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+		signed.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	}
+	return signed, nil
+}
+
+// SignedRestorationReceiptFromJSON parses a signed receipt.
+func SignedRestorationReceiptFromJSON(data []byte) (*SignedRestorationReceipt, error) {
+	signed := &SignedRestorationReceipt{}
+	err := json.Unmarshal(data, signed)
+	if err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// Verify returns the receipt if it was signed by the owner of
+// publicKey, or an error if it wasn't, or if it's unsigned.
+func (s *SignedRestorationReceipt) Verify(publicKey ed25519.PublicKey) (*RestorationReceipt, error) {
+	if s.Signature == "" {
+		return nil, fmt.Errorf("receipt is not signed")
+	}
+	signature, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	data := &bytes.Buffer{}
+	err = json.Compact(data, s.Receipt)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(publicKey, data.Bytes(), signature) {
+		return nil, fmt.Errorf("signature does not match receipt and public key")
+	}
+	receipt := &RestorationReceipt{}
+	err = json.Unmarshal(s.Receipt, receipt)
+	if err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// ToJSON returns the signed receipt in indented JSON format.
+func (s *SignedRestorationReceipt) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
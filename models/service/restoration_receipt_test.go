@@ -0,0 +1,77 @@
+package service_test
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReceipt() *service.RestorationReceipt {
+	return &service.RestorationReceipt{
+		Identifier:  "test.edu/bag",
+		WorkItemID:  1234,
+		URL:         "https://s3.amazonaws.com/restore/test.edu/bag.tar",
+		Format:      "tar",
+		StartedAt:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		CompletedAt: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+		Files: []*service.ReceiptFile{
+			{
+				Path:              "bag/data/file.txt",
+				Identifier:        "test.edu/bag/data/file.txt",
+				Size:              5,
+				SourceURL:         "https://s3.amazonaws.com/preservation/uuid",
+				RegistryChecksums: map[string]string{"md5": "abc"},
+				Digests:           map[string]string{"md5": "abc"},
+				WrittenAt:         time.Date(2024, 5, 1, 12, 10, 0, 0, time.UTC),
+			},
+		},
+	}
+}
+
+func TestSignedRestorationReceipt(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	signed, err := service.NewSignedRestorationReceipt(testReceipt(), privateKey)
+	require.Nil(t, err)
+	assert.NotEmpty(t, signed.Signature)
+	assert.NotEmpty(t, signed.PublicKey)
+
+	// Indenting doesn't break the signature.
+	data, err := signed.ToJSON()
+	require.Nil(t, err)
+	parsed, err := service.SignedRestorationReceiptFromJSON(data)
+	require.Nil(t, err)
+	receipt, err := parsed.Verify(publicKey)
+	require.Nil(t, err)
+	assert.Equal(t, testReceipt(), receipt)
+
+	// Changing the receipt does.
+	tampered := strings.Replace(string(data), `"abc"`, `"abd"`, 1)
+	parsed, err = service.SignedRestorationReceiptFromJSON([]byte(tampered))
+	require.Nil(t, err)
+	_, err = parsed.Verify(publicKey)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "signature does not match")
+
+	// So does using the wrong key.
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	_, err = signed.Verify(otherKey)
+	assert.NotNil(t, err)
+}
+
+func TestUnsignedRestorationReceipt(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	signed, err := service.NewSignedRestorationReceipt(testReceipt(), nil)
+	require.Nil(t, err)
+	assert.Empty(t, signed.Signature)
+	_, err = signed.Verify(publicKey)
+	require.NotNil(t, err)
+	assert.Equal(t, "receipt is not signed", err.Error())
+}
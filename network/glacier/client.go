@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/APTrust/preservation-services/constants"
 	"github.com/APTrust/preservation-services/models/common"
@@ -14,8 +17,78 @@ import (
 )
 
 const DaysToLiveInRestoreBucket = 10
+
+// MaxDaysToLiveInRestoreBucket is the longest a restored copy may stay
+// in S3. We pay for S3 storage the whole time, so we don't let a
+// WorkItem or institution setting keep copies around indefinitely.
+const MaxDaysToLiveInRestoreBucket = 30
 const DefaultTier = "Standard"
 
+// Glacier retrieval tiers
+const (
+	TierBulk      = "Bulk"
+	TierExpedited = "Expedited"
+	TierStandard  = "Standard"
+)
+
+// IsValidTier returns true if tier is a Glacier retrieval tier.
+func IsValidTier(tier string) bool {
+	return tier == TierBulk || tier == TierExpedited || tier == TierStandard
+}
+
+// retrievalPrice is the AWS retrieval price for one tier, in US dollars
+// per GB and per thousand requests.
+type retrievalPrice struct {
+	perGB       float64
+	perThousand float64
+}
+
+// retrievalPrices are AWS's US East list prices, by storage class and
+// tier. We use them only to estimate costs for the WorkItem note, so
+// they don't have to be exact. Deep Archive has no Expedited tier.
+var retrievalPrices = map[string]map[string]retrievalPrice{
+	"GLACIER": {
+		TierExpedited: {perGB: 0.03, perThousand: 10.00},
+		TierStandard:  {perGB: 0.01, perThousand: 0.05},
+		TierBulk:      {perGB: 0.00, perThousand: 0.00},
+	},
+	"DEEP_ARCHIVE": {
+		TierStandard: {perGB: 0.02, perThousand: 0.10},
+		TierBulk:     {perGB: 0.0025, perThousand: 0.025},
+	},
+}
+
+// EstimateRestoreCost returns the approximate cost, in US dollars, of
+// restoring an object of size bytes from storageClass at tier. It
+// returns zero for objects that don't need to be restored.
+func EstimateRestoreCost(tier, storageClass string, size int64) float64 {
+	price, ok := retrievalPrices[storageClass][tier]
+	if !ok {
+		return 0
+	}
+	return float64(size)/float64(1<<30)*price.perGB + price.perThousand/1000
+}
+
+// ExpectedRestoreTime returns the longest time AWS says a restore at
+// tier should take for an object in storageClass. See
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/restoring-objects-retrieval-options.html
+func ExpectedRestoreTime(tier, storageClass string) time.Duration {
+	if storageClass == "DEEP_ARCHIVE" {
+		if tier == TierBulk {
+			return 48 * time.Hour
+		}
+		return 12 * time.Hour
+	}
+	switch tier {
+	case TierExpedited:
+		return 5 * time.Minute
+	case TierBulk:
+		return 12 * time.Hour
+	default:
+		return 5 * time.Hour
+	}
+}
+
 // Restore sends a restoration request to Glacier, asking for the item at url
 // to be copied to S3. Since restoration typically takes several hours,
 // you should call this periodically and check the response code.
@@ -34,14 +107,138 @@ const DefaultTier = "Standard"
 // For more info, see
 // https://docs.aws.amazon.com/AmazonS3/latest/API/API_RestoreObject.html
 func Restore(context *common.Context, url string) (int, string, error) {
-	context.Logger.Infof("Requesting restoration of %s", url)
+	return RestoreVersion(context, url, "")
+}
+
+// RestoreVersion is like Restore, but asks for a specific version of
+// the item at url. If versionID is empty, this restores the current
+// version.
+func RestoreVersion(context *common.Context, url, versionID string) (int, string, error) {
+	return RequestRestore(context, url, versionID, DefaultTier, DaysToLiveInRestoreBucket)
+}
+
+// RequestRestore is like RestoreVersion, but lets the caller choose the
+// retrieval tier and the number of days the restored copy stays in S3.
+func RequestRestore(context *common.Context, url, versionID, tier string, days int) (int, string, error) {
+	context.Logger.Infof("Requesting restoration of %s (version '%s', tier %s, %d days)", url, versionID, tier, days)
 	postURL := fmt.Sprintf("%s?restore=", url)
-	body := getRequestBody()
-	request, err := http.NewRequest(http.MethodPost, postURL, strings.NewReader(body))
+	if versionID != "" {
+		postURL = fmt.Sprintf("%s&versionId=%s", postURL, neturl.QueryEscape(versionID))
+	}
+	body := getRequestBody(tier, days)
+	response, err := do(context, http.MethodPost, postURL, body)
 	if err != nil {
-		return 0, "", err
+		context.Logger.Errorf("Glacier restore request returned error %v", err)
+		return 0, body, err
+	}
+	defer response.Body.Close()
+
+	// These responses are short snippets of XML
+	buf := new(strings.Builder)
+	_, err = io.Copy(buf, response.Body)
+	if err == nil {
+		context.Logger.Infof("Glacier restore %s returned code %d, body %s", url, response.StatusCode, buf.String())
+	} else {
+		context.Logger.Warningf("Glacier restore %s: could not read response", url)
+	}
+
+	return response.StatusCode, buf.String(), nil
+}
+
+// RestoreStatus describes the storage class of an object and the state
+// of any restore request, as reported by a HEAD request.
+type RestoreStatus struct {
+	// StatusCode is the HTTP status of the HEAD request.
+	StatusCode int
+
+	// StorageClass is the object's storage class. S3 omits this for
+	// STANDARD objects.
+	StorageClass string
+
+	// Requested is true if someone has asked for the object to be
+	// restored. Ongoing is true until the restored copy is available.
+	Requested bool
+	Ongoing   bool
+
+	// ExpiresAt is when S3 will delete the restored copy.
+	ExpiresAt time.Time
+}
+
+// NeedsRestore returns true if the object is in Glacier or Glacier Deep
+// Archive, which means it has to be restored before we can read it.
+func (s *RestoreStatus) NeedsRestore() bool {
+	return s.StorageClass == "GLACIER" || s.StorageClass == "DEEP_ARCHIVE"
+}
+
+// Available returns true if the object can be read now.
+func (s *RestoreStatus) Available() bool {
+	return !s.NeedsRestore() || (s.Requested && !s.Ongoing)
+}
+
+// Status sends a HEAD request for the item at url and returns its
+// storage class and restore status, from the x-amz-storage-class and
+// x-amz-restore headers. Unlike Restore, this doesn't change anything,
+// so we can call it as often as we like.
+func Status(context *common.Context, url, versionID string) (*RestoreStatus, error) {
+	headURL := url
+	if versionID != "" {
+		headURL = fmt.Sprintf("%s?versionId=%s", url, neturl.QueryEscape(versionID))
+	}
+	response, err := do(context, http.MethodHead, headURL, "")
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+	status := &RestoreStatus{
+		StatusCode:   response.StatusCode,
+		StorageClass: response.Header.Get("x-amz-storage-class"),
+	}
+	if header := response.Header.Get("x-amz-restore"); header != "" {
+		status.Requested = true
+		status.Ongoing, status.ExpiresAt, err = ParseRestoreHeader(header)
+		if err != nil {
+			return nil, err
+		}
+	}
+	context.Logger.Infof("Glacier status %s: code %d, storage class '%s', restore '%s'", url, status.StatusCode, status.StorageClass, response.Header.Get("x-amz-restore"))
+	return status, nil
+}
+
+var restoreHeaderRegex = regexp.MustCompile(`(ongoing-request|expiry-date)="([^"]*)"`)
+
+// ParseRestoreHeader parses the value of an x-amz-restore header, which
+// looks like one of these:
+//
+//	ongoing-request="true"
+//	ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+func ParseRestoreHeader(value string) (ongoing bool, expiresAt time.Time, err error) {
+	matches := restoreHeaderRegex.FindAllStringSubmatch(value, -1)
+	if len(matches) == 0 {
+		return false, expiresAt, fmt.Errorf("Invalid x-amz-restore header: %s", value)
+	}
+	for _, match := range matches {
+		switch match[1] {
+		case "ongoing-request":
+			ongoing = match[2] == "true"
+		case "expiry-date":
+			expiresAt, err = http.ParseTime(match[2])
+			if err != nil {
+				return false, expiresAt, fmt.Errorf("Invalid expiry-date in x-amz-restore header: %s", value)
+			}
+		}
+	}
+	return ongoing, expiresAt, nil
+}
+
+// do sends a signed request to the preservation bucket at url.
+func do(context *common.Context, method, url, body string) (*http.Response, error) {
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != "" {
+		request.Header.Set("Content-Length", strconv.Itoa(len(body)))
 	}
-	request.Header.Set("Content-Length", strconv.Itoa(len(body)))
 
 	// Set the payload hash header
 	sha := sha256.New()
@@ -52,11 +249,11 @@ func Restore(context *common.Context, url string) (int, string, error) {
 	// provider, so constants.StorageProviderAWS will do us for now.
 	creds := context.Config.S3Credentials[constants.StorageProviderAWS]
 	if creds == nil {
-		return 0, "", fmt.Errorf("Can't find credentials for %s", constants.StorageProviderAWS)
+		return nil, fmt.Errorf("Can't find credentials for %s", constants.StorageProviderAWS)
 	}
 	presBucket := context.Config.PreservationBucketForUrl(url)
 	if presBucket == nil {
-		return 0, "", fmt.Errorf("Cannot find preservation bucket for url %s", url)
+		return nil, fmt.Errorf("Cannot find preservation bucket for url %s", url)
 	}
 	signedRequest := signer.SignV4(*request, creds.KeyID, creds.SecretKey, "", presBucket.Region)
 
@@ -67,27 +264,12 @@ func Restore(context *common.Context, url string) (int, string, error) {
 	context.Logger.Infof("Request body: %s", body)
 	// --- DEBUG ---
 
-	httpClient := &http.Client{}
-	response, err := httpClient.Do(signedRequest)
-	if err != nil {
-		context.Logger.Errorf("Glacier restore request returned error %v", err)
-		return 0, body, err
-	}
-	defer response.Body.Close()
-
-	// These responses are short snippets of XML
-	buf := new(strings.Builder)
-	_, err = io.Copy(buf, response.Body)
-	if err == nil {
-		context.Logger.Infof("Glacier restore %s returned code %d, body %s", url, response.StatusCode, buf.String())
-	} else {
-		context.Logger.Warningf("Glacier restore %s: could not read response", url)
-	}
-
-	return response.StatusCode, buf.String(), nil
+	return httpClient.Do(signedRequest)
 }
 
-func getRequestBody() string {
+var httpClient = &http.Client{}
+
+func getRequestBody(tier string, days int) string {
 	str := "<RestoreRequest><Days>%d</Days><GlacierJobParameters><Tier>%s</Tier></GlacierJobParameters></RestoreRequest>"
-	return fmt.Sprintf(str, DaysToLiveInRestoreBucket, DefaultTier)
+	return fmt.Sprintf(str, days, tier)
 }
@@ -7,10 +7,12 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/network/glacier"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var expectedResponseCode = 202
@@ -32,6 +34,22 @@ func TestGlacierRestore_200(t *testing.T) {
 	assert.Equal(t, "Hello Kitty", body)
 }
 
+func TestGlacierRestoreVersion(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, glacierPath+"?restore=&versionId=v1%2Babc", r.URL.String())
+		assert.NotNil(t, r.Header["Authorization"])
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer testServer.Close()
+
+	context := common.NewContext()
+	glacierURL = fmt.Sprintf("%s%s", testServer.URL, glacierPath)
+
+	statusCode, _, err := glacier.RestoreVersion(context, glacierURL, "v1+abc")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, statusCode)
+}
+
 func getRestoreHandler(t *testing.T) func(http.ResponseWriter, *http.Request) {
 	keys := []string{
 		"Content-Length",
@@ -61,3 +79,102 @@ func getRestoreHandler(t *testing.T) func(http.ResponseWriter, *http.Request) {
 		w.Write([]byte("Hello Kitty"))
 	}
 }
+
+func TestGlacierStatus(t *testing.T) {
+	restoreHeader := ""
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		assert.Equal(t, glacierPath+"?versionId=v1", r.URL.String())
+		assert.NotNil(t, r.Header["Authorization"])
+		w.Header().Set("x-amz-storage-class", "DEEP_ARCHIVE")
+		if restoreHeader != "" {
+			w.Header().Set("x-amz-restore", restoreHeader)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	context := common.NewContext()
+	glacierURL = fmt.Sprintf("%s%s", testServer.URL, glacierPath)
+
+	status, err := glacier.Status(context, glacierURL, "v1")
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, status.StatusCode)
+	assert.Equal(t, "DEEP_ARCHIVE", status.StorageClass)
+	assert.True(t, status.NeedsRestore())
+	assert.False(t, status.Requested)
+	assert.False(t, status.Available())
+
+	restoreHeader = `ongoing-request="true"`
+	status, err = glacier.Status(context, glacierURL, "v1")
+	require.Nil(t, err)
+	assert.True(t, status.Requested)
+	assert.True(t, status.Ongoing)
+	assert.False(t, status.Available())
+
+	restoreHeader = `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`
+	status, err = glacier.Status(context, glacierURL, "v1")
+	require.Nil(t, err)
+	assert.True(t, status.Available())
+	assert.Equal(t, time.Date(2012, 12, 21, 0, 0, 0, 0, time.UTC), status.ExpiresAt)
+}
+
+func TestParseRestoreHeader(t *testing.T) {
+	ongoing, expiresAt, err := glacier.ParseRestoreHeader(`ongoing-request="true"`)
+	require.Nil(t, err)
+	assert.True(t, ongoing)
+	assert.True(t, expiresAt.IsZero())
+
+	ongoing, expiresAt, err = glacier.ParseRestoreHeader(`ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`)
+	require.Nil(t, err)
+	assert.False(t, ongoing)
+	assert.Equal(t, time.Date(2012, 12, 21, 0, 0, 0, 0, time.UTC), expiresAt)
+
+	_, _, err = glacier.ParseRestoreHeader("garbage")
+	assert.NotNil(t, err)
+	_, _, err = glacier.ParseRestoreHeader(`ongoing-request="false", expiry-date="tomorrow"`)
+	assert.NotNil(t, err)
+}
+
+func TestExpectedRestoreTime(t *testing.T) {
+	assert.Equal(t, 5*time.Minute, glacier.ExpectedRestoreTime(glacier.TierExpedited, "GLACIER"))
+	assert.Equal(t, 5*time.Hour, glacier.ExpectedRestoreTime(glacier.TierStandard, "GLACIER"))
+	assert.Equal(t, 12*time.Hour, glacier.ExpectedRestoreTime(glacier.TierBulk, "GLACIER"))
+	assert.Equal(t, 12*time.Hour, glacier.ExpectedRestoreTime(glacier.TierStandard, "DEEP_ARCHIVE"))
+	assert.Equal(t, 48*time.Hour, glacier.ExpectedRestoreTime(glacier.TierBulk, "DEEP_ARCHIVE"))
+}
+
+func TestGlacierRequestRestore(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, "<RestoreRequest><Days>3</Days><GlacierJobParameters><Tier>Expedited</Tier></GlacierJobParameters></RestoreRequest>", string(body))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer testServer.Close()
+
+	context := common.NewContext()
+	glacierURL = fmt.Sprintf("%s%s", testServer.URL, glacierPath)
+
+	statusCode, _, err := glacier.RequestRestore(context, glacierURL, "", glacier.TierExpedited, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, statusCode)
+}
+
+func TestEstimateRestoreCost(t *testing.T) {
+	gb := int64(1 << 30)
+	assert.InDelta(t, 0.31, glacier.EstimateRestoreCost(glacier.TierExpedited, "GLACIER", 10*gb), 0.0001)
+	assert.InDelta(t, 0.10005, glacier.EstimateRestoreCost(glacier.TierStandard, "GLACIER", 10*gb), 0.0001)
+	assert.Equal(t, 0.0, glacier.EstimateRestoreCost(glacier.TierBulk, "GLACIER", 10*gb))
+	assert.InDelta(t, 0.2001, glacier.EstimateRestoreCost(glacier.TierStandard, "DEEP_ARCHIVE", 10*gb), 0.0001)
+	assert.Equal(t, 0.0, glacier.EstimateRestoreCost(glacier.TierExpedited, "DEEP_ARCHIVE", 10*gb))
+	assert.Equal(t, 0.0, glacier.EstimateRestoreCost(glacier.TierStandard, "", 10*gb))
+}
+
+func TestIsValidTier(t *testing.T) {
+	assert.True(t, glacier.IsValidTier(glacier.TierBulk))
+	assert.True(t, glacier.IsValidTier(glacier.TierExpedited))
+	assert.True(t, glacier.IsValidTier(glacier.TierStandard))
+	assert.False(t, glacier.IsValidTier("standard"))
+	assert.False(t, glacier.IsValidTier(""))
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/nsqio/nsq/nsqd"
 )
@@ -90,6 +91,17 @@ func (client *NSQClient) Enqueue(topic string, workItemID int64) error {
 // EnqueueString posts string data to the specified NSQ topic
 func (client *NSQClient) EnqueueString(topic string, data string) error {
 	url := fmt.Sprintf("%s/pub?topic=%s", client.URL, topic)
+	return client.publish(url, data)
+}
+
+// EnqueueDeferred posts a WorkItem ID to the specified NSQ topic.
+// Nsqd holds the message for delay before delivering it.
+func (client *NSQClient) EnqueueDeferred(topic string, workItemID int64, delay time.Duration) error {
+	url := fmt.Sprintf("%s/pub?topic=%s&defer=%d", client.URL, topic, delay.Milliseconds())
+	return client.publish(url, strconv.FormatInt(workItemID, 10))
+}
+
+func (client *NSQClient) publish(url, data string) error {
 	resp, err := http.Post(url, "text/html", bytes.NewBuffer([]byte(data)))
 	if err != nil {
 		return fmt.Errorf("Nsqd returned an error when queuing data: %v", err)
@@ -114,6 +126,23 @@ func (client *NSQClient) EnqueueString(topic string, data string) error {
 	return nil
 }
 
+// Ping checks that nsqd is up and healthy. It returns an error if
+// nsqd can't be reached or says it's unhealthy.
+func (client *NSQClient) Ping() error {
+	url := fmt.Sprintf("%s/ping", client.URL)
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("nsqd returned status code %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
 // GetStats allows us to get some basic stats from NSQ. The NSQ /stats endpoint
 // returns a richer set of stats than what this fuction returns, but we only
 // need some basic data for integration tests, so that's all we're parsing.
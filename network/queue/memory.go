@@ -0,0 +1,312 @@
+package queue
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMaxAttempts is the number of times Memory delivers a message
+// before giving up on it. This matches go-nsq's default.
+const DefaultMaxAttempts = 5
+
+// DefaultRequeueDelay is how long Memory waits before redelivering a
+// message whose handler returned an error.
+const DefaultRequeueDelay = 5 * time.Second
+
+var sharedMemory *Memory
+var sharedMemoryOnce sync.Once
+
+// SharedMemory returns the process-wide in-process queue. Each worker
+// creates its own Context, so workers running in the same binary must
+// share this queue to pass items along.
+func SharedMemory() *Memory {
+	sharedMemoryOnce.Do(func() {
+		sharedMemory = NewMemory()
+	})
+	return sharedMemory
+}
+
+// Memory is an in-process Queue. Messages live only as long as the
+// process, so this is for development and integration tests, not
+// production. Unlike NSQ, in-flight messages never time out, so Touch
+// is a no-op.
+type Memory struct {
+	// MaxAttempts is the number of times a message is delivered before
+	// we give up on it.
+	MaxAttempts uint16
+
+	// RequeueDelay is how long we wait before redelivering a message
+	// whose handler returned an error.
+	RequeueDelay time.Duration
+
+	mutex  sync.Mutex
+	topics map[string]*memoryTopic
+}
+
+// NewMemory returns a new, empty in-process queue.
+func NewMemory() *Memory {
+	return &Memory{
+		MaxAttempts:  DefaultMaxAttempts,
+		RequeueDelay: DefaultRequeueDelay,
+		topics:       make(map[string]*memoryTopic),
+	}
+}
+
+// memoryTopic holds a topic's channels. As in NSQ, messages published
+// before the topic has any channels wait until the first one appears.
+type memoryTopic struct {
+	channels map[string]*memoryChannel
+	pending  [][]byte
+}
+
+// memoryChannel holds the messages waiting for a channel's consumers.
+type memoryChannel struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	messages []*memoryMessage
+}
+
+func newMemoryChannel() *memoryChannel {
+	c := &memoryChannel{}
+	c.cond = sync.NewCond(&c.mutex)
+	return c
+}
+
+func (c *memoryChannel) push(message *memoryMessage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.messages = append(c.messages, message)
+	c.cond.Broadcast()
+}
+
+// Enqueue publishes a WorkItem or GenericFile ID to topic.
+func (q *Memory) Enqueue(topic string, id int64) error {
+	return q.EnqueueString(topic, strconv.FormatInt(id, 10))
+}
+
+// EnqueueString publishes data to topic. Each of the topic's channels
+// gets its own copy.
+func (q *Memory) EnqueueString(topic, data string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	t := q.topic(topic)
+	if len(t.channels) == 0 {
+		t.pending = append(t.pending, []byte(data))
+		return nil
+	}
+	for _, c := range t.channels {
+		c.push(&memoryMessage{body: []byte(data), queue: q, channel: c})
+	}
+	return nil
+}
+
+// EnqueueDeferred publishes a WorkItem or GenericFile ID to topic
+// after delay. The message is lost if the process exits first.
+func (q *Memory) EnqueueDeferred(topic string, id int64, delay time.Duration) error {
+	time.AfterFunc(delay, func() {
+		q.Enqueue(topic, id)
+	})
+	return nil
+}
+
+// Consume starts delivering the messages in channel of topic to
+// handler, with at most maxInFlight in progress at once.
+func (q *Memory) Consume(topic, channel string, maxInFlight int, handler Handler) (Consumer, error) {
+	q.mutex.Lock()
+	t := q.topic(topic)
+	c := t.channels[channel]
+	if c == nil {
+		c = newMemoryChannel()
+		t.channels[channel] = c
+		for _, body := range t.pending {
+			c.push(&memoryMessage{body: body, queue: q, channel: c})
+		}
+		t.pending = nil
+	}
+	q.mutex.Unlock()
+
+	consumer := &memoryConsumer{
+		channel:     c,
+		handler:     handler,
+		maxInFlight: maxInFlight,
+		stopChan:    make(chan int),
+	}
+	go consumer.run()
+	return consumer, nil
+}
+
+// Depth returns the number of messages waiting for delivery in channel
+// of topic, not counting those in flight. For topics with no channels
+// yet, pass an empty channel name to get the number waiting in the topic.
+func (q *Memory) Depth(topic, channel string) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	t := q.topic(topic)
+	if channel == "" {
+		return len(t.pending)
+	}
+	c := t.channels[channel]
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.messages)
+}
+
+// topic returns the named topic, creating it if necessary. Caller must
+// hold q.mutex.
+func (q *Memory) topic(name string) *memoryTopic {
+	t := q.topics[name]
+	if t == nil {
+		t = &memoryTopic{channels: make(map[string]*memoryChannel)}
+		q.topics[name] = t
+	}
+	return t
+}
+
+// memoryConsumer hands a channel's messages to its handler. It shares
+// the channel's mutex and condition with the channel's other consumers.
+type memoryConsumer struct {
+	channel     *memoryChannel
+	handler     Handler
+	maxInFlight int
+	inFlight    int
+	stopped     bool
+	stopChan    chan int
+}
+
+func (c *memoryConsumer) run() {
+	ch := c.channel
+	for {
+		ch.mutex.Lock()
+		for !c.stopped && (c.inFlight >= c.maxInFlight || len(ch.messages) == 0) {
+			ch.cond.Wait()
+		}
+		if c.stopped {
+			ch.mutex.Unlock()
+			close(c.stopChan)
+			return
+		}
+		message := ch.messages[0]
+		ch.messages = ch.messages[1:]
+		c.inFlight++
+		message.consumer = c
+		message.attempts++
+		ch.mutex.Unlock()
+		go c.deliver(message)
+	}
+}
+
+func (c *memoryConsumer) deliver(message *memoryMessage) {
+	err := c.handler.HandleMessage(message)
+	message.mutex.Lock()
+	autoResponse := !message.autoResponseDisabled
+	message.mutex.Unlock()
+	if !autoResponse {
+		return
+	}
+	if err != nil {
+		message.Requeue(-1)
+	} else {
+		message.Finish()
+	}
+}
+
+// done frees the in-flight slot message was using.
+func (c *memoryConsumer) done() {
+	c.channel.mutex.Lock()
+	defer c.channel.mutex.Unlock()
+	c.inFlight--
+	c.channel.cond.Broadcast()
+}
+
+func (c *memoryConsumer) ChangeMaxInFlight(maxInFlight int) {
+	c.channel.mutex.Lock()
+	defer c.channel.mutex.Unlock()
+	c.maxInFlight = maxInFlight
+	c.channel.cond.Broadcast()
+}
+
+func (c *memoryConsumer) Stop() {
+	c.channel.mutex.Lock()
+	defer c.channel.mutex.Unlock()
+	c.stopped = true
+	c.channel.cond.Broadcast()
+}
+
+func (c *memoryConsumer) StopChan() <-chan int {
+	return c.stopChan
+}
+
+type memoryMessage struct {
+	body     []byte
+	attempts uint16
+	queue    *Memory
+	channel  *memoryChannel
+	consumer *memoryConsumer
+
+	mutex                sync.Mutex
+	autoResponseDisabled bool
+	responded            bool
+}
+
+func (m *memoryMessage) Body() []byte {
+	return m.body
+}
+
+func (m *memoryMessage) Attempts() uint16 {
+	return m.attempts
+}
+
+func (m *memoryMessage) DisableAutoResponse() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.autoResponseDisabled = true
+}
+
+// Touch does nothing, because in-process messages don't time out.
+func (m *memoryMessage) Touch() {}
+
+// Requeue redelivers the message after delay. A negative delay means
+// use the queue's RequeueDelay. If the message has already been
+// delivered MaxAttempts times, we drop it, as go-nsq does.
+func (m *memoryMessage) Requeue(delay time.Duration) {
+	if !m.respond() {
+		return
+	}
+	if m.attempts >= m.queue.MaxAttempts {
+		return
+	}
+	if delay < 0 {
+		delay = m.queue.RequeueDelay
+	}
+	requeued := &memoryMessage{
+		body:     m.body,
+		attempts: m.attempts,
+		queue:    m.queue,
+		channel:  m.channel,
+	}
+	time.AfterFunc(delay, func() { m.channel.push(requeued) })
+}
+
+func (m *memoryMessage) Finish() {
+	m.respond()
+}
+
+// respond marks the message as finished or requeued and frees its
+// consumer's in-flight slot. It returns false if that's already happened.
+func (m *memoryMessage) respond() bool {
+	m.mutex.Lock()
+	if m.responded {
+		m.mutex.Unlock()
+		return false
+	}
+	m.responded = true
+	m.mutex.Unlock()
+	if m.consumer != nil {
+		m.consumer.done()
+	}
+	return true
+}
@@ -0,0 +1,202 @@
+package queue_test
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/network/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a Handler that records the bodies of the messages it
+// handles. If fail is set, it returns an error for those bodies.
+type recorder struct {
+	mutex    sync.Mutex
+	bodies   []string
+	attempts map[string]uint16
+	fail     map[string]bool
+	received chan string
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		attempts: make(map[string]uint16),
+		fail:     make(map[string]bool),
+		received: make(chan string, 100),
+	}
+}
+
+func (r *recorder) HandleMessage(message queue.Message) error {
+	body := string(message.Body())
+	r.mutex.Lock()
+	r.bodies = append(r.bodies, body)
+	r.attempts[body] = message.Attempts()
+	fail := r.fail[body]
+	r.mutex.Unlock()
+	r.received <- body
+	if fail {
+		return fmt.Errorf("failed %s", body)
+	}
+	return nil
+}
+
+func (r *recorder) waitFor(t *testing.T, count int) []string {
+	bodies := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		select {
+		case body := <-r.received:
+			bodies = append(bodies, body)
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "Timed out waiting for messages", "got %v", bodies)
+		}
+	}
+	sort.Strings(bodies)
+	return bodies
+}
+
+func TestMemoryQueue(t *testing.T) {
+	q := queue.NewMemory()
+
+	// Messages published before anyone consumes wait in the topic.
+	require.Nil(t, q.Enqueue("ingest01_prefetch", 1))
+	require.Nil(t, q.EnqueueString("ingest01_prefetch", "2"))
+	assert.Equal(t, 2, q.Depth("ingest01_prefetch", ""))
+
+	// Each channel gets every message. Consumers of the same channel
+	// share its messages.
+	first := newRecorder()
+	second := newRecorder()
+	other := newRecorder()
+	c1, err := q.Consume("ingest01_prefetch", "worker_chan", 2, first)
+	require.Nil(t, err)
+	c2, err := q.Consume("ingest01_prefetch", "worker_chan", 2, second)
+	require.Nil(t, err)
+	c3, err := q.Consume("ingest01_prefetch", "other_chan", 2, other)
+	require.Nil(t, err)
+
+	require.Nil(t, q.Enqueue("ingest01_prefetch", 3))
+	shared := make([]string, 0)
+	for len(shared) < 3 {
+		select {
+		case body := <-first.received:
+			shared = append(shared, body)
+		case body := <-second.received:
+			shared = append(shared, body)
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "Timed out waiting for messages")
+		}
+	}
+	sort.Strings(shared)
+	assert.Equal(t, []string{"1", "2", "3"}, shared)
+	assert.Equal(t, []string{"3"}, other.waitFor(t, 1))
+
+	for _, c := range []queue.Consumer{c1, c2, c3} {
+		c.Stop()
+		select {
+		case <-c.StopChan():
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "Consumer did not stop")
+		}
+	}
+}
+
+func TestMemoryQueueRequeue(t *testing.T) {
+	q := queue.NewMemory()
+	q.MaxAttempts = 3
+	q.RequeueDelay = time.Millisecond
+	handler := newRecorder()
+	handler.fail["7"] = true
+	consumer, err := q.Consume("topic", "chan", 1, handler)
+	require.Nil(t, err)
+	defer consumer.Stop()
+
+	// Errors requeue the message until it runs out of attempts.
+	require.Nil(t, q.Enqueue("topic", 7))
+	assert.Equal(t, []string{"7", "7", "7"}, handler.waitFor(t, 3))
+	handler.mutex.Lock()
+	assert.Equal(t, uint16(3), handler.attempts["7"])
+	handler.mutex.Unlock()
+	select {
+	case body := <-handler.received:
+		assert.Fail(t, "Message delivered after max attempts", body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryQueueEnqueueDeferred(t *testing.T) {
+	q := queue.NewMemory()
+	handler := newRecorder()
+	consumer, err := q.Consume("topic", "chan", 1, handler)
+	require.Nil(t, err)
+	defer consumer.Stop()
+
+	start := time.Now()
+	require.Nil(t, q.EnqueueDeferred("topic", 8, 50*time.Millisecond))
+	assert.Equal(t, []string{"8"}, handler.waitFor(t, 1))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	// Deferred messages start over as first attempts.
+	handler.mutex.Lock()
+	assert.Equal(t, uint16(1), handler.attempts["8"])
+	handler.mutex.Unlock()
+}
+
+// holder disables auto response and holds on to its messages, the way
+// workers do while a task is in progress.
+type holder struct {
+	messages chan queue.Message
+}
+
+func (h *holder) HandleMessage(message queue.Message) error {
+	message.DisableAutoResponse()
+	h.messages <- message
+	return nil
+}
+
+func TestMemoryQueueMaxInFlight(t *testing.T) {
+	q := queue.NewMemory()
+	handler := &holder{messages: make(chan queue.Message, 10)}
+	consumer, err := q.Consume("topic", "chan", 1, handler)
+	require.Nil(t, err)
+	defer consumer.Stop()
+	for i := int64(1); i <= 3; i++ {
+		require.Nil(t, q.Enqueue("topic", i))
+	}
+
+	// Only one message at a time until we finish it.
+	message := <-handler.messages
+	assert.Equal(t, "1", string(message.Body()))
+	select {
+	case <-handler.messages:
+		assert.Fail(t, "Delivered more than max in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	message.Finish()
+	message = <-handler.messages
+	assert.Equal(t, "2", string(message.Body()))
+
+	// Max in flight zero pauses delivery.
+	consumer.ChangeMaxInFlight(0)
+	message.Requeue(0)
+	select {
+	case <-handler.messages:
+		assert.Fail(t, "Delivered while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, 2, q.Depth("topic", "chan"))
+	consumer.ChangeMaxInFlight(1)
+	message = <-handler.messages
+	assert.Equal(t, "3", string(message.Body()))
+	message.Finish()
+	message = <-handler.messages
+	assert.Equal(t, "2", string(message.Body()))
+	assert.Equal(t, uint16(2), message.Attempts())
+}
+
+func TestSharedMemory(t *testing.T) {
+	assert.Same(t, queue.SharedMemory(), queue.SharedMemory())
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/APTrust/preservation-services/network"
+	"github.com/nsqio/go-nsq"
+)
+
+// NSQ is a Queue backed by nsqd. It publishes through nsqd's HTTP
+// interface and finds consumers' nsqd instances through nsqlookupd.
+type NSQ struct {
+	// Client publishes messages to nsqd.
+	Client *network.NSQClient
+
+	// LookupdAddress is the HTTP address of nsqlookupd.
+	LookupdAddress string
+}
+
+// NewNSQ returns a Queue that publishes to the nsqd at url (usually on
+// port 4151) and consumes through the nsqlookupd at lookupdAddress.
+func NewNSQ(url, lookupdAddress string) *NSQ {
+	return &NSQ{
+		Client:         network.NewNSQClient(url),
+		LookupdAddress: lookupdAddress,
+	}
+}
+
+// Enqueue publishes a WorkItem or GenericFile ID to topic.
+func (q *NSQ) Enqueue(topic string, id int64) error {
+	return q.Client.Enqueue(topic, id)
+}
+
+// EnqueueString publishes data to topic.
+func (q *NSQ) EnqueueString(topic, data string) error {
+	return q.Client.EnqueueString(topic, data)
+}
+
+// EnqueueDeferred publishes a WorkItem or GenericFile ID to topic,
+// to be delivered after delay.
+func (q *NSQ) EnqueueDeferred(topic string, id int64, delay time.Duration) error {
+	return q.Client.EnqueueDeferred(topic, id, delay)
+}
+
+// Consume registers handler as an NSQ consumer on topic and channel.
+// Note that the consumer starts handling messages as soon as it connects.
+func (q *NSQ) Consume(topic, channel string, maxInFlight int, handler Handler) (Consumer, error) {
+	config := nsq.NewConfig()
+	//config.Set("msg_timeout", "600m")
+	config.Set("heartbeat_interval", "10s")
+	config.Set("max_in_flight", maxInFlight)
+	consumer, err := nsq.NewConsumer(topic, channel, config)
+	if err != nil {
+		return nil, err
+	}
+	consumer.AddHandler(nsq.HandlerFunc(func(message *nsq.Message) error {
+		return handler.HandleMessage(NewNSQMessage(message))
+	}))
+	err = consumer.ConnectToNSQLookupd(q.LookupdAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &nsqConsumer{consumer}, nil
+}
+
+type nsqConsumer struct {
+	consumer *nsq.Consumer
+}
+
+func (c *nsqConsumer) ChangeMaxInFlight(maxInFlight int) {
+	c.consumer.ChangeMaxInFlight(maxInFlight)
+}
+
+func (c *nsqConsumer) Stop() {
+	c.consumer.Stop()
+}
+
+func (c *nsqConsumer) StopChan() <-chan int {
+	return c.consumer.StopChan
+}
+
+// NewNSQMessage wraps an NSQ message so it satisfies Message.
+func NewNSQMessage(message *nsq.Message) Message {
+	return &nsqMessage{message}
+}
+
+type nsqMessage struct {
+	message *nsq.Message
+}
+
+func (m *nsqMessage) Body() []byte {
+	return m.message.Body
+}
+
+func (m *nsqMessage) Attempts() uint16 {
+	return m.message.Attempts
+}
+
+func (m *nsqMessage) DisableAutoResponse() {
+	m.message.DisableAutoResponse()
+}
+
+func (m *nsqMessage) Touch() {
+	m.message.Touch()
+}
+
+func (m *nsqMessage) Requeue(delay time.Duration) {
+	m.message.Requeue(delay)
+}
+
+func (m *nsqMessage) Finish() {
+	m.message.Finish()
+}
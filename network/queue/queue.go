@@ -0,0 +1,84 @@
+// Package queue defines the work queues that connect our workers, and
+// provides two implementations: NSQ, which we use in production, and
+// Memory, an in-process queue that lets a whole chain of workers run
+// inside one binary without nsqd and nsqlookupd.
+package queue
+
+import (
+	"time"
+)
+
+const (
+	// BackendNSQ selects the NSQ queue. This is the default.
+	BackendNSQ = "nsq"
+
+	// BackendMemory selects the in-process queue.
+	BackendMemory = "memory"
+)
+
+// Message is a message from a queue. Its body is usually the ID of the
+// WorkItem or GenericFile a worker should process.
+type Message interface {
+	// Body returns the message body.
+	Body() []byte
+
+	// Attempts returns the number of times the message has been
+	// delivered, including this one.
+	Attempts() uint16
+
+	// DisableAutoResponse tells the queue that the consumer will call
+	// Finish or Requeue itself, instead of having the queue finish or
+	// requeue the message according to what the handler returns.
+	DisableAutoResponse()
+
+	// Touch tells the queue we're still working on the message, so it
+	// doesn't time out and go to another consumer.
+	Touch()
+
+	// Requeue puts the message back in the queue, to be delivered again
+	// after delay.
+	Requeue(delay time.Duration)
+
+	// Finish tells the queue we're done with the message.
+	Finish()
+}
+
+// Handler handles messages from a queue. Unless the handler disables
+// auto response, the queue finishes the message when HandleMessage
+// returns nil and requeues it when HandleMessage returns an error.
+type Handler interface {
+	HandleMessage(Message) error
+}
+
+// Consumer delivers the messages in one channel of a topic to a Handler.
+type Consumer interface {
+	// ChangeMaxInFlight changes the number of messages the consumer
+	// may be working on at once. Zero stops delivery.
+	ChangeMaxInFlight(maxInFlight int)
+
+	// Stop stops delivering messages. StopChan is closed once the
+	// consumer has stopped.
+	Stop()
+	StopChan() <-chan int
+}
+
+// Queue publishes messages to topics and consumes them from channels.
+// As in NSQ, each channel of a topic gets a copy of every message
+// published to the topic, and the consumers of a channel share its
+// messages between them.
+type Queue interface {
+	// Enqueue publishes a WorkItem or GenericFile ID to topic.
+	Enqueue(topic string, id int64) error
+
+	// EnqueueString publishes data to topic.
+	EnqueueString(topic, data string) error
+
+	// EnqueueDeferred publishes a WorkItem or GenericFile ID to topic,
+	// to be delivered after delay. Unlike Message.Requeue, this does
+	// not count as a delivery attempt.
+	EnqueueDeferred(topic string, id int64, delay time.Duration) error
+
+	// Consume starts delivering the messages in channel of topic to
+	// handler, with at most maxInFlight messages in progress at once.
+	Consume(topic, channel string, maxInFlight int, handler Handler) (Consumer, error)
+}
@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// Router is a Queue that keeps messages for some topics in process.
+// Messages published to a local topic go to the in-process queue, and
+// all others go to the external queue, usually NSQ. Consumers of a
+// local topic read from both queues, so they still get messages that
+// other processes publish to the external queue.
+//
+// apt_ingest_all uses this to hand items from one ingest stage to the
+// next without a round trip through nsqd.
+type Router struct {
+	// External is the queue for topics that aren't local.
+	External Queue
+
+	// Local is the in-process queue for local topics.
+	Local *Memory
+
+	topics map[string]bool
+}
+
+// NewRouter returns a Router that keeps the specified topics in local
+// and sends everything else to external.
+func NewRouter(external Queue, local *Memory, topics ...string) *Router {
+	router := &Router{
+		External: external,
+		Local:    local,
+		topics:   make(map[string]bool),
+	}
+	for _, topic := range topics {
+		router.topics[topic] = true
+	}
+	return router
+}
+
+// IsLocal returns true if messages for topic stay in process.
+func (r *Router) IsLocal(topic string) bool {
+	return r.topics[topic]
+}
+
+// Enqueue publishes a WorkItem or GenericFile ID to topic.
+func (r *Router) Enqueue(topic string, id int64) error {
+	if r.IsLocal(topic) {
+		return r.Local.Enqueue(topic, id)
+	}
+	return r.External.Enqueue(topic, id)
+}
+
+// EnqueueString publishes data to topic.
+func (r *Router) EnqueueString(topic, data string) error {
+	if r.IsLocal(topic) {
+		return r.Local.EnqueueString(topic, data)
+	}
+	return r.External.EnqueueString(topic, data)
+}
+
+// EnqueueDeferred publishes a WorkItem or GenericFile ID to topic,
+// to be delivered after delay.
+func (r *Router) EnqueueDeferred(topic string, id int64, delay time.Duration) error {
+	if r.IsLocal(topic) {
+		return r.Local.EnqueueDeferred(topic, id, delay)
+	}
+	return r.External.EnqueueDeferred(topic, id, delay)
+}
+
+// Consume delivers the messages in channel of topic to handler. For
+// local topics, it delivers messages from both queues, with at most
+// maxInFlight from each in progress at once.
+func (r *Router) Consume(topic, channel string, maxInFlight int, handler Handler) (Consumer, error) {
+	external, err := r.External.Consume(topic, channel, maxInFlight, handler)
+	if err != nil || !r.IsLocal(topic) {
+		return external, err
+	}
+	local, err := r.Local.Consume(topic, channel, maxInFlight, handler)
+	if err != nil {
+		external.Stop()
+		return nil, err
+	}
+	return newMultiConsumer(external, local), nil
+}
+
+// ConsumeAll delivers the messages in channel of each of topics to
+// handler, with at most maxInFlight from each topic in progress at
+// once. Pausing or stopping the returned consumer pauses or stops
+// delivery from all of them.
+func ConsumeAll(q Queue, topics []string, channel string, maxInFlight int, handler Handler) (Consumer, error) {
+	consumers := make([]Consumer, 0, len(topics))
+	for _, topic := range topics {
+		consumer, err := q.Consume(topic, channel, maxInFlight, handler)
+		if err != nil {
+			for _, c := range consumers {
+				c.Stop()
+			}
+			return nil, err
+		}
+		consumers = append(consumers, consumer)
+	}
+	return newMultiConsumer(consumers...), nil
+}
+
+// multiConsumer combines several consumers into one.
+type multiConsumer struct {
+	consumers []Consumer
+	stopChan  chan int
+	stopOnce  sync.Once
+}
+
+func newMultiConsumer(consumers ...Consumer) *multiConsumer {
+	return &multiConsumer{
+		consumers: consumers,
+		stopChan:  make(chan int),
+	}
+}
+
+func (c *multiConsumer) ChangeMaxInFlight(maxInFlight int) {
+	for _, consumer := range c.consumers {
+		consumer.ChangeMaxInFlight(maxInFlight)
+	}
+}
+
+func (c *multiConsumer) Stop() {
+	for _, consumer := range c.consumers {
+		consumer.Stop()
+	}
+	c.stopOnce.Do(func() {
+		go func() {
+			for _, consumer := range c.consumers {
+				<-consumer.StopChan()
+			}
+			close(c.stopChan)
+		}()
+	})
+}
+
+func (c *multiConsumer) StopChan() <-chan int {
+	return c.stopChan
+}
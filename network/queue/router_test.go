@@ -0,0 +1,68 @@
+package queue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/network/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	external := queue.NewMemory()
+	local := queue.NewMemory()
+	router := queue.NewRouter(external, local, "ingest02_bag_validation")
+	assert.True(t, router.IsLocal("ingest02_bag_validation"))
+	assert.False(t, router.IsLocal("restore_file"))
+
+	// Local topics stay in the local queue, and others go out.
+	require.Nil(t, router.Enqueue("ingest02_bag_validation", 1))
+	require.Nil(t, router.EnqueueString("restore_file", "2"))
+	assert.Equal(t, 1, local.Depth("ingest02_bag_validation", ""))
+	assert.Equal(t, 0, external.Depth("ingest02_bag_validation", ""))
+	assert.Equal(t, 1, external.Depth("restore_file", ""))
+	assert.Equal(t, 0, local.Depth("restore_file", ""))
+
+	// Consumers of local topics get messages from both queues.
+	require.Nil(t, external.Enqueue("ingest02_bag_validation", 3))
+	handler := newRecorder()
+	consumer, err := router.Consume("ingest02_bag_validation", "chan", 2, handler)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1", "3"}, handler.waitFor(t, 2))
+
+	consumer.Stop()
+	select {
+	case <-consumer.StopChan():
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Consumer did not stop")
+	}
+}
+
+func TestConsumeAll(t *testing.T) {
+	q := queue.NewMemory()
+	require.Nil(t, q.Enqueue("restore_file", 1))
+	require.Nil(t, q.Enqueue("restore_file_priority", 2))
+	handler := newRecorder()
+	consumer, err := queue.ConsumeAll(q, []string{"restore_file", "restore_file_priority"}, "chan", 2, handler)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1", "2"}, handler.waitFor(t, 2))
+
+	// Pausing the consumer pauses delivery from every topic.
+	consumer.ChangeMaxInFlight(0)
+	require.Nil(t, q.Enqueue("restore_file_priority", 3))
+	select {
+	case body := <-handler.received:
+		assert.Fail(t, "Paused consumer delivered "+body)
+	case <-time.After(100 * time.Millisecond):
+	}
+	consumer.ChangeMaxInFlight(2)
+	assert.Equal(t, []string{"3"}, handler.waitFor(t, 1))
+
+	consumer.Stop()
+	select {
+	case <-consumer.StopChan():
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Consumer did not stop")
+	}
+}
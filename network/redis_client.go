@@ -100,6 +100,78 @@ func (c *RedisClient) RestorationObjectDelete(workItemID int64, objIdentifier st
 	return err
 }
 
+// GlacierRestoreGet returns the state of the Glacier restore request
+// for a file in a restoration WorkItem.
+func (c *RedisClient) GlacierRestoreGet(workItemID int64, fileIdentifier string) (*service.GlacierRestoreState, error) {
+	key := strconv.FormatInt(workItemID, 10)
+	field := fmt.Sprintf("glacier:%s", fileIdentifier)
+	data, err := c.client.HGet(key, field).Result()
+	if err != nil {
+		return nil, fmt.Errorf("GlacierRestoreGet (%d, %s): %s",
+			workItemID, fileIdentifier, err.Error())
+	}
+	return service.GlacierRestoreStateFromJSON(data)
+}
+
+// GlacierRestoreSave saves the state of a Glacier restore request.
+func (c *RedisClient) GlacierRestoreSave(workItemID int64, state *service.GlacierRestoreState) error {
+	key := strconv.FormatInt(workItemID, 10)
+	field := fmt.Sprintf("glacier:%s", state.GenericFileIdentifier)
+	jsonData, err := state.ToJSON()
+	if err != nil {
+		return err
+	}
+	_, err = c.client.HSet(key, field, jsonData).Result()
+	return err
+}
+
+// DeletedFileSave saves the record of a file deleted as part of a
+// deletion WorkItem.
+func (c *RedisClient) DeletedFileSave(workItemID int64, f *service.DeletedFile) error {
+	key := strconv.FormatInt(workItemID, 10)
+	field := fmt.Sprintf("deleted:%s", f.Identifier)
+	jsonData, err := f.ToJSON()
+	if err != nil {
+		return err
+	}
+	_, err = c.client.HSet(key, field, jsonData).Result()
+	return err
+}
+
+// DeletedFileList returns the records of all files deleted so far as
+// part of a deletion WorkItem.
+func (c *RedisClient) DeletedFileList(workItemID int64) ([]*service.DeletedFile, error) {
+	key := strconv.FormatInt(workItemID, 10)
+	files := make([]*service.DeletedFile, 0)
+	cursor := uint64(0)
+	for {
+		keysAndValues, nextCursor, err := c.client.HScan(key, cursor, "deleted:*", 100).Result()
+		if err != nil {
+			return nil, fmt.Errorf("DeletedFileList (%d): %s", workItemID, err.Error())
+		}
+		for i := 1; i < len(keysAndValues); i += 2 {
+			f, err := service.DeletedFileFromJSON(keysAndValues[i])
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, f)
+		}
+		if nextCursor == 0 {
+			break
+		}
+		cursor = nextCursor
+	}
+	return files, nil
+}
+
+// DeletedFileDelete removes the record of a deleted file.
+func (c *RedisClient) DeletedFileDelete(workItemID int64, fileIdentifier string) error {
+	key := strconv.FormatInt(workItemID, 10)
+	field := fmt.Sprintf("deleted:%s", fileIdentifier)
+	_, err := c.client.HDel(key, field).Result()
+	return err
+}
+
 // IngestFileGet returns an IngestFile from Redis.
 func (c *RedisClient) IngestFileGet(workItemID int64, fileIdentifier string) (*service.IngestFile, error) {
 	key := strconv.FormatInt(workItemID, 10)
@@ -278,6 +350,38 @@ func (c *RedisClient) WorkResultDelete(workItemID int64, operationName string) e
 	return err
 }
 
+// DeadLetterSave records that WorkItem workItemID went to the
+// dead-letter topic of the worker that consumes topic.
+func (c *RedisClient) DeadLetterSave(workItemID int64, topic string) error {
+	key := strconv.FormatInt(workItemID, 10)
+	field := fmt.Sprintf("deadletter:%s", topic)
+	_, err := c.client.HSet(key, field, time.Now().UTC().Format(time.RFC3339)).Result()
+	return err
+}
+
+// DeadLetterExists returns true if WorkItem workItemID is in the
+// dead-letter topic of the worker that consumes topic and has not
+// been replayed.
+func (c *RedisClient) DeadLetterExists(workItemID int64, topic string) (bool, error) {
+	key := strconv.FormatInt(workItemID, 10)
+	field := fmt.Sprintf("deadletter:%s", topic)
+	exists, err := c.client.HExists(key, field).Result()
+	if err != nil {
+		return false, fmt.Errorf("DeadLetterExists (%d, %s): %s",
+			workItemID, topic, err.Error())
+	}
+	return exists, nil
+}
+
+// DeadLetterDelete clears the record saved by DeadLetterSave. Call
+// this when the WorkItem is replayed.
+func (c *RedisClient) DeadLetterDelete(workItemID int64, topic string) error {
+	key := strconv.FormatInt(workItemID, 10)
+	field := fmt.Sprintf("deadletter:%s", topic)
+	_, err := c.client.HDel(key, field).Result()
+	return err
+}
+
 // Keys returns all keys in the Redis DB matching the specified pattern.
 // Each key is a WorkItem.ID in string form. It's generally safe to call
 // this with pattern "*" because we rarely have more than a few dozen items
@@ -285,3 +389,167 @@ func (c *RedisClient) WorkResultDelete(workItemID int64, operationName string) e
 func (c *RedisClient) Keys(pattern string) ([]string, error) {
 	return c.client.Keys(pattern).Result()
 }
+
+// Fixity comparisons and quarantined replicas are not tied to a
+// WorkItem, since fixity checks don't have WorkItems. They live in
+// their own hashes, which persist until an operator clears them.
+const (
+	fixityComparisonKey = "fixity:comparisons"
+	fixityProgressKey   = "fixity:progress"
+	quarantineKey       = "fixity:quarantine"
+)
+
+// FixityComparisonGet returns the most recent FixityComparison for
+// the GenericFile with the specified ID.
+func (c *RedisClient) FixityComparisonGet(genericFileID int64) (*service.FixityComparison, error) {
+	field := strconv.FormatInt(genericFileID, 10)
+	data, err := c.client.HGet(fixityComparisonKey, field).Result()
+	if err != nil {
+		return nil, fmt.Errorf("FixityComparisonGet (%d): %s",
+			genericFileID, err.Error())
+	}
+	return service.FixityComparisonFromJSON(data)
+}
+
+// FixityComparisonSave saves a FixityComparison to Redis, replacing
+// any earlier comparison for the same GenericFile.
+func (c *RedisClient) FixityComparisonSave(comparison *service.FixityComparison) error {
+	field := strconv.FormatInt(comparison.GenericFileID, 10)
+	jsonData, err := comparison.ToJSON()
+	if err != nil {
+		return err
+	}
+	_, err = c.client.HSet(fixityComparisonKey, field, jsonData).Result()
+	return err
+}
+
+// QuarantineGet returns the QuarantinedReplica record for the copy of
+// the GenericFile at url.
+func (c *RedisClient) QuarantineGet(genericFileID int64, url string) (*service.QuarantinedReplica, error) {
+	field := fmt.Sprintf("%d:%s", genericFileID, url)
+	data, err := c.client.HGet(quarantineKey, field).Result()
+	if err != nil {
+		return nil, fmt.Errorf("QuarantineGet (%d, %s): %s",
+			genericFileID, url, err.Error())
+	}
+	return service.QuarantinedReplicaFromJSON(data)
+}
+
+// IsQuarantined returns true if the copy of the GenericFile at url is
+// quarantined.
+func (c *RedisClient) IsQuarantined(genericFileID int64, url string) (bool, error) {
+	field := fmt.Sprintf("%d:%s", genericFileID, url)
+	exists, err := c.client.HExists(quarantineKey, field).Result()
+	if err != nil {
+		return false, fmt.Errorf("IsQuarantined (%d, %s): %s",
+			genericFileID, url, err.Error())
+	}
+	return exists, nil
+}
+
+// QuarantineSave marks a preservation copy as quarantined.
+func (c *RedisClient) QuarantineSave(replica *service.QuarantinedReplica) error {
+	field := fmt.Sprintf("%d:%s", replica.GenericFileID, replica.URL)
+	jsonData, err := replica.ToJSON()
+	if err != nil {
+		return err
+	}
+	_, err = c.client.HSet(quarantineKey, field, jsonData).Result()
+	return err
+}
+
+// QuarantineDelete releases a preservation copy from quarantine.
+// Call this after the copy has been repaired.
+func (c *RedisClient) QuarantineDelete(genericFileID int64, url string) error {
+	field := fmt.Sprintf("%d:%s", genericFileID, url)
+	_, err := c.client.HDel(quarantineKey, field).Result()
+	return err
+}
+
+// QuarantineList returns all quarantined replicas.
+func (c *RedisClient) QuarantineList() ([]*service.QuarantinedReplica, error) {
+	data, err := c.client.HGetAll(quarantineKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("QuarantineList: %s", err.Error())
+	}
+	replicas := make([]*service.QuarantinedReplica, 0, len(data))
+	for _, jsonData := range data {
+		replica, err := service.QuarantinedReplicaFromJSON(jsonData)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, replica)
+	}
+	return replicas, nil
+}
+
+// FixityProgressGet returns the saved progress of a fixity check on
+// the copy of the GenericFile at url.
+func (c *RedisClient) FixityProgressGet(genericFileID int64, url string) (*service.FixityProgress, error) {
+	field := fmt.Sprintf("%d:%s", genericFileID, url)
+	data, err := c.client.HGet(fixityProgressKey, field).Result()
+	if err != nil {
+		return nil, fmt.Errorf("FixityProgressGet (%d, %s): %s",
+			genericFileID, url, err.Error())
+	}
+	return service.FixityProgressFromJSON(data)
+}
+
+// FixityProgressSave saves the progress of a fixity check.
+func (c *RedisClient) FixityProgressSave(progress *service.FixityProgress) error {
+	field := fmt.Sprintf("%d:%s", progress.GenericFileID, progress.URL)
+	jsonData, err := progress.ToJSON()
+	if err != nil {
+		return err
+	}
+	_, err = c.client.HSet(fixityProgressKey, field, jsonData).Result()
+	return err
+}
+
+// FixityProgressDelete deletes the saved progress of a fixity check.
+// Call this when the check completes.
+func (c *RedisClient) FixityProgressDelete(genericFileID int64, url string) error {
+	field := fmt.Sprintf("%d:%s", genericFileID, url)
+	_, err := c.client.HDel(fixityProgressKey, field).Result()
+	return err
+}
+
+// objectLockRetryKey is the hash of IntellectualObjects whose Object
+// Lock settings the syncer failed to apply. Like the fixity hashes,
+// it isn't tied to a WorkItem.
+const objectLockRetryKey = "objectlock:retry"
+
+// ObjectLockRetrySave records that applying Object Lock settings to
+// the IntellectualObject with the specified ID failed with errMsg, so
+// the syncer can try again later.
+func (c *RedisClient) ObjectLockRetrySave(objectID int64, errMsg string) error {
+	field := strconv.FormatInt(objectID, 10)
+	_, err := c.client.HSet(objectLockRetryKey, field, errMsg).Result()
+	return err
+}
+
+// ObjectLockRetryDelete clears the record saved by
+// ObjectLockRetrySave. Call this once the settings are applied.
+func (c *RedisClient) ObjectLockRetryDelete(objectID int64) error {
+	field := strconv.FormatInt(objectID, 10)
+	_, err := c.client.HDel(objectLockRetryKey, field).Result()
+	return err
+}
+
+// ObjectLockRetryList returns the IDs of all IntellectualObjects
+// saved by ObjectLockRetrySave.
+func (c *RedisClient) ObjectLockRetryList() ([]int64, error) {
+	data, err := c.client.HGetAll(objectLockRetryKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("ObjectLockRetryList: %s", err.Error())
+	}
+	ids := make([]int64, 0, len(data))
+	for field := range data {
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ObjectLockRetryList: bad object id %q", field)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/APTrust/preservation-services/constants"
 	"github.com/APTrust/preservation-services/models/common"
@@ -335,6 +336,27 @@ func TestWorkResultSaveAndDelete(t *testing.T) {
 	assert.Nil(t, deletedResult)
 }
 
+func TestDeadLetterMarker(t *testing.T) {
+	client := getRedisClient()
+	require.NotNil(t, client)
+	exists, err := client.DeadLetterExists(9998, constants.TopicFileRestore)
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	require.Nil(t, client.DeadLetterSave(9998, constants.TopicFileRestore))
+	exists, err = client.DeadLetterExists(9998, constants.TopicFileRestore)
+	require.Nil(t, err)
+	assert.True(t, exists)
+	exists, err = client.DeadLetterExists(9998, constants.TopicObjectRestore)
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	require.Nil(t, client.DeadLetterDelete(9998, constants.TopicFileRestore))
+	exists, err = client.DeadLetterExists(9998, constants.TopicFileRestore)
+	require.Nil(t, err)
+	assert.False(t, exists)
+}
+
 func TestKeys(t *testing.T) {
 	client := getRedisClient()
 	require.NotNil(t, client)
@@ -350,3 +372,124 @@ func TestKeys(t *testing.T) {
 	require.Nil(t, err)
 	assert.Equal(t, 1, len(keys))
 }
+
+func TestFixityComparisonSaveAndGet(t *testing.T) {
+	client := getRedisClient()
+	require.NotNil(t, client)
+	comparison := &service.FixityComparison{
+		GenericFileID:  7777,
+		ExpectedDigest: "1111",
+		Verdict:        "replica_corrupt",
+		Replicas: []*service.ReplicaDigest{
+			{URL: "https://example.com/va/uuid", Digest: "2222"},
+		},
+	}
+	err := client.FixityComparisonSave(comparison)
+	require.Nil(t, err)
+
+	retrieved, err := client.FixityComparisonGet(7777)
+	require.Nil(t, err)
+	assert.Equal(t, "replica_corrupt", retrieved.Verdict)
+	assert.Equal(t, 1, len(retrieved.Replicas))
+}
+
+func TestQuarantine(t *testing.T) {
+	client := getRedisClient()
+	require.NotNil(t, client)
+	replica := &service.QuarantinedReplica{
+		GenericFileID:  7777,
+		URL:            "https://example.com/va/uuid",
+		ExpectedDigest: "1111",
+		ActualDigest:   "2222",
+	}
+	err := client.QuarantineSave(replica)
+	require.Nil(t, err)
+
+	retrieved, err := client.QuarantineGet(7777, replica.URL)
+	require.Nil(t, err)
+	assert.Equal(t, "2222", retrieved.ActualDigest)
+
+	quarantined, err := client.IsQuarantined(7777, replica.URL)
+	require.Nil(t, err)
+	assert.True(t, quarantined)
+	quarantined, err = client.IsQuarantined(7777, "https://example.com/or/uuid")
+	require.Nil(t, err)
+	assert.False(t, quarantined)
+
+	list, err := client.QuarantineList()
+	require.Nil(t, err)
+	assert.NotEmpty(t, list)
+
+	err = client.QuarantineDelete(7777, replica.URL)
+	require.Nil(t, err)
+	deleted, _ := client.QuarantineGet(7777, replica.URL)
+	assert.Nil(t, deleted)
+	quarantined, err = client.IsQuarantined(7777, replica.URL)
+	require.Nil(t, err)
+	assert.False(t, quarantined)
+}
+
+func TestFixityProgress(t *testing.T) {
+	client := getRedisClient()
+	require.NotNil(t, client)
+	progress := &service.FixityProgress{
+		GenericFileID: 7777,
+		URL:           "https://example.com/va/uuid",
+		ETag:          "abc123",
+		Size:          5000,
+		Offset:        2000,
+		HashState:     []byte{0x01, 0x02},
+	}
+	err := client.FixityProgressSave(progress)
+	require.Nil(t, err)
+
+	retrieved, err := client.FixityProgressGet(7777, progress.URL)
+	require.Nil(t, err)
+	assert.Equal(t, int64(2000), retrieved.Offset)
+	assert.Equal(t, progress.HashState, retrieved.HashState)
+
+	err = client.FixityProgressDelete(7777, progress.URL)
+	require.Nil(t, err)
+	deleted, _ := client.FixityProgressGet(7777, progress.URL)
+	assert.Nil(t, deleted)
+}
+
+func TestObjectLockRetry(t *testing.T) {
+	client := getRedisClient()
+	require.NotNil(t, client)
+	require.Nil(t, client.ObjectLockRetrySave(7777, "S3 unavailable"))
+	ids, err := client.ObjectLockRetryList()
+	require.Nil(t, err)
+	assert.Contains(t, ids, int64(7777))
+
+	require.Nil(t, client.ObjectLockRetryDelete(7777))
+	ids, err = client.ObjectLockRetryList()
+	require.Nil(t, err)
+	assert.NotContains(t, ids, int64(7777))
+}
+
+func TestDeletedFiles(t *testing.T) {
+	client := getRedisClient()
+	require.NotNil(t, client)
+	workItemID := int64(8888)
+	defer client.WorkItemDelete(workItemID)
+
+	for _, name := range []string{"doc1", "doc2"} {
+		f := &service.DeletedFile{Identifier: "test.edu/bag/" + name}
+		f.AddCopy("https://example.com/va/"+name, time.Now().UTC(), 1)
+		require.Nil(t, client.DeletedFileSave(workItemID, f))
+	}
+	files, err := client.DeletedFileList(workItemID)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(files))
+	for _, f := range files {
+		assert.Equal(t, 1, len(f.Copies))
+		assert.False(t, f.IsVerified())
+	}
+
+	require.Nil(t, client.DeletedFileDelete(workItemID, "test.edu/bag/doc1"))
+	files, err = client.DeletedFileList(workItemID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(files))
+	assert.Equal(t, "test.edu/bag/doc2", files[0].Identifier)
+}
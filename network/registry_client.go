@@ -397,6 +397,7 @@ func (client *RegistryClient) genericFileGet(relativeURL string) *RegistryRespon
 
 // GenericFileList returns a list of Generic Files. Filter params include:
 //
+// id__in
 // identifier
 // uuid
 // intellectual_object_id
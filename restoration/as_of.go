@@ -0,0 +1,165 @@
+package restoration
+
+import (
+	ctx "context"
+	"fmt"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/minio/minio-go/v7"
+)
+
+// Historical restorations rebuild a bag as it was at
+// RestorationObject.AsOf. Reingest overwrites each file under its
+// existing UUID, so this relies on versioning being enabled on the
+// preservation buckets. The superseded copies stay in the bucket as
+// noncurrent versions, and we read the version that was current at
+// AsOf. Registry keeps every checksum it has recorded for a file, so
+// we verify against those that were current at the same time.
+//
+// AsOf is compared only with Registry's timestamps, so the checksums
+// and the version we pick can't disagree because of clock skew or
+// upload time. Ingest computes checksums before it uploads, so the
+// first checksum Registry recorded after AsOf marks the reingest that
+// replaced the version we want, and that reingest's upload comes
+// after it. We take the last version stored before then. See
+// VersionCutoff.
+//
+// Registry lists only active files, so files deleted since AsOf can't
+// be included.
+
+// ExistedAt returns true if gf had been ingested at asOf.
+func ExistedAt(gf *registry.GenericFile, asOf time.Time) bool {
+	return !gf.CreatedAt.After(asOf)
+}
+
+// ChecksumsAsOf returns the checksums of gf that Registry had recorded
+// by asOf.
+func ChecksumsAsOf(gf *registry.GenericFile, asOf time.Time) []*registry.Checksum {
+	checksums := make([]*registry.Checksum, 0, len(gf.Checksums))
+	for _, cs := range gf.Checksums {
+		if cs != nil && !cs.DateTime.After(asOf) {
+			checksums = append(checksums, cs)
+		}
+	}
+	return checksums
+}
+
+// VersionCutoff returns the time of the first checksum Registry
+// recorded for gf after asOf, which is when the reingest that replaced
+// the version current at asOf began. It returns the zero time if gf
+// hasn't been reingested since asOf.
+func VersionCutoff(gf *registry.GenericFile, asOf time.Time) time.Time {
+	var cutoff time.Time
+	for _, cs := range gf.Checksums {
+		if cs != nil && cs.DateTime.After(asOf) && (cutoff.IsZero() || cs.DateTime.Before(cutoff)) {
+			cutoff = cs.DateTime
+		}
+	}
+	return cutoff
+}
+
+// SelectVersion returns the last version of key stored before cutoff,
+// or the latest version if cutoff is the zero time. It returns nil if
+// there's no such version, or if it's a delete marker. Param versions
+// can include other keys, in any order.
+func SelectVersion(versions []minio.ObjectInfo, key string, cutoff time.Time) *minio.ObjectInfo {
+	var selected *minio.ObjectInfo
+	for i, version := range versions {
+		if version.Key != key || (!cutoff.IsZero() && !version.LastModified.Before(cutoff)) {
+			continue
+		}
+		if selected == nil || version.LastModified.After(selected.LastModified) {
+			selected = &versions[i]
+		}
+	}
+	if selected != nil && selected.IsDeleteMarker {
+		return nil
+	}
+	return selected
+}
+
+// FindVersion returns the version of gf in source that was current at
+// RestorationObject.AsOf. We remember versions we've found, since
+// restorers open the same copy more than once.
+func (b *Base) FindVersion(gf *registry.GenericFile, source *RestorationSource) (*minio.ObjectInfo, error) {
+	cacheKey := source.StorageRecord.URL
+	if version, ok := b.versions[cacheKey]; ok {
+		return version, nil
+	}
+	client := b.Context.S3Clients[source.Bucket.Bucket]
+	if client == nil {
+		return nil, fmt.Errorf("Cannot find S3 client for bucket %s", source.Bucket.Bucket)
+	}
+	err := b.checkVersioning(client, source.Bucket.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]minio.ObjectInfo, 0)
+	opts := minio.ListObjectsOptions{
+		Prefix:       gf.UUID,
+		WithVersions: true,
+	}
+	for obj := range client.ListObjects(ctx.Background(), source.Bucket.Bucket, opts) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		versions = append(versions, obj)
+	}
+	asOf := *b.RestorationObject.AsOf
+	version := SelectVersion(versions, gf.UUID, VersionCutoff(gf, asOf))
+	if version == nil {
+		return nil, fmt.Errorf("%s has no version of %s as of %s", source.StorageRecord.URL, gf.Identifier, asOf.Format(time.RFC3339))
+	}
+	if b.versions == nil {
+		b.versions = make(map[string]*minio.ObjectInfo)
+	}
+	b.versions[cacheKey] = version
+	return version, nil
+}
+
+// checkVersioning returns an error if versioning is not enabled on
+// bucket. Without it, reingest overwrote older versions, and the only
+// version in the bucket is the current one, which may not match the
+// checksums Registry had at AsOf.
+func (b *Base) checkVersioning(client *minio.Client, bucket string) error {
+	if b.versionedBuckets[bucket] {
+		return nil
+	}
+	config, err := client.GetBucketVersioning(ctx.Background(), bucket)
+	if err != nil {
+		return fmt.Errorf("Cannot get versioning status of bucket %s: %v", bucket, err)
+	}
+	if !config.Enabled() {
+		return fmt.Errorf("Versioning is not enabled on bucket %s, so it has no older versions to restore", bucket)
+	}
+	if b.versionedBuckets == nil {
+		b.versionedBuckets = make(map[string]bool)
+	}
+	b.versionedBuckets[bucket] = true
+	return nil
+}
+
+// FileAsOf returns a copy of gf as it was at RestorationObject.AsOf,
+// with the size of the version that was current then, and only the
+// checksums Registry had recorded by then. The caller should check
+// ExistedAt first.
+func (b *Base) FileAsOf(gf *registry.GenericFile) (*registry.GenericFile, error) {
+	asOf := *b.RestorationObject.AsOf
+	historical := *gf
+	historical.Checksums = ChecksumsAsOf(gf, asOf)
+	if len(historical.Checksums) == 0 {
+		return nil, fmt.Errorf("Registry has no checksums for %s as of %s", gf.Identifier, asOf.Format(time.RFC3339))
+	}
+	var err error
+	for _, source := range RestorationSources(b.Context, gf) {
+		var version *minio.ObjectInfo
+		version, err = b.FindVersion(gf, source)
+		if err == nil {
+			historical.Size = version.Size
+			return &historical, nil
+		}
+		b.Context.Logger.Warningf("Cannot find version of %s in %s: %v", gf.Identifier, source.StorageRecord.URL, err)
+	}
+	return nil, fmt.Errorf("Could not find a version of %s as of %s in any preservation bucket. Last error: %v", gf.Identifier, asOf.Format(time.RFC3339), err)
+}
@@ -0,0 +1,232 @@
+package restoration_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/restoration"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	jan1 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb1 = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	mar1 = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	apr1 = time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+)
+
+type fakeVersion struct {
+	id           string
+	lastModified time.Time
+	data         string
+}
+
+// versionedBucket serves a single key with several versions, the way
+// a bucket with versioning enabled would after a reingest.
+type versionedBucket struct {
+	key         string
+	versions    []fakeVersion
+	unversioned bool
+}
+
+func (b *versionedBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if _, ok := query["versioning"]; ok {
+		status := "Enabled"
+		if b.unversioned {
+			status = ""
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><VersioningConfiguration><Status>%s</Status></VersioningConfiguration>`, status)
+		return
+	}
+	if _, ok := query["versions"]; ok {
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListVersionsResult><Name>bucket</Name><IsTruncated>false</IsTruncated>`)
+		for i, v := range b.versions {
+			fmt.Fprintf(w, `<Version><Key>%s</Key><VersionId>%s</VersionId><IsLatest>%t</IsLatest><LastModified>%s</LastModified><Size>%d</Size><ETag>"x"</ETag></Version>`,
+				b.key, v.id, i == len(b.versions)-1, v.lastModified.Format(time.RFC3339), len(v.data))
+		}
+		fmt.Fprint(w, `</ListVersionsResult>`)
+		return
+	}
+	for _, v := range b.versions {
+		if v.id == query.Get("versionId") {
+			w.Header().Set("ETag", `"x"`)
+			w.Header().Set("Last-Modified", v.lastModified.Format(http.TimeFormat))
+			w.Header().Set("Content-Length", strconv.Itoa(len(v.data)))
+			io.WriteString(w, v.data)
+			return
+		}
+	}
+	writeS3Error(w, http.StatusNotFound, "NoSuchVersion")
+}
+
+func TestExistedAt(t *testing.T) {
+	gf := &registry.GenericFile{CreatedAt: feb1}
+	assert.False(t, restoration.ExistedAt(gf, jan1))
+	assert.True(t, restoration.ExistedAt(gf, feb1))
+	assert.True(t, restoration.ExistedAt(gf, mar1))
+}
+
+func TestChecksumsAsOf(t *testing.T) {
+	gf := &registry.GenericFile{
+		Checksums: []*registry.Checksum{
+			{Algorithm: constants.AlgSha256, Digest: "old", DateTime: jan1},
+			{Algorithm: constants.AlgSha256, Digest: "new", DateTime: mar1},
+		},
+	}
+	historical := &registry.GenericFile{Checksums: restoration.ChecksumsAsOf(gf, feb1)}
+	assert.Equal(t, "old", historical.GetLatestChecksum(constants.AlgSha256).Digest)
+	historical.Checksums = restoration.ChecksumsAsOf(gf, apr1)
+	assert.Equal(t, "new", historical.GetLatestChecksum(constants.AlgSha256).Digest)
+	assert.Empty(t, restoration.ChecksumsAsOf(gf, jan1.Add(-time.Second)))
+}
+
+func TestVersionCutoff(t *testing.T) {
+	gf := &registry.GenericFile{
+		Checksums: []*registry.Checksum{
+			{Algorithm: constants.AlgSha256, Digest: "new", DateTime: mar1},
+			{Algorithm: constants.AlgSha256, Digest: "old", DateTime: jan1},
+			{Algorithm: constants.AlgMd5, Digest: "new", DateTime: mar1.Add(-time.Second)},
+		},
+	}
+	assert.Equal(t, jan1, restoration.VersionCutoff(gf, jan1.Add(-time.Second)))
+	assert.Equal(t, mar1.Add(-time.Second), restoration.VersionCutoff(gf, feb1))
+	assert.True(t, restoration.VersionCutoff(gf, mar1).IsZero())
+}
+
+func TestSelectVersion(t *testing.T) {
+	versions := []minio.ObjectInfo{
+		{Key: "abc", VersionID: "v3", LastModified: mar1},
+		{Key: "abc", VersionID: "v1", LastModified: jan1},
+		{Key: "abcdef", VersionID: "other", LastModified: feb1},
+		{Key: "abc", VersionID: "v2", LastModified: feb1},
+	}
+	assert.Nil(t, restoration.SelectVersion(versions, "abc", jan1))
+	assert.Equal(t, "v1", restoration.SelectVersion(versions, "abc", jan1.Add(time.Second)).VersionID)
+	assert.Equal(t, "v1", restoration.SelectVersion(versions, "abc", feb1).VersionID)
+	assert.Equal(t, "v2", restoration.SelectVersion(versions, "abc", mar1).VersionID)
+	assert.Equal(t, "v3", restoration.SelectVersion(versions, "abc", time.Time{}).VersionID)
+
+	versions = append(versions, minio.ObjectInfo{Key: "abc", VersionID: "deleted", LastModified: apr1, IsDeleteMarker: true})
+	assert.Nil(t, restoration.SelectVersion(versions, "abc", time.Time{}))
+	assert.Equal(t, "v3", restoration.SelectVersion(versions, "abc", apr1).VersionID)
+}
+
+func TestFileAsOf(t *testing.T) {
+	bucket := &versionedBucket{
+		key: sourceTestUUID,
+		versions: []fakeVersion{
+			{id: "v1", lastModified: jan1, data: "original version"},
+			{id: "v2", lastModified: mar1, data: "reingested version, which is longer"},
+		},
+	}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.Nil(t, err)
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4("key", "secret", ""),
+		Region:       "us-east-1",
+		BucketLookup: minio.BucketLookupPath,
+		MaxRetries:   1,
+	})
+	require.Nil(t, err)
+
+	config := common.NewConfig()
+	primary := config.PreservationBucketsFor(constants.StorageStandard)[0]
+	asOf := feb1
+	base := &restoration.Base{
+		Context: &common.Context{
+			Config:    config,
+			Logger:    logger.DiscardLogger("as_of_test"),
+			S3Clients: map[string]*minio.Client{primary.Bucket: client},
+		},
+		RestorationObject: &service.RestorationObject{
+			Identifier: "test.edu/bag",
+			AsOf:       &asOf,
+		},
+	}
+	gf := &registry.GenericFile{
+		Identifier: "test.edu/bag/data/file.txt",
+		CreatedAt:  jan1,
+		Size:       int64(len(bucket.versions[1].data)),
+		UUID:       sourceTestUUID,
+		Checksums: []*registry.Checksum{
+			{Algorithm: constants.AlgMd5, Digest: "old", DateTime: jan1},
+			{Algorithm: constants.AlgMd5, Digest: "new", DateTime: mar1},
+		},
+		StorageRecords: []*registry.StorageRecord{
+			{URL: primary.URLFor(sourceTestUUID)},
+		},
+	}
+
+	historical, err := base.FileAsOf(gf)
+	require.Nil(t, err)
+	assert.Equal(t, int64(len("original version")), historical.Size)
+	assert.Equal(t, "old", historical.GetLatestChecksum(constants.AlgMd5).Digest)
+
+	// S3's clock doesn't matter. Ingest computed the first checksum a
+	// little before it uploaded the file, and an as-of time in between
+	// still gets the version that matches that checksum.
+	skewed := &restoration.Base{
+		Context:           base.Context,
+		RestorationObject: &service.RestorationObject{AsOf: &jan1},
+	}
+	gf.Checksums[0].DateTime = jan1.Add(-time.Minute)
+	skewedFile, err := skewed.FileAsOf(gf)
+	require.Nil(t, err)
+	assert.Equal(t, int64(len("original version")), skewedFile.Size)
+	assert.Equal(t, "old", skewedFile.GetLatestChecksum(constants.AlgMd5).Digest)
+	gf.Checksums[0].DateTime = jan1
+
+	// The current record is untouched.
+	assert.Equal(t, int64(len(bucket.versions[1].data)), gf.Size)
+	assert.Equal(t, 2, len(gf.Checksums))
+
+	// Reads get the version that was current as of the requested date.
+	reader, err := base.NewSourceReader(historical)
+	require.Nil(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	assert.Equal(t, "original version", string(data))
+
+	// Before the file was first ingested, there's nothing to restore.
+	earlier := jan1.Add(-time.Hour)
+	base = &restoration.Base{
+		Context:           base.Context,
+		RestorationObject: &service.RestorationObject{AsOf: &earlier},
+	}
+	assert.False(t, restoration.ExistedAt(gf, earlier))
+	_, err = base.FileAsOf(gf)
+	require.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "Registry has no checksums"))
+
+	// Without versioning, the bucket has only the current version, so
+	// we can't restore an older one.
+	bucket.unversioned = true
+	base = &restoration.Base{
+		Context:           base.Context,
+		RestorationObject: &service.RestorationObject{AsOf: &asOf},
+	}
+	_, err = base.FileAsOf(gf)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Versioning is not enabled")
+}
@@ -3,7 +3,7 @@ package restoration
 import (
 	"archive/tar"
 	"bytes"
-	ctx "context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -19,15 +19,17 @@ import (
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
 	"github.com/APTrust/preservation-services/util"
-	"github.com/minio/minio-go/v7"
 )
 
 // The restoration process pipes data as follows:
 //
-// S3 Preservation Bucket -> TarPipeWriter -> Restoration Bucket
+// S3 Preservation Bucket -> BagWriter -> Restoration Bucket
 //
-// The TarPipeWriter writes all files into a single tarball, which
-// will include manifests, tag manifests, and tag files.
+// By default, the BagWriter is a TarPipeWriter, which writes all files
+// into a single tarball, which will include manifests, tag manifests,
+// and tag files. Depositors may ask for a zip file, a gzipped tarball,
+// or a loose bag, in which each file is a separate object in the
+// restoration bucket.
 
 const batchSize = 100
 
@@ -41,8 +43,17 @@ Tag-File-Character-Encoding: UTF-8
 // depositor's restoration bucket.
 type BagRestorer struct {
 	Base
-	tarPipeWriter         *TarPipeWriter
+	bagWriter             BagWriter
 	bestRestorationSource *common.PreservationBucket
+	currentReader         *SourceReader
+	pathFilter            *PathFilter
+	payloadSize           int64
+	payloadFileCount      int64
+	multipartWriter       *MultipartWriter
+	resumePoint           *service.ResumePoint
+	mark                  *service.ResumePoint
+	entryIndex            int
+	manifestSizes         map[string]int64
 	bytesWritten          int64
 	uploadError           error
 	wg                    sync.WaitGroup
@@ -61,35 +72,83 @@ func NewBagRestorer(context *common.Context, workItemID int64, restorationObject
 }
 
 // Run restores the entire bag to the depositor's restoration bucket.
+// For partial restorations, the bag includes only the payload files
+// that match the RestorationObject's path patterns, along with all of
+// the tag files. For historical restorations, the bag includes the
+// files that existed at RestorationObject.AsOf, as they were then.
+//
+// Tar files going to S3 and loose bags can be resumed. If an earlier
+// attempt was interrupted, we pick up at the last point it recorded in
+// RestorationObject.Progress, without reading the files before that
+// point from preservation storage again.
 func (r *BagRestorer) Run() (fileCount int, errors []*service.ProcessingError) {
-
-	// Yes, we do this at the beginning and end of each run.
-	// If bag restoration worker was shut down on an interrupt signal,
-	// old manifests might persist. Those will cause an invalid bag
-	// on the next restoration attempt.
-	r.DeleteStaleManifests()
-	defer r.DeleteStaleManifests()
+	defer func() { r.cleanUp(errors) }()
 
 	r.Context.Logger.Infof("Bag %s has profile %s (%s)", r.RestorationObject.Identifier, r.RestorationObject.BagItProfileIdentifier, r.RestorationObject.BagItProfile())
 
-	r.tarPipeWriter = NewTarPipeWriter()
+	if r.RestorationObject.RestorationType == constants.RestorationTypePartial || r.RestorationObject.AsOf != nil {
+		err := r.selectFiles()
+		if err != nil {
+			errors = append(errors, r.Error(r.RestorationObject.Identifier, err, true))
+			return fileCount, errors
+		}
+	}
+
+	err := r.InitTarget()
+	if err != nil {
+		errors = append(errors, r.Error(r.RestorationObject.Identifier, err, true))
+		return fileCount, errors
+	}
+
+	// If an earlier attempt uploaded the whole bag and failed after
+	// that, all that's left is the receipt.
+	if r.bagWasUploaded() {
+		return r.finish(r.RestorationObject.Progress.FileCount)
+	}
+
+	err = r.initProgress()
+	if err != nil {
+		errors = append(errors, r.Error(r.RestorationObject.Identifier, err, false))
+		return fileCount, errors
+	}
+
+	bagWriter, err := r.NewBagWriter()
+	if err != nil {
+		errors = append(errors, r.Error(r.RestorationObject.Identifier, err, true))
+		return fileCount, errors
+	}
+	r.bagWriter = bagWriter
 
-	r.initUploader()
+	// Tar files going to S3 go up in a resumable multipart upload.
+	// Loose bags upload each file as we go. Everything else streams
+	// through a pipe to a single upload.
+	if pipeWriter, ok := r.bagWriter.(PipeBagWriter); ok && r.multipartWriter == nil {
+		r.initUploader(pipeWriter.GetReader())
+	}
 
-	err := r.AddBagItFile()
+	err = r.AddBagItFile()
 	if err != nil {
 		errors = append(errors, r.Error(r.RestorationObject.Identifier, err, true))
+		r.cancelUpload(err)
 		return fileCount, errors
 	}
 
-	// Restore payload files and preserved tag files.
+	// Restore payload files and preserved tag files. If any of them
+	// failed, the bag is incomplete. If we can resume, leave it
+	// unfinished, so the next attempt can pick up where this one
+	// stopped. Otherwise, cancel the upload.
 	fileCount, errors = r.restoreAllPreservedFiles()
+	if len(errors) > 0 {
+		r.cancelUpload(fmt.Errorf("%d files could not be restored", len(errors)))
+		return fileCount, errors
+	}
 
 	// Add payload manifests before tag manifests, because we need to
 	// calculate checksums on the payload manifests.
 	manifestsAdded, procErr := r.AddManifests(constants.FileTypeManifest)
 	if procErr != nil {
 		errors = append(errors, procErr)
+		r.cancelUpload(procErr)
 		return fileCount, errors
 	}
 	fileCount += manifestsAdded
@@ -99,6 +158,7 @@ func (r *BagRestorer) Run() (fileCount int, errors []*service.ProcessingError) {
 	tagManifestsAdded, procErr := r.AddManifests(constants.FileTypeTagManifest)
 	if procErr != nil {
 		errors = append(errors, procErr)
+		r.cancelUpload(procErr)
 		return fileCount, errors
 	}
 	fileCount += tagManifestsAdded
@@ -107,24 +167,77 @@ func (r *BagRestorer) Run() (fileCount int, errors []*service.ProcessingError) {
 	// but added above in the call to r.AddBagItFile().
 	fileCount++
 
-	// Close the PipeWriter, or the PipeReader will hang forever.
-	r.tarPipeWriter.Finish()
+	err = r.finishUpload()
+	if err != nil {
+		errors = append(errors, r.Error(r.RestorationObject.Identifier, err, false))
+		return fileCount, errors
+	}
+	r.RestorationObject.Progress.Uploaded = true
+	r.RestorationObject.Progress.FileCount = fileCount
+	r.saveProgress()
+
+	return r.finish(fileCount)
+}
 
-	r.wg.Wait()
+// finish writes the receipt for the uploaded bag and marks the
+// restoration complete.
+func (r *BagRestorer) finish(fileCount int) (int, []*service.ProcessingError) {
+	errors := make([]*service.ProcessingError, 0)
+	err := r.writeReceipt()
+	if err != nil {
+		err = fmt.Errorf("Bag was restored, but its receipt could not be written: %v", err)
+		errors = append(errors, r.Error(r.RestorationObject.Identifier, err, false))
+		return fileCount, errors
+	}
+	r.RestorationObject.AllFilesRestored = true
+	r.RestorationObject.URL = r.Target.URL(r.RestoredKey())
+	return fileCount, errors
+}
 
-	if len(errors) == 0 {
-		r.RestorationObject.AllFilesRestored = true
-		r.RestorationObject.URL = fmt.Sprintf("%s%s/%s.tar", constants.AWSBucketPrefix, r.RestorationObject.RestorationTarget, r.RestorationObject.Identifier)
+// NewBagWriter returns a BagWriter for the RestorationObject's format.
+func (r *BagRestorer) NewBagWriter() (BagWriter, error) {
+	switch r.RestorationObject.Format() {
+	case constants.RestorationFormatTar:
+		var tarWriter *TarPipeWriter
+		if r.multipartWriter != nil {
+			tarWriter = NewTarStreamWriter(r.multipartWriter)
+		} else {
+			tarWriter = NewTarPipeWriter()
+		}
+		tarWriter.ModTime = r.startedAt()
+		return tarWriter, nil
+	case constants.RestorationFormatTarGz:
+		return NewTarGzPipeWriter(), nil
+	case constants.RestorationFormatZip:
+		return NewZipPipeWriter(), nil
+	case constants.RestorationFormatLoose:
+		objName, err := r.RestorationObject.ObjName()
+		if err != nil {
+			return nil, err
+		}
+		// Tar header names start with the object name, so this puts
+		// the bag at <identifier>/ in the restoration bucket.
+		prefix := strings.TrimSuffix(r.RestorationObject.Identifier, objName)
+		return NewLooseWriter(r.Target, prefix), nil
 	}
+	return nil, fmt.Errorf("Unknown restoration format '%s'. Valid formats are %s.", r.RestorationObject.RestorationFormat, strings.Join(constants.RestorationFormats, ", "))
+}
 
-	return fileCount, errors
+// RestoredKey returns the key of the restored bag in the restoration
+// target. For loose bags, this is the prefix under which the bag's
+// files are written.
+func (r *BagRestorer) RestoredKey() string {
+	if r.RestorationObject.Format() == constants.RestorationFormatLoose {
+		return r.RestorationObject.Identifier + "/"
+	}
+	return r.RestorationObject.Identifier + "." + r.RestorationObject.Format()
 }
 
-// initUploader opens a connection to the depositor's S3 restoration bucket
-// using the Minio client's PutObject method. The reader from which PutObject
-// copies data comes from the TarPipeWriter. Anything we write into that pipe
-// gets copied to the restoration bucket.
-func (r *BagRestorer) initUploader() {
+// initUploader starts copying the restored bag to the restoration
+// target, which is usually the depositor's S3 restoration bucket. The
+// data comes from reader, which is the reading end of the BagWriter's
+// pipe. Anything we write into that pipe gets copied to the target.
+func (r *BagRestorer) initUploader(reader *io.PipeReader) {
 	r.wg.Add(1)
 
 	estimatedObjectSize := float64(r.RestorationObject.ObjectSize) * float64(1.10)
@@ -135,15 +248,9 @@ func (r *BagRestorer) initUploader() {
 		estimatedObjectSize, chunkSize)
 
 	go func() {
-		s3Client := r.Context.S3Clients[constants.StorageProviderAWS]
-
 		// NOTE: For debugging complex issues with the S3 client,
-		// uncommenting the TraceOn line in INCREDIBLY useful.
-		// Just don't do this in production, if you can help it,
-		// because it will output a ton of info to STDOUT for every
-		// file we upload.
-		//
-		// s3Client.TraceOn(nil)
+		// uncommenting the TraceOn line in S3Target.PutObject is
+		// INCREDIBLY useful.
 
 		defer func() {
 			if rec := recover(); rec != nil {
@@ -160,34 +267,93 @@ func (r *BagRestorer) initUploader() {
 		// impossible for us to predict the exact size of the
 		// restored bag because sizes of tag files and manifests
 		// vary.
-		//
-		// When size is -1, Minio SDK uses multipart upload.
-		// AutoChecksum causes issues with multipart uploads of
-		// unknown size because the SDK sends FULL_OBJECT checksum
-		// type in CompleteMultipartUpload with a part checksum,
-		// which doesn't match the server's computation.
-		// So we disable AutoChecksum for streaming uploads.
-		putOpts := minio.PutObjectOptions{}
-		var uploadInfo minio.UploadInfo
-		uploadInfo, r.uploadError = s3Client.PutObject(
-			ctx.Background(),
-			r.RestorationObject.RestorationTarget,
-			r.RestorationObject.Identifier+".tar",
-			r.tarPipeWriter.GetReader(),
-			-1,
-			putOpts,
-		)
-		r.bytesWritten = uploadInfo.Size
-		r.Context.Logger.Infof("Finished uploading tar file %s", r.RestorationObject.Identifier)
+		r.bytesWritten, r.uploadError = r.Target.PutObject(r.RestoredKey(), reader, -1, nil)
+		if r.uploadError != nil {
+			// Unblock the BagWriter, which would otherwise wait
+			// forever for someone to read what it's writing.
+			reader.CloseWithError(r.uploadError)
+		}
+		r.Context.Logger.Infof("Finished uploading %s", r.RestoredKey())
 		r.wg.Done()
 	}()
-	r.Context.Logger.Infof("Initialized uploader for %s going to %s", r.RestorationObject.Identifier, r.RestorationObject.RestorationTarget)
+	r.Context.Logger.Infof("Initialized uploader for %s going to %s", r.RestorationObject.Identifier, r.Target.URL(r.RestoredKey()))
+}
+
+// selectFiles works out which files go into a partial or historical
+// restoration, and tallies the size and count of the selected payload
+// files for the restored bag's Payload-Oxum. It returns an error if the
+// patterns are invalid or no payload files are selected.
+func (r *BagRestorer) selectFiles() error {
+	filter, err := NewPathFilter(r.RestorationObject.IncludePatterns, r.RestorationObject.ExcludePatterns)
+	if err != nil {
+		return err
+	}
+	r.pathFilter = filter
+	r.payloadSize = 0
+	r.payloadFileCount = 0
+	totalSize := int64(0)
+	pageNumber := 1
+	for {
+		files, err := GetBatchOfFiles(r.Context, r.RestorationObject.ItemID, pageNumber)
+		if err != nil {
+			return err
+		}
+		for _, gf := range files {
+			selected, err := r.selectFile(gf)
+			if err != nil {
+				return err
+			}
+			if selected == nil {
+				continue
+			}
+			pathInBag, _ := selected.PathInBag()
+			totalSize += selected.Size
+			if IsPayload(pathInBag) {
+				r.payloadSize += selected.Size
+				r.payloadFileCount++
+			}
+		}
+		if len(files) == 0 {
+			break
+		}
+		pageNumber++
+	}
+	if r.payloadFileCount == 0 {
+		if r.RestorationObject.AsOf != nil {
+			return fmt.Errorf("No payload files in %s as of %s match include patterns %v and exclude patterns %v", r.RestorationObject.Identifier, r.RestorationObject.AsOf.Format(time.RFC3339), filter.Include, filter.Exclude)
+		}
+		return fmt.Errorf("No payload files in %s match include patterns %v and exclude patterns %v", r.RestorationObject.Identifier, filter.Include, filter.Exclude)
+	}
+	// The uploader uses this to estimate chunk size.
+	r.RestorationObject.ObjectSize = totalSize
+	r.Context.Logger.Infof("Restoration of %s includes %d payload files (%d bytes)", r.RestorationObject.Identifier, r.payloadFileCount, r.payloadSize)
+	return nil
+}
+
+// selectFile returns the file to restore in place of gf, or nil if gf
+// doesn't belong in the restored bag. For historical restorations, the
+// file returned describes gf as it was at RestorationObject.AsOf.
+func (r *BagRestorer) selectFile(gf *registry.GenericFile) (*registry.GenericFile, error) {
+	pathInBag, err := gf.PathInBag()
+	if err != nil {
+		return nil, err
+	}
+	if r.pathFilter != nil && !r.pathFilter.Matches(pathInBag) {
+		return nil, nil
+	}
+	if r.RestorationObject.AsOf == nil {
+		return gf, nil
+	}
+	if !ExistedAt(gf, *r.RestorationObject.AsOf) {
+		return nil, nil
+	}
+	return r.FileAsOf(gf)
 }
 
 // restoreAllPreservedFiles restores all files from the preservation bucket
 // to the restoration bucket in the form of a tar archive.
 func (r *BagRestorer) restoreAllPreservedFiles() (fileCount int, errors []*service.ProcessingError) {
-	isObjectRestoration := r.RestorationObject.RestorationType == constants.RestorationTypeObject
+	isObjectRestoration := r.RestorationObject.IsBagRestoration()
 	fileCount = 0
 	pageNumber := 1
 	for {
@@ -198,7 +364,26 @@ func (r *BagRestorer) restoreAllPreservedFiles() (fileCount int, errors []*servi
 		}
 		for _, gf := range files {
 			var digests map[string]string
+			selected, err := r.selectFile(gf)
+			if err != nil {
+				errors = append(errors, r.Error(gf.Identifier, err, false))
+				return fileCount, errors
+			}
+			if selected == nil {
+				continue
+			}
+			gf = selected
 			filename, _ := gf.PathInBag()
+			shouldRestore, err := r.startEntry(gf.Identifier, r.GetTarHeader(gf).Name)
+			if err != nil {
+				errors = append(errors, r.Error(gf.Identifier, err, false))
+				return fileCount, errors
+			}
+			if !shouldRestore {
+				// Restored on an earlier attempt.
+				fileCount++
+				continue
+			}
 			if isObjectRestoration && filename == "bag-info.txt" {
 				digests, err = r.RewriteBagInfo(gf)
 				//
@@ -219,7 +404,9 @@ func (r *BagRestorer) restoreAllPreservedFiles() (fileCount int, errors []*servi
 			}
 			if err != nil {
 				r.Context.Logger.Errorf("Error adding %s: %v", gf.Identifier, err)
-				errors = append(errors, r.Error(gf.Identifier, err, true))
+				// If the remaining copies are in Glacier, this isn't
+				// fatal. The worker will hand off to Glacier restoration.
+				errors = append(errors, r.Error(gf.Identifier, err, !r.RestorationObject.NeedsGlacierRestore))
 				return fileCount, errors
 			} else {
 				r.Context.Logger.Infof("Added %s", gf.Identifier)
@@ -230,7 +417,7 @@ func (r *BagRestorer) restoreAllPreservedFiles() (fileCount int, errors []*servi
 				err = r.RecordDigests(gf, digests)
 				if err != nil {
 					r.Context.Logger.Errorf("Error recording digests for %s: %v", gf.Identifier, err)
-					errors = append(errors, r.Error(gf.Identifier, err, true))
+					errors = append(errors, r.digestError(gf, err))
 					return fileCount, errors
 				}
 			}
@@ -252,6 +439,7 @@ func (r *BagRestorer) restoreAllPreservedFiles() (fileCount int, errors []*servi
 // megabytes, but these are rare.
 func (r *BagRestorer) RecordDigests(gf *registry.GenericFile, digests map[string]string) error {
 	atLeastOneChecksumVerified := false
+	registryChecksums := make(map[string]string)
 	for _, alg := range constants.SupportedManifestAlgorithms {
 		digest := digests[alg]
 		// The checksums in the digests map include only those algorithms required
@@ -263,7 +451,12 @@ func (r *BagRestorer) RecordDigests(gf *registry.GenericFile, digests map[string
 		}
 		registryChecksum := gf.GetLatestChecksum(alg)
 		if registryChecksum != nil && digest != registryChecksum.Digest {
-			return fmt.Errorf("%s digest mismatch for %s. Registry says %s, S3 file has %s", alg, gf.Identifier, registryChecksum.Digest, digest)
+			return &DigestMismatchError{
+				message: fmt.Sprintf("%s digest mismatch for %s. Registry says %s, S3 file has %s", alg, gf.Identifier, registryChecksum.Digest, digest),
+			}
+		}
+		if registryChecksum != nil {
+			registryChecksums[alg] = registryChecksum.Digest
 		}
 		atLeastOneChecksumVerified = true
 		err := r.AppendDigestToManifest(gf, digest, alg)
@@ -274,7 +467,43 @@ func (r *BagRestorer) RecordDigests(gf *registry.GenericFile, digests map[string
 	if !atLeastOneChecksumVerified {
 		return fmt.Errorf("BagRestorer.RecordDigests was not able to verify any checksums for %s", gf.Identifier)
 	}
-	return nil
+	return r.addToReceipt(r.GetTarHeader(gf), gf, digests, registryChecksums)
+}
+
+// DigestMismatchError means the digest of a file we restored did not
+// match the Registry checksum.
+type DigestMismatchError struct {
+	message string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return e.message
+}
+
+// digestError returns a ProcessingError for a file whose digests
+// could not be recorded. If the problem was a digest mismatch, we
+// note that the copies that served the file are bad. That's usually
+// one copy, but if the reader failed over partway through, any of the
+// copies it read from could be to blame, so we mark them all. We can't
+// take back what we've already written to the tar file, but if there's
+// another copy to try, the error is not fatal, and the next attempt at
+// this restoration will skip the bad copies.
+func (r *BagRestorer) digestError(gf *registry.GenericFile, err error) *service.ProcessingError {
+	var mismatch *DigestMismatchError
+	if !errors.As(err, &mismatch) || r.currentReader == nil || len(r.currentReader.Served()) == 0 {
+		return r.Error(gf.Identifier, err, true)
+	}
+	r.discardCorruptProgress()
+	for _, source := range r.currentReader.Served() {
+		r.RestorationObject.AddFailedSource(source.StorageRecord.URL)
+		r.RestorationObject.AddFailover(gf.Identifier, source.StorageRecord.URL, err)
+	}
+	remaining := r.Candidates(gf)
+	if len(remaining) == 0 {
+		return r.Error(gf.Identifier, err, true)
+	}
+	err = fmt.Errorf("%v. Will retry from %s.", err, remaining[0].StorageRecord.URL)
+	return r.Error(gf.Identifier, err, false)
 }
 
 // AppendDigestToManifest adds the given digest (checksum) for the
@@ -303,7 +532,11 @@ func (r *BagRestorer) AppendDigestToManifest(gf *registry.GenericFile, digest, a
 		return err
 	}
 	defer file.Close()
-	_, err = fmt.Fprintf(file, "%s  %s\n", digest, pathInBag)
+	n, err := fmt.Fprintf(file, "%s  %s\n", digest, pathInBag)
+	if r.manifestSizes == nil {
+		r.manifestSizes = make(map[string]int64)
+	}
+	r.manifestSizes[manifestPath] += int64(n)
 	return err
 }
 
@@ -329,6 +562,7 @@ func (r *BagRestorer) DeleteStaleManifests() error {
 			os.Remove(manifestFile)
 		}
 	}
+	os.Remove(r.GetReceiptJournalPath())
 	return nil
 }
 
@@ -337,7 +571,7 @@ func (r *BagRestorer) GetTarHeader(gf *registry.GenericFile) *tar.Header {
 	pathMinusInstitution, _ := gf.PathMinusInstitution()
 	modTime := gf.ModTime
 	if modTime.IsZero() {
-		modTime = time.Now().UTC()
+		modTime = r.startedAt()
 	}
 	return &tar.Header{
 		Name:     pathMinusInstitution,
@@ -351,7 +585,7 @@ func (r *BagRestorer) GetTarHeader(gf *registry.GenericFile) *tar.Header {
 
 // AddBagItFile adds the bagit.txt file to the tar file.
 func (r *BagRestorer) AddBagItFile() error {
-	// Add header and file data to tarPipeWriter
+	// Add header and file data to bagWriter
 	objName, err := r.RestorationObject.ObjName()
 	if err != nil {
 		return err
@@ -361,11 +595,19 @@ func (r *BagRestorer) AddBagItFile() error {
 		Size:     int64(len(bagitTxt)),
 		Typeflag: tar.TypeReg,
 		Mode:     int64(0755),
-		ModTime:  time.Now().UTC(),
+		ModTime:  r.startedAt(),
 		Format:   tar.FormatPAX,
 	}
+	shouldRestore, err := r.startEntry("bagit.txt", tarHeader.Name)
+	if !shouldRestore {
+		return err
+	}
 
-	digests, err := r.tarPipeWriter.AddFile(tarHeader, strings.NewReader(bagitTxt), r.RestorationObject.ManifestAlgorithms())
+	digests, err := r.bagWriter.AddFile(tarHeader, strings.NewReader(bagitTxt), r.RestorationObject.ManifestAlgorithms())
+	if err != nil {
+		return err
+	}
+	err = r.addToReceipt(tarHeader, nil, digests, nil)
 	if err != nil {
 		return err
 	}
@@ -402,6 +644,10 @@ func (r *BagRestorer) _addManifest(manifestFile, manifestType string) error {
 		return err
 	}
 	manifestName := path.Base(manifestFile)
+	shouldRestore, err := r.startEntry(manifestName, fmt.Sprintf("%s/%s", objName, manifestName))
+	if !shouldRestore {
+		return err
+	}
 	r.Context.Logger.Info("Adding %s from %s", manifestName, manifestFile)
 	fileInfo, err := os.Stat(manifestFile)
 	if err != nil {
@@ -418,18 +664,17 @@ func (r *BagRestorer) _addManifest(manifestFile, manifestType string) error {
 		Size:     fileInfo.Size(),
 		Typeflag: tar.TypeReg,
 		Mode:     int64(0755),
-		ModTime:  fileInfo.ModTime(),
+		ModTime:  r.startedAt(),
 		Format:   tar.FormatPAX,
 	}
 
-	// Calculate digests on manifests, but not on tag manifests.
-	// Tag manifest files will contain digests of manifest files.
-	algs := make([]string, 0)
-	if manifestType == constants.FileTypeManifest {
-		algs = r.RestorationObject.ManifestAlgorithms()
+	// Tag manifests will contain digests of payload manifests. We
+	// calculate digests of tag manifests only for the receipt.
+	digests, err := r.bagWriter.AddFile(tarHeader, file, r.RestorationObject.ManifestAlgorithms())
+	if err != nil {
+		return err
 	}
-
-	digests, err := r.tarPipeWriter.AddFile(tarHeader, file, algs)
+	err = r.addToReceipt(tarHeader, nil, digests, nil)
 	if err != nil {
 		return err
 	}
@@ -451,17 +696,17 @@ func (r *BagRestorer) _addManifest(manifestFile, manifestType string) error {
 	return nil
 }
 
-// AddToTarFile adds a GenericFile to the TarPipeWriter. The contents
-// go through the TarPipeWriter to restoration bucket.
+// AddToTarFile adds a GenericFile to the BagWriter. The contents
+// go through the BagWriter to restoration bucket.
 func (r *BagRestorer) AddToTarFile(gf *registry.GenericFile) (digests map[string]string, err error) {
 	obj, digests, err := r.getS3Object(gf)
 	if err != nil {
 		return digests, err
 	}
 	defer obj.Close()
-	// Add header and file data to tarPipeWriter
+	// Add header and file data to bagWriter
 	tarHeader := r.GetTarHeader(gf)
-	return r.tarPipeWriter.AddFile(tarHeader, obj, r.RestorationObject.ManifestAlgorithms())
+	return r.bagWriter.AddFile(tarHeader, obj, r.RestorationObject.ManifestAlgorithms())
 }
 
 // RewriteBagInfo updates this bag's bag-info.txt file. Depositors want
@@ -509,12 +754,16 @@ func (r *BagRestorer) RewriteBagInfo(gf *registry.GenericFile) (digests map[stri
 		return digests, fmt.Errorf("error during bag-info.txt rewrite: %v", resp.Error)
 	}
 	intelObj := resp.IntellectualObject()
-	newTags := RewriteTags(tags, intelObj.PayloadSize, intelObj.PayloadFileCount)
+	payloadSize, payloadFileCount := intelObj.PayloadSize, intelObj.PayloadFileCount
+	if r.pathFilter != nil {
+		payloadSize, payloadFileCount = r.payloadSize, r.payloadFileCount
+	}
+	newTags := RewriteTagsAt(tags, payloadSize, payloadFileCount, r.startedAt())
 
 	// Write tags out to string or buffer that supports Read() and Seek()
 	readSeeker, byteCount := TagsToReadSeeker(newTags)
 
-	// Now add it and the header to tarPipeWriter. We have to alter
+	// Now add it and the header to bagWriter. We have to alter
 	// gf.Size before we get the tar header, because our rewritten tag
 	// file is longer than the original. And be sure to reset gf.Size
 	// afterward, so we don't accidentally persist incorrect data to
@@ -523,10 +772,22 @@ func (r *BagRestorer) RewriteBagInfo(gf *registry.GenericFile) (digests map[stri
 	gf.Size = byteCount
 	defer func() { gf.Size = oldSize }()
 	tarHeader := r.GetTarHeader(gf)
-	return r.tarPipeWriter.AddFile(tarHeader, readSeeker, r.RestorationObject.ManifestAlgorithms())
+	digests, err = r.bagWriter.AddFile(tarHeader, readSeeker, r.RestorationObject.ManifestAlgorithms())
+	if err != nil {
+		return digests, err
+	}
+	return digests, r.addToReceipt(tarHeader, gf, digests, nil)
 }
 
+// RewriteTags rewrites bag-info.txt tags for a restored bag. See
+// RewriteBagInfo.
 func RewriteTags(tags []*bagit.Tag, objSize, fileCount int64) []*bagit.Tag {
+	return RewriteTagsAt(tags, objSize, fileCount, time.Now().UTC())
+}
+
+// RewriteTagsAt is like RewriteTags, with baggingDate as the new
+// Bagging-Date.
+func RewriteTagsAt(tags []*bagit.Tag, objSize, fileCount int64, baggingDate time.Time) []*bagit.Tag {
 	oxum := fmt.Sprintf("%d.%d", objSize, fileCount)
 	originalTags := make([]*bagit.Tag, 0)
 	for _, tag := range tags {
@@ -535,7 +796,7 @@ func RewriteTags(tags []*bagit.Tag, objSize, fileCount int64) []*bagit.Tag {
 			tag.Value = oxum
 		} else if tag.TagName == "Bagging-Date" {
 			originalTags = append(originalTags, bagit.NewTag("bag-info.txt", "Original-Bagging-Date", tag.Value))
-			tag.Value = time.Now().UTC().Format(time.RFC3339)
+			tag.Value = baggingDate.Format(time.RFC3339)
 		} else if tag.TagName == "Bag-Size" {
 			originalTags = append(originalTags, bagit.NewTag("bag-info.txt", "Original-Bag-Size", tag.Value))
 			tag.Value = util.ToHumanSize(objSize)
@@ -559,16 +820,18 @@ func TagsToReadSeeker(tags []*bagit.Tag) (io.ReadSeeker, int64) {
 	return strings.NewReader(serializedTags), int64(len([]byte(serializedTags)))
 }
 
-// getS3Object returns a *minio.Object and digest map for the specified
-// GenericFile, so we can stream it to wherever it needs to go.
-func (r *BagRestorer) getS3Object(gf *registry.GenericFile) (obj *minio.Object, digests map[string]string, err error) {
+// getS3Object returns a reader and digest map for the specified
+// GenericFile, so we can stream it to wherever it needs to go. The
+// reader fails over to the next preservation copy if the current one
+// can't be read.
+func (r *BagRestorer) getS3Object(gf *registry.GenericFile) (reader *SourceReader, digests map[string]string, err error) {
 	digests = make(map[string]string)
-	b, _, err := BestRestorationSource(r.Context, gf)
+	r.currentReader = nil
+	reader, err = r.NewSourceReader(gf)
 	if err != nil {
 		return nil, digests, err
 	}
-	r.Context.Logger.Infof("Getting %s from %s with UUID %s", gf.Identifier, b.Bucket, gf.UUID)
-	client := r.Context.S3Clients[b.Bucket]
-	obj, err = client.GetObject(ctx.Background(), b.Bucket, gf.UUID, minio.GetObjectOptions{})
-	return obj, digests, err
+	r.currentReader = reader
+	r.Context.Logger.Infof("Getting %s from %s with UUID %s", gf.Identifier, reader.Source.Bucket.Bucket, gf.UUID)
+	return reader, digests, err
 }
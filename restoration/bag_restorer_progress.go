@@ -0,0 +1,348 @@
+package restoration
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/util"
+)
+
+// This file contains the parts of BagRestorer that let us resume an
+// interrupted restoration.
+//
+// We think of a bag as a sequence of entries: bagit.txt, then each
+// preserved file, then the manifests and tag manifests. Before we
+// write each entry, we note where it starts (a ResumePoint). Once
+// everything before that point is safely in the restoration target,
+// we save the point in RestorationObject.Progress. For tar files, that
+// happens when a multipart upload part completes. For loose bags, it
+// happens as soon as the previous file is uploaded.
+//
+// To resume, we truncate the local manifests to their sizes at the
+// resume point, skip the entries before it, and regenerate the rest.
+// The multipart writer discards regenerated bytes that S3 already has.
+// This works because the tar stream is deterministic: all timestamps
+// come from Progress.StartedAt, and the files themselves don't change.
+
+// initProgress sets up progress tracking for this restoration. If an
+// earlier attempt left a resume point we can use, we'll resume from
+// there. Otherwise, we clear out anything left over from earlier
+// attempts and start from the beginning.
+func (r *BagRestorer) initProgress() error {
+	resume, reason := r.findResumePoint()
+	if resume == nil {
+		if r.RestorationObject.Progress != nil {
+			r.Context.Logger.Infof("Restoring %s from the beginning: %s", r.RestorationObject.Identifier, reason)
+			r.abortUpload()
+		}
+		// If bag restoration worker was shut down on an interrupt signal,
+		// old manifests might persist. Those will cause an invalid bag
+		// on the next restoration attempt.
+		r.DeleteStaleManifests()
+		r.RestorationObject.Progress = &service.RestorationProgress{
+			StartedAt: time.Now().UTC().Truncate(time.Second),
+			Key:       r.RestoredKey(),
+			Format:    r.RestorationObject.Format(),
+		}
+	} else {
+		r.Context.Logger.Infof("Resuming restoration of %s at %s (entry %d)", r.RestorationObject.Identifier, resume.Identifier, resume.EntryIndex)
+	}
+	r.resumePoint = resume
+	r.entryIndex = 0
+	r.manifestSizes = make(map[string]int64)
+	if resume != nil {
+		for manifestPath, size := range resume.ManifestSizes {
+			r.manifestSizes[manifestPath] = size
+		}
+	}
+
+	s3Target, ok := r.Target.(*S3Target)
+	if !ok || r.RestorationObject.Format() != constants.RestorationFormatTar {
+		return nil
+	}
+	estimatedObjectSize := float64(r.RestorationObject.ObjectSize) * float64(1.10)
+	partSize := int64(util.EstimatedChunkSize(estimatedObjectSize))
+	writer, err := NewMultipartWriter(s3Target, r.RestorationObject.Progress, partSize)
+	if err != nil {
+		return err
+	}
+	if resume != nil {
+		if err = writer.ResumeAt(resume.Offset); err != nil {
+			return err
+		}
+	}
+	writer.OnPart = r.checkpoint
+	r.multipartWriter = writer
+	return nil
+}
+
+// bagWasUploaded returns true if an earlier attempt uploaded this bag
+// to the restoration target and left the receipt journal we need to
+// write its receipt. We check that the bag is still there, in case
+// someone removed it in the meantime.
+func (r *BagRestorer) bagWasUploaded() bool {
+	progress := r.RestorationObject.Progress
+	if progress == nil || !progress.Uploaded {
+		return false
+	}
+	if progress.Key != r.RestoredKey() || progress.Format != r.RestorationObject.Format() {
+		return false
+	}
+	if _, err := os.Stat(r.GetReceiptJournalPath()); err != nil {
+		r.Context.Logger.Infof("Bag %s was uploaded, but its receipt journal is gone, so we'll restore it again: %v", r.RestorationObject.Identifier, err)
+		return false
+	}
+	// Loose bags have no single object, so check for the first file
+	// we wrote.
+	key := r.RestoredKey()
+	if r.RestorationObject.Format() == constants.RestorationFormatLoose {
+		key += "bagit.txt"
+	}
+	exists, err := r.Target.Exists(key)
+	if err != nil || !exists {
+		r.Context.Logger.Infof("Bag %s was uploaded, but %s is not in the restoration target, so we'll restore it again (error: %v)", r.RestorationObject.Identifier, r.Target.URL(key), err)
+		return false
+	}
+	r.Context.Logger.Infof("Bag %s was uploaded on an earlier attempt. Writing its receipt.", r.RestorationObject.Identifier)
+	return true
+}
+
+// findResumePoint returns the point from which we can resume an earlier
+// attempt at this restoration. If we can't resume, it returns nil and
+// the reason why.
+func (r *BagRestorer) findResumePoint() (*service.ResumePoint, string) {
+	progress := r.RestorationObject.Progress
+	if progress == nil {
+		return nil, "no earlier attempt"
+	}
+	if progress.ResumePoint == nil {
+		return nil, "earlier attempt did not record a resume point"
+	}
+	if progress.Key != r.RestoredKey() || progress.Format != r.RestorationObject.Format() {
+		return nil, "restoration format or target changed"
+	}
+	switch r.RestorationObject.Format() {
+	case constants.RestorationFormatLoose:
+	case constants.RestorationFormatTar:
+		s3Target, ok := r.Target.(*S3Target)
+		if !ok || progress.UploadID == "" {
+			return nil, "restoration target does not support multipart upload"
+		}
+		writer, err := NewMultipartWriter(s3Target, progress, progress.PartSize)
+		if err != nil {
+			return nil, err.Error()
+		}
+		if err = writer.Verify(); err != nil {
+			return nil, fmt.Sprintf("cannot continue multipart upload: %v", err)
+		}
+	default:
+		return nil, fmt.Sprintf("%s restorations cannot be resumed", r.RestorationObject.Format())
+	}
+	if err := r.truncateManifests(progress.ResumePoint.ManifestSizes); err != nil {
+		return nil, err.Error()
+	}
+	return progress.ResumePoint, ""
+}
+
+// truncateManifests truncates the manifests, tag manifests and receipt
+// journal on local disk to the sizes they were at the resume point,
+// discarding entries for files we'll restore again.
+func (r *BagRestorer) truncateManifests(sizes map[string]int64) error {
+	paths := []string{r.GetReceiptJournalPath()}
+	for _, alg := range constants.SupportedManifestAlgorithms {
+		for _, fileType := range constants.ManifestTypes {
+			paths = append(paths, r.GetManifestPath(alg, fileType))
+		}
+	}
+	for _, manifestPath := range paths {
+		size := sizes[manifestPath]
+		info, err := os.Stat(manifestPath)
+		if os.IsNotExist(err) && size == 0 {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("manifest from earlier attempt is not available: %v", err)
+		}
+		if info.Size() < size {
+			return fmt.Errorf("manifest %s has %d bytes, expected at least %d", manifestPath, info.Size(), size)
+		}
+		if err = os.Truncate(manifestPath, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startEntry is called before we add each entry to the bag. It returns
+// false if an earlier attempt already restored the entry, in which case
+// the caller should skip it. Param tarName is the entry's name in the
+// bag, as in its tar header.
+func (r *BagRestorer) startEntry(identifier, tarName string) (bool, error) {
+	index := r.entryIndex
+	r.entryIndex++
+	tarWriter, isTar := r.bagWriter.(*TarPipeWriter)
+	if r.resumePoint != nil {
+		if index < r.resumePoint.EntryIndex {
+			if isTar {
+				tarWriter.SkipDirectoryEntry(tarName)
+			}
+			return false, nil
+		}
+		if index == r.resumePoint.EntryIndex && identifier != r.resumePoint.Identifier {
+			r.mark = nil
+			r.RestorationObject.Progress.ResumePoint = nil
+			return false, fmt.Errorf("Cannot resume restoration at entry %d: expected %s, found %s. The object's files may have changed since the last attempt, which will have to start over.", index, r.resumePoint.Identifier, identifier)
+		}
+	}
+	offset := int64(0)
+	if r.multipartWriter != nil {
+		// Write the padding at the end of the last entry, so this
+		// entry begins at a clean offset.
+		if err := tarWriter.Flush(); err != nil {
+			return false, err
+		}
+		offset = r.multipartWriter.Offset()
+	}
+	sizes := make(map[string]int64, len(r.manifestSizes))
+	for manifestPath, size := range r.manifestSizes {
+		sizes[manifestPath] = size
+	}
+	r.mark = &service.ResumePoint{
+		EntryIndex:    index,
+		Identifier:    identifier,
+		Offset:        offset,
+		ManifestSizes: sizes,
+	}
+	// Every file before this one in a loose bag is already uploaded.
+	if r.RestorationObject.Format() == constants.RestorationFormatLoose {
+		r.checkpoint()
+	}
+	return true, nil
+}
+
+// checkpoint records the start of the current entry as the place to
+// resume if this attempt is interrupted.
+func (r *BagRestorer) checkpoint() {
+	if r.mark == nil || r.RestorationObject.Progress == nil {
+		return
+	}
+	r.RestorationObject.Progress.ResumePoint = r.mark
+	r.saveProgress()
+}
+
+// saveProgress saves the RestorationObject, with its progress, to
+// Redis. If this fails, we may have to redo more work if we're
+// interrupted, but that's not a reason to stop.
+func (r *BagRestorer) saveProgress() {
+	if r.Context.RedisClient == nil {
+		return
+	}
+	err := r.Context.RedisClient.RestorationObjectSave(r.WorkItemID, r.RestorationObject)
+	if err != nil {
+		r.Context.Logger.Warningf("Could not save restoration progress for %s: %v", r.RestorationObject.Identifier, err)
+	}
+}
+
+// discardCorruptProgress is called when the entry we just wrote turns
+// out to be corrupt. If any of it has already been uploaded as part of
+// a multipart upload, we can't resume from the start of the entry,
+// because S3 has the bad bytes. The next attempt will have to start
+// over.
+func (r *BagRestorer) discardCorruptProgress() {
+	progress := r.RestorationObject.Progress
+	if r.multipartWriter == nil || r.mark == nil || progress == nil {
+		return
+	}
+	if progress.UploadedBytes() > r.mark.Offset {
+		r.mark = nil
+		progress.ResumePoint = nil
+	}
+}
+
+// isResumable returns true if this restoration can be resumed.
+func (r *BagRestorer) isResumable() bool {
+	return r.multipartWriter != nil || r.RestorationObject.Format() == constants.RestorationFormatLoose
+}
+
+// startedAt returns the time this restoration started, which we use
+// for timestamps in the bag.
+func (r *BagRestorer) startedAt() time.Time {
+	if r.RestorationObject.Progress != nil {
+		return r.RestorationObject.Progress.StartedAt
+	}
+	return time.Now().UTC()
+}
+
+// finishUpload finishes writing the bag and waits for the upload to
+// complete.
+func (r *BagRestorer) finishUpload() error {
+	if r.multipartWriter != nil {
+		if err := r.bagWriter.(*TarPipeWriter).Close(); err != nil {
+			return err
+		}
+		size, err := r.multipartWriter.Complete()
+		r.bytesWritten = size
+		return err
+	}
+	// Close the PipeWriter, or the PipeReader will hang forever.
+	r.bagWriter.Finish()
+	r.wg.Wait()
+	return r.uploadError
+}
+
+// cancelUpload stops a streaming upload of an incomplete bag without
+// completing it, so the bag never appears in the restoration target,
+// and waits for the uploader to give up. Resumable uploads are left
+// alone for the next attempt.
+func (r *BagRestorer) cancelUpload(err error) {
+	pipeWriter, ok := r.bagWriter.(PipeBagWriter)
+	if !ok || r.multipartWriter != nil {
+		return
+	}
+	pipeWriter.GetReader().CloseWithError(err)
+	r.wg.Wait()
+}
+
+// abortUpload aborts the multipart upload from an earlier attempt, if
+// there was one, so S3 doesn't keep its parts.
+func (r *BagRestorer) abortUpload() {
+	progress := r.RestorationObject.Progress
+	s3Target, ok := r.Target.(*S3Target)
+	if progress == nil || progress.UploadID == "" || !ok {
+		return
+	}
+	writer, err := NewMultipartWriter(s3Target, progress, progress.PartSize)
+	if err == nil {
+		err = writer.Abort()
+	}
+	if err != nil {
+		r.Context.Logger.Warningf("Could not abort multipart upload %s for %s: %v", progress.UploadID, r.RestorationObject.Identifier, err)
+	}
+}
+
+// cleanUp deletes local manifests and progress once we're done with
+// this restoration, either because it succeeded, or because it failed
+// in a way that retrying won't fix. After a transient error, we keep
+// them so the next attempt can resume.
+func (r *BagRestorer) cleanUp(errors []*service.ProcessingError) {
+	isFatal := false
+	for _, procErr := range errors {
+		isFatal = isFatal || procErr.IsFatal
+	}
+	// Glacier restorations come back to us as a new WorkItem, which
+	// won't find this one's progress.
+	done := len(errors) == 0 || isFatal || r.RestorationObject.NeedsGlacierRestore
+	uploaded := r.RestorationObject.Progress != nil && r.RestorationObject.Progress.Uploaded
+	if !done && (r.isResumable() || uploaded) {
+		r.saveProgress()
+		return
+	}
+	if len(errors) > 0 {
+		r.abortUpload()
+	}
+	r.DeleteStaleManifests()
+	r.RestorationObject.Progress = nil
+}
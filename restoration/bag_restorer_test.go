@@ -0,0 +1,152 @@
+package restoration_test
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/bagit"
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/restoration"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBagRestorerFormats(t *testing.T) {
+	context := &common.Context{
+		Config: common.NewConfig(),
+		Logger: logger.DiscardLogger("bag_restorer_test"),
+	}
+	restObj := &service.RestorationObject{
+		Identifier:        "test.edu/bag",
+		RestorationTarget: "restore-bucket",
+		RestorationType:   constants.RestorationTypeObject,
+	}
+	restorer := restoration.NewBagRestorer(context, 1234, restObj)
+
+	expected := map[string]string{
+		"":                               "test.edu/bag.tar",
+		constants.RestorationFormatTar:   "test.edu/bag.tar",
+		constants.RestorationFormatTarGz: "test.edu/bag.tar.gz",
+		constants.RestorationFormatZip:   "test.edu/bag.zip",
+		constants.RestorationFormatLoose: "test.edu/bag/",
+	}
+	for format, key := range expected {
+		restObj.RestorationFormat = format
+		assert.Equal(t, key, restorer.RestoredKey(), format)
+		writer, err := restorer.NewBagWriter()
+		require.Nil(t, err, format)
+		require.NotNil(t, writer, format)
+		_, isPipe := writer.(restoration.PipeBagWriter)
+		assert.Equal(t, format != constants.RestorationFormatLoose, isPipe, format)
+	}
+
+	restObj.RestorationFormat = "rar"
+	_, err := restorer.NewBagWriter()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Unknown restoration format 'rar'")
+}
+
+func TestRewriteTagsAt(t *testing.T) {
+	tags := []*bagit.Tag{
+		bagit.NewTag("bag-info.txt", "Payload-Oxum", "1234.5"),
+		bagit.NewTag("bag-info.txt", "Bagging-Date", "2022-04-26"),
+	}
+	baggingDate := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	newTags := restoration.RewriteTagsAt(tags, 999, 3, baggingDate)
+	readSeeker, _ := restoration.TagsToReadSeeker(newTags)
+	data, err := io.ReadAll(readSeeker)
+	require.Nil(t, err)
+	assert.Equal(t, "Payload-Oxum: 999.3\nBagging-Date: 2026-03-04T05:06:07Z\nOriginal-Payload-Oxum: 1234.5\nOriginal-Bagging-Date: 2022-04-26", string(data))
+}
+
+// bagRegistry serves a single page of GenericFiles. Later pages are
+// empty.
+type bagRegistry struct {
+	files []*registry.GenericFile
+}
+
+func (r *bagRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.HasSuffix(req.URL.Path, "/files") {
+		http.NotFound(w, req)
+		return
+	}
+	files := r.files
+	if req.URL.Query().Get("page") != "1" {
+		files = []*registry.GenericFile{}
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"count":    len(r.files),
+		"next":     nil,
+		"previous": nil,
+		"results":  files,
+	})
+	w.Write(data)
+}
+
+// Regression test: a bag with a file that couldn't be restored used
+// to be finished anyway, with a receipt, and marked restored.
+func TestBagRestorerIncompleteBag(t *testing.T) {
+	st := newSourceTest(t)
+	defer st.server.Close()
+	context := st.base.Context
+	context.Config.RestoreDir = t.TempDir()
+	now := time.Now().UTC()
+
+	// The first file can be restored. The second has no preservation
+	// copies, so it can't.
+	st.gf.ID = 1
+	st.gf.State = constants.StateActive
+	st.gf.Checksums = []*registry.Checksum{
+		{Algorithm: constants.AlgMd5, Digest: fmt.Sprintf("%x", md5.Sum(st.buckets.data)), DateTime: now},
+		{Algorithm: constants.AlgSha256, Digest: fmt.Sprintf("%x", sha256.Sum256(st.buckets.data)), DateTime: now},
+	}
+	missing := &registry.GenericFile{
+		ID:         2,
+		Identifier: "test.edu/bag/data/file2.bin",
+		Size:       10,
+		State:      constants.StateActive,
+		Checksums:  []*registry.Checksum{{Algorithm: constants.AlgSha256, Digest: "1234", DateTime: now}},
+	}
+	server := httptest.NewServer(&bagRegistry{files: []*registry.GenericFile{st.gf, missing}})
+	defer server.Close()
+	client, err := network.NewRegistryClient(server.URL, "v3", "user", "key", constants.AdminAPIPrefix, context.Logger)
+	require.Nil(t, err)
+	context.RegistryClient = client
+
+	for _, format := range []string{constants.RestorationFormatZip, constants.RestorationFormatTarGz, constants.RestorationFormatTar} {
+		restObj := &service.RestorationObject{
+			Identifier:        "test.edu/bag",
+			RestorationType:   constants.RestorationTypeObject,
+			RestorationFormat: format,
+		}
+		restorer := restoration.NewBagRestorer(context, 4321, restObj)
+		target, err := restoration.NewLocalTarget(t.TempDir())
+		require.Nil(t, err)
+		restorer.Target = target
+
+		_, errors := restorer.Run()
+		require.Equal(t, 1, len(errors), format)
+		assert.Equal(t, missing.Identifier, errors[0].Identifier, format)
+		assert.False(t, restObj.AllFilesRestored, format)
+		assert.Empty(t, restObj.URL, format)
+		bagExists, err := target.Exists(restorer.RestoredKey())
+		require.Nil(t, err)
+		assert.False(t, bagExists, format)
+		receiptExists, err := target.Exists(restorer.ReceiptKey())
+		require.Nil(t, err)
+		assert.False(t, receiptExists, format)
+	}
+}
@@ -0,0 +1,61 @@
+package restoration
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+)
+
+// BagWriter packages the files of a restored bag. TarPipeWriter,
+// ZipPipeWriter and LooseWriter implement this interface, one for each
+// of constants.RestorationFormats.
+type BagWriter interface {
+	// AddFile writes the file described by header, with data from
+	// reader r, and returns its digests for each of manifestAlgs.
+	AddFile(header *tar.Header, r io.Reader, manifestAlgs []string) (map[string]string, error)
+
+	// Finish flushes anything left to write. Nothing can be added
+	// after this is called.
+	Finish()
+}
+
+// PipeBagWriter is a BagWriter that streams a single serialized bag
+// through a pipe. Whatever goes into the bag comes out of the reader
+// returned by GetReader.
+type PipeBagWriter interface {
+	BagWriter
+	GetReader() *io.PipeReader
+}
+
+// copyWithDigests copies header.Size bytes from r to dst, computing
+// digests for each of manifestAlgs along the way. Param dest describes
+// dst in error messages.
+func copyWithDigests(dst io.Writer, header *tar.Header, r io.Reader, manifestAlgs []string, dest string) (digests map[string]string, err error) {
+	digests = make(map[string]string, 4)
+	hashes := GetHashes(manifestAlgs)
+	writers := make([]io.Writer, 0, len(hashes)+1)
+	for _, hash := range hashes {
+		writers = append(writers, hash)
+	}
+	writers = append(writers, dst)
+	multiWriter := io.MultiWriter(writers...)
+
+	// Write the file contents
+	bytesWritten, err := io.Copy(multiWriter, r)
+	if bytesWritten != header.Size {
+		return digests, fmt.Errorf("AddFile copied only %d of %d bytes for file %s",
+			bytesWritten, header.Size, header.Name)
+	}
+	if err != nil {
+		return digests, fmt.Errorf("Error copying %s into %s: %v",
+			header.Name, dest, err)
+	}
+
+	// Collect the digests for return to caller. E.g.
+	// digest['md5'] = "68b329da9893e34099c7d8ad5cb9c940"
+	// digest['sha256'] = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	for alg, hash := range hashes {
+		digests[alg] = fmt.Sprintf("%x", hash.Sum(nil))
+	}
+	return digests, nil
+}
@@ -1,8 +1,14 @@
 package restoration
 
 import (
+	ctx "context"
+	"fmt"
+	"io"
+
 	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
+	"github.com/minio/minio-go/v7"
 )
 
 type BaseConstructor func(*common.Context, int, *service.RestorationObject) Runnable
@@ -16,6 +22,32 @@ type Base struct {
 	Context           *common.Context
 	RestorationObject *service.RestorationObject
 	WorkItemID        int64
+
+	// Target is where we write restored files. If nil, InitTarget
+	// sets it from the RestorationObject.
+	Target Target
+
+	// versions caches the object versions FindVersion selected for
+	// historical restorations, keyed by preservation URL.
+	versions map[string]*minio.ObjectInfo
+
+	// versionedBuckets records the buckets checkVersioning found
+	// have versioning enabled.
+	versionedBuckets map[string]bool
+}
+
+// InitTarget sets b.Target from the RestorationObject, unless it's
+// already set.
+func (b *Base) InitTarget() error {
+	if b.Target != nil {
+		return nil
+	}
+	target, err := NewTarget(b.Context, b.RestorationObject)
+	if err != nil {
+		return err
+	}
+	b.Target = target
+	return nil
 }
 
 // Error returns a ProcessingError describing something that went wrong
@@ -43,3 +75,76 @@ func (b *Base) IngestObjectGet() *service.IngestObject {
 func (b *Base) IngestObjectSave() error {
 	return nil
 }
+
+// Candidates returns the preservation copies of gf we can try to
+// restore from, in restore-priority order. Copies that failed digest
+// verification on an earlier attempt are left out.
+func (b *Base) Candidates(gf *registry.GenericFile) []*RestorationSource {
+	candidates := make([]*RestorationSource, 0)
+	for _, source := range RestorationSources(b.Context, gf) {
+		if !b.RestorationObject.HasFailedSource(source.StorageRecord.URL) {
+			candidates = append(candidates, source)
+		}
+	}
+	return candidates
+}
+
+// OpenSource opens the preservation copy of gf in source. This sends
+// the GET request right away, unlike minio's Client.GetObject, which
+// waits for the first read. That way, a missing, unreachable or
+// un-restored Glacier object returns an error here, before the caller
+// has written anything.
+func (b *Base) OpenSource(gf *registry.GenericFile, source *RestorationSource) (io.ReadCloser, error) {
+	return b.openRange(gf, source, 0)
+}
+
+func (b *Base) openRange(gf *registry.GenericFile, source *RestorationSource, offset int64) (io.ReadCloser, error) {
+	client := b.Context.S3Clients[source.Bucket.Bucket]
+	if client == nil {
+		return nil, fmt.Errorf("Cannot find S3 client for bucket %s", source.Bucket.Bucket)
+	}
+	opts := minio.GetObjectOptions{}
+	if b.RestorationObject.AsOf != nil {
+		version, err := b.FindVersion(gf, source)
+		if err != nil {
+			return nil, err
+		}
+		opts.VersionID = version.VersionID
+	}
+	if offset > 0 {
+		err := opts.SetRange(offset, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	core := minio.Core{Client: client}
+	obj, _, _, err := core.GetObject(ctx.Background(), source.Bucket.Bucket, gf.UUID, opts)
+	return obj, err
+}
+
+// OpenFirstAvailable opens the first copy of gf in candidates that we
+// can read, recording a failover for each one we can't. If none can be
+// read, and at least one is in Glacier and has not yet been restored
+// to S3, this sets RestorationObject.NeedsGlacierRestore.
+func (b *Base) OpenFirstAvailable(gf *registry.GenericFile, candidates []*RestorationSource) (io.ReadCloser, *RestorationSource, error) {
+	if len(candidates) == 0 {
+		return nil, nil, fmt.Errorf("No usable preservation copy of %s. (%d preservation URLS, %d failed verification)", gf.Identifier, len(gf.StorageRecords), len(b.RestorationObject.FailedSources))
+	}
+	var err error
+	glacierNotRestored := false
+	for _, source := range candidates {
+		var obj io.ReadCloser
+		obj, err = b.OpenSource(gf, source)
+		if err == nil {
+			b.Context.Logger.Infof("Reading %s from %s", gf.Identifier, source.StorageRecord.URL)
+			return obj, source, nil
+		}
+		b.Context.Logger.Warningf("Cannot read %s from %s: %v", gf.Identifier, source.StorageRecord.URL, err)
+		b.RestorationObject.AddFailover(gf.Identifier, source.StorageRecord.URL, err)
+		if IsGlacierBucket(source.Bucket) && IsNotRestoredError(err) {
+			glacierNotRestored = true
+		}
+	}
+	b.RestorationObject.NeedsGlacierRestore = glacierNotRestored
+	return nil, nil, fmt.Errorf("Could not read %s from any of its %d preservation copies. Last error: %v", gf.Identifier, len(candidates), err)
+}
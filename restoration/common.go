@@ -3,35 +3,111 @@ package restoration
 import (
 	"fmt"
 	"net/url"
+	"sort"
 	"strconv"
 
+	"github.com/APTrust/preservation-services/constants"
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/minio/minio-go/v7"
 )
 
-const defaultPriority = 10000
+// RestorationSource is a preservation copy of a file from which we
+// can restore.
+type RestorationSource struct {
+	Bucket        *common.PreservationBucket
+	StorageRecord *registry.StorageRecord
+}
 
-// BestRestorationSource returns the best preservation bucket from which
-// to restore a file. We generally want to restore from S3 over Glacier,
-// and US East over other regions. We only need to figure this out once,
-// since all of an object's files will be stored in the same preservation
-// bucket or buckets.
-func BestRestorationSource(context *common.Context, gf *registry.GenericFile) (bestSource *common.PreservationBucket, storageRecord *registry.StorageRecord, err error) {
-	priority := defaultPriority
+// RestorationSources returns all of the preservation copies of gf that
+// we know how to restore from, ordered by the RestorePriority of the
+// buckets they're in. The first item is the most accessible copy.
+//
+// Copies that the fixity checker has quarantined are left out, since
+// we know their contents are bad. The fixity checker uses this list
+// too, so it doesn't keep re-checking a copy that's known to be bad.
+func RestorationSources(context *common.Context, gf *registry.GenericFile) []*RestorationSource {
+	sources := make([]*RestorationSource, 0)
 	for _, sr := range gf.StorageRecords {
+		if IsQuarantined(context, gf, sr.URL) {
+			continue
+		}
 		for _, preservationBucket := range context.Config.PreservationBuckets {
-			if preservationBucket.HostsURL(sr.URL) && preservationBucket.RestorePriority < priority {
-				bestSource = preservationBucket
-				storageRecord = sr
-				priority = preservationBucket.RestorePriority
+			if preservationBucket.HostsURL(sr.URL) {
+				sources = append(sources, &RestorationSource{
+					Bucket:        preservationBucket,
+					StorageRecord: sr,
+				})
+				break
 			}
 		}
 	}
-	if priority == defaultPriority {
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].Bucket.RestorePriority < sources[j].Bucket.RestorePriority
+	})
+	return sources
+}
+
+// IsQuarantined returns true if the copy of gf at url has been
+// quarantined after failing fixity re-verification. If we can't tell
+// because Redis is unavailable, we log a warning and assume the copy is
+// fine. The restorers verify digests anyway, so a bad copy would still
+// be caught.
+func IsQuarantined(context *common.Context, gf *registry.GenericFile, url string) bool {
+	if context.RedisClient == nil {
+		return false
+	}
+	quarantined, err := context.RedisClient.IsQuarantined(gf.ID, url)
+	if err != nil {
+		if context.Logger != nil {
+			context.Logger.Warningf("Could not check quarantine status of %s: %v", url, err)
+		}
+		return false
+	}
+	if quarantined && context.Logger != nil {
+		context.Logger.Warningf("Skipping quarantined copy of %s at %s", gf.Identifier, url)
+	}
+	return quarantined
+}
+
+// GlacierSource returns the most accessible source in sources that is
+// in Glacier or Glacier Deep Archive, or nil if there are none.
+func GlacierSource(sources []*RestorationSource) *RestorationSource {
+	for _, source := range sources {
+		if IsGlacierBucket(source.Bucket) {
+			return source
+		}
+	}
+	return nil
+}
+
+// IsGlacierBucket returns true if objects in bucket must be restored
+// from Glacier or Glacier Deep Archive before they can be read.
+func IsGlacierBucket(bucket *common.PreservationBucket) bool {
+	return bucket.StorageClass == constants.StorageClassGlacier ||
+		bucket.StorageClass == constants.StorageClassGlacierDeep
+}
+
+// IsNotRestoredError returns true if err means the object is in Glacier
+// and has not been restored to S3.
+func IsNotRestoredError(err error) bool {
+	return minio.ToErrorResponse(err).Code == "InvalidObjectState"
+}
+
+// BestRestorationSource returns the best preservation bucket from which
+// to restore a file. We generally want to restore from S3 over Glacier,
+// and US East over other regions. This is the first item returned by
+// RestorationSources. Restorers should use that list instead, so they
+// can move on to the next copy if this one can't be read.
+func BestRestorationSource(context *common.Context, gf *registry.GenericFile) (bestSource *common.PreservationBucket, storageRecord *registry.StorageRecord, err error) {
+	sources := RestorationSources(context, gf)
+	if len(sources) == 0 {
 		err = fmt.Errorf("Could not find any suitable restoration source for %s. (%d preservation URLS, %d PreservationBuckets)", gf.Identifier, len(gf.StorageRecords), len(context.Config.PreservationBuckets))
-	} else {
-		context.Logger.Infof("Most accessible source for %s is %s", gf.Identifier, bestSource.Bucket)
+		return nil, nil, err
 	}
+	bestSource = sources[0].Bucket
+	storageRecord = sources[0].StorageRecord
+	context.Logger.Infof("Most accessible source for %s is %s", gf.Identifier, bestSource.Bucket)
 	return bestSource, storageRecord, err
 }
 
@@ -0,0 +1,81 @@
+package restoration_test
+
+import (
+	"testing"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/restoration"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/APTrust/preservation-services/util/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestorationSources(t *testing.T) {
+	context := &common.Context{Config: common.NewConfig()}
+	glacierVA := context.Config.PreservationBucketsFor(constants.StorageGlacierVA)[0]
+	wasabiOR := context.Config.PreservationBucketsFor(constants.StorageWasabiOR)[0]
+	standard := context.Config.PreservationBucketsFor(constants.StorageStandard)
+	require.Equal(t, 2, len(standard))
+
+	gf := &registry.GenericFile{
+		Identifier: "test.edu/bag/data/file.txt",
+		StorageRecords: []*registry.StorageRecord{
+			{URL: glacierVA.URLFor("uuid")},
+			{URL: "https://example.com/unknown-bucket/uuid"},
+			{URL: wasabiOR.URLFor("uuid")},
+			{URL: standard[1].URLFor("uuid")},
+			{URL: standard[0].URLFor("uuid")},
+		},
+	}
+	sources := restoration.RestorationSources(context, gf)
+	require.Equal(t, 4, len(sources))
+	for i := 1; i < len(sources); i++ {
+		assert.True(t, sources[i-1].Bucket.RestorePriority < sources[i].Bucket.RestorePriority)
+	}
+	assert.Equal(t, glacierVA, sources[3].Bucket)
+	assert.Equal(t, glacierVA.URLFor("uuid"), sources[3].StorageRecord.URL)
+
+	gf.StorageRecords = []*registry.StorageRecord{{URL: "https://example.com/unknown-bucket/uuid"}}
+	assert.Empty(t, restoration.RestorationSources(context, gf))
+}
+
+func TestRestorationSourcesSkipsQuarantined(t *testing.T) {
+	fakeRedis, err := testutil.NewFakeRedis()
+	require.Nil(t, err)
+	defer fakeRedis.Close()
+	context := &common.Context{
+		Config:      common.NewConfig(),
+		Logger:      logger.DiscardLogger("common_test"),
+		RedisClient: network.NewRedisClient(fakeRedis.Addr(), "", 0),
+	}
+	standard := context.Config.PreservationBucketsFor(constants.StorageStandard)
+	gf := &registry.GenericFile{
+		ID:         99,
+		Identifier: "test.edu/bag/data/file.txt",
+		StorageRecords: []*registry.StorageRecord{
+			{URL: standard[0].URLFor("uuid")},
+			{URL: standard[1].URLFor("uuid")},
+		},
+	}
+	require.Equal(t, 2, len(restoration.RestorationSources(context, gf)))
+
+	// Once the most accessible copy is quarantined, it's no longer
+	// a source.
+	require.Nil(t, context.RedisClient.QuarantineSave(&service.QuarantinedReplica{
+		GenericFileID: gf.ID,
+		URL:           standard[0].URLFor("uuid"),
+	}))
+	assert.True(t, restoration.IsQuarantined(context, gf, standard[0].URLFor("uuid")))
+	sources := restoration.RestorationSources(context, gf)
+	require.Equal(t, 1, len(sources))
+	assert.Equal(t, standard[1].URLFor("uuid"), sources[0].StorageRecord.URL)
+
+	// Another file's copy at the same URL isn't affected.
+	gf.ID = 100
+	assert.Equal(t, 2, len(restoration.RestorationSources(context, gf)))
+}
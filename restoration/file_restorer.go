@@ -1,16 +1,23 @@
 package restoration
 
 import (
-	ctx "context"
 	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/APTrust/preservation-services/constants"
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
-	"github.com/minio/minio-go/v7"
+	"github.com/google/uuid"
 )
 
+// stagingSuffix is added to the key of a file restoration while it's
+// being uploaded and verified. See FileRestorer.Run.
+const stagingSuffix = ".restoring"
+
 // FileRestorer restores individual files to a depositor's restoration bucket.
 type FileRestorer struct {
 	Base
@@ -29,38 +36,109 @@ func NewFileRestorer(context *common.Context, workItemID int64, restorationObjec
 }
 
 // Run restores the file to the depositor's restoration bucket.
+//
+// The file streams from preservation storage through a hash for each
+// algorithm for which Registry has a checksum, into a staging key next
+// to the file's own key (the same key plus ".restoring"). Only when
+// every digest matches Registry do we move the staged object to its
+// real key, so the depositor never sees an unverified file there. If
+// a copy can't be read, or any digest doesn't match, we delete the
+// staged object and try the next preservation copy in restore-priority
+// order. If no copy matches, nothing is restored. If the only
+// copies left are in Glacier and haven't been restored to S3, we set
+// RestorationObject.NeedsGlacierRestore so the worker can hand off to
+// the Glacier restorer.
+//
+// When we're done, we record a restoration event in Registry describing
+// which copy we restored and which digests we verified.
 func (r *FileRestorer) Run() (fileCount int, errors []*service.ProcessingError) {
+	err := r.InitTarget()
+	if err != nil {
+		errors = append(errors, r.Error(r.RestorationObject.Identifier, err, true))
+		return fileCount, errors
+	}
 	gf, err := r.getGenericFile()
 	if err != nil {
 		errors = append(errors, r.Error(r.RestorationObject.Identifier, err, false))
 		return fileCount, errors
 	}
-	obj, err := r.getFileFromPreservation(gf)
-	if err != nil {
-		errors = append(errors, r.Error(gf.Identifier, err, false))
+	expectedDigests := ExpectedDigests(gf)
+	if len(expectedDigests) == 0 {
+		err = fmt.Errorf("Registry has no checksums for %s, so restored file cannot be verified", gf.Identifier)
+		errors = append(errors, r.Error(gf.Identifier, err, true))
 		return fileCount, errors
 	}
-	defer obj.Close()
-	r.Context.Logger.Infof("Copying %s to %s", gf.Identifier, r.RestorationObject.RestorationTarget)
-	_, err = r.Context.S3Clients[constants.StorageProviderAWS].PutObject(
-		ctx.Background(),
-		r.RestorationObject.RestorationTarget,
-		gf.Identifier,
-		obj,
-		gf.Size,
-		minio.PutObjectOptions{})
+	candidates := r.Candidates(gf)
+	if len(candidates) == 0 {
+		// If every copy failed verification on earlier attempts, there's
+		// nothing left to try.
+		err = fmt.Errorf("Could not find any suitable restoration source for %s. (%d preservation URLS, %d failed verification)", gf.Identifier, len(gf.StorageRecords), len(r.RestorationObject.FailedSources))
+		errors = append(errors, r.Error(gf.Identifier, err, len(r.RestorationObject.FailedSources) > 0))
+		return fileCount, errors
+	}
+
+	var restoredFrom *RestorationSource
+	var digests map[string]string
+	failures := make([]string, 0)
+	mismatches := 0
+	glacierNotRestored := false
+	for _, source := range candidates {
+		digests, err = r.copyFrom(gf, source, expectedDigests)
+		if err == nil {
+			err = VerifyDigests(expectedDigests, digests)
+			if err == nil {
+				restoredFrom = source
+				break
+			}
+			mismatches++
+			r.RestorationObject.AddFailedSource(source.StorageRecord.URL)
+		} else if IsGlacierBucket(source.Bucket) && IsNotRestoredError(err) {
+			glacierNotRestored = true
+		}
+		r.removeStagedCopy(gf)
+		r.Context.Logger.Warningf("Could not restore %s from %s: %v", gf.Identifier, source.StorageRecord.URL, err)
+		r.RestorationObject.AddFailover(gf.Identifier, source.StorageRecord.URL, err)
+		failures = append(failures, fmt.Sprintf("%s: %v", source.StorageRecord.URL, err))
+	}
+
+	if restoredFrom == nil {
+		// If every copy was readable and none matched, retrying won't
+		// help. If some copies couldn't be read, they may be readable
+		// later, or after a Glacier restore.
+		r.RestorationObject.NeedsGlacierRestore = glacierNotRestored
+		allCorrupt := mismatches == len(candidates)
+		if allCorrupt {
+			event := r.restorationEvent(gf, nil, digests, failures)
+			if resp := r.Context.RegistryClient.PremisEventSave(event); resp.Error != nil {
+				r.Context.Logger.Errorf("Error saving failed restoration event for %s: %v", gf.Identifier, resp.Error)
+			}
+		}
+		err = fmt.Errorf("Could not restore %s from any of its %d preservation copies. %s", gf.Identifier, len(candidates), strings.Join(failures, "; "))
+		errors = append(errors, r.Error(gf.Identifier, err, allCorrupt))
+		return fileCount, errors
+	}
+
+	err = r.Target.MoveObject(gf.Identifier+stagingSuffix, gf.Identifier)
 	if err != nil {
+		err = fmt.Errorf("File %s was verified, but could not be moved into place at %s: %v", gf.Identifier, r.Target.URL(gf.Identifier), err)
 		errors = append(errors, r.Error(gf.Identifier, err, false))
+		return fileCount, errors
 	}
-	if len(errors) == 0 {
-		fileCount = 1
-		r.RestorationObject.AllFilesRestored = true
-		r.RestorationObject.URL = fmt.Sprintf("%s%s/%s", constants.AWSBucketPrefix, r.RestorationObject.RestorationTarget, r.RestorationObject.Identifier)
+	fileCount = 1
+	r.RestorationObject.AllFilesRestored = true
+	r.RestorationObject.URL = r.Target.URL(r.RestorationObject.Identifier)
+
+	event := r.restorationEvent(gf, restoredFrom, digests, failures)
+	resp := r.Context.RegistryClient.PremisEventSave(event)
+	if resp.Error != nil {
+		err = fmt.Errorf("File %s was restored, but the restoration event could not be saved: %v", gf.Identifier, resp.Error)
+		errors = append(errors, r.Error(gf.Identifier, err, false))
 	}
 	return fileCount, errors
 }
 
-// Get the GenericFile record from Registry
+// Get the GenericFile record from Registry. For historical restorations,
+// this describes the file as it was at RestorationObject.AsOf.
 func (r *FileRestorer) getGenericFile() (*registry.GenericFile, error) {
 	resp := r.Context.RegistryClient.GenericFileByIdentifier(r.RestorationObject.Identifier)
 	if resp.Error != nil {
@@ -71,20 +149,152 @@ func (r *FileRestorer) getGenericFile() (*registry.GenericFile, error) {
 		return nil, fmt.Errorf("Registry returned nil for file %s", r.RestorationObject.Identifier)
 	}
 	r.Context.Logger.Infof("File %s has %d storage records", gf.Identifier, len(gf.StorageRecords))
+	if r.RestorationObject.AsOf != nil {
+		asOf := *r.RestorationObject.AsOf
+		if !ExistedAt(gf, asOf) {
+			return nil, fmt.Errorf("File %s was not ingested until %s, after the requested date %s", gf.Identifier, gf.CreatedAt.Format(time.RFC3339), asOf.Format(time.RFC3339))
+		}
+		return r.FileAsOf(gf)
+	}
 	return gf, nil
 }
 
-// Get the S3 object from preservation storage.
-func (r *FileRestorer) getFileFromPreservation(gf *registry.GenericFile) (*minio.Object, error) {
-	b, _, err := BestRestorationSource(r.Context, gf)
+// copyFrom streams the preservation copy of gf in source to its
+// staging key in the restoration target, returning digests for each
+// algorithm in expectedDigests. The Registry checksums are written to
+// the restored object's metadata.
+func (r *FileRestorer) copyFrom(gf *registry.GenericFile, source *RestorationSource, expectedDigests map[string]string) (map[string]string, error) {
+	obj, err := r.OpenSource(gf, source)
 	if err != nil {
 		return nil, err
 	}
-	client := r.Context.S3Clients[b.Bucket]
-	obj, err := client.GetObject(ctx.Background(), b.Bucket, gf.UUID, minio.GetObjectOptions{})
+	defer obj.Close()
+	stagingKey := gf.Identifier + stagingSuffix
+	r.Context.Logger.Infof("Copying %s from %s to %s", gf.Identifier, source.Bucket.Bucket, r.Target.URL(stagingKey))
+
+	algs := make([]string, 0, len(expectedDigests))
+	for alg := range expectedDigests {
+		algs = append(algs, alg)
+	}
+	hashes := GetHashes(algs)
+	writers := make([]io.Writer, 0, len(hashes))
+	for _, hash := range hashes {
+		writers = append(writers, hash)
+	}
+	reader := io.TeeReader(obj, io.MultiWriter(writers...))
+
+	bytesWritten, err := r.Target.PutObject(stagingKey, reader, gf.Size, ChecksumMetadata(expectedDigests))
 	if err != nil {
 		return nil, err
 	}
-	r.Context.Logger.Infof("Found %s in bucket %s", gf.Identifier, b.Bucket)
-	return obj, err
+	if bytesWritten != gf.Size {
+		return nil, fmt.Errorf("copied %d of %d bytes", bytesWritten, gf.Size)
+	}
+	digests := make(map[string]string, len(hashes))
+	for alg, hash := range hashes {
+		digests[alg] = fmt.Sprintf("%x", hash.Sum(nil))
+	}
+	return digests, nil
+}
+
+// removeStagedCopy deletes the staged copy of gf from the restoration
+// target. We call this when a preservation copy fails verification.
+func (r *FileRestorer) removeStagedCopy(gf *registry.GenericFile) {
+	stagingKey := gf.Identifier + stagingSuffix
+	err := r.Target.RemoveObject(stagingKey)
+	if err != nil {
+		r.Context.Logger.Warningf("Could not remove unverified copy of %s from %s: %v", gf.Identifier, r.Target.URL(stagingKey), err)
+	}
+}
+
+// restorationEvent returns a PremisEvent describing the restoration
+// of gf. Param source is nil if no preservation copy passed
+// verification. Param failures describes copies we tried and rejected.
+func (r *FileRestorer) restorationEvent(gf *registry.GenericFile, source *RestorationSource, digests map[string]string, failures []string) *registry.PremisEvent {
+	outcome := constants.StatusSuccess
+	outcomeInformation := ""
+	if source != nil {
+		outcomeInformation = fmt.Sprintf("Restored %s to %s. All digests match Registry.", source.StorageRecord.URL, r.RestorationObject.URL)
+	} else {
+		outcome = constants.StatusFailed
+		outcomeInformation = "No preservation copy matched Registry checksums. Nothing was restored."
+	}
+	if len(failures) > 0 {
+		outcomeInformation += fmt.Sprintf(" Rejected copies: %s", strings.Join(failures, "; "))
+	}
+	now := time.Now().UTC()
+	return &registry.PremisEvent{
+		Agent:                 "https://github.com/minio/minio-go",
+		DateTime:              now,
+		Detail:                "File restored to restoration target with fixity verification",
+		EventType:             constants.EventRestoration,
+		GenericFileID:         gf.ID,
+		GenericFileIdentifier: gf.Identifier,
+		Identifier:            uuid.New().String(),
+		InstitutionID:         gf.InstitutionID,
+		IntellectualObjectID:  gf.IntellectualObjectID,
+		Object:                "Minio S3 library + Go language crypto hashes",
+		Outcome:               string(outcome),
+		OutcomeDetail:         FormatDigests(digests),
+		OutcomeInformation:    outcomeInformation,
+		CreatedAt:             now,
+		UpdatedAt:             now,
+	}
+}
+
+// ExpectedDigests returns the latest Registry checksum of gf for each
+// supported algorithm, keyed by algorithm.
+func ExpectedDigests(gf *registry.GenericFile) map[string]string {
+	expected := make(map[string]string)
+	for _, alg := range constants.SupportedManifestAlgorithms {
+		checksum := gf.GetLatestChecksum(alg)
+		if checksum != nil {
+			expected[alg] = checksum.Digest
+		}
+	}
+	return expected
+}
+
+// VerifyDigests returns an error describing each algorithm for which
+// the actual digest is missing or does not match the expected digest.
+func VerifyDigests(expected, actual map[string]string) error {
+	mismatches := make([]string, 0)
+	for _, alg := range sortedKeys(expected) {
+		if actual[alg] != expected[alg] {
+			mismatches = append(mismatches, fmt.Sprintf("%s expected %s, got %s", alg, expected[alg], actual[alg]))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("digest mismatch: %s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// ChecksumMetadata returns S3 user metadata that records digests on a
+// restored object, so depositors can verify the file after download.
+func ChecksumMetadata(digests map[string]string) map[string]string {
+	metadata := make(map[string]string, len(digests))
+	for alg, digest := range digests {
+		metadata[alg] = digest
+	}
+	return metadata
+}
+
+// FormatDigests returns digests as a string in the form
+// "md5:1234, sha256:5678", sorted by algorithm.
+func FormatDigests(digests map[string]string) string {
+	formatted := make([]string, 0, len(digests))
+	for _, alg := range sortedKeys(digests) {
+		formatted = append(formatted, fmt.Sprintf("%s:%s", alg, digests[alg]))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
@@ -55,4 +55,5 @@ func testRestoredFile(t *testing.T, context *common.Context, restObj *service.Re
 		minio.StatObjectOptions{})
 	require.Nil(t, err)
 	assert.Equal(t, gfSize, objInfo.Size)
+	assert.NotEmpty(t, objInfo.Metadata.Get("X-Amz-Meta-Sha256"))
 }
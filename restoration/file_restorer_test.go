@@ -0,0 +1,206 @@
+package restoration_test
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/restoration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTarget is a LocalTarget that records the keys written to
+// and moved into place.
+type recordingTarget struct {
+	*restoration.LocalTarget
+	sync.Mutex
+	puts  []string
+	moves []string
+}
+
+func (t *recordingTarget) PutObject(key string, reader io.Reader, size int64, metadata map[string]string) (int64, error) {
+	t.Lock()
+	t.puts = append(t.puts, key)
+	t.Unlock()
+	return t.LocalTarget.PutObject(key, reader, size, metadata)
+}
+
+func (t *recordingTarget) MoveObject(from, to string) error {
+	t.Lock()
+	t.moves = append(t.moves, to)
+	t.Unlock()
+	return t.LocalTarget.MoveObject(from, to)
+}
+
+// fileRegistry serves one GenericFile and records saved events.
+type fileRegistry struct {
+	sync.Mutex
+	gf     *registry.GenericFile
+	events []*registry.PremisEvent
+}
+
+func (r *fileRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	defer r.Unlock()
+	switch {
+	case strings.Contains(req.URL.Path, "/files/show/"):
+		data, _ := json.Marshal(r.gf)
+		w.Write(data)
+	case strings.HasSuffix(req.URL.Path, "/events/create"):
+		event := &registry.PremisEvent{}
+		json.NewDecoder(req.Body).Decode(event)
+		r.events = append(r.events, event)
+		data, _ := json.Marshal(event)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(data)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func newFileRestorerTest(t *testing.T, st *sourceTest, sha256Digest string) (*restoration.FileRestorer, *recordingTarget, *fileRegistry) {
+	st.gf.Checksums = []*registry.Checksum{
+		{Algorithm: constants.AlgSha256, Digest: sha256Digest, DateTime: time.Now().UTC()},
+	}
+	// Just the two copies that don't need a Glacier restore.
+	st.gf.StorageRecords = st.gf.StorageRecords[1:]
+	standIn := &fileRegistry{gf: st.gf}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	client, err := network.NewRegistryClient(server.URL, "v3", "user", "key", constants.AdminAPIPrefix, st.base.Context.Logger)
+	require.Nil(t, err)
+	st.base.Context.RegistryClient = client
+
+	restObj := &service.RestorationObject{
+		Identifier:      st.gf.Identifier,
+		RestorationType: constants.RestorationTypeFile,
+	}
+	restorer := restoration.NewFileRestorer(st.base.Context, 1234, restObj)
+	localTarget, err := restoration.NewLocalTarget(t.TempDir())
+	require.Nil(t, err)
+	target := &recordingTarget{LocalTarget: localTarget}
+	restorer.Target = target
+	return restorer, target, standIn
+}
+
+func TestFileRestorerVerifiesBeforeMove(t *testing.T) {
+	st := newSourceTest(t)
+	defer st.server.Close()
+	restorer, target, standIn := newFileRestorerTest(t, st, fmt.Sprintf("%x", sha256.Sum256(st.buckets.data)))
+
+	count, errors := restorer.Run()
+	require.Empty(t, errors)
+	assert.Equal(t, 1, count)
+
+	// The upload went to the staging key, then moved into place.
+	assert.Equal(t, []string{st.gf.Identifier + ".restoring"}, target.puts)
+	assert.Equal(t, []string{st.gf.Identifier}, target.moves)
+	path, err := target.Path(st.gf.Identifier)
+	require.Nil(t, err)
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, st.buckets.data, data)
+	_, err = os.Stat(path + ".restoring")
+	assert.True(t, os.IsNotExist(err))
+	require.Equal(t, 1, len(standIn.events))
+	assert.Equal(t, string(constants.StatusSuccess), standIn.events[0].Outcome)
+}
+
+func TestFileRestorerDigestMismatch(t *testing.T) {
+	st := newSourceTest(t)
+	defer st.server.Close()
+	restorer, target, standIn := newFileRestorerTest(t, st, strings.Repeat("0", 64))
+
+	count, errors := restorer.Run()
+	assert.Equal(t, 0, count)
+	require.Equal(t, 1, len(errors))
+	assert.True(t, errors[0].IsFatal)
+
+	// Both copies were staged and rejected. Nothing was ever moved to
+	// the file's real key, and no staged copy is left behind.
+	assert.Equal(t, 2, len(target.puts))
+	for _, key := range target.puts {
+		assert.Equal(t, st.gf.Identifier+".restoring", key)
+	}
+	assert.Empty(t, target.moves)
+	entries, err := os.ReadDir(filepath.Join(target.Dir, filepath.Dir(st.gf.Identifier)))
+	require.Nil(t, err)
+	assert.Empty(t, entries)
+	assert.False(t, restorer.RestorationObject.AllFilesRestored)
+	assert.Equal(t, 2, len(restorer.RestorationObject.FailedSources))
+	require.Equal(t, 1, len(standIn.events))
+	assert.Equal(t, string(constants.StatusFailed), standIn.events[0].Outcome)
+}
+
+func TestExpectedDigests(t *testing.T) {
+	older := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	gf := &registry.GenericFile{
+		Checksums: []*registry.Checksum{
+			{Algorithm: constants.AlgMd5, Digest: "old-md5", DateTime: older},
+			{Algorithm: constants.AlgMd5, Digest: "new-md5", DateTime: newer},
+			{Algorithm: constants.AlgSha256, Digest: "sha256", DateTime: older},
+		},
+	}
+	expected := restoration.ExpectedDigests(gf)
+	assert.Equal(t, map[string]string{
+		constants.AlgMd5:    "new-md5",
+		constants.AlgSha256: "sha256",
+	}, expected)
+
+	assert.Empty(t, restoration.ExpectedDigests(&registry.GenericFile{}))
+}
+
+func TestVerifyDigests(t *testing.T) {
+	expected := map[string]string{
+		constants.AlgMd5:    "1111",
+		constants.AlgSha256: "2222",
+	}
+	assert.Nil(t, restoration.VerifyDigests(expected, map[string]string{
+		constants.AlgMd5:    "1111",
+		constants.AlgSha256: "2222",
+	}))
+
+	err := restoration.VerifyDigests(expected, map[string]string{
+		constants.AlgMd5:    "1111",
+		constants.AlgSha256: "3333",
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, "digest mismatch: sha256 expected 2222, got 3333", err.Error())
+
+	// Missing digests don't count as verified.
+	err = restoration.VerifyDigests(expected, map[string]string{constants.AlgMd5: "1111"})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "sha256 expected 2222, got ")
+}
+
+func TestFormatDigests(t *testing.T) {
+	digests := map[string]string{
+		constants.AlgSha256: "2222",
+		constants.AlgMd5:    "1111",
+	}
+	assert.Equal(t, "md5:1111, sha256:2222", restoration.FormatDigests(digests))
+	assert.Equal(t, "", restoration.FormatDigests(map[string]string{}))
+}
+
+func TestChecksumMetadata(t *testing.T) {
+	digests := map[string]string{
+		constants.AlgSha256: "2222",
+		constants.AlgMd5:    "1111",
+	}
+	assert.Equal(t, digests, restoration.ChecksumMetadata(digests))
+}
@@ -22,42 +22,83 @@ const (
 	RestoreError
 )
 
+// Restore requests that are past their expected completion time are
+// checked at a quarter of the tier's expected time, but no more often
+// than this. So are requests Glacier turned away with a 503.
+const minGlacierCheckInterval = 5 * time.Minute
+
+// GlacierRestoreStore saves and retrieves the state of the Glacier
+// restore request for each file. network.RedisClient implements this.
+type GlacierRestoreStore interface {
+	GlacierRestoreGet(workItemID int64, fileIdentifier string) (*service.GlacierRestoreState, error)
+	GlacierRestoreSave(workItemID int64, state *service.GlacierRestoreState) error
+}
+
 // GlacierRestorer initiates restoration of an object from Glacier to S3.
 // From there, the BagRestorer or FileRestorer can move items to the
 // depositor's restoration bucket.
 type GlacierRestorer struct {
 	Base
+
+	// Store keeps track of each file's restore request between runs.
+	// If it's nil, we check the status of every file on every run.
+	Store GlacierRestoreStore
+
+	// Endpoint, if set, replaces the scheme and host of Glacier URLs.
+	// Tests use this to point at a local stand-in for S3.
+	Endpoint string
+
+	nextCheck *time.Time
+	estimate  *service.GlacierRestoreEstimate
 }
 
 // NewGlacierRestorer creates a new GlacierRestorer
 func NewGlacierRestorer(context *common.Context, workItemID int64, restorationObject *service.RestorationObject) *GlacierRestorer {
-	return &GlacierRestorer{
+	restorer := &GlacierRestorer{
 		Base: Base{
 			Context:           context,
 			RestorationObject: restorationObject,
 			WorkItemID:        workItemID,
 		},
 	}
+	if context.RedisClient != nil {
+		restorer.Store = context.RedisClient
+	}
+	return restorer
 }
 
 // Run initiates or checks on the Glacier restore requests for a single file or for
-// all of the files that make up an intellectual object. The first call to run
-// initiates restoration requests. Subsequent calls check on the progress of the
-// restoration.
+// all of the files that make up an intellectual object. We check each file's
+// status with a HEAD request, and ask Glacier to restore only those files that
+// have no restore request in progress. We don't check on a pending request
+// until it's expected to be done.
+//
+// New requests use the tier and lifetime in RestorationObject. If Glacier
+// turns away an Expedited request with a 503, we fall back to Standard.
 //
 // This will return a non-fatal error unless all of the requested restorations
-// are available in S3.
+// are available in S3. RestorationObject.NextGlacierCheck says when the first
+// pending request should be done, and RestorationObject.GlacierEstimate
+// describes the requests we've made and what they should cost.
 func (r *GlacierRestorer) Run() (fileCount int, errors []*service.ProcessingError) {
+	r.nextCheck = nil
+	r.estimate = nil
+	defer func() {
+		r.RestorationObject.NextGlacierCheck = r.nextCheck
+		r.RestorationObject.GlacierEstimate = r.estimate
+	}()
 	if r.RestorationObject.RestorationType == constants.RestorationTypeFile {
-		status, errors := r.restoreFile()
+		var status int
+		status, errors = r.restoreFile()
 		if len(errors) == 0 && status == RestoreCompleted {
 			r.RestorationObject.AllFilesRestored = true
 			r.RestorationObject.RestoredAt = time.Now().UTC()
 		}
 		fileCount = 1
 	} else {
-		completed, pending, errored, errors := r.restoreAllFiles()
-		if completed > 1 && pending == 0 && errored == 0 && len(errors) == 0 {
+		var completed, pending, errored int
+		completed, pending, errored, errors = r.restoreAllFiles()
+		if completed > 0 && pending == 0 && errored == 0 && len(errors) == 0 {
 			r.RestorationObject.AllFilesRestored = true
 			r.RestorationObject.RestoredAt = time.Now().UTC()
 		}
@@ -67,8 +108,14 @@ func (r *GlacierRestorer) Run() (fileCount int, errors []*service.ProcessingErro
 	// If we have no errors but not all files are ready in S3,
 	// return a non-fatal error so the worker will requeue this
 	// item and check it again in a few hours.
+	if r.estimate != nil {
+		r.Context.Logger.Infof("%s: %s", r.RestorationObject.Identifier, r.estimate.String())
+	}
 	if len(errors) == 0 && !r.RestorationObject.AllFilesRestored {
 		err := fmt.Errorf("Initiated restore, but files are not yet available in S3. Requeued for later recheck.")
+		if r.estimate != nil {
+			err = fmt.Errorf("%s %s", err.Error(), r.estimate.String())
+		}
 		errors = append(errors, r.Error(r.RestorationObject.Identifier, err, false))
 	}
 
@@ -77,6 +124,17 @@ func (r *GlacierRestorer) Run() (fileCount int, errors []*service.ProcessingErro
 
 // Restore all of the files belonging to an IntellectualObject.
 func (r *GlacierRestorer) restoreAllFiles() (completed, pending, errored int, errors []*service.ProcessingError) {
+	// For partial restorations, we need only the files that will go
+	// into the restored bag.
+	var filter *PathFilter
+	if r.RestorationObject.RestorationType == constants.RestorationTypePartial {
+		var err error
+		filter, err = NewPathFilter(r.RestorationObject.IncludePatterns, r.RestorationObject.ExcludePatterns)
+		if err != nil {
+			errors = append(errors, r.Error(r.RestorationObject.Identifier, err, true))
+			return completed, pending, errored, errors
+		}
+	}
 	pageNumber := 1
 	for {
 		files, err := GetBatchOfFiles(r.Context, r.RestorationObject.ItemID, pageNumber)
@@ -85,8 +143,16 @@ func (r *GlacierRestorer) restoreAllFiles() (completed, pending, errored int, er
 			return completed, pending, errored, errors
 		}
 		for _, gf := range files {
-			restoreStatus, errs := r.requestRestoration(gf)
-			errors = errs
+			if filter != nil {
+				if pathInBag, _ := gf.PathInBag(); !filter.Matches(pathInBag) {
+					continue
+				}
+			}
+			if r.RestorationObject.AsOf != nil && !ExistedAt(gf, *r.RestorationObject.AsOf) {
+				continue
+			}
+			restoreStatus, errs := r.CheckFile(gf)
+			errors = append(errors, errs...)
 			switch restoreStatus {
 			case RestoreCompleted:
 				completed++
@@ -117,35 +183,109 @@ func (r *GlacierRestorer) restoreFile() (restoreStatus int, errors []*service.Pr
 		errors = append(errors, r.Error(r.RestorationObject.Identifier, err, true))
 		return RestoreError, errors
 	}
-	return r.requestRestoration(gf)
+	return r.CheckFile(gf)
 }
 
-// Send the restoration request to Glacier and return the status.
-func (r *GlacierRestorer) requestRestoration(gf *registry.GenericFile) (restoreStatus int, errors []*service.ProcessingError) {
-	_, storageRecord, err := BestRestorationSource(r.Context, gf)
-	if err != nil {
+// CheckFile checks on the Glacier restore request for gf, making one
+// if there isn't one in progress, and returns the status. Run calls
+// this for each file it restores.
+func (r *GlacierRestorer) CheckFile(gf *registry.GenericFile) (restoreStatus int, errors []*service.ProcessingError) {
+	// We may get here because the copies in S3 or Wasabi could not be
+	// restored, so look specifically for the best copy in Glacier.
+	source := GlacierSource(RestorationSources(r.Context, gf))
+	if source == nil {
+		err := fmt.Errorf("File %s has no copy in Glacier or Glacier Deep Archive", gf.Identifier)
 		errors = append(errors, r.Error(gf.Identifier, err, true))
 		return RestoreError, errors
 	}
-	r.Context.Logger.Infof("Requesting Glacier restore of file '%s' (uuid %s) from %s", gf.Identifier, gf.UUID, storageRecord.URL)
-	statusCode, body, err := glacier.Restore(r.Context, storageRecord.URL)
-	r.Context.Logger.Infof("Response from Glacier (%d): %s", statusCode, body)
+	// Historical restorations need the version that was current
+	// at the requested date.
+	versionID := ""
+	if r.RestorationObject.AsOf != nil {
+		version, err := r.FindVersion(gf, source)
+		if err != nil {
+			errors = append(errors, r.Error(gf.Identifier, err, false))
+			return RestoreError, errors
+		}
+		versionID = version.VersionID
+	}
+
+	now := time.Now().UTC()
+	state := r.getState(gf, source, versionID)
+	defer r.addToEstimate(state)
+	if state.IsAvailable(now) {
+		return RestoreCompleted, errors
+	}
+	if now.Before(state.NextCheckAt) {
+		r.Context.Logger.Infof("Restore of %s is not expected to be done until %s", gf.Identifier, state.NextCheckAt.Format(time.RFC3339))
+		r.scheduleCheck(state.NextCheckAt)
+		return RestorePending, errors
+	}
+	restoreStatus, errors = r.checkStatus(gf, state, now)
+	if restoreStatus == RestorePending {
+		r.scheduleCheck(state.NextCheckAt)
+	}
+	r.saveState(state)
+	return restoreStatus, errors
+}
+
+// checkStatus checks the status of the Glacier copy of gf with a HEAD
+// request. If there's no restore request in progress, it makes one.
+func (r *GlacierRestorer) checkStatus(gf *registry.GenericFile, state *service.GlacierRestoreState, now time.Time) (restoreStatus int, errors []*service.ProcessingError) {
+	url := r.glacierURL(state.URL)
+	state.Checks++
+	status, err := glacier.Status(r.Context, url, state.VersionID)
 	if err != nil {
 		errors = append(errors, r.Error(gf.Identifier, err, false))
 		return RestoreError, errors
 	}
-	if statusCode == http.StatusNotFound {
+	switch {
+	case status.StatusCode == http.StatusNotFound:
 		err = fmt.Errorf("Glacier returned 404 - object not found.")
 		errors = append(errors, r.Error(gf.Identifier, err, true))
 		return RestoreError, errors
-	} else {
-		r.Context.Logger.Infof("Glacier returned StatusCode %d for %s", statusCode, gf.Identifier)
+	case status.StatusCode != http.StatusOK:
+		err = fmt.Errorf("Glacier status check returned %d", status.StatusCode)
+		errors = append(errors, r.Error(gf.Identifier, err, false))
+		return RestoreError, errors
+	case status.Available():
+		r.Context.Logger.Infof("File %s (%s) is available in S3", gf.Identifier, gf.UUID)
+		state.CompletedAt = now
+		state.ExpiresAt = status.ExpiresAt
+		return RestoreCompleted, errors
+	case status.Ongoing:
+		r.Context.Logger.Infof("Restoration request for %s (%s) is in progress", gf.Identifier, gf.UUID)
+		state.NextCheckAt = r.nextCheckTime(state, status.StorageClass, now)
+		return RestorePending, errors
+	}
+	return r.requestRestore(gf, url, status.StorageClass, state, now)
+}
+
+// requestRestore asks Glacier to restore gf and returns the status.
+func (r *GlacierRestorer) requestRestore(gf *registry.GenericFile, url, storageClass string, state *service.GlacierRestoreState, now time.Time) (restoreStatus int, errors []*service.ProcessingError) {
+	tier := r.tier(storageClass)
+	days := r.restoreDays()
+	r.Context.Logger.Infof("Requesting Glacier restore of file '%s' (uuid %s) from %s at %s tier", gf.Identifier, gf.UUID, state.URL, tier)
+	statusCode, body, err := glacier.RequestRestore(r.Context, url, state.VersionID, tier, days)
+	r.Context.Logger.Infof("Response from Glacier (%d): %s", statusCode, body)
+	if err == nil && statusCode == http.StatusServiceUnavailable && tier == glacier.TierExpedited {
+		// Expedited retrievals depend on available capacity.
+		// Standard retrievals are always accepted.
+		r.Context.Logger.Warningf("Expedited retrieval of %s is unavailable. Falling back to Standard tier.", gf.Identifier)
+		tier = glacier.TierStandard
+		statusCode, body, err = glacier.RequestRestore(r.Context, url, state.VersionID, tier, days)
+		r.Context.Logger.Infof("Response from Glacier (%d): %s", statusCode, body)
+	}
+	if err != nil {
+		errors = append(errors, r.Error(gf.Identifier, err, false))
+		return RestoreError, errors
 	}
 
 	switch statusCode {
 	case http.StatusOK:
 		// 200 means item has already been restored to S3
 		r.Context.Logger.Infof("File %s (%s) has been copied to S3", gf.Identifier, gf.UUID)
+		state.CompletedAt = now
 		restoreStatus = RestoreCompleted
 	case http.StatusForbidden:
 		// 403 means item is in S3 storage class, not Glacier.
@@ -154,6 +294,7 @@ func (r *GlacierRestorer) requestRestoration(gf *registry.GenericFile) (restoreS
 		// to Glacier. As long as it's in S3, we're OK to proceed.
 		if r.isInS3StorageClass(body) {
 			r.Context.Logger.Infof("File %s (%s) is already in S3 storage class", gf.Identifier, gf.UUID)
+			state.CompletedAt = now
 			restoreStatus = RestoreCompleted
 		} else {
 			// If this is forbidden for some other reason, it's likely
@@ -166,15 +307,29 @@ func (r *GlacierRestorer) requestRestoration(gf *registry.GenericFile) (restoreS
 		}
 	case http.StatusAccepted:
 		// 202/Accepted means the restore request has been queued
-		r.Context.Logger.Infof("Restoration request for %s (%s) has been accepted", gf.Identifier, gf.UUID)
+		state.RequestedAt = now
+		state.Tier = tier
+		state.Days = days
+		state.StorageClass = storageClass
+		state.Size = gf.Size
+		state.EstimatedCost = glacier.EstimateRestoreCost(tier, storageClass, gf.Size)
+		state.NextCheckAt = r.nextCheckTime(state, storageClass, now)
+		r.Context.Logger.Infof("Restoration request for %s (%s) has been accepted at %s tier. Estimated cost $%.4f, expected to be available by %s.",
+			gf.Identifier, gf.UUID, tier, state.EstimatedCost, state.NextCheckAt.Format(time.RFC3339))
 		restoreStatus = RestorePending
 	case http.StatusConflict:
 		// 409/Conflict means restore request is in progress
 		r.Context.Logger.Infof("Restoration request for %s (%s) was accepted earlier and is pending", gf.Identifier, gf.UUID)
+		state.NextCheckAt = r.nextCheckTime(state, storageClass, now)
 		restoreStatus = RestorePending
 	case http.StatusServiceUnavailable:
 		r.Context.Logger.Infof("Restoration request for %s (%s) temporarily denied: expedited restore service unavailable. Try again later.", gf.Identifier, gf.UUID)
+		state.NextCheckAt = now.Add(minGlacierCheckInterval)
 		restoreStatus = RestorePending
+	case http.StatusNotFound:
+		err = fmt.Errorf("Glacier returned 404 - object not found.")
+		errors = append(errors, r.Error(gf.Identifier, err, true))
+		restoreStatus = RestoreError
 	default:
 		err = fmt.Errorf("Glacier returned unexpected status %d: %s", statusCode, body)
 		errors = append(errors, r.Error(gf.Identifier, err, true))
@@ -183,6 +338,119 @@ func (r *GlacierRestorer) requestRestoration(gf *registry.GenericFile) (restoreS
 	return restoreStatus, errors
 }
 
+// getState returns the saved state of the restore request for gf, or a
+// new state if there isn't one for this copy.
+func (r *GlacierRestorer) getState(gf *registry.GenericFile, source *RestorationSource, versionID string) *service.GlacierRestoreState {
+	if r.Store != nil {
+		state, err := r.Store.GlacierRestoreGet(r.WorkItemID, gf.Identifier)
+		if err == nil && state != nil && state.URL == source.StorageRecord.URL && state.VersionID == versionID {
+			return state
+		}
+	}
+	return &service.GlacierRestoreState{
+		GenericFileIdentifier: gf.Identifier,
+		URL:                   source.StorageRecord.URL,
+		VersionID:             versionID,
+		Tier:                  r.tier(""),
+	}
+}
+
+// tier returns the retrieval tier to request for an object in
+// storageClass. That's the tier requested for this restoration, if
+// it's valid, or the default tier. Deep Archive has no Expedited tier,
+// so we use Standard there instead.
+func (r *GlacierRestorer) tier(storageClass string) string {
+	tier := r.RestorationObject.GlacierTier
+	if tier == "" {
+		return glacier.DefaultTier
+	}
+	if !glacier.IsValidTier(tier) {
+		r.Context.Logger.Warningf("Ignoring invalid Glacier tier '%s' for %s. Using %s.", tier, r.RestorationObject.Identifier, glacier.DefaultTier)
+		return glacier.DefaultTier
+	}
+	if tier == glacier.TierExpedited && storageClass == "DEEP_ARCHIVE" {
+		return glacier.TierStandard
+	}
+	return tier
+}
+
+// restoreDays returns the number of days restored copies should stay
+// in S3, never more than glacier.MaxDaysToLiveInRestoreBucket.
+func (r *GlacierRestorer) restoreDays() int {
+	days := r.RestorationObject.GlacierRestoreDays
+	if days <= 0 {
+		return glacier.DaysToLiveInRestoreBucket
+	}
+	if days > glacier.MaxDaysToLiveInRestoreBucket {
+		r.Context.Logger.Warningf("Glacier restore days %d for %s is over the limit. Using %d.", days, r.RestorationObject.Identifier, glacier.MaxDaysToLiveInRestoreBucket)
+		return glacier.MaxDaysToLiveInRestoreBucket
+	}
+	return days
+}
+
+// addToEstimate adds the restore request described by state to this
+// run's estimate, if we made the request.
+func (r *GlacierRestorer) addToEstimate(state *service.GlacierRestoreState) {
+	if state.RequestedAt.IsZero() {
+		return
+	}
+	if r.estimate == nil {
+		r.estimate = &service.GlacierRestoreEstimate{}
+	}
+	availableAt := state.RequestedAt.Add(glacier.ExpectedRestoreTime(state.Tier, state.StorageClass))
+	r.estimate.Add(state, availableAt)
+}
+
+// saveState saves the state of a restore request. If this fails, we'll
+// check the file's status again next time, which is harmless.
+func (r *GlacierRestorer) saveState(state *service.GlacierRestoreState) {
+	if r.Store == nil {
+		return
+	}
+	err := r.Store.GlacierRestoreSave(r.WorkItemID, state)
+	if err != nil {
+		r.Context.Logger.Warningf("Could not save Glacier restore state for %s: %v", state.GenericFileIdentifier, err)
+	}
+}
+
+// nextCheckTime returns when to check on a pending restore request.
+// That's when the request is expected to be done, if we know when it
+// was made. Otherwise, or if it's overdue, we check periodically.
+func (r *GlacierRestorer) nextCheckTime(state *service.GlacierRestoreState, storageClass string, now time.Time) time.Time {
+	expected := glacier.ExpectedRestoreTime(state.Tier, storageClass)
+	if !state.RequestedAt.IsZero() {
+		due := state.RequestedAt.Add(expected)
+		if due.After(now) {
+			return due
+		}
+	}
+	interval := expected / 4
+	if interval < minGlacierCheckInterval {
+		interval = minGlacierCheckInterval
+	}
+	return now.Add(interval)
+}
+
+// scheduleCheck notes that a pending request should be checked at t.
+func (r *GlacierRestorer) scheduleCheck(t time.Time) {
+	if r.nextCheck == nil || t.Before(*r.nextCheck) {
+		r.nextCheck = &t
+	}
+}
+
+// glacierURL returns url with the scheme and host replaced by
+// r.Endpoint, if it's set.
+func (r *GlacierRestorer) glacierURL(url string) string {
+	if r.Endpoint == "" {
+		return url
+	}
+	parts := strings.SplitN(url, "/", 4)
+	if len(parts) < 4 {
+		return url
+	}
+	return strings.TrimSuffix(r.Endpoint, "/") + "/" + parts[3]
+}
+
 func (r *GlacierRestorer) isInS3StorageClass(body string) bool {
 	// Body is typically ~200 bytes of XML
 	return strings.Contains(body, "InvalidObjectState")
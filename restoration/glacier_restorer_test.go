@@ -0,0 +1,353 @@
+package restoration_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/network/glacier"
+	"github.com/APTrust/preservation-services/restoration"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// glacierStandIn emulates the parts of the S3 API we use to restore
+// objects from Glacier: POST ?restore and HEAD with the
+// x-amz-storage-class and x-amz-restore headers. If noExpedited is
+// set, it turns away Expedited requests the way S3 does when there's
+// no capacity for them. Keys in missing return 404.
+type glacierStandIn struct {
+	mutex       sync.Mutex
+	requested   map[string]bool
+	done        map[string]bool
+	missing     map[string]bool
+	bodies      []string
+	posts       int
+	heads       int
+	noExpedited bool
+}
+
+func newGlacierStandIn() *glacierStandIn {
+	return &glacierStandIn{
+		requested: make(map[string]bool),
+		done:      make(map[string]bool),
+		missing:   make(map[string]bool),
+	}
+}
+
+func (g *glacierStandIn) finish(path string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.done[path] = true
+}
+
+func (g *glacierStandIn) counts() (posts, heads int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.posts, g.heads
+}
+
+func (g *glacierStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	key := r.URL.Path
+	if g.missing[key] {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		g.posts++
+		body, _ := io.ReadAll(r.Body)
+		g.bodies = append(g.bodies, string(body))
+		switch {
+		case g.noExpedited && strings.Contains(string(body), "<Tier>Expedited</Tier>"):
+			writeS3Error(w, http.StatusServiceUnavailable, "GlacierExpeditedRetrievalNotAvailable")
+		case g.done[key]:
+			w.WriteHeader(http.StatusOK)
+		case g.requested[key]:
+			writeS3Error(w, http.StatusConflict, "RestoreAlreadyInProgress")
+		default:
+			g.requested[key] = true
+			w.WriteHeader(http.StatusAccepted)
+		}
+	case http.MethodHead:
+		g.heads++
+		w.Header().Set("x-amz-storage-class", "GLACIER")
+		if g.done[key] {
+			expires := time.Now().Add(10 * 24 * time.Hour).UTC().Format(http.TimeFormat)
+			w.Header().Set("x-amz-restore", fmt.Sprintf(`ongoing-request="false", expiry-date="%s"`, expires))
+		} else if g.requested[key] {
+			w.Header().Set("x-amz-restore", `ongoing-request="true"`)
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type memoryGlacierStore struct {
+	states map[string]*service.GlacierRestoreState
+}
+
+func (s *memoryGlacierStore) GlacierRestoreGet(workItemID int64, fileIdentifier string) (*service.GlacierRestoreState, error) {
+	state := s.states[fileIdentifier]
+	if state == nil {
+		return nil, nil
+	}
+	stateCopy := *state
+	return &stateCopy, nil
+}
+
+func (s *memoryGlacierStore) GlacierRestoreSave(workItemID int64, state *service.GlacierRestoreState) error {
+	stateCopy := *state
+	s.states[state.GenericFileIdentifier] = &stateCopy
+	return nil
+}
+
+// rewind makes the next check on identifier due now.
+func (s *memoryGlacierStore) rewind(identifier string) {
+	s.states[identifier].NextCheckAt = time.Now().Add(-1 * time.Second)
+}
+
+func glacierTestFile(t *testing.T, config *common.Config) (*registry.GenericFile, string) {
+	for _, bucket := range config.PreservationBuckets {
+		if bucket.StorageClass == constants.StorageClassGlacier {
+			path := fmt.Sprintf("/%s/%s", bucket.Bucket, sourceTestUUID)
+			gf := &registry.GenericFile{
+				Identifier: "test.edu/bag/data/glacier.txt",
+				UUID:       sourceTestUUID,
+				StorageRecords: []*registry.StorageRecord{
+					{URL: fmt.Sprintf("https://%s%s", bucket.Host, path)},
+				},
+			}
+			return gf, path
+		}
+	}
+	require.FailNow(t, "Test config has no Glacier bucket")
+	return nil, ""
+}
+
+func TestGlacierRestorerCheckFile(t *testing.T) {
+	standIn := newGlacierStandIn()
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	config := common.NewConfig()
+	context := &common.Context{
+		Config: config,
+		Logger: logger.DiscardLogger("glacier_restorer_test"),
+	}
+	gf, path := glacierTestFile(t, config)
+	store := &memoryGlacierStore{states: make(map[string]*service.GlacierRestoreState)}
+	restorer := restoration.NewGlacierRestorer(context, 4321, &service.RestorationObject{
+		Identifier:      gf.Identifier,
+		RestorationType: constants.RestorationTypeFile,
+	})
+	restorer.Store = store
+	restorer.Endpoint = server.URL
+
+	// First check finds no restore request and makes one.
+	status, errors := restorer.CheckFile(gf)
+	assert.Empty(t, errors)
+	assert.Equal(t, restoration.RestorePending, status)
+	posts, heads := standIn.counts()
+	assert.Equal(t, 1, posts)
+	assert.Equal(t, 1, heads)
+	state := store.states[gf.Identifier]
+	require.NotNil(t, state)
+	assert.False(t, state.RequestedAt.IsZero())
+	assert.Equal(t, state.RequestedAt.Add(5*time.Hour), state.NextCheckAt)
+
+	// Checking again before the request is due doesn't touch S3.
+	status, errors = restorer.CheckFile(gf)
+	assert.Empty(t, errors)
+	assert.Equal(t, restoration.RestorePending, status)
+	posts, heads = standIn.counts()
+	assert.Equal(t, 1, posts)
+	assert.Equal(t, 1, heads)
+
+	// When the request is overdue, we check its status, but don't
+	// make another request while it's in progress.
+	store.rewind(gf.Identifier)
+	status, errors = restorer.CheckFile(gf)
+	assert.Empty(t, errors)
+	assert.Equal(t, restoration.RestorePending, status)
+	posts, heads = standIn.counts()
+	assert.Equal(t, 1, posts)
+	assert.Equal(t, 2, heads)
+	assert.True(t, store.states[gf.Identifier].NextCheckAt.After(time.Now()))
+
+	// Once the restore is done, the file is available until the
+	// restored copy expires, and we stop checking.
+	standIn.finish(path)
+	store.rewind(gf.Identifier)
+	status, errors = restorer.CheckFile(gf)
+	assert.Empty(t, errors)
+	assert.Equal(t, restoration.RestoreCompleted, status)
+	state = store.states[gf.Identifier]
+	assert.False(t, state.CompletedAt.IsZero())
+	assert.True(t, state.ExpiresAt.After(time.Now()))
+	assert.Equal(t, 3, state.Checks)
+
+	status, errors = restorer.CheckFile(gf)
+	assert.Empty(t, errors)
+	assert.Equal(t, restoration.RestoreCompleted, status)
+	posts, heads = standIn.counts()
+	assert.Equal(t, 1, posts)
+	assert.Equal(t, 3, heads)
+}
+
+func TestGlacierRestorerCheckFileWithoutStore(t *testing.T) {
+	standIn := newGlacierStandIn()
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	config := common.NewConfig()
+	context := &common.Context{
+		Config: config,
+		Logger: logger.DiscardLogger("glacier_restorer_test"),
+	}
+	gf, _ := glacierTestFile(t, config)
+	restorer := restoration.NewGlacierRestorer(context, 4321, &service.RestorationObject{
+		Identifier:      gf.Identifier,
+		RestorationType: constants.RestorationTypeFile,
+	})
+	assert.Nil(t, restorer.Store)
+	restorer.Endpoint = server.URL
+
+	// With nothing to remember the first request, we check the
+	// status every time, but still request the restore only once.
+	for i := 0; i < 3; i++ {
+		status, errors := restorer.CheckFile(gf)
+		assert.Empty(t, errors)
+		assert.Equal(t, restoration.RestorePending, status)
+	}
+	posts, heads := standIn.counts()
+	assert.Equal(t, 1, posts)
+	assert.Equal(t, 3, heads)
+}
+
+func TestGlacierRestorerExpeditedFallback(t *testing.T) {
+	standIn := newGlacierStandIn()
+	standIn.noExpedited = true
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	config := common.NewConfig()
+	context := &common.Context{
+		Config: config,
+		Logger: logger.DiscardLogger("glacier_restorer_test"),
+	}
+	gf, _ := glacierTestFile(t, config)
+	gf.Size = 1 << 30
+	store := &memoryGlacierStore{states: make(map[string]*service.GlacierRestoreState)}
+	restorer := restoration.NewGlacierRestorer(context, 4321, &service.RestorationObject{
+		Identifier:         gf.Identifier,
+		RestorationType:    constants.RestorationTypeFile,
+		GlacierTier:        glacier.TierExpedited,
+		GlacierRestoreDays: 3,
+	})
+	restorer.Store = store
+	restorer.Endpoint = server.URL
+
+	status, errors := restorer.CheckFile(gf)
+	assert.Empty(t, errors)
+	assert.Equal(t, restoration.RestorePending, status)
+	require.Equal(t, 2, len(standIn.bodies))
+	assert.Equal(t, "<RestoreRequest><Days>3</Days><GlacierJobParameters><Tier>Expedited</Tier></GlacierJobParameters></RestoreRequest>", standIn.bodies[0])
+	assert.Equal(t, "<RestoreRequest><Days>3</Days><GlacierJobParameters><Tier>Standard</Tier></GlacierJobParameters></RestoreRequest>", standIn.bodies[1])
+
+	state := store.states[gf.Identifier]
+	require.NotNil(t, state)
+	assert.Equal(t, glacier.TierStandard, state.Tier)
+	assert.Equal(t, 3, state.Days)
+	assert.Equal(t, "GLACIER", state.StorageClass)
+	assert.Equal(t, gf.Size, state.Size)
+	assert.InDelta(t, 0.01005, state.EstimatedCost, 0.000001)
+	assert.Equal(t, state.RequestedAt.Add(5*time.Hour), state.NextCheckAt)
+}
+
+func TestGlacierRestorerClampsRestoreDays(t *testing.T) {
+	standIn := newGlacierStandIn()
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	config := common.NewConfig()
+	context := &common.Context{
+		Config: config,
+		Logger: logger.DiscardLogger("glacier_restorer_test"),
+	}
+	gf, _ := glacierTestFile(t, config)
+	store := &memoryGlacierStore{states: make(map[string]*service.GlacierRestoreState)}
+	restorer := restoration.NewGlacierRestorer(context, 4321, &service.RestorationObject{
+		Identifier:         gf.Identifier,
+		RestorationType:    constants.RestorationTypeFile,
+		GlacierTier:        "Overnight",
+		GlacierRestoreDays: 100000,
+	})
+	restorer.Store = store
+	restorer.Endpoint = server.URL
+
+	_, errors := restorer.CheckFile(gf)
+	assert.Empty(t, errors)
+	require.Equal(t, 1, len(standIn.bodies))
+	assert.Equal(t, fmt.Sprintf("<RestoreRequest><Days>%d</Days><GlacierJobParameters><Tier>%s</Tier></GlacierJobParameters></RestoreRequest>", glacier.MaxDaysToLiveInRestoreBucket, glacier.DefaultTier), standIn.bodies[0])
+}
+
+// Regression test: for object restorations, Run dropped the errors
+// from individual files and reported only that the restore was still
+// in progress.
+func TestGlacierRestorerRunKeepsFileErrors(t *testing.T) {
+	standIn := newGlacierStandIn()
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	config := common.NewConfig()
+	context := &common.Context{
+		Config: config,
+		Logger: logger.DiscardLogger("glacier_restorer_test"),
+	}
+	gf, _ := glacierTestFile(t, config)
+	gf.ID = 1
+	missing, missingPath := glacierTestFile(t, config)
+	missing.ID = 2
+	missing.Identifier = "test.edu/bag/data/missing.txt"
+	missing.UUID = "6a1c6b1e-7a1f-4a8e-9d4b-000000000003"
+	missingPath = strings.Replace(missingPath, sourceTestUUID, missing.UUID, 1)
+	missing.StorageRecords[0].URL = strings.Replace(missing.StorageRecords[0].URL, sourceTestUUID, missing.UUID, 1)
+	standIn.missing[missingPath] = true
+
+	registryServer := httptest.NewServer(&bagRegistry{files: []*registry.GenericFile{gf, missing}})
+	defer registryServer.Close()
+	client, err := network.NewRegistryClient(registryServer.URL, "v3", "user", "key", constants.AdminAPIPrefix, context.Logger)
+	require.Nil(t, err)
+	context.RegistryClient = client
+
+	restObj := &service.RestorationObject{
+		Identifier:      "test.edu/bag",
+		RestorationType: constants.RestorationTypeObject,
+	}
+	restorer := restoration.NewGlacierRestorer(context, 4321, restObj)
+	restorer.Store = &memoryGlacierStore{states: make(map[string]*service.GlacierRestoreState)}
+	restorer.Endpoint = server.URL
+
+	fileCount, errors := restorer.Run()
+	assert.Equal(t, 2, fileCount)
+	require.Equal(t, 1, len(errors))
+	assert.True(t, errors[0].IsFatal)
+	assert.Equal(t, missing.Identifier, errors[0].Identifier)
+	assert.Contains(t, errors[0].Message, "404")
+	assert.False(t, restObj.AllFilesRestored)
+}
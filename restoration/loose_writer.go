@@ -0,0 +1,73 @@
+package restoration
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// LooseWriter writes each file of a restored bag as a separate object
+// in the restoration target, under a common prefix. Depositors who want
+// only a few files from a large bag can download just those files.
+//
+// Unlike the pipe writers, LooseWriter uploads synchronously, so
+// AddFile returns upload errors directly. If a restoration fails
+// partway through, the files already written stay in the bucket, and
+// the next attempt overwrites them.
+type LooseWriter struct {
+	Target Target
+	Prefix string
+}
+
+// NewLooseWriter returns a LooseWriter that writes files to target
+// under prefix. A file whose tar header name is "bag/data/file.txt"
+// goes to "<prefix>/bag/data/file.txt".
+func NewLooseWriter(target Target, prefix string) *LooseWriter {
+	return &LooseWriter{
+		Target: target,
+		Prefix: strings.TrimSuffix(prefix, "/"),
+	}
+}
+
+// Key returns the S3 key for the file with the given tar header name.
+func (w *LooseWriter) Key(name string) string {
+	if w.Prefix == "" {
+		return name
+	}
+	return w.Prefix + "/" + name
+}
+
+// AddFile uploads the file described by header, with data from reader
+// r, and returns its digests for each of manifestAlgs.
+func (w *LooseWriter) AddFile(header *tar.Header, r io.Reader, manifestAlgs []string) (digests map[string]string, err error) {
+	digests = make(map[string]string)
+	if header.Name == "" {
+		return digests, fmt.Errorf("File name is missing.")
+	}
+	if header.Size < 0 {
+		return digests, fmt.Errorf("File size cannot be negative for %s.", header.Name)
+	}
+	hashes := GetHashes(manifestAlgs)
+	writers := make([]io.Writer, 0, len(hashes))
+	for _, hash := range hashes {
+		writers = append(writers, hash)
+	}
+	reader := io.TeeReader(r, io.MultiWriter(writers...))
+	key := w.Key(header.Name)
+	bytesWritten, err := w.Target.PutObject(key, reader, header.Size, nil)
+	if err != nil {
+		return digests, fmt.Errorf("Error copying %s to %s: %v", header.Name, w.Target.URL(key), err)
+	}
+	if bytesWritten != header.Size {
+		return digests, fmt.Errorf("AddFile copied only %d of %d bytes for file %s",
+			bytesWritten, header.Size, header.Name)
+	}
+	for alg, hash := range hashes {
+		digests[alg] = fmt.Sprintf("%x", hash.Sum(nil))
+	}
+	return digests, nil
+}
+
+// Finish is a no-op. Each file is complete once AddFile returns.
+func (w *LooseWriter) Finish() {}
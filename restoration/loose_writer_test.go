@@ -0,0 +1,71 @@
+package restoration_test
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/restoration"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putRecorder is a fake S3 server that records PUT requests.
+type putRecorder struct {
+	sync.Mutex
+	objects map[string]string
+}
+
+func (p *putRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+		return
+	}
+	data, _ := io.ReadAll(r.Body)
+	p.Lock()
+	p.objects[strings.TrimPrefix(r.URL.Path, "/")] = string(data)
+	p.Unlock()
+	w.Header().Set("ETag", `"abc123"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestLooseWriter(t *testing.T) {
+	recorder := &putRecorder{objects: make(map[string]string)}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.Nil(t, err)
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4("key", "secret", ""),
+		Region:       "us-east-1",
+		BucketLookup: minio.BucketLookupPath,
+		MaxRetries:   1,
+	})
+	require.Nil(t, err)
+
+	target := restoration.NewS3Target(client, constants.StorageProviderAWS, "restore-bucket", "")
+	w := restoration.NewLooseWriter(target, "test.edu/")
+	assert.Equal(t, "test.edu/bag/data/file.txt", w.Key("bag/data/file.txt"))
+
+	header := &tar.Header{Name: "bag/data/file.txt", Size: 11}
+	digests, err := w.AddFile(header, strings.NewReader("sample data"), constants.APTrustRestorationAlgorithms)
+	require.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("sample data"))), digests[constants.AlgSha256])
+	// Over plain HTTP, minio sends the body in signed chunks.
+	require.Contains(t, recorder.objects, "restore-bucket/test.edu/bag/data/file.txt")
+	assert.Contains(t, recorder.objects["restore-bucket/test.edu/bag/data/file.txt"], "sample data")
+	w.Finish()
+
+	_, err = w.AddFile(&tar.Header{Size: 1}, strings.NewReader("x"), nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,185 @@
+package restoration
+
+import (
+	"bytes"
+	ctx "context"
+	"fmt"
+	"strings"
+
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/minio/minio-go/v7"
+)
+
+// MultipartWriter uploads everything written to it to an S3Target as
+// a multipart upload, recording each part in a RestorationProgress.
+// If we're interrupted, a new MultipartWriter can pick up the same
+// upload from the recorded parts.
+//
+// Writes are buffered until we have a full part, so the caller can
+// resume only from points at or before the end of the last uploaded
+// part. See ResumeAt.
+type MultipartWriter struct {
+	Target   *S3Target
+	Progress *service.RestorationProgress
+
+	// OnPart, if set, is called after each part is uploaded. The
+	// BagRestorer uses this to save its progress.
+	OnPart func()
+
+	core    minio.Core
+	buf     *bytes.Buffer
+	offset  int64
+	discard int64
+}
+
+// NewMultipartWriter returns a MultipartWriter that uploads to
+// progress.Key in target. If progress has no UploadID, this starts a
+// new multipart upload with parts of partSize bytes. Otherwise, it
+// continues the existing upload with the recorded part size.
+func NewMultipartWriter(target *S3Target, progress *service.RestorationProgress, partSize int64) (*MultipartWriter, error) {
+	w := &MultipartWriter{
+		Target:   target,
+		Progress: progress,
+		core:     minio.Core{Client: target.Client},
+	}
+	if progress.UploadID == "" {
+		uploadID, err := w.core.NewMultipartUpload(ctx.Background(), target.Bucket, target.Key(progress.Key), minio.PutObjectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		progress.UploadID = uploadID
+		progress.PartSize = partSize
+		progress.Parts = nil
+	}
+	w.buf = bytes.NewBuffer(make([]byte, 0, progress.PartSize))
+	w.offset = progress.UploadedBytes()
+	return w, nil
+}
+
+// Verify checks that S3 still has every part we recorded. S3 discards
+// incomplete uploads after a while, depending on the bucket's lifecycle
+// rules.
+func (w *MultipartWriter) Verify() error {
+	etags := make(map[int]string, len(w.Progress.Parts))
+	marker := 0
+	for {
+		result, err := w.core.ListObjectParts(ctx.Background(), w.Target.Bucket, w.Target.Key(w.Progress.Key), w.Progress.UploadID, marker, 1000)
+		if err != nil {
+			return err
+		}
+		for _, part := range result.ObjectParts {
+			etags[part.PartNumber] = part.ETag
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+	for _, part := range w.Progress.Parts {
+		if trimETag(etags[part.PartNumber]) != trimETag(part.ETag) {
+			return fmt.Errorf("part %d of upload %s is missing or has changed", part.PartNumber, w.Progress.UploadID)
+		}
+	}
+	return nil
+}
+
+// ResumeAt tells the writer that the caller will start writing from
+// offset, which must be at or before the end of the uploaded parts.
+// Bytes up to the end of the uploaded parts are discarded, since S3
+// already has them.
+func (w *MultipartWriter) ResumeAt(offset int64) error {
+	uploaded := w.Progress.UploadedBytes()
+	if offset > uploaded {
+		return fmt.Errorf("cannot resume at byte %d, since only %d bytes were uploaded", offset, uploaded)
+	}
+	w.offset = offset
+	w.discard = uploaded - offset
+	w.buf.Reset()
+	return nil
+}
+
+// Offset returns the number of bytes written so far, including
+// those written before we resumed.
+func (w *MultipartWriter) Offset() int64 {
+	return w.offset
+}
+
+// Write buffers p, uploading a part each time the buffer is full.
+func (w *MultipartWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.discard > 0 {
+			n := int64(len(p))
+			if n > w.discard {
+				n = w.discard
+			}
+			w.discard -= n
+			w.offset += n
+			written += int(n)
+			p = p[n:]
+			continue
+		}
+		room := int(w.Progress.PartSize) - w.buf.Len()
+		n := len(p)
+		if n > room {
+			n = room
+		}
+		w.buf.Write(p[:n])
+		w.offset += int64(n)
+		written += n
+		p = p[n:]
+		if int64(w.buf.Len()) == w.Progress.PartSize {
+			if err := w.uploadPart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Complete uploads whatever is left in the buffer as the last part and
+// completes the multipart upload. It returns the size of the object.
+func (w *MultipartWriter) Complete() (int64, error) {
+	if w.buf.Len() > 0 || len(w.Progress.Parts) == 0 {
+		if err := w.uploadPart(); err != nil {
+			return 0, err
+		}
+	}
+	parts := make([]minio.CompletePart, len(w.Progress.Parts))
+	for i, part := range w.Progress.Parts {
+		parts[i] = minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag}
+	}
+	_, err := w.core.CompleteMultipartUpload(ctx.Background(), w.Target.Bucket, w.Target.Key(w.Progress.Key), w.Progress.UploadID, parts, minio.PutObjectOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return w.Progress.UploadedBytes(), nil
+}
+
+// Abort aborts the multipart upload, so S3 can discard its parts.
+func (w *MultipartWriter) Abort() error {
+	return w.core.AbortMultipartUpload(ctx.Background(), w.Target.Bucket, w.Target.Key(w.Progress.Key), w.Progress.UploadID)
+}
+
+func (w *MultipartWriter) uploadPart() error {
+	partNumber := len(w.Progress.Parts) + 1
+	size := int64(w.buf.Len())
+	part, err := w.core.PutObjectPart(ctx.Background(), w.Target.Bucket, w.Target.Key(w.Progress.Key), w.Progress.UploadID, partNumber, bytes.NewReader(w.buf.Bytes()), size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return fmt.Errorf("error uploading part %d: %v", partNumber, err)
+	}
+	w.Progress.Parts = append(w.Progress.Parts, &service.UploadedPart{
+		PartNumber: partNumber,
+		ETag:       part.ETag,
+		Size:       size,
+	})
+	w.buf.Reset()
+	if w.OnPart != nil {
+		w.OnPart()
+	}
+	return nil
+}
+
+func trimETag(etag string) string {
+	return strings.Trim(etag, `"`)
+}
@@ -0,0 +1,237 @@
+package restoration_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/restoration"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const multipartTestPartSize = 5 * 1024 * 1024
+
+// fakeMultipartS3 implements just enough of the S3 multipart upload
+// API to test MultipartWriter.
+type fakeMultipartS3 struct {
+	sync.Mutex
+	uploads   map[string]map[int][]byte
+	completed map[string][]byte
+	failPart  int
+	nextID    int
+}
+
+func newFakeMultipartS3() *fakeMultipartS3 {
+	return &fakeMultipartS3{
+		uploads:   make(map[string]map[int][]byte),
+		completed: make(map[string][]byte),
+	}
+}
+
+func (f *fakeMultipartS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		uploadID = fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[uploadID] = make(map[int][]byte)
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>b</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, uploadID)
+	case r.Method == http.MethodPut && uploadID != "":
+		parts, ok := f.uploads[uploadID]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		if partNumber == f.failPart {
+			f.failPart = 0
+			writeS3Error(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		data := decodeAWSChunked(r)
+		parts[partNumber] = data
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(data)))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && uploadID != "":
+		parts, ok := f.uploads[uploadID]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		fmt.Fprintf(w, `<ListPartsResult><Bucket>b</Bucket><Key>%s</Key><UploadId>%s</UploadId><IsTruncated>false</IsTruncated>`, key, uploadID)
+		for _, n := range sortedPartNumbers(parts) {
+			fmt.Fprintf(w, `<Part><PartNumber>%d</PartNumber><ETag>"%x"</ETag><Size>%d</Size></Part>`, n, md5.Sum(parts[n]), len(parts[n]))
+		}
+		fmt.Fprint(w, `</ListPartsResult>`)
+	case r.Method == http.MethodPost && uploadID != "":
+		parts := f.uploads[uploadID]
+		var object []byte
+		for _, n := range sortedPartNumbers(parts) {
+			object = append(object, parts[n]...)
+		}
+		f.completed[key] = object
+		delete(f.uploads, uploadID)
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>b</Bucket><Key>%s</Key><ETag>"abc"</ETag></CompleteMultipartUploadResult>`, key)
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func sortedPartNumbers(parts map[int][]byte) []int {
+	numbers := make([]int, 0, len(parts))
+	for n := range parts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// decodeAWSChunked returns the body of a request, removing the chunk
+// signatures minio adds when it streams over plain HTTP.
+func decodeAWSChunked(r *http.Request) []byte {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		data, _ := io.ReadAll(r.Body)
+		return data
+	}
+	reader := bufio.NewReader(r.Body)
+	var data []byte
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return data
+		}
+		size, _ := strconv.ParseInt(strings.SplitN(strings.TrimSpace(line), ";", 2)[0], 16, 64)
+		if size == 0 {
+			return data
+		}
+		chunk := make([]byte, size+2)
+		io.ReadFull(reader, chunk)
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func newMultipartTestTarget(t *testing.T, serverURL string) *restoration.S3Target {
+	u, err := url.Parse(serverURL)
+	require.Nil(t, err)
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4("key", "secret", ""),
+		Region:       "us-east-1",
+		BucketLookup: minio.BucketLookupPath,
+		MaxRetries:   1,
+	})
+	require.Nil(t, err)
+	return restoration.NewS3Target(client, constants.StorageProviderAWS, "restore-bucket", "")
+}
+
+func TestMultipartWriter(t *testing.T) {
+	s3 := newFakeMultipartS3()
+	server := httptest.NewServer(s3)
+	defer server.Close()
+	target := newMultipartTestTarget(t, server.URL)
+
+	data := make([]byte, 2*multipartTestPartSize+1234)
+	rand.New(rand.NewSource(3)).Read(data)
+
+	progress := &service.RestorationProgress{Key: "test.edu/bag.tar"}
+	writer, err := restoration.NewMultipartWriter(target, progress, multipartTestPartSize)
+	require.Nil(t, err)
+	assert.NotEmpty(t, progress.UploadID)
+	assert.Equal(t, int64(multipartTestPartSize), progress.PartSize)
+
+	parts := 0
+	writer.OnPart = func() { parts++ }
+	n, err := io.Copy(writer, bytes.NewReader(data))
+	require.Nil(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, int64(len(data)), writer.Offset())
+
+	// Two full parts are uploaded. The rest waits for Complete.
+	assert.Equal(t, 2, parts)
+	assert.Equal(t, int64(2*multipartTestPartSize), progress.UploadedBytes())
+	require.Nil(t, writer.Verify())
+
+	size, err := writer.Complete()
+	require.Nil(t, err)
+	assert.Equal(t, int64(len(data)), size)
+	assert.Equal(t, 3, len(progress.Parts))
+	assert.Equal(t, data, s3.completed["restore-bucket/test.edu/bag.tar"])
+}
+
+func TestMultipartWriterResume(t *testing.T) {
+	s3 := newFakeMultipartS3()
+	server := httptest.NewServer(s3)
+	defer server.Close()
+	target := newMultipartTestTarget(t, server.URL)
+
+	data := make([]byte, 3*multipartTestPartSize+100)
+	rand.New(rand.NewSource(4)).Read(data)
+
+	// The third part fails, as if we lost our connection.
+	s3.failPart = 3
+	progress := &service.RestorationProgress{Key: "test.edu/bag.tar"}
+	writer, err := restoration.NewMultipartWriter(target, progress, multipartTestPartSize)
+	require.Nil(t, err)
+	_, err = io.Copy(writer, bytes.NewReader(data))
+	require.NotNil(t, err)
+	require.Equal(t, 2, len(progress.Parts))
+
+	// A new writer resumes from the saved progress. The caller goes
+	// back to some point before the end of the uploaded parts, and the
+	// writer discards what S3 already has.
+	writer, err = restoration.NewMultipartWriter(target, progress, 0)
+	require.Nil(t, err)
+	require.Nil(t, writer.Verify())
+	assert.NotNil(t, writer.ResumeAt(progress.UploadedBytes()+1))
+	resumeAt := int64(multipartTestPartSize + 500)
+	require.Nil(t, writer.ResumeAt(resumeAt))
+	_, err = io.Copy(writer, bytes.NewReader(data[resumeAt:]))
+	require.Nil(t, err)
+	_, err = writer.Complete()
+	require.Nil(t, err)
+	assert.Equal(t, data, s3.completed["restore-bucket/test.edu/bag.tar"])
+}
+
+func TestMultipartWriterVerify(t *testing.T) {
+	s3 := newFakeMultipartS3()
+	server := httptest.NewServer(s3)
+	defer server.Close()
+	target := newMultipartTestTarget(t, server.URL)
+
+	progress := &service.RestorationProgress{Key: "test.edu/bag.tar"}
+	writer, err := restoration.NewMultipartWriter(target, progress, multipartTestPartSize)
+	require.Nil(t, err)
+	_, err = writer.Write(make([]byte, multipartTestPartSize))
+	require.Nil(t, err)
+	require.Nil(t, writer.Verify())
+
+	// Part changed
+	progress.Parts[0].ETag = "something-else"
+	assert.NotNil(t, writer.Verify())
+
+	// Upload aborted
+	require.Nil(t, writer.Abort())
+	assert.NotNil(t, writer.Verify())
+}
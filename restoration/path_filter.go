@@ -0,0 +1,94 @@
+package restoration
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PathFilter decides which payload files go into a partial restoration.
+// Patterns are globs over GenericFile.PathInBag, such as
+// "data/images/*.tif". In addition to the usual path.Match syntax, a
+// "**" segment matches any number of directories, so "data/images/**"
+// matches everything under data/images.
+//
+// The filter applies only to payload files. Tag files are always
+// restored, since a bag isn't much use without them.
+type PathFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// NewPathFilter returns a PathFilter, or an error if any of the
+// patterns is malformed.
+func NewPathFilter(include, exclude []string) (*PathFilter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid restoration path pattern '%s': %v", pattern, err)
+		}
+	}
+	return &PathFilter{
+		Include: include,
+		Exclude: exclude,
+	}, nil
+}
+
+// IsPayload returns true if pathInBag is in the bag's data directory.
+func IsPayload(pathInBag string) bool {
+	return strings.HasPrefix(pathInBag, "data/")
+}
+
+// Matches returns true if the file at pathInBag should be restored.
+// Payload files are restored if they match at least one include
+// pattern (or there are no include patterns) and no exclude pattern.
+func (f *PathFilter) Matches(pathInBag string) bool {
+	if !IsPayload(pathInBag) {
+		return true
+	}
+	included := len(f.Include) == 0
+	for _, pattern := range f.Include {
+		if GlobMatch(pattern, pathInBag) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range f.Exclude {
+		if GlobMatch(pattern, pathInBag) {
+			return false
+		}
+	}
+	return true
+}
+
+// GlobMatch returns true if name matches pattern. Each path segment is
+// matched with path.Match, except that a "**" segment matches zero or
+// more whole segments. Malformed patterns match nothing.
+func GlobMatch(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		matched, err := path.Match(pattern[0], name[0])
+		if err != nil || !matched {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,57 @@
+package restoration_test
+
+import (
+	"testing"
+
+	"github.com/APTrust/preservation-services/restoration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobMatch(t *testing.T) {
+	assert.True(t, restoration.GlobMatch("data/*.txt", "data/file.txt"))
+	assert.False(t, restoration.GlobMatch("data/*.txt", "data/sub/file.txt"))
+	assert.True(t, restoration.GlobMatch("data/**", "data/sub/file.txt"))
+	assert.True(t, restoration.GlobMatch("data/**/*.tif", "data/image.tif"))
+	assert.True(t, restoration.GlobMatch("data/**/*.tif", "data/a/b/image.tif"))
+	assert.False(t, restoration.GlobMatch("data/**/*.tif", "data/a/b/image.jpg"))
+	assert.True(t, restoration.GlobMatch("**/README", "data/docs/README"))
+	assert.False(t, restoration.GlobMatch("data/images", "data/images/one.tif"))
+	assert.False(t, restoration.GlobMatch("data/[", "data/["))
+}
+
+func TestNewPathFilter(t *testing.T) {
+	_, err := restoration.NewPathFilter([]string{"data/*"}, []string{"data/["})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "data/[")
+
+	filter, err := restoration.NewPathFilter([]string{"data/**"}, nil)
+	require.Nil(t, err)
+	require.NotNil(t, filter)
+}
+
+func TestPathFilterMatches(t *testing.T) {
+	filter, err := restoration.NewPathFilter(
+		[]string{"data/images/**", "data/*.csv"},
+		[]string{"**/*.tmp"})
+	require.Nil(t, err)
+
+	assert.True(t, filter.Matches("data/images/one.tif"))
+	assert.True(t, filter.Matches("data/images/2020/two.tif"))
+	assert.True(t, filter.Matches("data/index.csv"))
+	assert.False(t, filter.Matches("data/images/scratch.tmp"))
+	assert.False(t, filter.Matches("data/docs/readme.txt"))
+	assert.False(t, filter.Matches("data/sub/index.csv"))
+
+	// Tag files are always included, even if an exclude pattern
+	// matches them.
+	assert.True(t, filter.Matches("bag-info.txt"))
+	assert.True(t, filter.Matches("aptrust-info.txt"))
+	assert.True(t, filter.Matches("custom-tags/notes.tmp"))
+
+	// No include patterns means include all payload.
+	filter, err = restoration.NewPathFilter(nil, []string{"data/private/**"})
+	require.Nil(t, err)
+	assert.True(t, filter.Matches("data/public/file.txt"))
+	assert.False(t, filter.Matches("data/private/file.txt"))
+}
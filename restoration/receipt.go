@@ -0,0 +1,349 @@
+package restoration
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+)
+
+// A restoration receipt lists every file we wrote into a restored bag,
+// with the digests we calculated and the Registry checksums we
+// compared them to. We sign it with the environment's
+// RestorationReceiptKey and upload it next to the bag, so auditors can
+// check the restored bag against what we preserved.
+//
+// As we write each file, we append its entry to a journal on local
+// disk, next to the manifests. Like the manifests, the journal is
+// truncated to its size at the resume point when we resume an
+// interrupted restoration.
+
+// LoadReceiptKey decodes a base64-encoded Ed25519 private key or
+// 32-byte seed. It returns nil if encoded is empty.
+func LoadReceiptKey(encoded string) (ed25519.PrivateKey, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid restoration receipt key: %v", err)
+	}
+	switch len(data) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(data), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(data), nil
+	}
+	return nil, fmt.Errorf("Invalid restoration receipt key: expected %d or %d bytes, got %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(data))
+}
+
+// ParseReceiptPublicKey decodes a base64-encoded Ed25519 public key.
+func ParseReceiptPublicKey(encoded string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid public key: %v", err)
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Invalid public key: expected %d bytes, got %d", ed25519.PublicKeySize, len(data))
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// ReceiptKey returns the key of the receipt in the restoration target.
+// It goes next to the bag, not inside it, so a loose bag's receipt
+// doesn't become part of the bag.
+func (r *BagRestorer) ReceiptKey() string {
+	return strings.TrimSuffix(r.RestoredKey(), "/") + ".receipt.json"
+}
+
+// GetReceiptJournalPath returns the path to the receipt journal on
+// local disk.
+func (r *BagRestorer) GetReceiptJournalPath() string {
+	return path.Join(r.Context.Config.RestoreDir, strconv.FormatInt(r.WorkItemID, 10), "receipt-files.jsonl")
+}
+
+// addToReceipt appends an entry for the file described by header to
+// the receipt journal. Param gf is nil for files we generate. Param
+// registryChecksums is nil if we didn't compare the file to Registry.
+func (r *BagRestorer) addToReceipt(header *tar.Header, gf *registry.GenericFile, digests, registryChecksums map[string]string) error {
+	entry := &service.ReceiptFile{
+		Path:              header.Name,
+		Size:              header.Size,
+		RegistryChecksums: registryChecksums,
+		Digests:           digests,
+		WrittenAt:         time.Now().UTC(),
+	}
+	if gf != nil {
+		entry.Identifier = gf.Identifier
+	}
+	if gf != nil && r.currentReader != nil {
+		urls := make([]string, 0)
+		for _, source := range r.currentReader.Served() {
+			urls = append(urls, source.StorageRecord.URL)
+		}
+		entry.SourceURL = strings.Join(urls, ", ")
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	journalPath := r.GetReceiptJournalPath()
+	if err = os.MkdirAll(path.Dir(journalPath), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(journalPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	n, err := fmt.Fprintf(file, "%s\n", data)
+	if r.manifestSizes == nil {
+		r.manifestSizes = make(map[string]int64)
+	}
+	r.manifestSizes[journalPath] += int64(n)
+	return err
+}
+
+// BuildReceipt returns the receipt for this restoration, with the
+// entries from the journal.
+func (r *BagRestorer) BuildReceipt() (*service.RestorationReceipt, error) {
+	receipt := &service.RestorationReceipt{
+		Identifier:  r.RestorationObject.Identifier,
+		WorkItemID:  r.WorkItemID,
+		URL:         r.Target.URL(r.RestoredKey()),
+		Format:      r.RestorationObject.Format(),
+		AsOf:        r.RestorationObject.AsOf,
+		StartedAt:   r.startedAt(),
+		CompletedAt: time.Now().UTC(),
+		Files:       make([]*service.ReceiptFile, 0),
+	}
+	file, err := os.Open(r.GetReceiptJournalPath())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := &service.ReceiptFile{}
+		if err = json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, fmt.Errorf("Invalid entry in receipt journal: %v", err)
+		}
+		receipt.Files = append(receipt.Files, entry)
+	}
+	return receipt, scanner.Err()
+}
+
+// writeReceipt signs the receipt and writes it to the restoration
+// target. In dev and test, if there's no receipt key, we write the
+// receipt unsigned. Other environments must have a key.
+func (r *BagRestorer) writeReceipt() error {
+	if err := r.Context.Config.CheckRestorationReceiptKey(); err != nil {
+		return err
+	}
+	key, err := LoadReceiptKey(r.Context.Config.RestorationReceiptKey)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		r.Context.Logger.Warningf("No restoration receipt key is configured. Receipt for %s will be unsigned.", r.RestorationObject.Identifier)
+	}
+	receipt, err := r.BuildReceipt()
+	if err != nil {
+		return err
+	}
+	signed, err := service.NewSignedRestorationReceipt(receipt, key)
+	if err != nil {
+		return err
+	}
+	data, err := signed.ToJSON()
+	if err != nil {
+		return err
+	}
+	_, err = r.Target.PutObject(r.ReceiptKey(), bytes.NewReader(data), int64(len(data)), nil)
+	if err == nil {
+		r.Context.Logger.Infof("Wrote receipt for %s to %s", r.RestorationObject.Identifier, r.Target.URL(r.ReceiptKey()))
+	}
+	return err
+}
+
+// VerifyRestoredBag checks the restored bag at bagPath against
+// receipt. Param bagPath can be a tar, tar.gz or zip file, or a
+// directory containing a loose bag. For loose bags, bagPath can be the
+// bag directory or the directory above it.
+//
+// It returns a description of each problem found: files whose size or
+// digests don't match the receipt, files in the receipt that are not
+// in the bag, and files in the bag that are not in the receipt.
+func VerifyRestoredBag(receipt *service.RestorationReceipt, bagPath string) ([]string, error) {
+	expected := make(map[string]*service.ReceiptFile, len(receipt.Files))
+	for _, entry := range receipt.Files {
+		expected[entry.Path] = entry
+	}
+	problems := make([]string, 0)
+	found := make(map[string]bool, len(receipt.Files))
+	check := func(name string, size int64, reader io.Reader) error {
+		entry := expected[name]
+		if entry == nil {
+			problems = append(problems, fmt.Sprintf("%s is in the bag but not in the receipt", name))
+			return nil
+		}
+		found[name] = true
+		algs := make([]string, 0, len(entry.Digests))
+		for alg := range entry.Digests {
+			algs = append(algs, alg)
+		}
+		hashes := GetHashes(algs)
+		writers := make([]io.Writer, 0, len(hashes))
+		for _, hash := range hashes {
+			writers = append(writers, hash)
+		}
+		bytesRead, err := io.Copy(io.MultiWriter(writers...), reader)
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %v", name, err)
+		}
+		if bytesRead != entry.Size || size != entry.Size {
+			problems = append(problems, fmt.Sprintf("%s has %d bytes, receipt says %d", name, bytesRead, entry.Size))
+		}
+		actual := make(map[string]string, len(hashes))
+		for alg, hash := range hashes {
+			actual[alg] = fmt.Sprintf("%x", hash.Sum(nil))
+		}
+		if err = VerifyDigests(entry.Digests, actual); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+		return nil
+	}
+
+	info, err := os.Stat(bagPath)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case info.IsDir():
+		err = verifyDir(receipt, bagPath, check)
+	case strings.HasSuffix(bagPath, ".zip"):
+		err = verifyZip(bagPath, check)
+	default:
+		err = verifyTar(bagPath, check)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range receipt.Files {
+		if !found[entry.Path] {
+			problems = append(problems, fmt.Sprintf("%s is in the receipt but not in the bag", entry.Path))
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
+
+type checkFunc func(name string, size int64, reader io.Reader) error
+
+func verifyTar(bagPath string, check checkFunc) error {
+	file, err := os.Open(bagPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(bagPath, ".gz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = check(header.Name, header.Size, tarReader); err != nil {
+			return err
+		}
+	}
+}
+
+func verifyZip(bagPath string, check checkFunc) error {
+	zipReader, err := zip.OpenReader(bagPath)
+	if err != nil {
+		return err
+	}
+	defer zipReader.Close()
+	for _, file := range zipReader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = check(file.Name, int64(file.UncompressedSize64), reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyDir(receipt *service.RestorationReceipt, bagPath string, check checkFunc) error {
+	// Receipt paths start with the bag's directory name. bagPath may be
+	// that directory or the one above it.
+	root := filepath.Clean(bagPath)
+	bagDir := root
+	if len(receipt.Files) > 0 {
+		name := strings.SplitN(receipt.Files[0].Path, "/", 2)[0]
+		if filepath.Base(root) == name {
+			root = filepath.Dir(root)
+		}
+		bagDir = filepath.Join(root, name)
+	}
+	return filepath.WalkDir(bagDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return check(filepath.ToSlash(relPath), info.Size(), file)
+	})
+}
@@ -0,0 +1,244 @@
+package restoration_test
+
+import (
+	"archive/tar"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/restoration"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var receiptFiles = map[string]string{
+	"bag/bagit.txt":      "BagIt-Version: 1.0\n",
+	"bag/data/file1.txt": "first file",
+	"bag/data/file2.txt": "second file",
+}
+
+func receiptFor(files map[string]string) *service.RestorationReceipt {
+	receipt := &service.RestorationReceipt{Identifier: "test.edu/bag"}
+	for name, content := range files {
+		receipt.Files = append(receipt.Files, &service.ReceiptFile{
+			Path: name,
+			Size: int64(len(content)),
+			Digests: map[string]string{
+				constants.AlgSha256: fmt.Sprintf("%x", sha256.Sum256([]byte(content))),
+			},
+		})
+	}
+	return receipt
+}
+
+func writeTestTar(t *testing.T, tarPath string, files map[string]string) {
+	file, err := os.Create(tarPath)
+	require.Nil(t, err)
+	defer file.Close()
+	tarWriter := tar.NewWriter(file)
+	for name, content := range files {
+		require.Nil(t, tarWriter.WriteHeader(&tar.Header{
+			Name:     name,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+		}))
+		_, err = tarWriter.Write([]byte(content))
+		require.Nil(t, err)
+	}
+	require.Nil(t, tarWriter.Close())
+}
+
+func TestLoadReceiptKey(t *testing.T) {
+	key, err := restoration.LoadReceiptKey("")
+	require.Nil(t, err)
+	assert.Nil(t, key)
+
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	key, err = restoration.LoadReceiptKey(base64.StdEncoding.EncodeToString(privateKey.Seed()))
+	require.Nil(t, err)
+	assert.Equal(t, privateKey, key)
+	key, err = restoration.LoadReceiptKey(base64.StdEncoding.EncodeToString(privateKey))
+	require.Nil(t, err)
+	assert.Equal(t, privateKey, key)
+
+	_, err = restoration.LoadReceiptKey(base64.StdEncoding.EncodeToString([]byte("too short")))
+	assert.NotNil(t, err)
+	_, err = restoration.LoadReceiptKey("not base64!")
+	assert.NotNil(t, err)
+
+	_, err = restoration.ParseReceiptPublicKey(base64.StdEncoding.EncodeToString(privateKey.Public().(ed25519.PublicKey)))
+	assert.Nil(t, err)
+	_, err = restoration.ParseReceiptPublicKey(base64.StdEncoding.EncodeToString(privateKey))
+	assert.NotNil(t, err)
+}
+
+func TestVerifyRestoredBagTar(t *testing.T) {
+	dir := t.TempDir()
+	tarPath := filepath.Join(dir, "bag.tar")
+	writeTestTar(t, tarPath, receiptFiles)
+	receipt := receiptFor(receiptFiles)
+
+	problems, err := restoration.VerifyRestoredBag(receipt, tarPath)
+	require.Nil(t, err)
+	assert.Empty(t, problems)
+
+	altered := map[string]string{
+		"bag/bagit.txt":      receiptFiles["bag/bagit.txt"],
+		"bag/data/file1.txt": "first file, altered",
+		"bag/data/extra.txt": "not in receipt",
+	}
+	writeTestTar(t, tarPath, altered)
+	problems, err = restoration.VerifyRestoredBag(receipt, tarPath)
+	require.Nil(t, err)
+	require.Equal(t, 4, len(problems))
+	assert.Contains(t, problems[0], "bag/data/extra.txt is in the bag but not in the receipt")
+	assert.Contains(t, problems[1], "bag/data/file1.txt has 19 bytes, receipt says 10")
+	assert.Contains(t, problems[2], "bag/data/file1.txt: digest mismatch")
+	assert.Contains(t, problems[3], "bag/data/file2.txt is in the receipt but not in the bag")
+}
+
+func TestVerifyRestoredBagLoose(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range receiptFiles {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		require.Nil(t, os.MkdirAll(filepath.Dir(filePath), 0755))
+		require.Nil(t, os.WriteFile(filePath, []byte(content), 0644))
+	}
+	// The receipt sits next to the bag directory.
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "bag.receipt.json"), []byte("{}"), 0644))
+	receipt := receiptFor(receiptFiles)
+
+	// We can point at the bag directory or the one above it.
+	for _, bagPath := range []string{dir, filepath.Join(dir, "bag")} {
+		problems, err := restoration.VerifyRestoredBag(receipt, bagPath)
+		require.Nil(t, err)
+		assert.Empty(t, problems, bagPath)
+	}
+
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "bag", "data", "file2.txt"), []byte("changed"), 0644))
+	problems, err := restoration.VerifyRestoredBag(receipt, dir)
+	require.Nil(t, err)
+	assert.Equal(t, 2, len(problems))
+}
+
+func TestBagRestorerReceipt(t *testing.T) {
+	config := common.NewConfig()
+	config.RestoreDir = t.TempDir()
+	context := &common.Context{
+		Config: config,
+		Logger: logger.DiscardLogger("receipt_test"),
+	}
+	asOf := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	restObj := &service.RestorationObject{
+		Identifier:      "test.edu/bag",
+		RestorationType: constants.RestorationTypeObject,
+		AsOf:            &asOf,
+	}
+	restorer := restoration.NewBagRestorer(context, 5678, restObj)
+	target, err := restoration.NewLocalTarget(t.TempDir())
+	require.Nil(t, err)
+	restorer.Target = target
+	require.Nil(t, restorer.DeleteStaleManifests())
+
+	content := receiptFiles["bag/data/file1.txt"]
+	md5Digest := fmt.Sprintf("%x", md5.Sum([]byte(content)))
+	sha256Digest := fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+	gf := &registry.GenericFile{
+		Identifier: "test.edu/bag/data/file1.txt",
+		Size:       int64(len(content)),
+		Checksums: []*registry.Checksum{
+			{Algorithm: constants.AlgMd5, Digest: md5Digest, DateTime: asOf},
+			{Algorithm: constants.AlgSha256, Digest: sha256Digest, DateTime: asOf},
+		},
+	}
+	err = restorer.RecordDigests(gf, map[string]string{
+		constants.AlgMd5:    md5Digest,
+		constants.AlgSha256: sha256Digest,
+	})
+	require.Nil(t, err)
+
+	receipt, err := restorer.BuildReceipt()
+	require.Nil(t, err)
+	assert.Equal(t, "test.edu/bag", receipt.Identifier)
+	assert.Equal(t, int64(5678), receipt.WorkItemID)
+	assert.Equal(t, &asOf, receipt.AsOf)
+	require.Equal(t, 1, len(receipt.Files))
+	entry := receipt.Files[0]
+	assert.Equal(t, "bag/data/file1.txt", entry.Path)
+	assert.Equal(t, gf.Identifier, entry.Identifier)
+	assert.Equal(t, int64(len(content)), entry.Size)
+	assert.Equal(t, sha256Digest, entry.RegistryChecksums[constants.AlgSha256])
+	assert.Equal(t, md5Digest, entry.Digests[constants.AlgMd5])
+
+	// The journal goes away with the manifests.
+	require.Nil(t, restorer.DeleteStaleManifests())
+	_, err = os.Stat(restorer.GetReceiptJournalPath())
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, "test.edu/bag.tar.receipt.json", restorer.ReceiptKey())
+	restObj.RestorationFormat = constants.RestorationFormatLoose
+	assert.Equal(t, "test.edu/bag.receipt.json", restorer.ReceiptKey())
+}
+
+func TestBagRestorerResumesAtReceipt(t *testing.T) {
+	config := common.NewConfig()
+	config.RestoreDir = t.TempDir()
+	context := &common.Context{
+		Config: config,
+		Logger: logger.DiscardLogger("receipt_test"),
+	}
+	restObj := &service.RestorationObject{
+		Identifier:      "test.edu/bag",
+		RestorationType: constants.RestorationTypeObject,
+	}
+	restorer := restoration.NewBagRestorer(context, 5679, restObj)
+	target, err := restoration.NewLocalTarget(t.TempDir())
+	require.Nil(t, err)
+	restorer.Target = target
+	require.Nil(t, restorer.DeleteStaleManifests())
+
+	// An earlier attempt uploaded the bag and recorded a file in the
+	// journal, but couldn't write the receipt.
+	content := receiptFiles["bag/data/file1.txt"]
+	sha256Digest := fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+	gf := &registry.GenericFile{
+		Identifier: "test.edu/bag/data/file1.txt",
+		Size:       int64(len(content)),
+		Checksums:  []*registry.Checksum{{Algorithm: constants.AlgSha256, Digest: sha256Digest}},
+	}
+	require.Nil(t, restorer.RecordDigests(gf, map[string]string{constants.AlgSha256: sha256Digest}))
+	_, err = target.PutObject(restorer.RestoredKey(), strings.NewReader("the bag"), -1, nil)
+	require.Nil(t, err)
+	restObj.Progress = &service.RestorationProgress{
+		StartedAt: time.Now().UTC(),
+		Key:       restorer.RestoredKey(),
+		Format:    restObj.Format(),
+		Uploaded:  true,
+		FileCount: 4,
+	}
+
+	// This attempt writes only the receipt. There's no Registry or
+	// preservation storage here, so anything more would fail.
+	fileCount, errors := restorer.Run()
+	require.Empty(t, errors)
+	assert.Equal(t, 4, fileCount)
+	assert.True(t, restObj.AllFilesRestored)
+	assert.Equal(t, target.URL(restorer.RestoredKey()), restObj.URL)
+	receiptExists, err := target.Exists(restorer.ReceiptKey())
+	require.Nil(t, err)
+	assert.True(t, receiptExists)
+	assert.Nil(t, restObj.Progress)
+}
@@ -0,0 +1,116 @@
+package restoration
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/APTrust/preservation-services/models/registry"
+)
+
+// SourceReader reads a GenericFile from its preservation copies, in
+// restore-priority order. If a read fails partway through, it picks up
+// at the same offset from the next copy. That matters when we're
+// streaming into a tar file, because we can't go back and rewrite what
+// we've already written.
+//
+// All copies of a file should be identical, byte for byte, but a read
+// that spans two copies is only as good as the worse of them. The
+// caller still has to compare the final digests to Registry's.
+type SourceReader struct {
+	// Source is the copy we started reading from.
+	Source *RestorationSource
+
+	base       *Base
+	served     []*RestorationSource
+	gf         *registry.GenericFile
+	candidates []*RestorationSource
+	index      int
+	obj        io.ReadCloser
+	offset     int64
+}
+
+// NewSourceReader returns a SourceReader for gf, opened on the first
+// readable candidate copy.
+func (b *Base) NewSourceReader(gf *registry.GenericFile) (*SourceReader, error) {
+	candidates := b.Candidates(gf)
+	obj, source, err := b.OpenFirstAvailable(gf, candidates)
+	if err != nil {
+		return nil, err
+	}
+	index := 0
+	for i, candidate := range candidates {
+		if candidate == source {
+			index = i
+		}
+	}
+	return &SourceReader{
+		Source:     source,
+		base:       b,
+		gf:         gf,
+		candidates: candidates,
+		index:      index,
+		obj:        obj,
+	}, nil
+}
+
+// Read reads from the current copy, failing over to the next copy if
+// the current one returns an error or ends early.
+func (r *SourceReader) Read(p []byte) (int, error) {
+	n, err := r.obj.Read(p)
+	r.offset += int64(n)
+	if n > 0 {
+		r.markServed()
+	}
+	if err == io.EOF && r.offset < r.gf.Size {
+		err = fmt.Errorf("copy ended after %d of %d bytes", r.offset, r.gf.Size)
+	}
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	if r.failover(err) {
+		return n, nil
+	}
+	return n, err
+}
+
+// Served returns the copies that supplied bytes to the reader so far,
+// in the order they were read. There's more than one only if the reader
+// failed over partway through the file. If the file's digests turn out
+// wrong, any of these copies could be to blame.
+func (r *SourceReader) Served() []*RestorationSource {
+	return r.served
+}
+
+func (r *SourceReader) markServed() {
+	current := r.candidates[r.index]
+	if len(r.served) == 0 || r.served[len(r.served)-1] != current {
+		r.served = append(r.served, current)
+	}
+}
+
+// Close closes the current copy.
+func (r *SourceReader) Close() error {
+	return r.obj.Close()
+}
+
+// failover moves to the next candidate that can be opened at the
+// current offset. It returns false if there are none.
+func (r *SourceReader) failover(cause error) bool {
+	r.obj.Close()
+	current := r.candidates[r.index]
+	r.base.Context.Logger.Warningf("Error reading %s from %s at byte %d: %v", r.gf.Identifier, current.StorageRecord.URL, r.offset, cause)
+	r.base.RestorationObject.AddFailover(r.gf.Identifier, current.StorageRecord.URL, fmt.Errorf("at byte %d: %v", r.offset, cause))
+	for r.index+1 < len(r.candidates) {
+		r.index++
+		next := r.candidates[r.index]
+		obj, err := r.base.openRange(r.gf, next, r.offset)
+		if err == nil {
+			r.base.Context.Logger.Infof("Resuming %s from %s at byte %d", r.gf.Identifier, next.StorageRecord.URL, r.offset)
+			r.obj = obj
+			return true
+		}
+		r.base.Context.Logger.Warningf("Cannot read %s from %s: %v", r.gf.Identifier, next.StorageRecord.URL, err)
+		r.base.RestorationObject.AddFailover(r.gf.Identifier, next.StorageRecord.URL, err)
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+package restoration_test
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/restoration"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sourceTestUUID = "6a1c6b1e-7a1f-4a8e-9d4b-000000000002"
+
+// Behaviors for fakeBuckets
+const (
+	serveOK = iota
+	serveMissing
+	serveDropAfter3000
+	serveNotRestored
+)
+
+// fakeBuckets serves one object from any number of buckets, each of
+// which can be set to misbehave in a particular way.
+type fakeBuckets struct {
+	sync.Mutex
+	data     []byte
+	behavior map[string]int
+	requests map[string][]string
+}
+
+func (f *fakeBuckets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	f.Lock()
+	behavior := f.behavior[bucket]
+	f.requests[bucket] = append(f.requests[bucket], r.Header.Get("Range"))
+	f.Unlock()
+
+	switch behavior {
+	case serveMissing:
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	case serveNotRestored:
+		writeS3Error(w, http.StatusForbidden, "InvalidObjectState")
+		return
+	}
+
+	start, end := int64(0), int64(len(f.data)-1)
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		spec := strings.SplitN(strings.TrimPrefix(rangeHeader, "bytes="), "-", 2)
+		start, _ = strconv.ParseInt(spec[0], 10, 64)
+		if n, err := strconv.ParseInt(spec[1], 10, 64); err == nil {
+			end = n
+		}
+	}
+	w.Header().Set("ETag", `"abc123"`)
+	w.Header().Set("Last-Modified", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	status := http.StatusOK
+	if r.Header.Get("Range") != "" {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(f.data)))
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	body := f.data[start : end+1]
+	if behavior == serveDropAfter3000 && len(body) > 3000 {
+		w.Write(body[:3000])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(body)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+type sourceTest struct {
+	server    *httptest.Server
+	buckets   *fakeBuckets
+	base      *restoration.Base
+	gf        *registry.GenericFile
+	primary   *common.PreservationBucket
+	secondary *common.PreservationBucket
+	glacier   *common.PreservationBucket
+}
+
+func newSourceTest(t *testing.T) *sourceTest {
+	data := make([]byte, 10000)
+	rand.New(rand.NewSource(7)).Read(data)
+	buckets := &fakeBuckets{
+		data:     data,
+		behavior: make(map[string]int),
+		requests: make(map[string][]string),
+	}
+	server := httptest.NewServer(buckets)
+	u, err := url.Parse(server.URL)
+	require.Nil(t, err)
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4("key", "secret", ""),
+		Region:       "us-east-1",
+		BucketLookup: minio.BucketLookupPath,
+		MaxRetries:   1,
+	})
+	require.Nil(t, err)
+
+	config := common.NewConfig()
+	primary := config.PreservationBucketsFor(constants.StorageStandard)[0]
+	secondary := config.PreservationBucketsFor(constants.StorageWasabiOR)[0]
+	glacier := config.PreservationBucketsFor(constants.StorageGlacierVA)[0]
+	require.True(t, primary.RestorePriority < secondary.RestorePriority)
+	require.True(t, secondary.RestorePriority < glacier.RestorePriority)
+
+	context := &common.Context{
+		Config: config,
+		Logger: logger.DiscardLogger("source_reader_test"),
+		S3Clients: map[string]*minio.Client{
+			primary.Bucket:   client,
+			secondary.Bucket: client,
+			glacier.Bucket:   client,
+		},
+	}
+	return &sourceTest{
+		server:  server,
+		buckets: buckets,
+		base: &restoration.Base{
+			Context:           context,
+			RestorationObject: &service.RestorationObject{Identifier: "test.edu/bag"},
+		},
+		gf: &registry.GenericFile{
+			Identifier: "test.edu/bag/data/file.bin",
+			Size:       int64(len(data)),
+			UUID:       sourceTestUUID,
+			StorageRecords: []*registry.StorageRecord{
+				{URL: glacier.URLFor(sourceTestUUID)},
+				{URL: secondary.URLFor(sourceTestUUID)},
+				{URL: primary.URLFor(sourceTestUUID)},
+			},
+		},
+		primary:   primary,
+		secondary: secondary,
+		glacier:   glacier,
+	}
+}
+
+func TestSourceReaderNoFailover(t *testing.T) {
+	st := newSourceTest(t)
+	defer st.server.Close()
+
+	reader, err := st.base.NewSourceReader(st.gf)
+	require.Nil(t, err)
+	defer reader.Close()
+	assert.Equal(t, st.primary, reader.Source.Bucket)
+	data, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	assert.Equal(t, st.buckets.data, data)
+	assert.Equal(t, 0, st.base.RestorationObject.FailoverCount)
+	assert.Empty(t, st.buckets.requests[st.secondary.Bucket])
+	require.Equal(t, 1, len(reader.Served()))
+	assert.Equal(t, st.primary, reader.Served()[0].Bucket)
+}
+
+func TestSourceReaderMissingCopy(t *testing.T) {
+	st := newSourceTest(t)
+	defer st.server.Close()
+	st.buckets.behavior[st.primary.Bucket] = serveMissing
+
+	reader, err := st.base.NewSourceReader(st.gf)
+	require.Nil(t, err)
+	defer reader.Close()
+	assert.Equal(t, st.secondary, reader.Source.Bucket)
+	data, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	assert.Equal(t, st.buckets.data, data)
+	assert.Equal(t, 1, st.base.RestorationObject.FailoverCount)
+	assert.Contains(t, st.base.RestorationObject.FailoverNote(), st.primary.URLFor(sourceTestUUID))
+}
+
+func TestSourceReaderFailsOverMidStream(t *testing.T) {
+	st := newSourceTest(t)
+	defer st.server.Close()
+	st.buckets.behavior[st.primary.Bucket] = serveDropAfter3000
+
+	reader, err := st.base.NewSourceReader(st.gf)
+	require.Nil(t, err)
+	defer reader.Close()
+	assert.Equal(t, st.primary, reader.Source.Bucket)
+	data, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	assert.Equal(t, st.buckets.data, data)
+	assert.Equal(t, 1, st.base.RestorationObject.FailoverCount)
+
+	// The second copy was read from where the first left off, and
+	// both copies served part of the file.
+	assert.Equal(t, []string{"bytes=3000-"}, st.buckets.requests[st.secondary.Bucket])
+	require.Equal(t, 2, len(reader.Served()))
+	assert.Equal(t, st.primary, reader.Served()[0].Bucket)
+	assert.Equal(t, st.secondary, reader.Served()[1].Bucket)
+}
+
+func TestSourceReaderNeedsGlacier(t *testing.T) {
+	st := newSourceTest(t)
+	defer st.server.Close()
+	st.buckets.behavior[st.primary.Bucket] = serveMissing
+	st.buckets.behavior[st.secondary.Bucket] = serveMissing
+	st.buckets.behavior[st.glacier.Bucket] = serveNotRestored
+
+	_, err := st.base.NewSourceReader(st.gf)
+	require.NotNil(t, err)
+	assert.True(t, st.base.RestorationObject.NeedsGlacierRestore)
+	assert.Equal(t, 3, st.base.RestorationObject.FailoverCount)
+
+	// If the Glacier copy has been restored to S3, we can read it.
+	st.base.RestorationObject = &service.RestorationObject{}
+	st.buckets.behavior[st.glacier.Bucket] = serveOK
+	reader, err := st.base.NewSourceReader(st.gf)
+	require.Nil(t, err)
+	defer reader.Close()
+	assert.Equal(t, st.glacier, reader.Source.Bucket)
+	assert.False(t, st.base.RestorationObject.NeedsGlacierRestore)
+}
+
+func TestCandidatesSkipFailedSources(t *testing.T) {
+	st := newSourceTest(t)
+	defer st.server.Close()
+	assert.Equal(t, 3, len(st.base.Candidates(st.gf)))
+
+	st.base.RestorationObject.AddFailedSource(st.primary.URLFor(sourceTestUUID))
+	candidates := st.base.Candidates(st.gf)
+	require.Equal(t, 2, len(candidates))
+	assert.Equal(t, st.secondary, candidates[0].Bucket)
+
+	reader, err := st.base.NewSourceReader(st.gf)
+	require.Nil(t, err)
+	defer reader.Close()
+	assert.Equal(t, st.secondary, reader.Source.Bucket)
+	assert.Empty(t, st.buckets.requests[st.primary.Bucket])
+}
+
+func TestGlacierSource(t *testing.T) {
+	st := newSourceTest(t)
+	defer st.server.Close()
+	sources := restoration.RestorationSources(st.base.Context, st.gf)
+	source := restoration.GlacierSource(sources)
+	require.NotNil(t, source)
+	assert.Equal(t, st.glacier, source.Bucket)
+	assert.Nil(t, restoration.GlacierSource(sources[:2]))
+}
@@ -2,6 +2,7 @@ package restoration
 
 import (
 	"archive/tar"
+	"compress/gzip"
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
@@ -19,8 +20,13 @@ import (
 // that accepts an io.Reader. This allows us to write a tar file
 // directly to S3.
 type TarPipeWriter struct {
+	// ModTime is the timestamp for the directory entries we add. If
+	// it's zero, we use the current time.
+	ModTime time.Time
+
 	pipeReader  *io.PipeReader
 	pipeWriter  *io.PipeWriter
+	gzipWriter  *gzip.Writer
 	tarWriter   *tar.Writer
 	directories map[string]bool
 }
@@ -36,6 +42,29 @@ func NewTarPipeWriter() *TarPipeWriter {
 	}
 }
 
+// NewTarGzPipeWriter creates a new TarPipeWriter that gzips the tar
+// file as it goes through the pipe.
+func NewTarGzPipeWriter() *TarPipeWriter {
+	pipeReader, pipeWriter := io.Pipe()
+	gzipWriter := gzip.NewWriter(pipeWriter)
+	return &TarPipeWriter{
+		pipeReader:  pipeReader,
+		pipeWriter:  pipeWriter,
+		gzipWriter:  gzipWriter,
+		tarWriter:   tar.NewWriter(gzipWriter),
+		directories: make(map[string]bool),
+	}
+}
+
+// NewTarStreamWriter creates a TarPipeWriter that writes directly to
+// dst instead of through a pipe. GetReader returns nil.
+func NewTarStreamWriter(dst io.Writer) *TarPipeWriter {
+	return &TarPipeWriter{
+		tarWriter:   tar.NewWriter(dst),
+		directories: make(map[string]bool),
+	}
+}
+
 // AddFile writes the specified tar header and file data (from reader r)
 // into the pipeline.
 func (w *TarPipeWriter) AddFile(header *tar.Header, r io.Reader, manifestAlgs []string) (digests map[string]string, err error) {
@@ -56,40 +85,18 @@ func (w *TarPipeWriter) AddFile(header *tar.Header, r io.Reader, manifestAlgs []
 	}
 
 	// Write file data through hashes to tar writer
-	hashes := w.GetManifestHashes(manifestAlgs)
-	writers := make([]io.Writer, len(hashes)+1)
-	i := 0
-	for _, hash := range hashes {
-		writers[i] = hash
-		i++
-	}
-	writers[i] = w.tarWriter
-	multiWriter := io.MultiWriter(writers...)
-
-	// Write the file contents
-	bytesWritten, err := io.Copy(multiWriter, r)
-	if bytesWritten != header.Size {
-		return digests, fmt.Errorf("AddFile copied only %d of %d bytes for file %s",
-			bytesWritten, header.Size, header.Name)
-	}
-	if err != nil {
-		return digests, fmt.Errorf("Error copying %s into tar archive: %v",
-			header.Name, err)
-	}
-
-	// Collect the digests for return to caller. E.g.
-	// digest['md5'] = "68b329da9893e34099c7d8ad5cb9c940"
-	// digest['sha256'] = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
-	for alg, hash := range hashes {
-		digests[alg] = fmt.Sprintf("%x", hash.Sum(nil))
-	}
-
-	return digests, nil
+	return copyWithDigests(w.tarWriter, header, r, manifestAlgs, "tar archive")
 }
 
 func (w *TarPipeWriter) GetManifestHashes(manifestAlgs []string) map[string]hash.Hash {
-	hashes := make(map[string]hash.Hash, len(manifestAlgs))
-	for _, alg := range manifestAlgs {
+	return GetHashes(manifestAlgs)
+}
+
+// GetHashes returns a map of new hashes for the specified algorithms,
+// keyed by algorithm name. Unsupported algorithms are ignored.
+func GetHashes(algs []string) map[string]hash.Hash {
+	hashes := make(map[string]hash.Hash, len(algs))
+	for _, alg := range algs {
 		switch alg {
 		case constants.AlgMd5:
 			hashes[alg] = md5.New()
@@ -107,14 +114,17 @@ func (w *TarPipeWriter) GetManifestHashes(manifestAlgs []string) map[string]hash
 func (w *TarPipeWriter) EnsureDirectoryEntry(filename string) (err error) {
 	// path.Dir will break on Windows
 	// tar format always uses forward slash
-	i := strings.LastIndex(filename, "/")
-	dirname := filename[:i+1]
+	dirname := tarDirName(filename)
 	if _, ok := w.directories[dirname]; !ok {
+		modTime := w.ModTime
+		if modTime.IsZero() {
+			modTime = time.Now().UTC()
+		}
 		header := &tar.Header{
 			Name:     dirname,
 			Typeflag: tar.TypeDir,
 			Mode:     int64(0755),
-			ModTime:  time.Now().UTC(),
+			ModTime:  modTime,
 		}
 		err = w.tarWriter.WriteHeader(header)
 		if err == nil {
@@ -124,6 +134,23 @@ func (w *TarPipeWriter) EnsureDirectoryEntry(filename string) (err error) {
 	return err
 }
 
+// SkipDirectoryEntry records that the directory entry for filename
+// was already written, on an earlier attempt, without writing it.
+func (w *TarPipeWriter) SkipDirectoryEntry(filename string) {
+	w.directories[tarDirName(filename)] = true
+}
+
+func tarDirName(filename string) string {
+	i := strings.LastIndex(filename, "/")
+	return filename[:i+1]
+}
+
+// Flush writes the padding at the end of the last file added, so the
+// next byte written will be the start of the next entry.
+func (w *TarPipeWriter) Flush() error {
+	return w.tarWriter.Flush()
+}
+
 // ValidateHeader returns an error if the tar header is missing a name
 // or if its size is less than zero.
 func (w *TarPipeWriter) ValidateHeader(header *tar.Header) error {
@@ -151,6 +178,18 @@ func (w *TarPipeWriter) GetReader() *io.PipeReader {
 // the PipeWriter, which sends an EOF to the PipeReader. Without this,
 // the process at the reading end will hang forever, waiting for EOF.
 func (w *TarPipeWriter) Finish() {
-	w.tarWriter.Close()
-	w.pipeWriter.Close()
+	w.Close()
+}
+
+// Close is like Finish, but it returns any error from closing the
+// TarWriter.
+func (w *TarPipeWriter) Close() error {
+	err := w.tarWriter.Close()
+	if w.gzipWriter != nil {
+		w.gzipWriter.Close()
+	}
+	if w.pipeWriter != nil {
+		w.pipeWriter.Close()
+	}
+	return err
 }
@@ -2,10 +2,13 @@ package restoration_test
 
 import (
 	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"io"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/APTrust/preservation-services/constants"
 	"github.com/APTrust/preservation-services/restoration"
@@ -138,3 +141,76 @@ func TestAddFile(t *testing.T) {
 		assert.True(t, len(digest) >= 32)
 	}
 }
+
+func TestTarGzPipeWriter(t *testing.T) {
+	w := restoration.NewTarGzPipeWriter()
+	require.NotNil(t, w)
+	pipeReader := w.GetReader()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var names []string
+	var contents []string
+	go func() {
+		defer wg.Done()
+		gzipReader, err := gzip.NewReader(pipeReader)
+		require.Nil(t, err)
+		tarReader := tar.NewReader(gzipReader)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			require.Nil(t, err)
+			names = append(names, header.Name)
+			data, err := io.ReadAll(tarReader)
+			require.Nil(t, err)
+			contents = append(contents, string(data))
+		}
+	}()
+
+	tarHeader := &tar.Header{
+		Name: "bag/data/SampleData.txt",
+		Size: 11,
+	}
+	digests, err := w.AddFile(tarHeader, strings.NewReader("sample data"), constants.APTrustRestorationAlgorithms)
+	w.Finish()
+	wg.Wait()
+
+	require.Nil(t, err)
+	assert.Equal(t, len(constants.APTrustRestorationAlgorithms), len(digests))
+	assert.Equal(t, []string{"bag/data/", "bag/data/SampleData.txt"}, names)
+	assert.Equal(t, "sample data", contents[1])
+}
+
+func TestTarStreamWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := restoration.NewTarStreamWriter(buf)
+	assert.Nil(t, w.GetReader())
+	w.ModTime = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// As when resuming, when the directory entry was written on an
+	// earlier attempt.
+	w.SkipDirectoryEntry("bag/data/one.txt")
+
+	_, err := w.AddFile(&tar.Header{Name: "bag/data/two.txt", Size: 3, ModTime: w.ModTime}, strings.NewReader("two"), nil)
+	require.Nil(t, err)
+	require.Nil(t, w.Flush())
+	assert.Equal(t, 0, buf.Len()%512)
+	_, err = w.AddFile(&tar.Header{Name: "bag/tags/three.txt", Size: 5, ModTime: w.ModTime}, strings.NewReader("three"), nil)
+	require.Nil(t, err)
+	require.Nil(t, w.Close())
+
+	tarReader := tar.NewReader(buf)
+	names := make([]string, 0)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		names = append(names, header.Name)
+		assert.True(t, w.ModTime.Equal(header.ModTime))
+	}
+	assert.Equal(t, []string{"bag/data/two.txt", "bag/tags/", "bag/tags/three.txt"}, names)
+}
@@ -0,0 +1,276 @@
+package restoration
+
+import (
+	ctx "context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/minio/minio-go/v7"
+)
+
+// Target is where restored bags and files go. Usually, that's the
+// institution's restoration bucket, but it can be a bucket on any
+// configured S3 provider, or a directory on local disk.
+type Target interface {
+	// PutObject writes data from reader to key. Size may be -1 if
+	// unknown. Metadata is written as S3 user metadata where
+	// supported. PutObject returns the number of bytes written.
+	PutObject(key string, reader io.Reader, size int64, metadata map[string]string) (int64, error)
+
+	// RemoveObject deletes whatever was written to key.
+	RemoveObject(key string) error
+
+	// MoveObject moves the object at from to to, keeping its metadata.
+	// The file restorer uploads to a staging key and moves the object
+	// into place only after its digests check out.
+	MoveObject(from, to string) error
+
+	// Exists returns true if there's an object at key.
+	Exists(key string) (bool, error)
+
+	// URL returns the URL of key in the target.
+	URL(key string) string
+}
+
+// NewTarget returns the Target described by restorationObject. If
+// RestorationTargetDir is set, that's a LocalTarget. Otherwise, it's an
+// S3Target for RestorationTarget on the RestorationTargetProvider
+// (default AWS), with keys under RestorationTargetPrefix.
+func NewTarget(context *common.Context, restorationObject *service.RestorationObject) (Target, error) {
+	if restorationObject.RestorationTargetDir != "" {
+		return NewLocalTarget(restorationObject.RestorationTargetDir)
+	}
+	provider := restorationObject.TargetProvider()
+	client := context.S3Clients[provider]
+	if client == nil {
+		return nil, fmt.Errorf("No S3 client for restoration target provider %s", provider)
+	}
+	if restorationObject.RestorationTarget == "" {
+		return nil, fmt.Errorf("Restoration target bucket is missing for %s", restorationObject.Identifier)
+	}
+	return NewS3Target(client, provider, restorationObject.RestorationTarget, restorationObject.RestorationTargetPrefix), nil
+}
+
+// S3Target writes to a bucket on an S3-compatible service.
+type S3Target struct {
+	Client   *minio.Client
+	Provider string
+	Bucket   string
+	Prefix   string
+}
+
+// NewS3Target returns an S3Target that writes to bucket through client,
+// with all keys under prefix.
+func NewS3Target(client *minio.Client, provider, bucket, prefix string) *S3Target {
+	return &S3Target{
+		Client:   client,
+		Provider: provider,
+		Bucket:   bucket,
+		Prefix:   strings.Trim(prefix, "/"),
+	}
+}
+
+// Key returns the full S3 key for key, including the target's prefix.
+func (t *S3Target) Key(key string) string {
+	if t.Prefix == "" {
+		return key
+	}
+	fullKey := path.Join(t.Prefix, key)
+	if strings.HasSuffix(key, "/") {
+		fullKey += "/"
+	}
+	return fullKey
+}
+
+// PutObject uploads data from reader to key in the target bucket.
+func (t *S3Target) PutObject(key string, reader io.Reader, size int64, metadata map[string]string) (int64, error) {
+	// NOTE: For debugging complex issues with the S3 client,
+	// uncommenting the TraceOn line in INCREDIBLY useful.
+	// Just don't do this in production, if you can help it,
+	// because it will output a ton of info to STDOUT for every
+	// file we upload.
+	//
+	// t.Client.TraceOn(nil)
+
+	// When size is -1, Minio SDK uses multipart upload.
+	// AutoChecksum causes issues with multipart uploads of
+	// unknown size because the SDK sends FULL_OBJECT checksum
+	// type in CompleteMultipartUpload with a part checksum,
+	// which doesn't match the server's computation.
+	// So we disable AutoChecksum for streaming uploads.
+	uploadInfo, err := t.Client.PutObject(
+		ctx.Background(),
+		t.Bucket,
+		t.Key(key),
+		reader,
+		size,
+		minio.PutObjectOptions{UserMetadata: metadata})
+	return uploadInfo.Size, err
+}
+
+// RemoveObject deletes key from the target bucket.
+func (t *S3Target) RemoveObject(key string) error {
+	return t.Client.RemoveObject(ctx.Background(), t.Bucket, t.Key(key), minio.RemoveObjectOptions{})
+}
+
+// MoveObject copies from to to within the target bucket, then deletes
+// from. The copy happens on the server, in parts if the object is over
+// 5 GB, and keeps the object's user metadata.
+func (t *S3Target) MoveObject(from, to string) error {
+	_, err := t.Client.ComposeObject(
+		ctx.Background(),
+		minio.CopyDestOptions{Bucket: t.Bucket, Object: t.Key(to)},
+		minio.CopySrcOptions{Bucket: t.Bucket, Object: t.Key(from)})
+	if err != nil {
+		return err
+	}
+	return t.RemoveObject(from)
+}
+
+// Exists returns true if key is in the target bucket.
+func (t *S3Target) Exists(key string) (bool, error) {
+	_, err := t.Client.StatObject(ctx.Background(), t.Bucket, t.Key(key), minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return false, nil
+	}
+	return false, err
+}
+
+// URL returns the URL of key in the target bucket. We've always given
+// depositors AWS URLs in the form https://s3.amazonaws.com/bucket/key,
+// so we keep that form for AWS. For other providers, the URL is based
+// on the client's endpoint.
+func (t *S3Target) URL(key string) string {
+	base := constants.AWSBucketPrefix
+	if t.Provider != constants.StorageProviderAWS {
+		endpoint := t.Client.EndpointURL()
+		base = fmt.Sprintf("%s://%s/", endpoint.Scheme, endpoint.Host)
+	}
+	return fmt.Sprintf("%s%s/%s", base, t.Bucket, t.Key(key))
+}
+
+// LocalTarget writes to a directory on local disk. Operators use this
+// for offline restorations, for example to a mounted external drive.
+type LocalTarget struct {
+	Dir string
+}
+
+// NewLocalTarget returns a LocalTarget that writes into dir. Dir must
+// be an absolute path to an existing directory. We won't create it,
+// because a missing directory usually means the operator forgot to
+// mount a drive, and we don't want to fill up the root volume.
+func NewLocalTarget(dir string) (*LocalTarget, error) {
+	if !filepath.IsAbs(dir) {
+		return nil, fmt.Errorf("Local restoration directory %s must be an absolute path", dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Local restoration directory %s: %v", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("Local restoration target %s is not a directory", dir)
+	}
+	return &LocalTarget{Dir: filepath.Clean(dir)}, nil
+}
+
+// Path returns the absolute path of key in the target directory, or
+// an error if key would land outside the directory.
+func (t *LocalTarget) Path(key string) (string, error) {
+	fullPath := filepath.Join(t.Dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(fullPath, t.Dir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("Key %s is outside local restoration directory %s", key, t.Dir)
+	}
+	return fullPath, nil
+}
+
+// PutObject writes data from reader to key under the target directory.
+// We write to a temp file and rename it when we're done, so a failed
+// restoration doesn't leave a truncated file that looks complete.
+// Local files have no place for metadata, so we ignore it.
+func (t *LocalTarget) PutObject(key string, reader io.Reader, size int64, metadata map[string]string) (int64, error) {
+	fullPath, err := t.Path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err = os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return 0, err
+	}
+	tempPath := fullPath + ".partial"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return 0, err
+	}
+	bytesWritten, err := io.Copy(file, reader)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && bytesWritten != size {
+		err = fmt.Errorf("wrote %d of %d bytes to %s", bytesWritten, size, fullPath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return bytesWritten, err
+	}
+	return bytesWritten, os.Rename(tempPath, fullPath)
+}
+
+// RemoveObject deletes key from the target directory.
+func (t *LocalTarget) RemoveObject(key string) error {
+	fullPath, err := t.Path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(fullPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// MoveObject renames from to to within the target directory.
+func (t *LocalTarget) MoveObject(from, to string) error {
+	fromPath, err := t.Path(from)
+	if err != nil {
+		return err
+	}
+	toPath, err := t.Path(to)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(toPath), 0755); err != nil {
+		return err
+	}
+	return os.Rename(fromPath, toPath)
+}
+
+// Exists returns true if key is in the target directory.
+func (t *LocalTarget) Exists(key string) (bool, error) {
+	fullPath, err := t.Path(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// URL returns a file URL for key.
+func (t *LocalTarget) URL(key string) string {
+	fullPath, _ := t.Path(key)
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(fullPath)}
+	return u.String()
+}
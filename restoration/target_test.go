@@ -0,0 +1,125 @@
+package restoration_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/restoration"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalTarget(t *testing.T) {
+	_, err := restoration.NewLocalTarget("relative/path")
+	assert.NotNil(t, err)
+	_, err = restoration.NewLocalTarget(filepath.Join(t.TempDir(), "does-not-exist"))
+	assert.NotNil(t, err)
+
+	dir := t.TempDir()
+	target, err := restoration.NewLocalTarget(dir)
+	require.Nil(t, err)
+
+	n, err := target.PutObject("test.edu/bag.tar", strings.NewReader("some data"), 9, map[string]string{"md5": "1234"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(9), n)
+	data, err := os.ReadFile(filepath.Join(dir, "test.edu", "bag.tar"))
+	require.Nil(t, err)
+	assert.Equal(t, "some data", string(data))
+	assert.Equal(t, "file://"+filepath.ToSlash(filepath.Join(dir, "test.edu", "bag.tar")), target.URL("test.edu/bag.tar"))
+
+	// Size -1 means unknown.
+	_, err = target.PutObject("test.edu/bag.zip", strings.NewReader("zipped"), -1, nil)
+	require.Nil(t, err)
+
+	// Wrong size leaves nothing behind.
+	_, err = target.PutObject("test.edu/short.txt", strings.NewReader("short"), 100, nil)
+	require.NotNil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "test.edu", "short.txt"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "test.edu", "short.txt.partial"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Keys can't escape the directory.
+	_, err = target.PutObject("../escape.txt", strings.NewReader("x"), 1, nil)
+	assert.NotNil(t, err)
+
+	require.Nil(t, target.MoveObject("test.edu/bag.zip", "test.edu/moved/bag.zip"))
+	data, err = os.ReadFile(filepath.Join(dir, "test.edu", "moved", "bag.zip"))
+	require.Nil(t, err)
+	assert.Equal(t, "zipped", string(data))
+	_, err = os.Stat(filepath.Join(dir, "test.edu", "bag.zip"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NotNil(t, target.MoveObject("test.edu/moved/bag.zip", "../escape.zip"))
+
+	require.Nil(t, target.RemoveObject("test.edu/bag.tar"))
+	_, err = os.Stat(filepath.Join(dir, "test.edu", "bag.tar"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, target.RemoveObject("test.edu/bag.tar"))
+}
+
+func TestS3Target(t *testing.T) {
+	client, err := minio.New("s3.example.com", &minio.Options{
+		Creds:  credentials.NewStaticV4("key", "secret", ""),
+		Secure: true,
+	})
+	require.Nil(t, err)
+
+	target := restoration.NewS3Target(client, constants.StorageProviderAWS, "restore-bucket", "")
+	assert.Equal(t, "test.edu/bag.tar", target.Key("test.edu/bag.tar"))
+	assert.Equal(t, constants.AWSBucketPrefix+"restore-bucket/test.edu/bag.tar", target.URL("test.edu/bag.tar"))
+
+	target = restoration.NewS3Target(client, constants.StorageProviderWasabiVA, "restore-bucket", "/requests/1234/")
+	assert.Equal(t, "requests/1234/test.edu/bag.tar", target.Key("test.edu/bag.tar"))
+	assert.Equal(t, "requests/1234/test.edu/bag/", target.Key("test.edu/bag/"))
+	assert.Equal(t, "https://s3.example.com/restore-bucket/requests/1234/test.edu/bag.tar", target.URL("test.edu/bag.tar"))
+}
+
+func TestNewTarget(t *testing.T) {
+	client, err := minio.New("s3.example.com", &minio.Options{
+		Creds: credentials.NewStaticV4("key", "secret", ""),
+	})
+	require.Nil(t, err)
+	context := &common.Context{
+		Config: common.NewConfig(),
+		Logger: logger.DiscardLogger("target_test"),
+		S3Clients: map[string]*minio.Client{
+			constants.StorageProviderAWS:      client,
+			constants.StorageProviderWasabiOR: client,
+		},
+	}
+	restObj := &service.RestorationObject{
+		Identifier:        "test.edu/bag",
+		RestorationTarget: "restore-bucket",
+	}
+
+	target, err := restoration.NewTarget(context, restObj)
+	require.Nil(t, err)
+	s3Target, ok := target.(*restoration.S3Target)
+	require.True(t, ok)
+	assert.Equal(t, constants.StorageProviderAWS, s3Target.Provider)
+	assert.Equal(t, "restore-bucket", s3Target.Bucket)
+
+	restObj.RestorationTargetProvider = constants.StorageProviderWasabiOR
+	restObj.RestorationTargetPrefix = "override"
+	target, err = restoration.NewTarget(context, restObj)
+	require.Nil(t, err)
+	assert.Equal(t, "override/test.edu/bag.tar", target.(*restoration.S3Target).Key("test.edu/bag.tar"))
+
+	restObj.RestorationTargetProvider = constants.StorageProviderWasabiTX
+	_, err = restoration.NewTarget(context, restObj)
+	assert.NotNil(t, err)
+
+	restObj.RestorationTargetDir = t.TempDir()
+	target, err = restoration.NewTarget(context, restObj)
+	require.Nil(t, err)
+	_, ok = target.(*restoration.LocalTarget)
+	assert.True(t, ok)
+}
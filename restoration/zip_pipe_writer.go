@@ -0,0 +1,65 @@
+package restoration
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+)
+
+// ZipPipeWriter writes a zip file through a pipe to any destination
+// that accepts an io.Reader, so we can stream a zipped bag directly
+// to S3. Entries are written with data descriptors, so we never need
+// to seek back and rewrite a header, and the zip writer switches to
+// zip64 on its own for large files and bags.
+type ZipPipeWriter struct {
+	pipeReader *io.PipeReader
+	pipeWriter *io.PipeWriter
+	zipWriter  *zip.Writer
+}
+
+// NewZipPipeWriter creates a new ZipPipeWriter.
+func NewZipPipeWriter() *ZipPipeWriter {
+	pipeReader, pipeWriter := io.Pipe()
+	return &ZipPipeWriter{
+		pipeReader: pipeReader,
+		pipeWriter: pipeWriter,
+		zipWriter:  zip.NewWriter(pipeWriter),
+	}
+}
+
+// AddFile writes a zip entry for the file described by the tar header,
+// with data from reader r. We take a tar header so ZipPipeWriter can
+// stand in for TarPipeWriter.
+func (w *ZipPipeWriter) AddFile(header *tar.Header, r io.Reader, manifestAlgs []string) (digests map[string]string, err error) {
+	if header.Name == "" {
+		return make(map[string]string), fmt.Errorf("Zip entry name is missing.")
+	}
+	if header.Size < 0 {
+		return make(map[string]string), fmt.Errorf("Zip entry size cannot be negative for %s.", header.Name)
+	}
+	zipHeader := &zip.FileHeader{
+		Name:     header.Name,
+		Method:   zip.Deflate,
+		Modified: header.ModTime,
+	}
+	zipHeader.SetMode(0644)
+	entry, err := w.zipWriter.CreateHeader(zipHeader)
+	if err != nil {
+		return make(map[string]string), err
+	}
+	return copyWithDigests(entry, header, r, manifestAlgs, "zip archive")
+}
+
+// GetReader returns the io.PipeReader, from which you can read the
+// zip file as it's written.
+func (w *ZipPipeWriter) GetReader() *io.PipeReader {
+	return w.pipeReader
+}
+
+// Finish writes the zip file's central directory and closes the
+// PipeWriter, which sends an EOF to the PipeReader.
+func (w *ZipPipeWriter) Finish() {
+	w.zipWriter.Close()
+	w.pipeWriter.Close()
+}
@@ -0,0 +1,69 @@
+package restoration_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/restoration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZipPipeWriter(t *testing.T) {
+	w := restoration.NewZipPipeWriter()
+	require.NotNil(t, w)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	buf := new(bytes.Buffer)
+	go func() {
+		io.Copy(buf, w.GetReader())
+		wg.Done()
+	}()
+
+	files := map[string]string{
+		"bag/bagit.txt":           "BagIt-Version: 1.0\n",
+		"bag/data/SampleData.txt": "sample data",
+	}
+	for _, name := range []string{"bag/bagit.txt", "bag/data/SampleData.txt"} {
+		header := &tar.Header{Name: name, Size: int64(len(files[name]))}
+		digests, err := w.AddFile(header, bytes.NewReader([]byte(files[name])), constants.APTrustRestorationAlgorithms)
+		require.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte(files[name]))), digests[constants.AlgMd5])
+	}
+
+	// Wrong size
+	_, err := w.AddFile(&tar.Header{Name: "bag/short.txt", Size: 100}, bytes.NewReader([]byte("short")), nil)
+	assert.NotNil(t, err)
+
+	w.Finish()
+	wg.Wait()
+
+	zipReader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Nil(t, err)
+	require.Equal(t, 3, len(zipReader.File))
+	for _, f := range zipReader.File[:2] {
+		reader, err := f.Open()
+		require.Nil(t, err)
+		data, err := io.ReadAll(reader)
+		require.Nil(t, err)
+		assert.Equal(t, files[f.Name], string(data))
+	}
+}
+
+func TestZipPipeWriterValidatesHeader(t *testing.T) {
+	w := restoration.NewZipPipeWriter()
+	go io.Copy(io.Discard, w.GetReader())
+	defer w.Finish()
+	_, err := w.AddFile(&tar.Header{Size: 5}, bytes.NewReader([]byte("hello")), nil)
+	assert.NotNil(t, err)
+	_, err = w.AddFile(&tar.Header{Name: "x", Size: -1}, bytes.NewReader(nil), nil)
+	assert.NotNil(t, err)
+}
@@ -20,10 +20,18 @@ OUTPUT_DIR="$(cd "$SCRIPT_DIR/.." && pwd)/bin/go-bin"
 # SOURCES lists the main go files for each app we're going
 # to compile.
 SOURCES=(
+  "apt_dead_letter/apt_dead_letter.go"
   "apt_delete/apt_delete.go"
+  "apt_delete_dry_run/apt_delete_dry_run.go"
   "apt_fixity/apt_fixity.go"
+  "apt_ingest_all/apt_ingest_all.go"
+  "apt_inventory/apt_inventory.go"
   "apt_queue/apt_queue.go"
+  "apt_purge/apt_purge.go"
   "apt_queue_fixity/apt_queue_fixity.go"
+  "apt_replica_check/apt_replica_check.go"
+  "apt_sync_object_lock/apt_sync_object_lock.go"
+  "apt_verify_receipt/apt_verify_receipt.go"
   "bag_restorer/bag_restorer.go"
   "file_restorer/file_restorer.go"
   "glacier_restorer/glacier_restorer.go"
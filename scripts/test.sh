@@ -76,6 +76,7 @@ make_test_dirs() {
     "wasabi-va"
     "receiving"
     "staging"
+    "deletion-certificates"
     "aptrust.receiving.test.test.edu"
     "aptrust.restore.test.test.edu"
     "aptrust.receiving.test.institution1.edu"
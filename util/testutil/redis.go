@@ -0,0 +1,178 @@
+package testutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakeRedis is a tiny in-process Redis server for unit tests that need
+// a RedisClient but not a real Redis. It understands only the hash
+// commands and a few others that our RedisClient uses. Integration
+// tests should use the real Redis.
+type FakeRedis struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	hashes   map[string]map[string]string
+}
+
+// NewFakeRedis starts a FakeRedis on a random localhost port. Call
+// Close when you're done with it.
+func NewFakeRedis() (*FakeRedis, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	f := &FakeRedis{
+		listener: listener,
+		hashes:   make(map[string]map[string]string),
+	}
+	go f.serve()
+	return f, nil
+}
+
+// Addr returns the host:port on which the server is listening.
+func (f *FakeRedis) Addr() string {
+	return f.listener.Addr().String()
+}
+
+// Close stops the server.
+func (f *FakeRedis) Close() error {
+	return f.listener.Close()
+}
+
+func (f *FakeRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *FakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		_, err = io.WriteString(conn, f.run(args))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads one command, which clients send as an array of
+// bulk strings.
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected array, got %q", line)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func (f *FakeRedis) run(args []string) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(args) == 0 {
+		return "-ERR empty command\r\n"
+	}
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT", "AUTH":
+		return "+OK\r\n"
+	case "HSET":
+		hash := f.hashes[args[1]]
+		if hash == nil {
+			hash = make(map[string]string)
+			f.hashes[args[1]] = hash
+		}
+		added := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, ok := hash[args[i]]; !ok {
+				added++
+			}
+			hash[args[i]] = args[i+1]
+		}
+		return integer(added)
+	case "HGET":
+		value, ok := f.hashes[args[1]][args[2]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case "HEXISTS":
+		if _, ok := f.hashes[args[1]][args[2]]; ok {
+			return integer(1)
+		}
+		return integer(0)
+	case "HDEL":
+		deleted := 0
+		for _, field := range args[2:] {
+			if _, ok := f.hashes[args[1]][field]; ok {
+				delete(f.hashes[args[1]], field)
+				deleted++
+			}
+		}
+		return integer(deleted)
+	case "HGETALL":
+		hash := f.hashes[args[1]]
+		reply := fmt.Sprintf("*%d\r\n", len(hash)*2)
+		for field, value := range hash {
+			reply += bulk(field) + bulk(value)
+		}
+		return reply
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := f.hashes[key]; ok {
+				delete(f.hashes, key)
+				deleted++
+			}
+		}
+		return integer(deleted)
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+func integer(n int) string {
+	return fmt.Sprintf(":%d\r\n", n)
+}
+
+func bulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
@@ -0,0 +1,132 @@
+package workers
+
+import (
+	"fmt"
+	"time"
+)
+
+// DrainPollInterval is how often a draining worker checks whether it has
+// finished its items in process.
+var DrainPollInterval = 5 * time.Second
+
+// AdminState describes what an admin has asked a worker to do through
+// the admin API, and how far the worker has gotten. See AdminServer.
+type AdminState struct {
+	// Topic is the topic the worker consumes.
+	Topic string
+	// Paused indicates the worker has stopped taking new messages.
+	// Items already in process continue.
+	Paused bool
+	// Draining indicates the worker is finishing its items in process
+	// so it can exit. Draining workers are paused.
+	Draining bool
+	// DrainDeadline is when a draining worker will give up and release
+	// whatever items it hasn't finished. It's zero if there's no deadline.
+	DrainDeadline time.Time
+	// Drained indicates the worker finished draining and stopped its
+	// queue consumer, which ends the process.
+	Drained bool
+	// ItemsInProcess is the number of WorkItems this worker is
+	// working on right now.
+	ItemsInProcess int
+	// ItemsReleased is the number of WorkItems a drain released in
+	// Registry because they weren't done by the deadline.
+	ItemsReleased int
+	// FailedReleases is the number of WorkItems a drain tried
+	// unsuccessfully to release.
+	FailedReleases int
+	// SigTermState describes the worker's response to SIGTERM, if
+	// it got one.
+	SigTermState SigTermState
+}
+
+// GetAdminState returns this worker's AdminState.
+func (b *Base) GetAdminState() AdminState {
+	b.adminMutex.Lock()
+	state := b.adminState
+	b.adminMutex.Unlock()
+	state.Topic = b.Settings.NSQTopic
+	state.ItemsInProcess = len(b.ItemsInProcess.Items())
+	state.SigTermState = b.GetSigTermState()
+	return state
+}
+
+// Pause tells the worker to stop taking new messages from its queue.
+// Items already in process continue.
+func (b *Base) Pause() error {
+	b.adminMutex.Lock()
+	defer b.adminMutex.Unlock()
+	if b.NSQConsumer == nil {
+		return fmt.Errorf("Worker %s is not consuming messages", b.Settings.NSQTopic)
+	}
+	b.NSQConsumer.ChangeMaxInFlight(0)
+	b.adminState.Paused = true
+	b.Context.Logger.Warning("Paused by admin. Not taking new messages.")
+	return nil
+}
+
+// Resume tells a paused worker to start taking new messages again.
+// A worker that's draining or got SIGTERM can't resume.
+func (b *Base) Resume() error {
+	b.adminMutex.Lock()
+	defer b.adminMutex.Unlock()
+	if b.NSQConsumer == nil {
+		return fmt.Errorf("Worker %s is not consuming messages", b.Settings.NSQTopic)
+	}
+	if b.adminState.Draining || b.adminState.Drained {
+		return fmt.Errorf("Worker %s is draining and cannot resume", b.Settings.NSQTopic)
+	}
+	if b.GetSigTermState().Received {
+		return fmt.Errorf("Worker %s received SIGTERM and cannot resume", b.Settings.NSQTopic)
+	}
+	b.NSQConsumer.ChangeMaxInFlight(b.Settings.ChannelBufferSize)
+	b.adminState.Paused = false
+	b.Context.Logger.Warning("Resumed by admin. Taking new messages.")
+	return nil
+}
+
+// Drain pauses the worker and waits in the background for it to finish
+// its items in process. It then stops the worker's queue consumer, which
+// ends the process. Each item is released in the usual way as it
+// finishes. If timeout is greater than zero and the worker hasn't
+// finished by then, the worker releases its remaining items in Registry,
+// as it does on SIGTERM, so other workers can pick them up.
+func (b *Base) Drain(timeout time.Duration) error {
+	err := b.Pause()
+	if err != nil {
+		return err
+	}
+	b.adminMutex.Lock()
+	defer b.adminMutex.Unlock()
+	if b.adminState.Draining || b.adminState.Drained {
+		return nil
+	}
+	b.adminState.Draining = true
+	if timeout > 0 {
+		b.adminState.DrainDeadline = time.Now().Add(timeout)
+	}
+	b.Context.Logger.Warningf("Draining by admin request. %d items in process.", len(b.ItemsInProcess.Items()))
+	go b.waitForDrain(b.adminState.DrainDeadline)
+	return nil
+}
+
+func (b *Base) waitForDrain(deadline time.Time) {
+	for len(b.ItemsInProcess.Items()) > 0 {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			b.Context.Logger.Warning("Drain deadline passed. Releasing remaining items.")
+			_, released, failed := b.releaseItemsInProcess("drain timeout")
+			b.adminMutex.Lock()
+			b.adminState.ItemsReleased = released
+			b.adminState.FailedReleases = failed
+			b.adminMutex.Unlock()
+			break
+		}
+		time.Sleep(DrainPollInterval)
+	}
+	b.adminMutex.Lock()
+	b.adminState.Draining = false
+	b.adminState.Drained = true
+	b.adminMutex.Unlock()
+	b.Context.Logger.Warning("Drain complete. Stopping queue consumer.")
+	b.NSQConsumer.Stop()
+}
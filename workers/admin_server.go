@@ -0,0 +1,181 @@
+package workers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/common"
+)
+
+// AdminServer lets an admin pause, drain and resume the workers in this
+// process, and see what they're doing. There's one per process, so
+// apt_ingest_all's nine workers share it. Workers start it by calling
+// StartAdminServer when Config.AdminServerPort is set. It listens on
+// localhost only, so you have to be on the host or in the container to
+// use it.
+//
+// GET /status returns each worker's AdminState. POST /pause, /resume
+// and /drain do what they say. /drain takes an optional timeout, such as
+// /drain?timeout=30m, after which the worker releases the items it
+// hasn't finished. All endpoints take an optional topic, such as
+// /pause?topic=ingest07_storage, to act on one worker only. Otherwise,
+// they act on all of the workers in the process. All return JSON.
+//
+// apt_fixity doesn't use this. See FixityChecker.
+type AdminServer struct {
+	Context *common.Context
+	Server  *http.Server
+
+	mutex   sync.Mutex
+	workers []*Base
+}
+
+var adminServer *AdminServer
+var adminServerMutex sync.Mutex
+
+// StartAdminServer registers worker with the process's admin server,
+// starting the server first if this is the first worker to register.
+// It does nothing if Config.AdminServerPort is zero.
+func StartAdminServer(worker *Base) {
+	port := worker.Context.Config.AdminServerPort
+	if port <= 0 {
+		return
+	}
+	adminServerMutex.Lock()
+	defer adminServerMutex.Unlock()
+	if adminServer == nil {
+		adminServer = NewAdminServer(worker.Context, port)
+		go func(s *AdminServer) {
+			err := s.Server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				s.Context.Logger.Errorf("Admin server stopped: %v", err)
+			}
+		}(adminServer)
+		worker.Context.Logger.Infof("Admin server listening on localhost port %d", port)
+	}
+	adminServer.Register(worker)
+}
+
+// NewAdminServer returns an AdminServer that will listen on port on
+// localhost. Call Server.ListenAndServe to start it.
+func NewAdminServer(context *common.Context, port int) *AdminServer {
+	s := &AdminServer{
+		Context: context,
+		workers: make([]*Base, 0),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.Status)
+	mux.HandleFunc("/pause", s.Pause)
+	mux.HandleFunc("/resume", s.Resume)
+	mux.HandleFunc("/drain", s.Drain)
+	s.Server = &http.Server{
+		Addr:              fmt.Sprintf("127.0.0.1:%d", port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Register adds worker to the workers the server controls.
+func (s *AdminServer) Register(worker *Base) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.workers = append(s.workers, worker)
+}
+
+// Workers returns the registered workers.
+func (s *AdminServer) Workers() []*Base {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*Base{}, s.workers...)
+}
+
+// Status returns the AdminState of the requested workers.
+func (s *AdminServer) Status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Use GET"})
+		return
+	}
+	workers, ok := s.workersFor(w, r)
+	if ok {
+		writeJSON(w, http.StatusOK, adminStates(workers))
+	}
+}
+
+// Pause tells the requested workers to stop taking new messages.
+func (s *AdminServer) Pause(w http.ResponseWriter, r *http.Request) {
+	s.apply(w, r, func(b *Base) error { return b.Pause() })
+}
+
+// Resume tells the requested workers to start taking new messages again.
+func (s *AdminServer) Resume(w http.ResponseWriter, r *http.Request) {
+	s.apply(w, r, func(b *Base) error { return b.Resume() })
+}
+
+// Drain tells the requested workers to finish what they're doing and
+// stop. See Base.Drain.
+func (s *AdminServer) Drain(w http.ResponseWriter, r *http.Request) {
+	timeout := time.Duration(0)
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		var err error
+		timeout, err = time.ParseDuration(value)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid timeout: %v", err)})
+			return
+		}
+	}
+	s.apply(w, r, func(b *Base) error { return b.Drain(timeout) })
+}
+
+// apply calls fn on each requested worker and returns the workers'
+// states. It returns 409 if fn failed for any of them.
+func (s *AdminServer) apply(w http.ResponseWriter, r *http.Request, fn func(*Base) error) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Use POST"})
+		return
+	}
+	workers, ok := s.workersFor(w, r)
+	if !ok {
+		return
+	}
+	status := http.StatusOK
+	errors := make(map[string]string)
+	for _, worker := range workers {
+		if err := fn(worker); err != nil {
+			status = http.StatusConflict
+			errors[worker.Settings.NSQTopic] = err.Error()
+		}
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"errors":  errors,
+		"workers": adminStates(workers),
+	})
+}
+
+// workersFor returns the workers for the topic in the request, or all
+// workers if there's no topic. If no worker consumes the topic, it
+// writes a 404 and returns false.
+func (s *AdminServer) workersFor(w http.ResponseWriter, r *http.Request) ([]*Base, bool) {
+	workers := s.Workers()
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		return workers, true
+	}
+	for _, worker := range workers {
+		if worker.Settings.NSQTopic == topic {
+			return []*Base{worker}, true
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("No worker for topic %s", topic)})
+	return nil, false
+}
+
+func adminStates(workers []*Base) []AdminState {
+	states := make([]AdminState, len(workers))
+	for i, worker := range workers {
+		states[i] = worker.GetAdminState()
+	}
+	return states
+}
@@ -0,0 +1,81 @@
+package workers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/network/queue"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/APTrust/preservation-services/workers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adminTestWorker(t *testing.T, context *common.Context, topic string) *workers.Base {
+	worker := metricsTestWorker(topic)
+	worker.Context = context
+	worker.Settings.ChannelBufferSize = 10
+	consumer, err := context.Queue.Consume(topic, "admin_test", 10, worker)
+	require.Nil(t, err)
+	worker.NSQConsumer = consumer
+	return worker
+}
+
+func adminRequest(server *workers.AdminServer, method, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	server.Server.Handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	return recorder
+}
+
+func TestAdminServer(t *testing.T) {
+	workers.DrainPollInterval = 10 * time.Millisecond
+	context := &common.Context{
+		Config: common.NewConfig(),
+		Logger: logger.DiscardLogger("admin_server_test"),
+		Queue:  queue.NewMemory(),
+	}
+	worker1 := adminTestWorker(t, context, "admin_test_1")
+	worker2 := adminTestWorker(t, context, "admin_test_2")
+	server := workers.NewAdminServer(context, 8998)
+	assert.Equal(t, "127.0.0.1:8998", server.Server.Addr)
+	server.Register(worker1)
+	server.Register(worker2)
+
+	recorder := adminRequest(server, http.MethodGet, "/status")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var states []workers.AdminState
+	require.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &states))
+	require.Equal(t, 2, len(states))
+	assert.Equal(t, "admin_test_1", states[0].Topic)
+	assert.False(t, states[0].Paused)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, adminRequest(server, http.MethodGet, "/pause").Code)
+	assert.Equal(t, http.StatusNotFound, adminRequest(server, http.MethodPost, "/pause?topic=no_such_topic").Code)
+
+	// Pause one worker, then resume it.
+	assert.Equal(t, http.StatusOK, adminRequest(server, http.MethodPost, "/pause?topic=admin_test_1").Code)
+	assert.True(t, worker1.GetAdminState().Paused)
+	assert.False(t, worker2.GetAdminState().Paused)
+	assert.Equal(t, http.StatusOK, adminRequest(server, http.MethodPost, "/resume?topic=admin_test_1").Code)
+	assert.False(t, worker1.GetAdminState().Paused)
+
+	// Drain with no items in process stops the consumer right away,
+	// and a drained worker can't resume.
+	assert.Equal(t, http.StatusBadRequest, adminRequest(server, http.MethodPost, "/drain?timeout=soon").Code)
+	assert.Equal(t, http.StatusOK, adminRequest(server, http.MethodPost, "/drain?topic=admin_test_2&timeout=1m").Code)
+	select {
+	case <-worker2.NSQConsumer.StopChan():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drained worker did not stop its consumer")
+	}
+	state := worker2.GetAdminState()
+	assert.True(t, state.Paused)
+	assert.True(t, state.Drained)
+	assert.False(t, state.Draining)
+	assert.Equal(t, http.StatusConflict, adminRequest(server, http.MethodPost, "/resume?topic=admin_test_2").Code)
+	assert.False(t, worker1.GetAdminState().Drained)
+}
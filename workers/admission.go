@@ -0,0 +1,88 @@
+package workers
+
+import (
+	"time"
+
+	"github.com/APTrust/preservation-services/models/registry"
+)
+
+// DefaultInstitutionRequeueDelay is how long DeferItem holds an item
+// back when Config.InstitutionRequeueDelay isn't set.
+const DefaultInstitutionRequeueDelay = 30 * time.Second
+
+// AdmitItem returns true if this worker should start work on workItem
+// now. It returns false if workItem's institution already has as many
+// items in process in this worker as Config.InstitutionLimitFor allows,
+// so that one depositor's large batch doesn't hold up everyone else.
+// Items with a priority above zero are always admitted, though they
+// still count toward their institution's limit.
+//
+// Admitted items count toward the limit until they're removed from the
+// ItemsInProcess list.
+func (b *Base) AdmitItem(workItem *registry.WorkItem) bool {
+	limit := b.institutionLimit(workItem.InstitutionID)
+	b.admissionMutex.Lock()
+	defer b.admissionMutex.Unlock()
+	if b.admitted == nil {
+		b.admitted = make(map[int64]int64)
+	}
+	if _, ok := b.admitted[workItem.ID]; ok {
+		return true
+	}
+	if workItem.Priority <= 0 && limit > 0 {
+		inFlight := 0
+		for _, instID := range b.admitted {
+			if instID == workItem.InstitutionID {
+				inFlight++
+			}
+		}
+		if inFlight >= limit {
+			b.Context.Logger.Infof("Holding back WorkItem %d (%s) because institution %d already has %d items in process, and its limit is %d", workItem.ID, workItem.Name, workItem.InstitutionID, inFlight, limit)
+			return false
+		}
+	}
+	b.admitted[workItem.ID] = workItem.InstitutionID
+	return true
+}
+
+// DeferItem puts workItem back in this worker's queue, to be delivered
+// again after Config.InstitutionRequeueDelay. Unlike requeueing the
+// message, this doesn't count as an attempt, so an item can wait as
+// long as it has to without being dropped. If publishing fails, this
+// returns the error so the queue requeues the message instead.
+func (b *Base) DeferItem(workItem *registry.WorkItem) error {
+	delay := b.Context.Config.InstitutionRequeueDelay
+	if delay <= 0 {
+		delay = DefaultInstitutionRequeueDelay
+	}
+	err := b.Context.Queue.EnqueueDeferred(b.Settings.NSQTopic, workItem.ID, delay)
+	if err != nil {
+		b.Context.Logger.Errorf("Could not defer WorkItem %d (%s), so requeueing it: %v", workItem.ID, workItem.Name, err)
+		return err
+	}
+	MetricsFor(b.Settings.NSQTopic).RecordDeferred()
+	return nil
+}
+
+// institutionLimit returns the in-flight limit for the institution with
+// the specified id. If we can't look up the institution's identifier,
+// it returns the default limit.
+func (b *Base) institutionLimit(instID int64) int {
+	if len(b.Context.Config.InstitutionLimits) == 0 {
+		return b.Context.Config.InstitutionMaxInFlight
+	}
+	identifier, err := b.GetInstitutionIdentifier(instID)
+	if err != nil {
+		b.Context.Logger.Warningf("Using default in-flight limit for institution %d because we could not get its identifier: %v", instID, err)
+		return b.Context.Config.InstitutionMaxInFlight
+	}
+	return b.Context.Config.InstitutionLimitFor(identifier)
+}
+
+// releaseAdmission stops counting workItemID toward its institution's
+// in-flight limit.
+func (b *Base) releaseAdmission(workItemID int64) {
+	b.admissionMutex.Lock()
+	defer b.admissionMutex.Unlock()
+	delete(b.admitted, workItemID)
+}
@@ -0,0 +1,146 @@
+package workers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network/queue"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/APTrust/preservation-services/workers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deferredHandler records the bodies of the messages it gets.
+type deferredHandler struct {
+	bodies chan string
+}
+
+func (h *deferredHandler) HandleMessage(message queue.Message) error {
+	h.bodies <- string(message.Body())
+	return nil
+}
+
+func TestAdmitItem(t *testing.T) {
+	context := &common.Context{
+		Config: common.NewConfig(),
+		Logger: logger.DiscardLogger("admission_test"),
+		Queue:  queue.NewMemory(),
+	}
+	context.Config.InstitutionMaxInFlight = 2
+	worker := metricsTestWorker("admission_test_topic")
+	worker.Context = context
+
+	item := func(id, instID int64, priority int) *registry.WorkItem {
+		return &registry.WorkItem{ID: id, InstitutionID: instID, Priority: priority}
+	}
+
+	// Institution 1 gets two items, and then it has to wait.
+	assert.True(t, worker.AdmitItem(item(1, 1, 0)))
+	assert.True(t, worker.AdmitItem(item(2, 1, 0)))
+	assert.False(t, worker.AdmitItem(item(3, 1, 0)))
+
+	// Other institutions don't wait on institution 1, and urgent
+	// items don't wait at all.
+	assert.True(t, worker.AdmitItem(item(4, 2, 0)))
+	assert.True(t, worker.AdmitItem(item(5, 1, 1)))
+	assert.False(t, worker.AdmitItem(item(3, 1, 0)))
+
+	// Finishing items frees up room.
+	worker.RemoveFromInProcessList(1)
+	assert.False(t, worker.AdmitItem(item(3, 1, 0)))
+	worker.RemoveFromInProcessList(5)
+	assert.True(t, worker.AdmitItem(item(3, 1, 0)))
+
+	// No limit means everything gets in.
+	context.Config.InstitutionMaxInFlight = 0
+	assert.True(t, worker.AdmitItem(item(6, 1, 0)))
+}
+
+func TestDeferItem(t *testing.T) {
+	topic := "defer_test_topic"
+	context := &common.Context{
+		Config: common.NewConfig(),
+		Logger: logger.DiscardLogger("admission_test"),
+		Queue:  queue.NewMemory(),
+	}
+	context.Config.InstitutionRequeueDelay = 20 * time.Millisecond
+	worker := metricsTestWorker(topic)
+	worker.Context = context
+
+	handler := &deferredHandler{bodies: make(chan string, 1)}
+	consumer, err := context.Queue.Consume(topic, "defer_test", 1, handler)
+	require.Nil(t, err)
+	defer consumer.Stop()
+
+	require.Nil(t, worker.DeferItem(&registry.WorkItem{ID: 77, InstitutionID: 1}))
+	select {
+	case body := <-handler.bodies:
+		assert.Equal(t, "77", body)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Deferred item was never delivered")
+	}
+	assert.Equal(t, int64(1), workers.MetricsFor(topic).Deferred)
+}
+
+// noopProcessor is a processor that does nothing and succeeds.
+type noopProcessor struct{}
+
+func (p *noopProcessor) Run() (int, []*service.ProcessingError) { return 1, nil }
+func (p *noopProcessor) IngestObjectGet() *service.IngestObject { return nil }
+func (p *noopProcessor) IngestObjectSave() error                { return nil }
+
+func TestEnqueueWorkItem(t *testing.T) {
+	memory := queue.NewMemory()
+	context := &common.Context{
+		Config: common.NewConfig(),
+		Logger: logger.DiscardLogger("admission_test"),
+		Queue:  memory,
+	}
+	topic, err := workers.EnqueueWorkItem(context, constants.TopicFileRestore, &registry.WorkItem{ID: 1})
+	require.Nil(t, err)
+	assert.Equal(t, constants.TopicFileRestore, topic)
+	topic, err = workers.EnqueueWorkItem(context, constants.TopicFileRestore, &registry.WorkItem{ID: 2, Priority: 1})
+	require.Nil(t, err)
+	assert.Equal(t, "restore_file_priority", topic)
+	assert.Equal(t, 1, memory.Depth(constants.TopicFileRestore, ""))
+	assert.Equal(t, 1, memory.Depth("restore_file_priority", ""))
+}
+
+func TestProcessItemTakesPriorityFirst(t *testing.T) {
+	topic := "priority_test_topic"
+	worker := metricsTestWorker(topic)
+	worker.Context = &common.Context{
+		Config: common.NewConfig(),
+		Logger: logger.DiscardLogger("admission_test"),
+	}
+	task := func(id int64, priority int) *workers.Task {
+		return &workers.Task{
+			Processor:  &noopProcessor{},
+			WorkItem:   &registry.WorkItem{ID: id, Priority: priority},
+			WorkResult: service.NewWorkResult(topic),
+		}
+	}
+
+	// Three items were waiting when an urgent one arrived.
+	worker.ProcessChannel <- task(1, 0)
+	worker.ProcessChannel <- task(2, 0)
+	worker.ProcessChannel <- task(3, 0)
+	worker.PriorityChannel <- task(4, 1)
+	go worker.ProcessItem()
+
+	processed := make([]int64, 0, 4)
+	for len(processed) < 4 {
+		select {
+		case task := <-worker.SuccessChannel:
+			processed = append(processed, task.WorkItem.ID)
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "Timed out waiting for tasks", "got %v", processed)
+		}
+	}
+	assert.Equal(t, []int64{4, 1, 2, 3}, processed)
+}
@@ -72,6 +72,7 @@ func (q *APTQueue) run() {
 	params.Set("status", constants.StatusPending)
 	params.Set("retry", "true")
 	params.Add("action__in", constants.ActionDelete)
+	params.Add("action__in", constants.ActionDeletionDryRun)
 	params.Add("action__in", constants.ActionGlacierRestore)
 	params.Add("action__in", constants.ActionRestoreFile)
 	params.Add("action__in", constants.ActionRestoreObject)
@@ -114,7 +115,7 @@ func (q *APTQueue) addToNSQ(workItem *registry.WorkItem) bool {
 			workItem.Stage, workItem.Status)
 		return false
 	}
-	err = q.Context.NSQClient.Enqueue(topic, workItem.ID)
+	topic, err = EnqueueWorkItem(q.Context, topic, workItem)
 	if err != nil {
 		q.Context.Logger.Errorf("Error sending WorkItem %d %s (%s/%s/%s) - to %s: %v",
 			workItem.ID, identifier, workItem.Action,
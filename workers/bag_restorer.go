@@ -11,8 +11,9 @@ import (
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network/queue"
 	"github.com/APTrust/preservation-services/restoration"
-	"github.com/nsqio/go-nsq"
+	"github.com/APTrust/preservation-services/util"
 )
 
 type BagRestorer struct {
@@ -23,6 +24,10 @@ type BagRestorer struct {
 // Context object with connections to S3, Redis, Registry, and NSQ.
 func NewBagRestorer(bufSize, numWorkers, maxAttempts int) *BagRestorer {
 	_context := common.NewContext()
+	// Don't restore bags we can't sign receipts for.
+	if err := _context.Config.CheckRestorationReceiptKey(); err != nil {
+		util.PrintAndExit(err.Error())
+	}
 	bufSize, numWorkers, maxAttempts = _context.Config.GetWorkerSettings(constants.TopicObjectRestore, bufSize, numWorkers, maxAttempts)
 	settings := &Settings{
 		ChannelBufferSize: bufSize,
@@ -40,6 +45,7 @@ func NewBagRestorer(bufSize, numWorkers, maxAttempts int) *BagRestorer {
 			Settings:          settings,
 			ItemsInProcess:    service.NewRingList(settings.ChannelBufferSize * settings.NumberOfWorkers),
 			ProcessChannel:    make(chan *Task, settings.ChannelBufferSize),
+			PriorityChannel:   make(chan *Task, settings.ChannelBufferSize),
 			SuccessChannel:    make(chan *Task, settings.ChannelBufferSize),
 			ErrorChannel:      make(chan *Task, settings.ChannelBufferSize),
 			FatalErrorChannel: make(chan *Task, settings.ChannelBufferSize),
@@ -86,6 +92,7 @@ func (r *BagRestorer) ProcessSuccessChannel() {
 		// Tell Registry item succeeded.
 		note := fmt.Sprintf("Object %s restored to %s.", task.WorkItem.ObjectIdentifier, task.RestorationObject.URL)
 		task.WorkItem.Note = note
+		AppendFailoverNote(task.WorkItem, task.RestorationObject)
 		task.WorkItem.Stage = r.Settings.NextWorkItemStage
 		task.WorkItem.Status = constants.StatusSuccess
 		task.WorkItem.Retry = false
@@ -93,6 +100,7 @@ func (r *BagRestorer) ProcessSuccessChannel() {
 		task.WorkItem.Outcome = "Bag restoration complete"
 
 		r.FinishItem(task)
+		r.DeleteRedisData(task)
 
 		// Tell NSQ this b is done with this message.
 		task.NSQFinish()
@@ -104,6 +112,12 @@ func (r *BagRestorer) ProcessSuccessChannel() {
 
 func (r *BagRestorer) ProcessErrorChannel() {
 	for task := range r.ErrorChannel {
+		if task.RestorationObject.NeedsGlacierRestore {
+			r.Context.Logger.Warningf("WorkItem %d (%s) can be restored only from Glacier",
+				task.WorkItem.ID, task.WorkItem.Name)
+			r.HandOffToGlacier(task)
+			continue
+		}
 		shouldRequeue := true
 		r.Context.Logger.Warningf("WorkItem %d (%s) is in error channel",
 			task.WorkItem.ID, task.WorkItem.Name)
@@ -113,17 +127,20 @@ func (r *BagRestorer) ProcessErrorChannel() {
 
 		// Update WorkItem in Registry
 		task.WorkItem.Note = task.WorkResult.NonFatalErrorMessage()
+		AppendFailoverNote(task.WorkItem, task.RestorationObject)
 		if task.WorkResult.Attempt >= r.Settings.MaxAttempts {
 			task.WorkItem.Note += fmt.Sprintf(" Will not retry: failed %d times.", task.WorkResult.Attempt)
 			task.WorkItem.Retry = false
 			task.WorkItem.NeedsAdminReview = true
 			shouldRequeue = false
+			r.DeadLetter(task.WorkItem, task.WorkResult, fmt.Sprintf("Failed %d times", task.WorkResult.Attempt))
 		}
 		r.FinishItem(task)
 		if shouldRequeue {
 			task.NSQRequeue(r.Settings.RequeueTimeout)
 		} else {
 			task.NSQFinish()
+			r.DeleteRedisData(task)
 			// For e2e tests, let the test worker know this failed
 			QueueE2EWorkItem(r.Context, constants.TopicE2ERestore, task.WorkItem.ID)
 		}
@@ -140,11 +157,14 @@ func (r *BagRestorer) ProcessFatalErrorChannel() {
 
 		// Update WorkItem for Registry
 		task.WorkItem.Note = task.WorkResult.FatalErrorMessage()
+		AppendFailoverNote(task.WorkItem, task.RestorationObject)
 		task.WorkItem.Retry = false
 		task.WorkItem.NeedsAdminReview = true
+		r.DeadLetter(task.WorkItem, task.WorkResult, "Fatal error")
 
 		// Update Registry and Redis
 		r.FinishItem(task)
+		r.DeleteRedisData(task)
 
 		// Tell NSQ we're done with this message.
 		task.NSQFinish()
@@ -154,7 +174,7 @@ func (r *BagRestorer) ProcessFatalErrorChannel() {
 	}
 }
 
-func (r *BagRestorer) GetTaskObject(message *nsq.Message, workItem *registry.WorkItem, workResult *service.WorkResult) (*Task, error) {
+func (r *BagRestorer) GetTaskObject(message queue.Message, workItem *registry.WorkItem, workResult *service.WorkResult) (*Task, error) {
 
 	restorationObject, err := GetRestorationObject(r.Context, workItem, constants.RestorationSourceS3)
 	if err != nil {
@@ -179,6 +199,12 @@ func (r *BagRestorer) GetTaskObject(message *nsq.Message, workItem *registry.Wor
 // WorkItem.
 func (r *BagRestorer) ShouldSkipThis(workItem *registry.WorkItem) bool {
 
+	// The item is waiting for its files to come out of Glacier.
+	// The Glacier restorer will queue it again when they do.
+	if IsAwaitingGlacier(r.Context, workItem) {
+		return true
+	}
+
 	// It's possible that another worker recently marked this as
 	// "do not retry." If that's the case, skip it.
 	if !r.ShouldRetry(workItem) {
@@ -1,11 +1,13 @@
 package workers
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,19 +17,19 @@ import (
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
 	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/network/queue"
 	"github.com/APTrust/preservation-services/util"
-	"github.com/nsqio/go-nsq"
 )
 
 // ServiceWorker defines the primary interface for service workers.
 // Actual workers will implement other methods in addition to these.
 type ServiceWorker interface {
 	RegisterAsNsqConsumer() error
-	HandleMessage(*nsq.Message) error
+	HandleMessage(queue.Message) error
 	ProcessSuccessChannel()
 	ProcessErrorChannel()
 	ProcessFatalErrorChannel()
-	GetWorkItem(*nsq.Message) (*registry.WorkItem, *service.ProcessingError)
+	GetWorkItem(queue.Message) (*registry.WorkItem, *service.ProcessingError)
 	Error(int, string, error, bool) *service.ProcessingError
 	GetInstitutionIdentifier(int) (string, error)
 	GetWorkResult(int) *service.WorkResult
@@ -36,6 +38,8 @@ type ServiceWorker interface {
 	OtherWorkerIsHandlingThis(*registry.WorkItem) bool
 	ImAlreadyProcessingThis(*registry.WorkItem) bool
 	ShouldRetry(*registry.WorkItem) bool
+	AdmitItem(*registry.WorkItem) bool
+	DeferItem(*registry.WorkItem) error
 	AddToInProcessList(int)
 	RemoveFromInProcessList(int)
 	MarkAsStarted(*Task)
@@ -80,6 +84,11 @@ type Base struct {
 	// storage, recording, etc., depending on the worker's responsibility.
 	ProcessChannel chan *Task
 
+	// PriorityChannel holds tasks for WorkItems with a priority above
+	// zero. ProcessItem takes tasks from here before it takes any from
+	// the ProcessChannel.
+	PriorityChannel chan *Task
+
 	// SuccessChannel processes items that have gone through the
 	// ProcessChannel with no errors.
 	SuccessChannel chan *Task
@@ -109,15 +118,18 @@ type Base struct {
 	// GetTaskObject returns a Task object to be worked on.
 	// This is not implemented in Base itself. It MUST be implemented
 	// in structs that derive from Base.
-	GetTaskObject func(*nsq.Message, *registry.WorkItem, *service.WorkResult) (*Task, error)
+	GetTaskObject func(queue.Message, *registry.WorkItem, *service.WorkResult) (*Task, error)
 
 	// institutionCache maps institution ids to identifiers. The institution
 	// identifier is typically a domain name like "virginia.edu", "test.org",
 	// etc.
 	institutionCache map[int64]string
+	institutionMutex sync.Mutex
 
-	// NSQConsumer implements HandleMessage to receive messages from NSQ.
-	NSQConsumer *nsq.Consumer
+	// NSQConsumer delivers messages from NSQ, or from the in-process
+	// queue, to HandleMessage. It consumes both the worker's topic and
+	// its priority topic.
+	NSQConsumer queue.Consumer
 
 	// processorConstructor is a function that returns an instance of
 	// *ingest.Base that will handle the processing for this worker.
@@ -127,28 +139,39 @@ type Base struct {
 	// SIGTERM or SIGINT, and what cleanup work it did after receiving the
 	// signal.
 	sigTermState SigTermState
+
+	// adminState describes what an admin has asked this worker to do
+	// through the admin API: pause, resume or drain.
+	adminState AdminState
+	adminMutex sync.Mutex
+
+	// admitted maps the ids of WorkItems this worker has admitted to
+	// the ids of their institutions. See AdmitItem.
+	admitted       map[int64]int64
+	admissionMutex sync.Mutex
 }
 
-// RegisterAsNsqConsumer registers this worker as an NSQ consumer on
-// Settings.NSQTopic and Settings.NSQChannel. Note that as soon as you
-// call this, your worker will start handling messages if any are
-// available.
+// RegisterAsNsqConsumer registers this worker as a consumer on
+// Settings.NSQTopic and Settings.NSQChannel of the Context's queue,
+// which is NSQ unless the config says otherwise. It also consumes the
+// priority topic for Settings.NSQTopic, so priority items don't wait
+// behind the items in NSQTopic. See EnqueueWorkItem. Note that as soon as
+// you call this, your worker will start handling messages if any are
+// available. If Config.HealthServerPort or Config.AdminServerPort is
+// set, this also registers the worker with the health or admin server.
 func (b *Base) RegisterAsNsqConsumer() error {
 	if b.Context.Config.ConfigName == "audit" {
 		panic("Do not run workers with 'audit' config")
 	}
-	config := nsq.NewConfig()
-	//config.Set("msg_timeout", "600m")
-	config.Set("heartbeat_interval", "10s")
-	config.Set("max_in_flight", b.Settings.ChannelBufferSize)
-	consumer, err := nsq.NewConsumer(b.Settings.NSQTopic, b.Settings.NSQChannel, config)
+	topics := []string{b.Settings.NSQTopic, constants.PriorityTopicFor(b.Settings.NSQTopic)}
+	consumer, err := queue.ConsumeAll(b.Context.Queue, topics, b.Settings.NSQChannel, b.Settings.ChannelBufferSize, b)
 	if err != nil {
 		return err
 	}
 	b.NSQConsumer = consumer
-	b.NSQConsumer.AddHandler(b)
-	b.NSQConsumer.ConnectToNSQLookupd(b.Context.Config.NsqLookupd)
-	b.Context.Logger.Info("Registered as NSQ consumer")
+	b.Context.Logger.Info("Registered as queue consumer")
+	StartHealthServer(b)
+	StartAdminServer(b)
 	return nil
 }
 
@@ -158,7 +181,7 @@ func (b *Base) RegisterAsNsqConsumer() error {
 // in the the PreProcessChannel. From there, the worker should instantiate
 // and assign the right IngestItem.Processor type and push the item into
 // the ProcessChannel.
-func (b *Base) HandleMessage(message *nsq.Message) error {
+func (b *Base) HandleMessage(message queue.Message) error {
 
 	// Try to capture and log panics. These happen only in
 	// the format identifier, and they're coming from an
@@ -187,10 +210,28 @@ func (b *Base) HandleMessage(message *nsq.Message) error {
 		return nil
 	}
 
+	// If this item's institution already has all the items in process
+	// it's allowed, put this one back in the queue for later, so other
+	// institutions get a turn. As with skipped items, we haven't marked
+	// this as started, so we don't save it to Registry.
+	if !b.AdmitItem(workItem) {
+		return b.DeferItem(workItem)
+	}
+
 	workResult := b.GetWorkResult(workItem.ID)
 	task, err := b.GetTaskObject(message, workItem, workResult)
 	if err != nil {
 		b.Context.Logger.Errorf("Could not get Task for WorkItem %d (%s): %v", workItem.ID, workItem.Name, err)
+		b.releaseAdmission(workItem.ID)
+		// A restoration to a target that isn't allowed will never
+		// succeed, so fail it now instead of letting NSQ retry it.
+		var targetErr *RestorationTargetError
+		if errors.As(err, &targetErr) {
+			workItem.Retry = false
+			workItem.MarkNoLongerInProgress(workItem.Stage, constants.StatusFailed, targetErr.Error())
+			b.SaveWorkItem(workItem)
+			return nil
+		}
 		return err
 	}
 
@@ -200,9 +241,13 @@ func (b *Base) HandleMessage(message *nsq.Message) error {
 	// Make a note that we're processing this.
 	b.AddToInProcessList(workItem.ID)
 
-	// Put the item into the PreProcess channel, which
-	// will set up the Processor to handle it.
-	b.ProcessChannel <- task
+	// Put the item into the Process channel, or the Priority channel
+	// if it's urgent, where the Processor will handle it.
+	if workItem.Priority > 0 {
+		b.PriorityChannel <- task
+	} else {
+		b.ProcessChannel <- task
+	}
 
 	// Return nil (no error) so NSQ knows we're working on this.
 	return nil
@@ -210,14 +255,25 @@ func (b *Base) HandleMessage(message *nsq.Message) error {
 
 // ProcessItem calls task.Processor.Run() and then routes the
 // task to the SuccessChannel, the ErrorChannel, or the
-// FatalErrorChannel, depending on the outcome.
+// FatalErrorChannel, depending on the outcome. It takes tasks from
+// the PriorityChannel first, and from the ProcessChannel only when
+// no priority task is waiting.
 func (b *Base) ProcessItem() {
 	for {
 		select {
 		case signal := <-b.KillChannel:
 			b.doSigTermCleanup(signal)
-		case task := <-b.ProcessChannel:
+		case task := <-b.PriorityChannel:
 			b.processItem(task)
+		default:
+			select {
+			case signal := <-b.KillChannel:
+				b.doSigTermCleanup(signal)
+			case task := <-b.PriorityChannel:
+				b.processItem(task)
+			case task := <-b.ProcessChannel:
+				b.processItem(task)
+			}
 		}
 	}
 }
@@ -229,18 +285,22 @@ func (b *Base) processItem(task *Task) {
 
 	b.Context.Logger.Infof("WorkItem %d: count %d", task.WorkItem.ID, count)
 
+	metrics := MetricsFor(b.Settings.NSQTopic)
 	if task.WorkResult.HasFatalErrors() {
+		metrics.RecordFatalError(task.WorkResult.RunTime())
 		b.FatalErrorChannel <- task
 	} else if task.WorkResult.HasErrors() {
+		metrics.RecordError(task.WorkResult.RunTime())
 		b.ErrorChannel <- task
 	} else {
+		metrics.RecordSuccess(task.WorkItem.Size, task.WorkResult.RunTime())
 		b.SuccessChannel <- task
 	}
 }
 
 // GetWorkItem returns the WorkItem we should be working on.
-func (b *Base) GetWorkItem(message *nsq.Message) (*registry.WorkItem, *service.ProcessingError) {
-	msgBody := strings.TrimSpace(string(message.Body))
+func (b *Base) GetWorkItem(message queue.Message) (*registry.WorkItem, *service.ProcessingError) {
+	msgBody := strings.TrimSpace(string(message.Body()))
 	b.Context.Logger.Info("NSQ Message body: ", msgBody)
 	workItemID, err := strconv.ParseInt(string(msgBody), 10, 64)
 	if err != nil || workItemID == 0 {
@@ -274,6 +334,11 @@ func (b *Base) Error(workItemID int64, identifier string, err error, isFatal boo
 // GetInstitutionIdentifier returns the identifier for the institution
 // with the specified ID.
 func (b *Base) GetInstitutionIdentifier(instID int64) (string, error) {
+	b.institutionMutex.Lock()
+	defer b.institutionMutex.Unlock()
+	if b.institutionCache == nil {
+		b.institutionCache = make(map[int64]string)
+	}
 	if _, ok := b.institutionCache[instID]; !ok {
 		v := url.Values{}
 		v.Add("sort", "name")
@@ -402,6 +467,14 @@ func (b *Base) ShouldRetry(workItem *registry.WorkItem) bool {
 		retry = false
 	}
 	if !retry {
+		// Items that didn't finish went to the dead-letter topic when
+		// the worker gave up on them, but items that someone stopped by
+		// setting retry to false did not. The queue may deliver a
+		// stopped item many times, and it needs only one letter.
+		if !util.StringListContains(constants.CompletedStatusValues, workItem.Status) && !b.HasDeadLetter(workItem) {
+			workResult, _ := b.Context.RedisClient.WorkResultGet(workItem.ID, b.Settings.NSQTopic)
+			b.DeadLetter(workItem, workResult, message)
+		}
 		workItem.MarkNoLongerInProgress(
 			workItem.Stage,
 			workItem.Status,
@@ -421,6 +494,7 @@ func (b *Base) AddToInProcessList(workItemID int64) {
 // ItemsInProcess list.
 func (b *Base) RemoveFromInProcessList(workItemID int64) {
 	b.ItemsInProcess.Del(strconv.FormatInt(workItemID, 10))
+	b.releaseAdmission(workItemID)
 }
 
 // MarkAsStarted tells Registry, Redis, and NSQ that work on this
@@ -459,17 +533,36 @@ func (b *Base) FinishItem(task *Task) {
 	b.SaveWorkItem(task.WorkItem)
 	task.WorkResult.Finish()
 	b.SaveWorkResult(task.WorkItem.ID, task.WorkResult)
+	if task.RestorationObject != nil {
+		// Keep track of bad copies, so the next attempt skips them.
+		err := b.Context.RedisClient.RestorationObjectSave(task.WorkItem.ID, task.RestorationObject)
+		if err != nil {
+			b.Context.Logger.Errorf("Error saving RestorationObject for WorkItem %d: %v", task.WorkItem.ID, err)
+		}
+	}
 	if task.NextQueueTopic != "" {
 		b.PushToQueue(task.WorkItem, task.NextQueueTopic)
 	}
 	b.RemoveFromInProcessList(task.WorkItem.ID)
 }
 
-// PushToQueue pushes the specified WorkItem to the named nsqTopic.
+// DeleteRedisData deletes everything Redis has for the task's WorkItem,
+// including its RestorationObject, Glacier restore states and
+// WorkResults. Call this only after FinishItem, when the item is done
+// for good and no worker will need that data again.
+func (b *Base) DeleteRedisData(task *Task) {
+	_, err := b.Context.RedisClient.WorkItemDelete(task.WorkItem.ID)
+	if err != nil {
+		b.Context.Logger.Errorf("Error deleting Redis data for WorkItem %d: %v", task.WorkItem.ID, err)
+	} else {
+		b.Context.Logger.Infof("Deleted Redis data for WorkItem %d", task.WorkItem.ID)
+	}
+}
+
+// PushToQueue pushes the specified WorkItem to the named nsqTopic, or
+// to its priority topic if the item has a priority above zero.
 func (b *Base) PushToQueue(workItem *registry.WorkItem, nsqTopic string) {
-	err := b.Context.NSQClient.Enqueue(
-		nsqTopic,
-		workItem.ID)
+	nsqTopic, err := EnqueueWorkItem(b.Context, nsqTopic, workItem)
 	if err != nil {
 		msg := fmt.Sprintf("Error adding WorkItem %d (%s/%s) to NSQ topic %s: %v",
 			workItem.ID, workItem.Bucket, workItem.Name, nsqTopic, err)
@@ -482,6 +575,60 @@ func (b *Base) PushToQueue(workItem *registry.WorkItem, nsqTopic string) {
 	}
 }
 
+// HandOffToGlacier parks a restoration task that could not be
+// completed because the only copies we can still try are in Glacier.
+// It creates a Glacier restore WorkItem and queues it, and leaves the
+// original WorkItem pending in StageAwaitingGlacier, so the depositor
+// doesn't see it as done. When the Glacier restorer is done, it moves
+// the original item back to StageRequested and queues it again, and
+// this time the restorer reads from the copies restored to S3. If the
+// Glacier restore fails, the Glacier restorer marks the original item
+// failed. Restorers skip items in StageAwaitingGlacier until then. See
+// IsAwaitingGlacier.
+func (b *Base) HandOffToGlacier(task *Task) {
+	glacierItem := &registry.WorkItem{
+		Action:                constants.ActionGlacierRestore,
+		BagDate:               task.WorkItem.BagDate,
+		Bucket:                task.WorkItem.Bucket,
+		DateProcessed:         task.WorkItem.DateProcessed,
+		ETag:                  task.WorkItem.ETag,
+		GenericFileID:         task.WorkItem.GenericFileID,
+		GenericFileIdentifier: task.WorkItem.GenericFileIdentifier,
+		InstitutionID:         task.WorkItem.InstitutionID,
+		IntellectualObjectID:  task.WorkItem.IntellectualObjectID,
+		Name:                  task.WorkItem.Name,
+		Note:                  fmt.Sprintf("Copies in S3 and Wasabi could not be restored (WorkItem %d). Restoring from Glacier.", task.WorkItem.ID),
+		ObjectIdentifier:      task.WorkItem.ObjectIdentifier,
+		Outcome:               "Awaiting Glacier restore",
+		Priority:              task.WorkItem.Priority,
+		Retry:                 true,
+		Size:                  task.WorkItem.Size,
+		Stage:                 constants.StageRequested,
+		Status:                constants.StatusPending,
+		User:                  task.WorkItem.User,
+	}
+	failoverNote := task.RestorationObject.FailoverNote()
+	resp := b.Context.RegistryClient.WorkItemSave(glacierItem)
+	if resp.Error != nil {
+		b.Context.Logger.Errorf("Error creating Glacier restore WorkItem for %s: %v", task.RestorationObject.Identifier, resp.Error)
+		task.WorkItem.Note = fmt.Sprintf("Only copies in Glacier remain, but worker could not create a Glacier restore WorkItem: %v. Create it manually. %s", resp.Error, failoverNote)
+		task.WorkItem.Status = constants.StatusFailed
+		task.WorkItem.NeedsAdminReview = true
+		task.WorkItem.Retry = false
+	} else {
+		glacierItem = resp.WorkItem()
+		task.WorkItem.Note = fmt.Sprintf("Only copies in Glacier remain. Waiting for WorkItem %d to restore them from Glacier. %s", glacierItem.ID, failoverNote)
+		task.WorkItem.Stage = constants.StageAwaitingGlacier
+		task.WorkItem.Status = constants.StatusPending
+		task.WorkItem.Outcome = "Awaiting Glacier restore"
+	}
+	b.FinishItem(task)
+	if resp.Error == nil {
+		b.PushToQueue(glacierItem, constants.TopicGlacierRestore)
+	}
+	task.NSQFinish()
+}
+
 // doSigTermCleanup handles SIGTERM and SIGINT. AWS's Elastic Scaling
 // service issues SIGTERM before SIGKILL, so we have time to clean up.
 // If we've set stopTimeout to two minutes, we have two minutes to wrap
@@ -543,36 +690,45 @@ func (b *Base) doSigTermCleanup(signal os.Signal) {
 	// record to indicate that this worker no longer owns
 	// it.
 	b.Context.Logger.Warning("SIGTERM step 2: Release WorkItems")
+	b.sigTermState.ItemsInProcess, b.sigTermState.ItemsReleased, b.sigTermState.FailedReleases = b.releaseItemsInProcess("SIGTERM")
+	b.sigTermState.Completed = true
+	b.Context.Logger.Warning("SIGTERM: Done releasing WorkItems")
+	b.Context.Logger.Warning("SIGTERM: Graceful shutdown steps complete. Waiting for SIGKILL.")
+}
+
+// releaseItemsInProcess releases all of the WorkItems this worker is
+// processing, so other workers can pick them up. Param reason goes into
+// the log messages. This returns the number of items in process, the
+// number released, and the number we failed to release.
+func (b *Base) releaseItemsInProcess(reason string) (inProcess, released, failed int) {
 	itemsInProcess := b.ItemsInProcess.Items()
-	b.sigTermState.ItemsInProcess = len(itemsInProcess)
 	for _, strItemID := range itemsInProcess {
 		itemID, err := strconv.ParseInt(strItemID, 10, 64)
 		if err != nil {
-			releaseErr := b.sigTermReleaseWorkItem(itemID)
-			if releaseErr != nil {
-				b.sigTermState.FailedReleases += 1
-				b.Context.Logger.Errorf("Could not release WorkItem %d after SIGTERM: %v", itemID, releaseErr)
-			} else {
-				b.sigTermState.ItemsReleased += 1
-				b.Context.Logger.Warningf("Released WorkItem %d due to SIGTERM", itemID)
-			}
+			continue
+		}
+		releaseErr := b.sigTermReleaseWorkItem(itemID)
+		if releaseErr != nil {
+			failed += 1
+			b.Context.Logger.Errorf("Could not release WorkItem %d after %s: %v", itemID, reason, releaseErr)
+		} else {
+			released += 1
+			b.Context.Logger.Warningf("Released WorkItem %d due to %s", itemID, reason)
 		}
 	}
-	b.sigTermState.Completed = true
-	b.Context.Logger.Warning("SIGTERM: Done releasing WorkItems")
-	b.Context.Logger.Warning("SIGTERM: Graceful shutdown steps complete. Waiting for SIGKILL.")
+	return len(itemsInProcess), released, failed
 }
 
 // sigTermReleaseWorkItem clears the Node and PID, and sets the status
-// to Pending on the specified WorkItem. This is used only when our worker
-// gets a SIGTERM.
+// to Pending on the specified WorkItem. This is used when our worker
+// gets a SIGTERM, or when a drain times out.
 func (b *Base) sigTermReleaseWorkItem(itemID int64) error {
 	resp := b.Context.RegistryClient.WorkItemByID(itemID)
 	if resp.Error != nil {
 		return resp.Error
 	}
 	item := resp.WorkItem()
-	if item.Node != "" {
+	if item.Node == "" {
 		// We haven't claimed this item yet,
 		// so there's no need to release it.
 		return nil
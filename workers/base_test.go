@@ -0,0 +1,167 @@
+package workers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registryStandIn serves WorkItems to the worker under test and records
+// the WorkItems it saves. The item list honors only the filters the
+// workers under test use.
+type registryStandIn struct {
+	mutex  sync.Mutex
+	items  map[int64]*registry.WorkItem
+	saved  []*registry.WorkItem
+	nextID int64
+}
+
+func newRegistryStandIn(items ...*registry.WorkItem) *registryStandIn {
+	s := &registryStandIn{items: make(map[int64]*registry.WorkItem), nextID: 1000}
+	for _, item := range items {
+		s.items[item.ID] = item
+	}
+	return s
+}
+
+func (s *registryStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id, _ := strconv.ParseInt(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], 10, 64)
+	switch {
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/items/show/"):
+		item := s.items[id]
+		if item == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(item)
+	case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/items/update/"):
+		item := &registry.WorkItem{}
+		if err := json.NewDecoder(r.Body).Decode(item); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.items[id] = item
+		s.saved = append(s.saved, item)
+		json.NewEncoder(w).Encode(item)
+	case r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/items/create/"):
+		item := &registry.WorkItem{}
+		if err := json.NewDecoder(r.Body).Decode(item); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.nextID++
+		item.ID = s.nextID
+		s.items[item.ID] = item
+		s.saved = append(s.saved, item)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(item)
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/items"):
+		results := s.listItems(r.URL.Query())
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"count":    len(results),
+			"next":     nil,
+			"previous": nil,
+			"results":  results,
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *registryStandIn) listItems(params url.Values) []*registry.WorkItem {
+	results := make([]*registry.WorkItem, 0)
+	for _, item := range s.items {
+		fields := map[string]string{
+			"action":                  item.Action,
+			"generic_file_identifier": item.GenericFileIdentifier,
+			"object_identifier":       item.ObjectIdentifier,
+			"stage":                   item.Stage,
+			"status":                  item.Status,
+		}
+		matches := true
+		for name, value := range fields {
+			if params.Has(name) && params.Get(name) != value {
+				matches = false
+			}
+		}
+		if matches {
+			results = append(results, item)
+		}
+	}
+	return results
+}
+
+func (s *registryStandIn) savedItems() []*registry.WorkItem {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*registry.WorkItem{}, s.saved...)
+}
+
+func registryStandInContext(t *testing.T, server *httptest.Server) *common.Context {
+	log := logger.DiscardLogger("base_test")
+	client, err := network.NewRegistryClient(server.URL, "v3", "user", "key", constants.AdminAPIPrefix, log)
+	require.Nil(t, err)
+	return &common.Context{
+		Config:         common.NewConfig(),
+		Logger:         log,
+		RegistryClient: client,
+	}
+}
+
+// Regression test: SIGTERM cleanup used to release items only when
+// their ids failed to parse, and skipped the items this worker had
+// claimed, so no items were ever released.
+func TestSigTermReleasesItemsInProcess(t *testing.T) {
+	hostname, _ := os.Hostname()
+	claimed := &registry.WorkItem{ID: 101, Name: "claimed.tar", Node: hostname, Pid: os.Getpid(), Status: constants.StatusStarted}
+	unclaimed := &registry.WorkItem{ID: 102, Name: "unclaimed.tar", Status: constants.StatusPending}
+	standIn := newRegistryStandIn(claimed, unclaimed)
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	worker := metricsTestWorker("sigterm_test_topic")
+	worker.Context = registryStandInContext(t, server)
+	worker.KillChannel = make(chan os.Signal, 1)
+	worker.AddToInProcessList(claimed.ID)
+	worker.AddToInProcessList(unclaimed.ID)
+	go worker.ProcessItem()
+	worker.KillChannel <- syscall.SIGTERM
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !worker.GetSigTermState().Completed {
+		require.True(t, time.Now().Before(deadline), "SIGTERM cleanup did not complete")
+		time.Sleep(10 * time.Millisecond)
+	}
+	state := worker.GetSigTermState()
+	assert.True(t, state.Received)
+	assert.Equal(t, 2, state.ItemsInProcess)
+	assert.Equal(t, 2, state.ItemsReleased)
+	assert.Equal(t, 0, state.FailedReleases)
+
+	// Only the claimed item needed saving.
+	saved := standIn.savedItems()
+	require.Equal(t, 1, len(saved))
+	assert.Equal(t, claimed.ID, saved[0].ID)
+	assert.Empty(t, saved[0].Node)
+	assert.Equal(t, 0, saved[0].Pid)
+	assert.Equal(t, constants.StatusPending, saved[0].Status)
+	assert.Contains(t, saved[0].Note, hostname)
+}
@@ -2,11 +2,15 @@ package workers
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/APTrust/preservation-services/constants"
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network/glacier"
+	"github.com/APTrust/preservation-services/util"
 )
 
 // HasWrongAction returns true and marks this item as no longer in
@@ -27,6 +31,17 @@ func HasWrongAction(context *common.Context, workItem *registry.WorkItem, expect
 	return false
 }
 
+// IsAwaitingGlacier returns true if workItem is a restoration that has
+// handed off to Glacier and is waiting for the Glacier restore to
+// finish. See Base.HandOffToGlacier.
+func IsAwaitingGlacier(context *common.Context, workItem *registry.WorkItem) bool {
+	if workItem.Stage == constants.StageAwaitingGlacier && workItem.Status == constants.StatusPending {
+		context.Logger.Infof("Skipping WorkItem %d because it's waiting for a Glacier restore", workItem.ID)
+		return true
+	}
+	return false
+}
+
 // IsWrongRestorationType returns true if this item does not match the
 // expected restoration type. This item actually belongs
 // in the object restoration queue, not the file restoration queue. Bag
@@ -83,17 +98,170 @@ func GetRestorationObject(context *common.Context, workItem *registry.WorkItem,
 			return nil, err
 		}
 		objectSize = fileSize
+	} else if len(workItem.RestoreIncludePatterns) > 0 || len(workItem.RestoreExcludePatterns) > 0 {
+		// BagRestorer works out the actual size of a partial
+		// restoration once it knows which files match.
+		restorationType = constants.RestorationTypePartial
+	}
+
+	restorationTarget := institution.RestoreBucket
+	if workItem.RestoreTargetBucket != "" {
+		restorationTarget = workItem.RestoreTargetBucket
+	}
+	err := ValidateRestorationTarget(context.Config, institution, workItem)
+	if err != nil {
+		return nil, err
+	}
+
+	// The WorkItem can override the institution's Glacier settings.
+	glacierTier := institution.GlacierRestoreTier
+	if workItem.GlacierRestoreTier != "" {
+		glacierTier = workItem.GlacierRestoreTier
+	}
+	glacierDays := institution.GlacierRestoreDays
+	if workItem.GlacierRestoreDays > 0 {
+		glacierDays = workItem.GlacierRestoreDays
+	}
+	err = ValidateGlacierSettings(workItem, glacierTier, glacierDays)
+	if err != nil {
+		return nil, err
+	}
+
+	restorationObject := &service.RestorationObject{
+		AsOf:                      workItem.RestoreAsOf,
+		Identifier:                identifier,
+		ItemID:                    itemID,
+		BagItProfileIdentifier:    intelObj.BagItProfileIdentifier,
+		ObjectSize:                objectSize,
+		RestorationSource:         restorationSource,
+		RestorationTarget:         restorationTarget,
+		RestorationType:           restorationType,
+		RestorationFormat:         workItem.RestorationFormat,
+		RestorationTargetProvider: workItem.RestoreTargetProvider,
+		RestorationTargetPrefix:   workItem.RestoreTargetPrefix,
+		RestorationTargetDir:      workItem.RestoreTargetDir,
+		IncludePatterns:           workItem.RestoreIncludePatterns,
+		ExcludePatterns:           workItem.RestoreExcludePatterns,
+		GlacierTier:               glacierTier,
+		GlacierRestoreDays:        glacierDays,
+	}
+
+	// Carry over copies that failed verification on an earlier attempt,
+	// so we don't try them again, and progress, so we can resume.
+	saved, err := context.RedisClient.RestorationObjectGet(workItem.ID, identifier)
+	if err == nil && saved != nil {
+		restorationObject.FailedSources = saved.FailedSources
+		restorationObject.Progress = saved.Progress
 	}
+	return restorationObject, nil
+}
 
-	return &service.RestorationObject{
-		Identifier:             identifier,
-		ItemID:                 itemID,
-		BagItProfileIdentifier: intelObj.BagItProfileIdentifier,
-		ObjectSize:             objectSize,
-		RestorationSource:      restorationSource,
-		RestorationTarget:      institution.RestoreBucket,
-		RestorationType:        restorationType,
-	}, nil
+// RestorationTargetError means a WorkItem asked to restore somewhere it
+// isn't allowed to, or with settings we don't accept. Retrying won't
+// help.
+type RestorationTargetError struct {
+	message string
+}
+
+func (e *RestorationTargetError) Error() string {
+	return e.message
+}
+
+func targetError(workItem *registry.WorkItem, format string, args ...interface{}) error {
+	return &RestorationTargetError{
+		message: fmt.Sprintf("WorkItem %d: %s", workItem.ID, fmt.Sprintf(format, args...)),
+	}
+}
+
+// ValidateGlacierSettings returns a RestorationTargetError if tier is
+// not a Glacier retrieval tier or days is negative or more than
+// glacier.MaxDaysToLiveInRestoreBucket. An empty tier and zero days
+// mean use the defaults.
+func ValidateGlacierSettings(workItem *registry.WorkItem, tier string, days int) error {
+	if tier != "" && !glacier.IsValidTier(tier) {
+		return targetError(workItem, "unknown Glacier retrieval tier %s", tier)
+	}
+	if days < 0 || days > glacier.MaxDaysToLiveInRestoreBucket {
+		return targetError(workItem, "Glacier restore days must be between 1 and %d, not %d", glacier.MaxDaysToLiveInRestoreBucket, days)
+	}
+	return nil
+}
+
+// ValidateRestorationTarget returns a RestorationTargetError if the
+// WorkItem's restoration target settings are not allowed. The provider
+// must be one we know. A target bucket must be the institution's
+// restoration bucket or one of its other RestoreBuckets, and it can
+// never be a preservation bucket or the staging bucket. A target
+// directory must be under config.RestoreTargetRoot, and if that isn't
+// set, restoring to local disk is not allowed at all.
+func ValidateRestorationTarget(config *common.Config, institution *registry.Institution, workItem *registry.WorkItem) error {
+	if workItem.RestoreTargetProvider != "" && !util.StringListContains(constants.StorageProviders, workItem.RestoreTargetProvider) {
+		return targetError(workItem, "unknown restoration target provider %s", workItem.RestoreTargetProvider)
+	}
+	if workItem.RestoreTargetDir != "" {
+		return validateTargetDir(config, workItem)
+	}
+	bucket := institution.RestoreBucket
+	if workItem.RestoreTargetBucket != "" {
+		bucket = workItem.RestoreTargetBucket
+	}
+	if bucket == config.StagingBucket {
+		return targetError(workItem, "cannot restore to the staging bucket %s", bucket)
+	}
+	for _, preservationBucket := range config.PreservationBuckets {
+		if bucket == preservationBucket.Bucket {
+			return targetError(workItem, "cannot restore to preservation bucket %s", bucket)
+		}
+	}
+	if bucket != institution.RestoreBucket && !util.StringListContains(institution.RestoreBuckets, bucket) {
+		return targetError(workItem, "bucket %s is not one of %s's restoration buckets", bucket, institution.Identifier)
+	}
+	return nil
+}
+
+// validateTargetDir makes sure the WorkItem's target directory is under
+// config.RestoreTargetRoot, after resolving ".." and any symlinks that
+// already exist.
+func validateTargetDir(config *common.Config, workItem *registry.WorkItem) error {
+	if config.RestoreTargetRoot == "" {
+		return targetError(workItem, "restoring to local disk is not enabled (RESTORE_TARGET_ROOT is not set)")
+	}
+	if !filepath.IsAbs(workItem.RestoreTargetDir) {
+		return targetError(workItem, "restoration target dir %s is not an absolute path", workItem.RestoreTargetDir)
+	}
+	root := resolvePath(config.RestoreTargetRoot)
+	dir := resolvePath(workItem.RestoreTargetDir)
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return targetError(workItem, "restoration target dir %s is not under %s", workItem.RestoreTargetDir, config.RestoreTargetRoot)
+	}
+	return nil
+}
+
+// resolvePath cleans path and resolves symlinks in the longest part of
+// it that exists, so a link inside the root can't point outside it.
+func resolvePath(path string) string {
+	path = filepath.Clean(path)
+	missing := ""
+	for dir := path; ; dir = filepath.Dir(dir) {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(resolved, missing)
+		}
+		if filepath.Dir(dir) == dir {
+			return path
+		}
+		missing = filepath.Join(filepath.Base(dir), missing)
+	}
+}
+
+// AppendFailoverNote adds a description of restoration failovers, if
+// there were any, to the WorkItem note.
+func AppendFailoverNote(workItem *registry.WorkItem, restorationObject *service.RestorationObject) {
+	note := restorationObject.FailoverNote()
+	if note != "" {
+		workItem.Note = strings.TrimSpace(workItem.Note + " " + note)
+	}
 }
 
 // GetFileSize returns the size of the GenericFile with the specified identifier.
@@ -109,11 +277,22 @@ func GetFileSize(context *common.Context, gfIdentifier string) (int64, error) {
 	return gf.Size, nil
 }
 
+// EnqueueWorkItem publishes workItem's ID to topic. If workItem has a
+// priority above zero, it goes to topic's priority topic instead, so
+// the worker picks it up ahead of the items waiting in topic. This
+// returns the topic the item went to.
+func EnqueueWorkItem(context *common.Context, topic string, workItem *registry.WorkItem) (string, error) {
+	if workItem.Priority > 0 {
+		topic = constants.PriorityTopicFor(topic)
+	}
+	return topic, context.Queue.Enqueue(topic, workItem.ID)
+}
+
 // QueueE2EWorkItem queues a WorkItem for post tests if the env variable
 // APT_E2E is set to "true".
 func QueueE2EWorkItem(context *common.Context, topic string, workItemID int64) {
 	if context.Config.IsE2ETest() {
-		err := context.NSQClient.Enqueue(topic, workItemID)
+		err := context.Queue.Enqueue(topic, workItemID)
 		if err != nil {
 			context.Logger.Errorf("E2E Queue Error %s/%d: %v", topic, workItemID, err)
 		} else {
@@ -126,7 +305,7 @@ func QueueE2EWorkItem(context *common.Context, topic string, workItemID int64) {
 // env variable APT_E2E is set to "true".
 func QueueE2EIdentifier(context *common.Context, topic, identifier string) {
 	if context.Config.IsE2ETest() {
-		err := context.NSQClient.EnqueueString(topic, identifier)
+		err := context.Queue.EnqueueString(topic, identifier)
 		if err != nil {
 			context.Logger.Errorf("E2E Queue Error %s/%d: %v", topic, identifier, err)
 		} else {
@@ -0,0 +1,90 @@
+package workers_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/network/glacier"
+	"github.com/APTrust/preservation-services/workers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRestorationTarget(t *testing.T) {
+	config := common.NewConfig()
+	config.RestoreTargetRoot = t.TempDir()
+	institution := &registry.Institution{
+		Identifier:     "test.edu",
+		RestoreBucket:  "aptrust.restore.test.edu",
+		RestoreBuckets: []string{"test.edu.offsite"},
+	}
+	outside := t.TempDir()
+	link := filepath.Join(config.RestoreTargetRoot, "link")
+	require.Nil(t, os.Symlink(outside, link))
+
+	valid := []*registry.WorkItem{
+		{},
+		{RestoreTargetBucket: "test.edu.offsite", RestoreTargetProvider: constants.StorageProviderWasabiVA},
+		{RestoreTargetDir: filepath.Join(config.RestoreTargetRoot, "offline", "job1")},
+	}
+	for _, workItem := range valid {
+		assert.Nil(t, workers.ValidateRestorationTarget(config, institution, workItem), "%+v", workItem)
+	}
+
+	invalid := map[string]*registry.WorkItem{
+		"unknown provider":    {RestoreTargetProvider: "Dropbox"},
+		"other bucket":        {RestoreTargetBucket: "aptrust.restore.example.edu"},
+		"preservation bucket": {RestoreTargetBucket: config.PreservationBuckets[0].Bucket},
+		"staging bucket":      {RestoreTargetBucket: config.StagingBucket},
+		"relative dir":        {RestoreTargetDir: "offline/job1"},
+		"dir outside root":    {RestoreTargetDir: outside},
+		"dot dot out of root": {RestoreTargetDir: filepath.Join(config.RestoreTargetRoot, "..", "elsewhere")},
+		"symlink out of root": {RestoreTargetDir: filepath.Join(link, "job1")},
+		"prefix of root name": {RestoreTargetDir: config.RestoreTargetRoot + "-other"},
+	}
+	// Even if Registry lists a preservation bucket as a restoration
+	// bucket, we don't restore there.
+	institution.RestoreBuckets = append(institution.RestoreBuckets, config.PreservationBuckets[0].Bucket, config.StagingBucket)
+	invalid["listed preservation bucket"] = &registry.WorkItem{RestoreTargetBucket: config.PreservationBuckets[0].Bucket}
+	invalid["listed staging bucket"] = &registry.WorkItem{RestoreTargetBucket: config.StagingBucket}
+	for name, workItem := range invalid {
+		err := workers.ValidateRestorationTarget(config, institution, workItem)
+		require.NotNil(t, err, name)
+		var targetErr *workers.RestorationTargetError
+		assert.ErrorAs(t, err, &targetErr, name)
+	}
+
+	// With no root configured, restoring to local disk is off.
+	config.RestoreTargetRoot = ""
+	err := workers.ValidateRestorationTarget(config, institution, valid[2])
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "RESTORE_TARGET_ROOT")
+}
+
+func TestValidateGlacierSettings(t *testing.T) {
+	workItem := &registry.WorkItem{ID: 77}
+	assert.Nil(t, workers.ValidateGlacierSettings(workItem, "", 0))
+	assert.Nil(t, workers.ValidateGlacierSettings(workItem, glacier.TierBulk, 1))
+	assert.Nil(t, workers.ValidateGlacierSettings(workItem, glacier.TierExpedited, glacier.MaxDaysToLiveInRestoreBucket))
+
+	invalid := map[string]struct {
+		tier string
+		days int
+	}{
+		"unknown tier":  {"Overnight", 5},
+		"lower case":    {"bulk", 5},
+		"negative days": {glacier.TierStandard, -1},
+		"too many days": {glacier.TierStandard, glacier.MaxDaysToLiveInRestoreBucket + 1},
+	}
+	for name, settings := range invalid {
+		err := workers.ValidateGlacierSettings(workItem, settings.tier, settings.days)
+		require.NotNil(t, err, name)
+		var targetErr *workers.RestorationTargetError
+		assert.ErrorAs(t, err, &targetErr, name)
+		assert.Contains(t, err.Error(), "WorkItem 77", name)
+	}
+}
@@ -0,0 +1,284 @@
+package workers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network/queue"
+)
+
+// DeadLetter publishes a DeadLetter for workItem to this worker's
+// dead-letter topic. Workers call this when they give up on an item,
+// so an admin can find it and replay it with apt_dead_letter. Param
+// workResult may be nil.
+//
+// This also notes in Redis that the item has a letter, so ShouldRetry
+// doesn't add another each time the queue redelivers the item. See
+// HasDeadLetter.
+func (b *Base) DeadLetter(workItem *registry.WorkItem, workResult *service.WorkResult, reason string) {
+	letter := service.NewDeadLetter(workItem, workResult, b.Settings.NSQTopic, reason)
+	data, err := letter.ToJSON()
+	if err != nil {
+		b.Context.Logger.Errorf("Cannot serialize dead letter for WorkItem %d: %v", workItem.ID, err)
+		return
+	}
+	topic := constants.DeadLetterTopicFor(b.Settings.NSQTopic)
+	err = b.Context.Queue.EnqueueString(topic, data)
+	if err != nil {
+		b.Context.Logger.Errorf("Error adding WorkItem %d (%s) to dead-letter topic %s: %v", workItem.ID, workItem.Name, topic, err)
+		return
+	}
+	b.Context.Logger.Warningf("Added WorkItem %d (%s) to dead-letter topic %s: %s", workItem.ID, workItem.Name, topic, reason)
+	err = b.Context.RedisClient.DeadLetterSave(workItem.ID, b.Settings.NSQTopic)
+	if err != nil {
+		b.Context.Logger.Errorf("Cannot record dead letter for WorkItem %d in Redis: %v", workItem.ID, err)
+	}
+}
+
+// HasDeadLetter returns true if workItem already has a letter in this
+// worker's dead-letter topic that hasn't been replayed. If we can't
+// tell, this returns false, since a duplicate letter is better than
+// none.
+func (b *Base) HasDeadLetter(workItem *registry.WorkItem) bool {
+	exists, err := b.Context.RedisClient.DeadLetterExists(workItem.ID, b.Settings.NSQTopic)
+	if err != nil {
+		b.Context.Logger.Warningf("Cannot check Redis for dead letter for WorkItem %d: %v", workItem.ID, err)
+		return false
+	}
+	return exists
+}
+
+// DeadLetterBox reads the dead letters in a worker's dead-letter topic
+// so apt_dead_letter can list and replay them. Call Open to read the
+// letters and Close when you're done. Close removes the letters of
+// replayed WorkItems from the topic and puts the rest back.
+//
+// The box holds every letter it reads in flight until Close, so it sees
+// each letter only once. While it holds them, it touches them every
+// TouchInterval so the queue doesn't time them out and deliver them
+// again. It reads at most MaxInFlight letters.
+type DeadLetterBox struct {
+	Context *common.Context
+
+	// Topic is the worker's topic, e.g. ingest01_prefetch, not the
+	// dead-letter topic itself.
+	Topic string
+
+	// MaxInFlight is the most letters the box will read.
+	MaxInFlight int
+
+	// Wait is how long Open waits for another letter before deciding
+	// it has read them all.
+	Wait time.Duration
+
+	// TouchInterval is how often the box touches the letters it holds.
+	// This must be shorter than nsqd's --msg-timeout, which defaults
+	// to one minute.
+	TouchInterval time.Duration
+
+	mutex    sync.Mutex
+	consumer queue.Consumer
+	messages []queue.Message
+	letters  []*service.DeadLetter
+	replayed map[int64]bool
+	received chan bool
+	done     chan bool
+}
+
+// NewDeadLetterBox returns a DeadLetterBox for the dead letters of the
+// worker that consumes topic.
+func NewDeadLetterBox(context *common.Context, topic string) *DeadLetterBox {
+	return &DeadLetterBox{
+		Context:       context,
+		Topic:         topic,
+		MaxInFlight:   1000,
+		Wait:          5 * time.Second,
+		TouchInterval: 20 * time.Second,
+		messages:      make([]queue.Message, 0),
+		letters:       make([]*service.DeadLetter, 0),
+		replayed:      make(map[int64]bool),
+		received:      make(chan bool, 1),
+	}
+}
+
+// Open reads the letters in the dead-letter topic. It returns once no
+// new letter has arrived for box.Wait.
+func (box *DeadLetterBox) Open() error {
+	consumer, err := box.Context.Queue.Consume(
+		constants.DeadLetterTopicFor(box.Topic),
+		constants.DeadLetterChannel,
+		box.MaxInFlight,
+		box)
+	if err != nil {
+		return err
+	}
+	box.consumer = consumer
+	box.done = make(chan bool)
+	go box.keepAlive()
+	for {
+		select {
+		case <-box.received:
+		case <-time.After(box.Wait):
+			// Stop delivery, so nothing arrives after we've
+			// returned the list of letters.
+			box.consumer.ChangeMaxInFlight(0)
+			return nil
+		}
+	}
+}
+
+// keepAlive touches the letters the box holds until Close.
+func (box *DeadLetterBox) keepAlive() {
+	ticker := time.NewTicker(box.TouchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-box.done:
+			return
+		case <-ticker.C:
+			box.mutex.Lock()
+			for _, message := range box.messages {
+				message.Touch()
+			}
+			box.mutex.Unlock()
+		}
+	}
+}
+
+// HandleMessage holds on to a dead letter until Close. Don't call this
+// directly. It's for the queue.
+func (box *DeadLetterBox) HandleMessage(message queue.Message) error {
+	message.DisableAutoResponse()
+	letter, err := service.DeadLetterFromJSON(string(message.Body()))
+	if err != nil {
+		box.Context.Logger.Errorf("Cannot parse dead letter %s: %v", string(message.Body()), err)
+	}
+	box.mutex.Lock()
+	box.messages = append(box.messages, message)
+	box.letters = append(box.letters, letter)
+	box.mutex.Unlock()
+	select {
+	case box.received <- true:
+	default:
+	}
+	return nil
+}
+
+// Letters returns the letters in the box, oldest first. A WorkItem
+// may have more than one letter if workers gave up on it more than once.
+func (box *DeadLetterBox) Letters() []*service.DeadLetter {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	letters := make([]*service.DeadLetter, 0, len(box.letters))
+	for _, letter := range box.letters {
+		if letter != nil {
+			letters = append(letters, letter)
+		}
+	}
+	sort.SliceStable(letters, func(i, j int) bool {
+		return letters[i].DeadLetteredAt.Before(letters[j].DeadLetteredAt)
+	})
+	return letters
+}
+
+// LettersFor returns the letters for the specified WorkItem, oldest first.
+func (box *DeadLetterBox) LettersFor(workItemID int64) []*service.DeadLetter {
+	letters := make([]*service.DeadLetter, 0)
+	for _, letter := range box.Letters() {
+		if letter.WorkItemID == workItemID {
+			letters = append(letters, letter)
+		}
+	}
+	return letters
+}
+
+// Replay resets the WorkItem in Registry so workers will retry it and
+// pushes it into the topic for its current action and stage, as
+// returned by constants.TopicFor, or into that topic's priority topic
+// if the item has a priority. See EnqueueWorkItem. Its letters are removed from the
+// dead-letter topic when the box is closed.
+func (box *DeadLetterBox) Replay(workItemID int64) (*registry.WorkItem, string, error) {
+	if len(box.LettersFor(workItemID)) == 0 {
+		return nil, "", fmt.Errorf("WorkItem %d is not in dead-letter topic %s", workItemID, constants.DeadLetterTopicFor(box.Topic))
+	}
+	resp := box.Context.RegistryClient.WorkItemByID(workItemID)
+	if resp.Error != nil {
+		return nil, "", resp.Error
+	}
+	workItem := resp.WorkItem()
+	topic, err := constants.TopicFor(workItem.Action, workItem.Stage, workItem.GenericFileIdentifier)
+	if err != nil {
+		return workItem, "", err
+	}
+	workItem.Retry = true
+	workItem.NeedsAdminReview = false
+	workItem.Status = constants.StatusPending
+	workItem.Node = ""
+	workItem.Pid = 0
+	workItem.Note = fmt.Sprintf("Replayed from dead-letter topic %s into %s", constants.DeadLetterTopicFor(box.Topic), topic)
+	resp = box.Context.RegistryClient.WorkItemSave(workItem)
+	if resp.Error != nil {
+		return workItem, topic, resp.Error
+	}
+	workItem = resp.WorkItem()
+	topic, err = EnqueueWorkItem(box.Context, topic, workItem)
+	if err != nil {
+		return workItem, topic, err
+	}
+	box.mutex.Lock()
+	box.replayed[workItemID] = true
+	box.mutex.Unlock()
+	err = box.Context.RedisClient.DeadLetterDelete(workItemID, box.Topic)
+	if err != nil {
+		// Replay worked. This only means that if the item is stopped
+		// again, we won't add another letter for it.
+		box.Context.Logger.Warningf("Cannot clear dead letter record for WorkItem %d in Redis: %v", workItemID, err)
+	}
+	box.Context.Logger.Infof("Replayed WorkItem %d (%s) from %s into %s", workItem.ID, workItem.Name, constants.DeadLetterTopicFor(box.Topic), topic)
+	return workItem, topic, nil
+}
+
+// Close removes the letters of replayed WorkItems from the dead-letter
+// topic and puts the rest back. We republish the letters we keep rather
+// than requeueing them, because queues drop messages that have been
+// requeued too many times, and we don't want listing the letters to
+// make them disappear.
+func (box *DeadLetterBox) Close() error {
+	if box.consumer == nil {
+		return nil
+	}
+	box.consumer.ChangeMaxInFlight(0)
+	close(box.done)
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	var firstErr error
+	topic := constants.DeadLetterTopicFor(box.Topic)
+	for i, message := range box.messages {
+		// Keep letters we couldn't parse, so someone can look at them.
+		letter := box.letters[i]
+		if letter == nil || !box.replayed[letter.WorkItemID] {
+			err := box.Context.Queue.EnqueueString(topic, string(message.Body()))
+			if err != nil {
+				// Leave the original for the queue to redeliver.
+				box.Context.Logger.Errorf("Cannot return dead letter %s to %s: %v", string(message.Body()), topic, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+		}
+		message.Finish()
+	}
+	box.messages = box.messages[:0]
+	box.letters = box.letters[:0]
+	box.consumer.Stop()
+	<-box.consumer.StopChan()
+	box.consumer = nil
+	return firstErr
+}
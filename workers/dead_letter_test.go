@@ -0,0 +1,140 @@
+package workers_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/network/queue"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/APTrust/preservation-services/util/testutil"
+	"github.com/APTrust/preservation-services/workers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deadLetterContext returns a context with an in-process queue and a
+// fake Redis server. Call the returned function to stop the server.
+func deadLetterContext(t *testing.T) (*common.Context, func()) {
+	fakeRedis, err := testutil.NewFakeRedis()
+	require.Nil(t, err)
+	return &common.Context{
+		Config:      common.NewConfig(),
+		Logger:      logger.DiscardLogger("dead_letter_test"),
+		Queue:       queue.NewMemory(),
+		RedisClient: network.NewRedisClient(fakeRedis.Addr(), "", 0),
+	}, func() { fakeRedis.Close() }
+}
+
+// touchedMessage is a queue message that counts how often it was
+// touched.
+type touchedMessage struct {
+	stubMessage
+	body    []byte
+	touches atomic.Int32
+}
+
+func (m *touchedMessage) Body() []byte { return m.body }
+func (m *touchedMessage) Touch()       { m.touches.Add(1) }
+
+func TestDeadLetterBox(t *testing.T) {
+	context, cleanUp := deadLetterContext(t)
+	defer cleanUp()
+	base := &workers.Base{
+		Context:  context,
+		Settings: &workers.Settings{NSQTopic: constants.TopicFileRestore},
+	}
+	workResult := service.NewWorkResult(constants.TopicFileRestore)
+	workResult.Attempt = 3
+	for _, id := range []int64{100, 200, 100} {
+		workItem := &registry.WorkItem{
+			ID:     id,
+			Action: constants.ActionRestoreFile,
+			Stage:  constants.StageRequested,
+			Status: constants.StatusStarted,
+		}
+		base.DeadLetter(workItem, workResult, "Failed 3 times")
+	}
+
+	box := workers.NewDeadLetterBox(context, constants.TopicFileRestore)
+	box.Wait = 100 * time.Millisecond
+	require.Nil(t, box.Open())
+	letters := box.Letters()
+	require.Equal(t, 3, len(letters))
+	assert.Equal(t, constants.TopicFileRestore, letters[0].Topic)
+	assert.Equal(t, 3, letters[0].Attempts)
+	assert.Equal(t, "Failed 3 times", letters[0].Reason)
+	assert.Equal(t, 2, len(box.LettersFor(100)))
+	assert.Equal(t, 1, len(box.LettersFor(200)))
+	assert.Empty(t, box.LettersFor(300))
+
+	_, _, err := box.Replay(300)
+	assert.NotNil(t, err)
+
+	// Reading the letters doesn't remove them.
+	require.Nil(t, box.Close())
+	box = workers.NewDeadLetterBox(context, constants.TopicFileRestore)
+	box.Wait = 100 * time.Millisecond
+	require.Nil(t, box.Open())
+	assert.Equal(t, 3, len(box.Letters()))
+	require.Nil(t, box.Close())
+}
+
+func TestDeadLetterBoxTouchesLetters(t *testing.T) {
+	context, cleanUp := deadLetterContext(t)
+	defer cleanUp()
+	box := workers.NewDeadLetterBox(context, constants.TopicFileRestore)
+	box.Wait = 50 * time.Millisecond
+	box.TouchInterval = 10 * time.Millisecond
+	require.Nil(t, box.Open())
+
+	letter := service.NewDeadLetter(&registry.WorkItem{ID: 100}, nil, constants.TopicFileRestore, "Fatal error")
+	data, err := letter.ToJSON()
+	require.Nil(t, err)
+	message := &touchedMessage{body: []byte(data)}
+	require.Nil(t, box.HandleMessage(message))
+
+	// The queue would time the letter out and deliver it again if
+	// the box didn't touch it while it's held.
+	assert.Eventually(t, func() bool { return message.touches.Load() >= 2 }, 2*time.Second, 10*time.Millisecond)
+	require.Nil(t, box.Close())
+	assert.True(t, message.finished.Load())
+	touches := message.touches.Load()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, touches, message.touches.Load())
+}
+
+func TestShouldRetryDeadLettersOnce(t *testing.T) {
+	context, cleanUp := deadLetterContext(t)
+	defer cleanUp()
+	base := &workers.Base{
+		Context:  context,
+		Settings: &workers.Settings{NSQTopic: constants.TopicFileRestore},
+	}
+	newItem := func() *registry.WorkItem {
+		return &registry.WorkItem{
+			ID:     100,
+			Action: constants.ActionRestoreFile,
+			Stage:  constants.StageRequested,
+			Status: constants.StatusStarted,
+			Retry:  false,
+		}
+	}
+
+	// The queue delivers the stopped item three times, but it gets
+	// only one letter.
+	for i := 0; i < 3; i++ {
+		assert.False(t, base.ShouldRetry(newItem()))
+	}
+	box := workers.NewDeadLetterBox(context, constants.TopicFileRestore)
+	box.Wait = 100 * time.Millisecond
+	require.Nil(t, box.Open())
+	assert.Equal(t, 1, len(box.LettersFor(100)))
+	require.Nil(t, box.Close())
+	assert.True(t, base.HasDeadLetter(newItem()))
+}
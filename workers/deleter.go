@@ -1,6 +1,7 @@
 package workers
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -12,7 +13,7 @@ import (
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
-	"github.com/nsqio/go-nsq"
+	"github.com/APTrust/preservation-services/network/queue"
 )
 
 // Deleter is a worker that processes file and object deletion requests.
@@ -41,6 +42,7 @@ func NewDeleter(bufSize, numWorkers, maxAttempts int) *Deleter {
 			Settings:          settings,
 			ItemsInProcess:    service.NewRingList(settings.ChannelBufferSize * settings.NumberOfWorkers),
 			ProcessChannel:    make(chan *Task, settings.ChannelBufferSize),
+			PriorityChannel:   make(chan *Task, settings.ChannelBufferSize),
 			SuccessChannel:    make(chan *Task, settings.ChannelBufferSize),
 			ErrorChannel:      make(chan *Task, settings.ChannelBufferSize),
 			FatalErrorChannel: make(chan *Task, settings.ChannelBufferSize),
@@ -84,22 +86,50 @@ func (d *Deleter) ProcessSuccessChannel() {
 		d.Context.Logger.Infof("WorkItem %d (%s) is in success channel",
 			task.WorkItem.ID, task.WorkItem.Name)
 
-		// Tell Registry item succeeded.
-		note := fmt.Sprintf("Deletion completed at the request of %s, approved by %s.", task.WorkItem.User, task.WorkItem.InstApprover)
-		if task.WorkItem.APTrustApprover != "" {
-			note += fmt.Sprintf(" APTrust approver: %s.", task.WorkItem.APTrustApprover)
+		manager, _ := task.Processor.(*deletion.Manager)
+
+		// A dry run deletes nothing, so there's nothing left to do.
+		// Its action and outcome say so, so no one reading Registry
+		// mistakes it for a completed deletion.
+		if manager != nil && manager.DryRun {
+			reportJSON, _ := manager.Report.ToJSON()
+			d.Context.Logger.Infof("Deletion dry run report for WorkItem %d: %s", task.WorkItem.ID, reportJSON)
+			task.WorkItem.Note = manager.Report.Summary() + " Nothing was deleted."
+			task.WorkItem.Outcome = "Dry run: nothing deleted"
+			task.WorkItem.Stage = d.Settings.NextWorkItemStage
+			task.WorkItem.Status = constants.StatusSuccess
+			task.WorkItem.Retry = false
+			task.WorkItem.NeedsAdminReview = false
+			d.FinishItem(task)
+			task.NSQFinish()
+			continue
+		}
+
+		// If the files are pending deletion, leave the WorkItem
+		// for apt_purge to finish after the retention period.
+		if manager != nil && !manager.PurgeAfter.IsZero() {
+			purgeAfter := manager.PurgeAfter
+			task.WorkItem.Note = fmt.Sprintf("Marked for deletion at the request of %s, approved by %s. Preservation copies will be purged after %s unless an admin cancels this request.",
+				task.WorkItem.User, task.WorkItem.InstApprover, purgeAfter.Format(time.RFC3339))
+			task.WorkItem.Outcome = "Pending purge"
+			task.WorkItem.Stage = constants.StageAwaitingPurge
+			task.WorkItem.StageStartedAt = time.Now().UTC()
+			task.WorkItem.Status = constants.StatusPending
+			task.WorkItem.PurgeAfter = &purgeAfter
+			task.WorkItem.Retry = true
+			task.WorkItem.NeedsAdminReview = false
+			d.FinishItem(task)
+			task.NSQFinish()
+			continue
 		}
-		task.WorkItem.Note = note
+
+		// Tell Registry item succeeded.
+		task.WorkItem.Note = deletionCompletedNote(task.WorkItem, manager)
 		task.WorkItem.Stage = d.Settings.NextWorkItemStage
 		task.WorkItem.Status = constants.StatusSuccess
 		task.WorkItem.Retry = false
 		task.WorkItem.NeedsAdminReview = false
-
-		if task.WorkItem.GenericFileID > 0 {
-			task.WorkItem.Outcome = "File deletion complete"
-		} else {
-			task.WorkItem.Outcome = "Object deletion complete"
-		}
+		task.WorkItem.Outcome = deletionCompletedOutcome(task.WorkItem)
 
 		d.FinishItem(task)
 
@@ -127,6 +157,7 @@ func (d *Deleter) ProcessErrorChannel() {
 			task.WorkItem.Retry = false
 			task.WorkItem.NeedsAdminReview = true
 			shouldRequeue = false
+			d.DeadLetter(task.WorkItem, task.WorkResult, fmt.Sprintf("Failed %d times", task.WorkResult.Attempt))
 		}
 		d.FinishItem(task)
 		if shouldRequeue {
@@ -150,6 +181,7 @@ func (d *Deleter) ProcessFatalErrorChannel() {
 		task.WorkItem.Note = task.WorkResult.FatalErrorMessage()
 		task.WorkItem.Retry = false
 		task.WorkItem.NeedsAdminReview = true
+		d.DeadLetter(task.WorkItem, task.WorkResult, "Fatal error")
 
 		// Update Registry and Redis
 		d.FinishItem(task)
@@ -160,24 +192,10 @@ func (d *Deleter) ProcessFatalErrorChannel() {
 	}
 }
 
-func (d *Deleter) GetTaskObject(message *nsq.Message, workItem *registry.WorkItem, workResult *service.WorkResult) (*Task, error) {
+func (d *Deleter) GetTaskObject(message queue.Message, workItem *registry.WorkItem, workResult *service.WorkResult) (*Task, error) {
 	// Set up the deletion manager, which actually deletes
 	// the files.
-	id := workItem.GenericFileID
-	itemType := constants.TypeFile
-	if id == 0 && workItem.IntellectualObjectID != 0 {
-		id = workItem.IntellectualObjectID
-		itemType = constants.TypeObject
-	}
-	deletionManager := deletion.NewManager(
-		d.Context,
-		workItem.ID,
-		id,
-		itemType,
-		workItem.User, // requested by
-		workItem.InstApprover,
-		workItem.APTrustApprover,
-	)
+	deletionManager := newDeletionManager(d.Context, workItem)
 
 	// Set up the deletion item, which is packages all the info
 	// that needs to be passed from channel to channel.
@@ -201,11 +219,21 @@ func (d *Deleter) ShouldSkipThis(workItem *registry.WorkItem) bool {
 	}
 
 	// Definitely don't delete this if it's not a deletion request.
-	if HasWrongAction(d.Context, workItem, constants.ActionDelete) {
+	// Dry runs have their own action, so they can never be mistaken
+	// for real deletions.
+	if workItem.Action != constants.ActionDeletionDryRun && HasWrongAction(d.Context, workItem, constants.ActionDelete) {
+		return true
+	}
+
+	// Once files are pending deletion, apt_purge takes over.
+	if workItem.Stage == constants.StageAwaitingPurge {
+		d.Context.Logger.Infof("Rejecting WorkItem %d because it's awaiting purge", workItem.ID)
 		return true
 	}
 
 	// Do not proceed without the approval of institutional admin.
+	// That goes for dry runs too. Admins who want a report without
+	// a request can run apt_delete_dry_run.
 	if d.MissingRequiredApproval(workItem) {
 		return true
 	}
@@ -235,9 +263,43 @@ func (d *Deleter) ShouldSkipThis(workItem *registry.WorkItem) bool {
 		return true
 	}
 
+	// Don't start deleting anything under legal hold or retention.
+	// Dry runs report the hold instead.
+	if workItem.Action != constants.ActionDeletionDryRun && d.BlockedByPolicy(workItem) {
+		return true
+	}
+
 	return false
 }
 
+// BlockedByPolicy returns true and marks this item as failed if a legal
+// hold or retention policy forbids the deletion. An admin can requeue the
+// item once the hold is lifted or the retention period ends. If we can't
+// check the policy, this returns false and leaves it to the deletion
+// manager, which checks again before deleting anything.
+func (d *Deleter) BlockedByPolicy(workItem *registry.WorkItem) bool {
+	err := newDeletionManager(d.Context, workItem).CheckPolicy()
+	var policyErr *deletion.PolicyError
+	if !errors.As(err, &policyErr) {
+		if err != nil {
+			d.Context.Logger.Warningf("Could not check deletion policy for WorkItem %d: %v", workItem.ID, err)
+		}
+		return false
+	}
+	message := fmt.Sprintf("Rejecting WorkItem %d. %s", workItem.ID, err.Error())
+	workItem.Retry = false
+	workItem.NeedsAdminReview = false
+	workItem.Outcome = "Deletion blocked"
+	workItem.MarkNoLongerInProgress(
+		workItem.Stage,
+		constants.StatusFailed,
+		err.Error(),
+	)
+	d.Context.Logger.Info(message)
+	d.SaveWorkItem(workItem)
+	return true
+}
+
 // MissingRequiredApproval returns true and marks this item as no longer in
 // progress if the deletion WorkItem has not been approved by an institutional
 // admin.
@@ -262,3 +324,47 @@ func (d *Deleter) getIdentifier(workItem *registry.WorkItem) string {
 	}
 	return workItem.ObjectIdentifier
 }
+
+// newDeletionManager returns a deletion.Manager for the file or object
+// in a deletion WorkItem.
+func newDeletionManager(context *common.Context, workItem *registry.WorkItem) *deletion.Manager {
+	id := workItem.GenericFileID
+	itemType := constants.TypeFile
+	if id == 0 && workItem.IntellectualObjectID != 0 {
+		id = workItem.IntellectualObjectID
+		itemType = constants.TypeObject
+	}
+	manager := deletion.NewManager(
+		context,
+		workItem.ID,
+		id,
+		itemType,
+		workItem.User, // requested by
+		workItem.InstApprover,
+		workItem.APTrustApprover,
+	)
+	manager.DryRun = workItem.Action == constants.ActionDeletionDryRun
+	return manager
+}
+
+// deletionCompletedNote returns the WorkItem note for a deletion whose
+// files have been removed from preservation storage.
+func deletionCompletedNote(workItem *registry.WorkItem, manager *deletion.Manager) string {
+	note := fmt.Sprintf("Deletion completed at the request of %s, approved by %s.", workItem.User, workItem.InstApprover)
+	if workItem.APTrustApprover != "" {
+		note += fmt.Sprintf(" APTrust approver: %s.", workItem.APTrustApprover)
+	}
+	if manager != nil && manager.CertificateURL != "" {
+		note += fmt.Sprintf(" Deletion certificate: %s", manager.CertificateURL)
+	}
+	return note
+}
+
+// deletionCompletedOutcome returns the WorkItem outcome for a completed
+// deletion.
+func deletionCompletedOutcome(workItem *registry.WorkItem) string {
+	if workItem.GenericFileID > 0 {
+		return "File deletion complete"
+	}
+	return "Object deletion complete"
+}
@@ -0,0 +1,91 @@
+package workers_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/deletion"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/util/testutil"
+	"github.com/APTrust/preservation-services/workers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dryRunItem() *registry.WorkItem {
+	return &registry.WorkItem{
+		ID:                   500,
+		Action:               constants.ActionDeletionDryRun,
+		IntellectualObjectID: 11,
+		Name:                 "bag.tar",
+		ObjectIdentifier:     "test.edu/bag",
+		Stage:                constants.StageRequested,
+		Status:               constants.StatusPending,
+		Retry:                true,
+		User:                 "requester@test.edu",
+	}
+}
+
+func TestDeleterDryRunNeedsApproval(t *testing.T) {
+	item := dryRunItem()
+	server := httptest.NewServer(newRegistryStandIn(item))
+	defer server.Close()
+	deleter := &workers.Deleter{}
+	deleter.Context = registryStandInContext(t, server)
+	assert.True(t, deleter.ShouldSkipThis(item))
+	assert.Equal(t, constants.StatusCancelled, item.Status)
+	assert.False(t, item.Retry)
+	assert.Contains(t, item.Note, "institutional approver is missing")
+}
+
+func TestDeleterFinishesDryRun(t *testing.T) {
+	item := dryRunItem()
+	item.InstApprover = "approver@test.edu"
+	item.Status = constants.StatusStarted
+	standIn := newRegistryStandIn(item)
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	fakeRedis, err := testutil.NewFakeRedis()
+	require.Nil(t, err)
+	defer fakeRedis.Close()
+
+	deleter := &workers.Deleter{}
+	deleter.Context = registryStandInContext(t, server)
+	deleter.Context.RedisClient = network.NewRedisClient(fakeRedis.Addr(), "", 0)
+	deleter.Settings = &workers.Settings{NSQTopic: constants.TopicDelete, NextWorkItemStage: constants.StageResolve}
+	deleter.ItemsInProcess = service.NewRingList(10)
+	deleter.SuccessChannel = make(chan *workers.Task, 1)
+
+	manager := deletion.NewManager(deleter.Context, item.ID, item.IntellectualObjectID, constants.TypeObject, item.User, item.InstApprover, "")
+	manager.DryRun = true
+	manager.Report = service.NewDeletionReport(item.ObjectIdentifier, item.ID)
+	message := &stubMessage{}
+	task := &workers.Task{
+		NSQMessage: message,
+		Processor:  manager,
+		WorkItem:   item,
+		WorkResult: service.NewWorkResult(constants.TopicDelete),
+	}
+	task.NSQStart()
+	go deleter.ProcessSuccessChannel()
+	deleter.SuccessChannel <- task
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !message.finished.Load() {
+		require.True(t, time.Now().Before(deadline), "Success channel did not finish the task")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Registry shows a finished dry run, not a finished deletion.
+	saved := standIn.savedItems()
+	require.NotEmpty(t, saved)
+	last := saved[len(saved)-1]
+	assert.Equal(t, constants.ActionDeletionDryRun, last.Action)
+	assert.Equal(t, "Dry run: nothing deleted", last.Outcome)
+	assert.Contains(t, last.Note, "Nothing was deleted.")
+	assert.Nil(t, last.PurgeAfter)
+}
@@ -11,8 +11,8 @@ import (
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network/queue"
 	"github.com/APTrust/preservation-services/restoration"
-	"github.com/nsqio/go-nsq"
 )
 
 type FileRestorer struct {
@@ -40,6 +40,7 @@ func NewFileRestorer(bufSize, numWorkers, maxAttempts int) *FileRestorer {
 			Settings:          settings,
 			ItemsInProcess:    service.NewRingList(settings.ChannelBufferSize * settings.NumberOfWorkers),
 			ProcessChannel:    make(chan *Task, settings.ChannelBufferSize),
+			PriorityChannel:   make(chan *Task, settings.ChannelBufferSize),
 			SuccessChannel:    make(chan *Task, settings.ChannelBufferSize),
 			ErrorChannel:      make(chan *Task, settings.ChannelBufferSize),
 			FatalErrorChannel: make(chan *Task, settings.ChannelBufferSize),
@@ -86,6 +87,7 @@ func (r *FileRestorer) ProcessSuccessChannel() {
 		// Tell Registry item succeeded.
 		note := fmt.Sprintf("File %s restored to %s.", task.WorkItem.GenericFileIdentifier, task.RestorationObject.URL)
 		task.WorkItem.Note = note
+		AppendFailoverNote(task.WorkItem, task.RestorationObject)
 		task.WorkItem.Stage = r.Settings.NextWorkItemStage
 		task.WorkItem.Status = constants.StatusSuccess
 		task.WorkItem.Retry = false
@@ -93,6 +95,7 @@ func (r *FileRestorer) ProcessSuccessChannel() {
 		task.WorkItem.Outcome = "File restoration complete"
 
 		r.FinishItem(task)
+		r.DeleteRedisData(task)
 
 		// Tell NSQ this b is done with this message.
 		task.NSQFinish()
@@ -104,6 +107,12 @@ func (r *FileRestorer) ProcessSuccessChannel() {
 
 func (r *FileRestorer) ProcessErrorChannel() {
 	for task := range r.ErrorChannel {
+		if task.RestorationObject.NeedsGlacierRestore {
+			r.Context.Logger.Warningf("WorkItem %d (%s) can be restored only from Glacier",
+				task.WorkItem.ID, task.WorkItem.Name)
+			r.HandOffToGlacier(task)
+			continue
+		}
 		shouldRequeue := true
 		r.Context.Logger.Warningf("WorkItem %d (%s) is in error channel",
 			task.WorkItem.ID, task.WorkItem.Name)
@@ -113,17 +122,20 @@ func (r *FileRestorer) ProcessErrorChannel() {
 
 		// Update WorkItem in Registry
 		task.WorkItem.Note = task.WorkResult.NonFatalErrorMessage()
+		AppendFailoverNote(task.WorkItem, task.RestorationObject)
 		if task.WorkResult.Attempt >= r.Settings.MaxAttempts {
 			task.WorkItem.Note += fmt.Sprintf(" Will not retry: failed %d times.", task.WorkResult.Attempt)
 			task.WorkItem.Retry = false
 			task.WorkItem.NeedsAdminReview = true
 			shouldRequeue = false
+			r.DeadLetter(task.WorkItem, task.WorkResult, fmt.Sprintf("Failed %d times", task.WorkResult.Attempt))
 		}
 		r.FinishItem(task)
 		if shouldRequeue {
 			task.NSQRequeue(r.Settings.RequeueTimeout)
 		} else {
 			task.NSQFinish()
+			r.DeleteRedisData(task)
 
 			// For e2e tests, let the test worker know restoration succeeded
 			QueueE2EWorkItem(r.Context, constants.TopicE2ERestore, task.WorkItem.ID)
@@ -141,11 +153,14 @@ func (r *FileRestorer) ProcessFatalErrorChannel() {
 
 		// Update WorkItem for Registry
 		task.WorkItem.Note = task.WorkResult.FatalErrorMessage()
+		AppendFailoverNote(task.WorkItem, task.RestorationObject)
 		task.WorkItem.Retry = false
 		task.WorkItem.NeedsAdminReview = true
+		r.DeadLetter(task.WorkItem, task.WorkResult, "Fatal error")
 
 		// Update Registry and Redis
 		r.FinishItem(task)
+		r.DeleteRedisData(task)
 
 		// Tell NSQ we're done with this message.
 		task.NSQFinish()
@@ -155,7 +170,7 @@ func (r *FileRestorer) ProcessFatalErrorChannel() {
 	}
 }
 
-func (r *FileRestorer) GetTaskObject(message *nsq.Message, workItem *registry.WorkItem, workResult *service.WorkResult) (*Task, error) {
+func (r *FileRestorer) GetTaskObject(message queue.Message, workItem *registry.WorkItem, workResult *service.WorkResult) (*Task, error) {
 
 	restorationObject, err := GetRestorationObject(r.Context, workItem, constants.RestorationSourceS3)
 	if err != nil {
@@ -180,6 +195,12 @@ func (r *FileRestorer) GetTaskObject(message *nsq.Message, workItem *registry.Wo
 // WorkItem.
 func (r *FileRestorer) ShouldSkipThis(workItem *registry.WorkItem) bool {
 
+	// The item is waiting for its files to come out of Glacier.
+	// The Glacier restorer will queue it again when they do.
+	if IsAwaitingGlacier(r.Context, workItem) {
+		return true
+	}
+
 	// It's possible that another worker recently marked this as
 	// "do not retry." If that's the case, skip it.
 	if !r.ShouldRetry(workItem) {
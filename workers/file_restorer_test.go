@@ -0,0 +1,90 @@
+package workers_test
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/network/queue"
+	"github.com/APTrust/preservation-services/util/testutil"
+	"github.com/APTrust/preservation-services/workers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileRestorerDeletesRedisDataWhenDone(t *testing.T) {
+	item := &registry.WorkItem{
+		ID:                    400,
+		Action:                constants.ActionRestoreFile,
+		Name:                  "bag.tar",
+		ObjectIdentifier:      "test.edu/bag",
+		GenericFileIdentifier: "test.edu/bag/data/file.txt",
+		Stage:                 constants.StageRequested,
+		Status:                constants.StatusStarted,
+	}
+	standIn := newRegistryStandIn(item)
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	fakeRedis, err := testutil.NewFakeRedis()
+	require.Nil(t, err)
+	defer fakeRedis.Close()
+
+	restorer := &workers.FileRestorer{}
+	restorer.Context = registryStandInContext(t, server)
+	restorer.Context.RedisClient = network.NewRedisClient(fakeRedis.Addr(), "", 0)
+	restorer.Context.Queue = queue.NewMemory()
+	restorer.Settings = &workers.Settings{NSQTopic: constants.TopicFileRestore, NextQueueTopic: ""}
+	restorer.ItemsInProcess = service.NewRingList(10)
+	restorer.FatalErrorChannel = make(chan *workers.Task, 1)
+	restorer.KillChannel = make(chan os.Signal, 1)
+
+	// This item and another one both have restoration data in Redis.
+	restorationObject := &service.RestorationObject{
+		Identifier:      item.GenericFileIdentifier,
+		RestorationType: constants.RestorationTypeFile,
+		FailedSources:   []string{"https://example.com/preservation/bad-copy"},
+	}
+	redis := restorer.Context.RedisClient
+	require.Nil(t, redis.RestorationObjectSave(item.ID, restorationObject))
+	require.Nil(t, redis.GlacierRestoreSave(item.ID, &service.GlacierRestoreState{GenericFileIdentifier: item.GenericFileIdentifier}))
+	require.Nil(t, redis.RestorationObjectSave(401, restorationObject))
+
+	workResult := service.NewWorkResult(constants.TopicFileRestore)
+	workResult.AddError(service.NewProcessingError(item.ID, item.GenericFileIdentifier, "Registry checksum is missing", true))
+	message := &stubMessage{}
+	task := &workers.Task{
+		NSQMessage:        message,
+		RestorationObject: restorationObject,
+		WorkItem:          item,
+		WorkResult:        workResult,
+	}
+	task.NSQStart()
+	go restorer.ProcessFatalErrorChannel()
+	restorer.FatalErrorChannel <- task
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !message.finished.Load() {
+		require.True(t, time.Now().Before(deadline), "Fatal error channel did not finish the task")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The item is done for good, so its Redis data is gone. The other
+	// item's data is still there.
+	saved, err := redis.RestorationObjectGet(item.ID, item.GenericFileIdentifier)
+	assert.True(t, saved == nil || err != nil)
+	state, err := redis.GlacierRestoreGet(item.ID, item.GenericFileIdentifier)
+	assert.True(t, state == nil || err != nil)
+	saved, err = redis.RestorationObjectGet(401, item.GenericFileIdentifier)
+	require.Nil(t, err)
+	assert.Equal(t, restorationObject.FailedSources, saved.FailedSources)
+
+	// Registry has the final status.
+	savedItems := standIn.savedItems()
+	require.NotEmpty(t, savedItems)
+	assert.False(t, savedItems[len(savedItems)-1].Retry)
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
-	"github.com/nsqio/go-nsq"
+	"github.com/APTrust/preservation-services/network/queue"
 )
 
 // FixityChecker is a worker that processes file and object deletion requests.
@@ -19,6 +19,14 @@ import (
 // code in workers.Base handles WorkItem and Redis housekeeping that is not
 // required here. In fact, that code would fail, since there are no WorkItems
 // or Redis records to work with.
+//
+// For the same reason, apt_fixity doesn't register with the admin or
+// health servers, and it doesn't dead-letter failed checks. Drains
+// release items by WorkItem ID, and dead letters are replayed by
+// WorkItem. Fixity tasks have no WorkItem. To stop apt_fixity, use
+// SIGTERM. The queue redelivers unfinished checks once their messages
+// time out. Checks that fail for good are logged as fatal errors, and
+// apt_queue_fixity queues the file again when its next check is due.
 type FixityChecker struct {
 	Context           *common.Context
 	ProcessChannel    chan *Task
@@ -26,7 +34,7 @@ type FixityChecker struct {
 	ErrorChannel      chan *Task
 	FatalErrorChannel chan *Task
 	Settings          *Settings
-	NSQConsumer       *nsq.Consumer
+	NSQConsumer       queue.Consumer
 }
 
 // NewFixityChecker creates a new FixityChecker worker. Param context is a
@@ -77,16 +85,11 @@ func NewFixityChecker(bufSize, numWorkers, maxAttempts int) *FixityChecker {
 
 // Tell NSQ we're listening
 func (c *FixityChecker) RegisterAsNsqConsumer() error {
-	config := nsq.NewConfig()
-	config.Set("heartbeat_interval", "10s")
-	config.Set("max_in_flight", c.Settings.ChannelBufferSize)
-	consumer, err := nsq.NewConsumer(c.Settings.NSQTopic, c.Settings.NSQChannel, config)
+	consumer, err := c.Context.Queue.Consume(c.Settings.NSQTopic, c.Settings.NSQChannel, c.Settings.ChannelBufferSize, c)
 	if err != nil {
 		return err
 	}
 	c.NSQConsumer = consumer
-	c.NSQConsumer.AddHandler(c)
-	c.NSQConsumer.ConnectToNSQLookupd(c.Context.Config.NsqLookupd)
 	c.Context.Logger.Info("Registered as NSQ consumer")
 	c.Context.Logger.Infof("Topic: %s, Channel: %s", c.Settings.NSQTopic, c.Settings.NSQChannel)
 	c.Context.Logger.Infof("Workers: %d", c.Settings.NumberOfWorkers)
@@ -97,10 +100,10 @@ func (c *FixityChecker) RegisterAsNsqConsumer() error {
 
 // This method omits a lot of WorkItem housekeeping that the other workers
 // need to do.
-func (c *FixityChecker) HandleMessage(message *nsq.Message) error {
-	gfId, err := strconv.ParseInt(string(message.Body), 10, 64)
+func (c *FixityChecker) HandleMessage(message queue.Message) error {
+	gfId, err := strconv.ParseInt(string(message.Body()), 10, 64)
 	if err != nil {
-		c.Context.Logger.Errorf("Invalid GenericFile.ID: cannot convert '%s' to integer", string(message.Body))
+		c.Context.Logger.Errorf("Invalid GenericFile.ID: cannot convert '%s' to integer", string(message.Body()))
 		return err
 	}
 	task, err := c.GetTaskObject(message, gfId)
@@ -108,7 +111,7 @@ func (c *FixityChecker) HandleMessage(message *nsq.Message) error {
 		c.Context.Logger.Errorf("Could not get Task for GenericFile ID %d: %v", gfId, err)
 		return err
 	}
-	c.Context.Logger.Infof("Starting attempt %d for %d", message.Attempts, gfId)
+	c.Context.Logger.Infof("Starting attempt %d for %d", message.Attempts(), gfId)
 	c.ProcessChannel <- task
 	return nil
 }
@@ -151,7 +154,7 @@ func (c *FixityChecker) ProcessSuccessChannel() {
 
 func (c *FixityChecker) ProcessErrorChannel() {
 	for task := range c.ErrorChannel {
-		shouldRequeue := int(task.NSQMessage.Attempts) < c.Settings.MaxAttempts
+		shouldRequeue := int(task.NSQMessage.Attempts()) < c.Settings.MaxAttempts
 		c.Context.Logger.Warningf("File %d is in error channel", task.WorkItem.GenericFileID)
 		c.Context.Logger.Warningf("Non-fatal errors for file %d: %s", task.WorkItem.GenericFileID, task.WorkResult.NonFatalErrorMessage())
 		if shouldRequeue {
@@ -180,14 +183,14 @@ func (c *FixityChecker) ProcessFatalErrorChannel() {
 	}
 }
 
-func (c *FixityChecker) GetTaskObject(message *nsq.Message, gfId int64) (*Task, error) {
+func (c *FixityChecker) GetTaskObject(message queue.Message, gfId int64) (*Task, error) {
 	fixityChecker := fixity.NewChecker(c.Context, gfId)
 	workItem := &registry.WorkItem{
 		ID:            -1,
 		GenericFileID: gfId,
 	}
 	workResult := service.NewWorkResult(constants.ActionFixityCheck)
-	workResult.Attempt = int(message.Attempts)
+	workResult.Attempt = int(message.Attempts())
 	task := &Task{
 		Processor:  fixityChecker,
 		NSQMessage: message,
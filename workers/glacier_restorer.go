@@ -2,6 +2,7 @@ package workers
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,8 +12,8 @@ import (
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network/queue"
 	"github.com/APTrust/preservation-services/restoration"
-	"github.com/nsqio/go-nsq"
 )
 
 // GlacierRestorer initiates and checks on the progress of Glacier restoration
@@ -20,8 +21,9 @@ import (
 // can be retrieved.
 //
 // Note that requeing is part of this worker's standard process. It makes an
-// initial restore request, then checks Glacier every four hours to see if
-// the request has been completed.
+// initial restore request, then requeues the item until the first pending
+// request is expected to be done, and checks again. It never waits more
+// than four hours between checks.
 type GlacierRestorer struct {
 	Base
 }
@@ -47,6 +49,7 @@ func NewGlacierRestorer(bufSize, numWorkers, maxAttempts int) *GlacierRestorer {
 			Settings:          settings,
 			ItemsInProcess:    service.NewRingList(settings.ChannelBufferSize * settings.NumberOfWorkers),
 			ProcessChannel:    make(chan *Task, settings.ChannelBufferSize),
+			PriorityChannel:   make(chan *Task, settings.ChannelBufferSize),
 			SuccessChannel:    make(chan *Task, settings.ChannelBufferSize),
 			ErrorChannel:      make(chan *Task, settings.ChannelBufferSize),
 			FatalErrorChannel: make(chan *Task, settings.ChannelBufferSize),
@@ -92,6 +95,9 @@ func (r *GlacierRestorer) ProcessSuccessChannel() {
 
 		// Tell Registry item succeeded.
 		note := fmt.Sprintf("Object %s restored from Glacier to S3.", task.WorkItem.ObjectIdentifier)
+		if task.RestorationObject.GlacierEstimate != nil {
+			note += " " + task.RestorationObject.GlacierEstimate.String()
+		}
 		task.WorkItem.Note = note
 		task.WorkItem.Stage = r.Settings.NextWorkItemStage
 		task.WorkItem.Status = constants.StatusSuccess
@@ -99,20 +105,29 @@ func (r *GlacierRestorer) ProcessSuccessChannel() {
 		task.WorkItem.NeedsAdminReview = false
 
 		r.FinishItem(task)
+		r.DeleteRedisData(task)
 
-		// Once Glacier Restoration is complete, we create a normal
-		// restoration WorkItem. Since all files are now in S3, we
-		// can follow the normal restoration workflow.
+		// Once Glacier Restoration is complete, the restoration
+		// items that were waiting on it can go back into the normal
+		// restoration workflow, since all files are now in S3. Items
+		// handed off before restorers started waiting for Glacier
+		// have nothing waiting, so we create a new restoration item.
 		//
-		// We don't handle error here. The function logs it internally.
-		workItem, _ := r.CreateRestorationWorkItem(task)
+		// We don't handle errors here. The functions log them internally.
+		workItems := r.ResumeWaitingItems(task)
+		if len(workItems) == 0 {
+			workItem, _ := r.CreateRestorationWorkItem(task)
+			if workItem != nil {
+				workItems = append(workItems, workItem)
+			}
+		}
 
-		// Add the WorkItem to NSQ.
-		if workItem != nil {
+		// Add the WorkItems to NSQ.
+		for _, workItem := range workItems {
 			if task.RestorationObject.RestorationType == constants.RestorationTypeFile {
-				r.Context.NSQClient.Enqueue(constants.TopicFileRestore, workItem.ID)
+				EnqueueWorkItem(r.Context, constants.TopicFileRestore, workItem)
 			} else {
-				r.Context.NSQClient.Enqueue(constants.TopicObjectRestore, workItem.ID)
+				EnqueueWorkItem(r.Context, constants.TopicObjectRestore, workItem)
 			}
 		}
 
@@ -137,16 +152,39 @@ func (r *GlacierRestorer) ProcessErrorChannel() {
 			task.WorkItem.Retry = false
 			task.WorkItem.NeedsAdminReview = true
 			shouldRequeue = false
+			r.DeadLetter(task.WorkItem, task.WorkResult, fmt.Sprintf("Failed %d times", task.WorkResult.Attempt))
 		}
 		r.FinishItem(task)
 		if shouldRequeue {
-			task.NSQRequeue(r.Settings.RequeueTimeout)
+			task.NSQRequeue(r.requeueTimeout(task))
 		} else {
+			// The restoration items waiting on this one can't finish.
+			r.FailWaitingItems(task)
 			task.NSQFinish()
+			r.DeleteRedisData(task)
 		}
 	}
 }
 
+// requeueTimeout returns how long to wait before checking on the task's
+// restore requests again. That's until the first pending request is
+// expected to be done, but at least a minute and no more than
+// Settings.RequeueTimeout.
+func (r *GlacierRestorer) requeueTimeout(task *Task) time.Duration {
+	next := task.RestorationObject.NextGlacierCheck
+	if next == nil {
+		return r.Settings.RequeueTimeout
+	}
+	timeout := time.Until(*next)
+	if timeout < time.Minute {
+		timeout = time.Minute
+	}
+	if timeout > r.Settings.RequeueTimeout {
+		timeout = r.Settings.RequeueTimeout
+	}
+	return timeout
+}
+
 func (r *GlacierRestorer) ProcessFatalErrorChannel() {
 	for task := range r.FatalErrorChannel {
 		r.Context.Logger.Errorf("WorkItem %d (%s) is in fatal error channel",
@@ -159,16 +197,100 @@ func (r *GlacierRestorer) ProcessFatalErrorChannel() {
 		task.WorkItem.Note = task.WorkResult.FatalErrorMessage()
 		task.WorkItem.Retry = false
 		task.WorkItem.NeedsAdminReview = true
+		r.DeadLetter(task.WorkItem, task.WorkResult, "Fatal error")
 
 		// Update Registry and Redis
 		r.FinishItem(task)
+		r.DeleteRedisData(task)
+
+		// The restoration items waiting on this one can't finish.
+		r.FailWaitingItems(task)
 
 		// Tell NSQ we're done with this message.
 		task.NSQFinish()
 	}
 }
 
-func (r *GlacierRestorer) GetTaskObject(message *nsq.Message, workItem *registry.WorkItem, workResult *service.WorkResult) (*Task, error) {
+// WaitingItems returns the restoration WorkItems that handed off to
+// Glacier and are waiting for the Glacier restore in task. These are
+// pending items in StageAwaitingGlacier for the same object or file.
+// Several depositor requests may be waiting on the same restore.
+func (r *GlacierRestorer) WaitingItems(task *Task) []*registry.WorkItem {
+	action := constants.ActionRestoreObject
+	if task.WorkItem.GenericFileID > 0 {
+		action = constants.ActionRestoreFile
+	}
+	params := url.Values{}
+	params.Set("action", action)
+	params.Set("object_identifier", task.WorkItem.ObjectIdentifier)
+	if task.WorkItem.GenericFileIdentifier != "" {
+		params.Set("generic_file_identifier", task.WorkItem.GenericFileIdentifier)
+	}
+	params.Set("stage", constants.StageAwaitingGlacier)
+	params.Set("status", constants.StatusPending)
+	params.Set("page", "1")
+	params.Set("per_page", "100")
+	items := make([]*registry.WorkItem, 0)
+	for {
+		resp := r.Context.RegistryClient.WorkItemList(params)
+		if resp.Error != nil {
+			r.Context.Logger.Errorf("Error getting WorkItems waiting on Glacier restore %d: %v", task.WorkItem.ID, resp.Error)
+			return items
+		}
+		for _, item := range resp.WorkItems() {
+			// Check here as well, in case Registry ignores a filter.
+			if item.Action == action && item.Stage == constants.StageAwaitingGlacier &&
+				item.Status == constants.StatusPending &&
+				item.GenericFileIdentifier == task.WorkItem.GenericFileIdentifier {
+				items = append(items, item)
+			}
+		}
+		if !resp.HasNextPage() {
+			return items
+		}
+		params = resp.ParamsForNextPage()
+	}
+}
+
+// ResumeWaitingItems moves the WorkItems waiting on the Glacier restore
+// in task back to StageRequested, so they can be restored from S3. It
+// returns the items it saved.
+func (r *GlacierRestorer) ResumeWaitingItems(task *Task) []*registry.WorkItem {
+	resumed := make([]*registry.WorkItem, 0)
+	for _, item := range r.WaitingItems(task) {
+		item.Stage = constants.StageRequested
+		item.Status = constants.StatusPending
+		item.Retry = true
+		item.Note = fmt.Sprintf("WorkItem %d restored files from Glacier to S3. Awaiting restoration.", task.WorkItem.ID)
+		item.Outcome = "Moved from Glacier to S3, awaiting restoration"
+		resp := r.Context.RegistryClient.WorkItemSave(item)
+		if resp.Error != nil {
+			r.Context.Logger.Errorf("Error resuming WorkItem %d after Glacier restore %d: %v", item.ID, task.WorkItem.ID, resp.Error)
+			continue
+		}
+		r.Context.Logger.Infof("Resumed WorkItem %d after Glacier restore %d", item.ID, task.WorkItem.ID)
+		resumed = append(resumed, resp.WorkItem())
+	}
+	return resumed
+}
+
+// FailWaitingItems marks the WorkItems waiting on the Glacier restore
+// in task as failed, since that restore failed.
+func (r *GlacierRestorer) FailWaitingItems(task *Task) {
+	for _, item := range r.WaitingItems(task) {
+		item.Status = constants.StatusFailed
+		item.Retry = false
+		item.NeedsAdminReview = true
+		item.Note = fmt.Sprintf("Glacier restore WorkItem %d failed: %s", task.WorkItem.ID, task.WorkItem.Note)
+		item.Outcome = "Glacier restore failed"
+		resp := r.Context.RegistryClient.WorkItemSave(item)
+		if resp.Error != nil {
+			r.Context.Logger.Errorf("Error marking WorkItem %d failed after Glacier restore %d failed: %v", item.ID, task.WorkItem.ID, resp.Error)
+		}
+	}
+}
+
+func (r *GlacierRestorer) GetTaskObject(message queue.Message, workItem *registry.WorkItem, workResult *service.WorkResult) (*Task, error) {
 
 	restorationObject, err := GetRestorationObject(r.Context, workItem, constants.RestorationSourceGlacier)
 	if err != nil {
@@ -256,6 +378,7 @@ func (r *GlacierRestorer) CreateRestorationWorkItem(task *Task) (*registry.WorkI
 		Note:                 "Moved from Glacier to S3, awaiting restoration",
 		ObjectIdentifier:     task.WorkItem.ObjectIdentifier,
 		Outcome:              "Moved from Glacier to S3, awaiting restoration",
+		Priority:             task.WorkItem.Priority,
 		Retry:                true,
 		Size:                 task.WorkItem.Size,
 		Stage:                constants.StageRequested,
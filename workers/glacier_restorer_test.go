@@ -0,0 +1,148 @@
+package workers_test
+
+import (
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/network/queue"
+	"github.com/APTrust/preservation-services/util/testutil"
+	"github.com/APTrust/preservation-services/workers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubMessage is a queue message that records whether it was finished.
+type stubMessage struct {
+	finished atomic.Bool
+}
+
+func (m *stubMessage) Body() []byte                { return nil }
+func (m *stubMessage) Attempts() uint16            { return 1 }
+func (m *stubMessage) DisableAutoResponse()        {}
+func (m *stubMessage) Touch()                      {}
+func (m *stubMessage) Requeue(delay time.Duration) {}
+func (m *stubMessage) Finish()                     { m.finished.Store(true) }
+
+func waitingItem(id int64, objIdentifier string) *registry.WorkItem {
+	return &registry.WorkItem{
+		ID:               id,
+		Action:           constants.ActionRestoreObject,
+		Name:             "bag.tar",
+		ObjectIdentifier: objIdentifier,
+		Stage:            constants.StageAwaitingGlacier,
+		Status:           constants.StatusPending,
+		Retry:            true,
+	}
+}
+
+func TestHandOffToGlacierWaits(t *testing.T) {
+	item := &registry.WorkItem{
+		ID:               200,
+		Action:           constants.ActionRestoreObject,
+		Name:             "bag.tar",
+		ObjectIdentifier: "test.edu/bag",
+		Stage:            constants.StageRequested,
+		Status:           constants.StatusStarted,
+		Retry:            true,
+	}
+	standIn := newRegistryStandIn(item)
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	fakeRedis, err := testutil.NewFakeRedis()
+	require.Nil(t, err)
+	defer fakeRedis.Close()
+
+	worker := metricsTestWorker("glacier_handoff_test_topic")
+	worker.Context = registryStandInContext(t, server)
+	worker.Context.RedisClient = network.NewRedisClient(fakeRedis.Addr(), "", 0)
+	worker.Context.Queue = queue.NewMemory()
+	message := &stubMessage{}
+	task := &workers.Task{
+		NSQMessage:        message,
+		RestorationObject: &service.RestorationObject{Identifier: item.ObjectIdentifier, RestorationType: constants.RestorationTypeObject},
+		WorkResult:        service.NewWorkResult("glacier_handoff_test_topic"),
+		WorkItem:          item,
+	}
+	task.NSQStart()
+	worker.HandOffToGlacier(task)
+	assert.True(t, message.finished.Load())
+
+	// The depositor's item is parked, not done, and restorers skip it
+	// until the Glacier restore finishes.
+	saved := standIn.savedItems()
+	require.Equal(t, 2, len(saved))
+	glacierItem := saved[0]
+	assert.Equal(t, constants.ActionGlacierRestore, glacierItem.Action)
+	assert.Equal(t, item.ID, saved[1].ID)
+	assert.Equal(t, constants.StageAwaitingGlacier, saved[1].Stage)
+	assert.Equal(t, constants.StatusPending, saved[1].Status)
+	assert.True(t, saved[1].Retry)
+	assert.True(t, workers.IsAwaitingGlacier(worker.Context, saved[1]))
+	assert.False(t, workers.IsAwaitingGlacier(worker.Context, glacierItem))
+
+	handler := &deferredHandler{bodies: make(chan string, 1)}
+	consumer, err := worker.Context.Queue.Consume(constants.TopicGlacierRestore, "test", 1, handler)
+	require.Nil(t, err)
+	defer consumer.Stop()
+	select {
+	case body := <-handler.bodies:
+		assert.Equal(t, "1001", body)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "Glacier restore item was not queued")
+	}
+}
+
+func TestGlacierRestorerResumesWaitingItems(t *testing.T) {
+	standIn := newRegistryStandIn(
+		waitingItem(200, "test.edu/bag"),
+		waitingItem(201, "test.edu/bag"),
+		waitingItem(202, "test.edu/other-bag"),
+	)
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	restorer := &workers.GlacierRestorer{}
+	restorer.Context = registryStandInContext(t, server)
+	task := &workers.Task{
+		WorkItem: &registry.WorkItem{ID: 300, Action: constants.ActionGlacierRestore, ObjectIdentifier: "test.edu/bag"},
+	}
+	resumed := restorer.ResumeWaitingItems(task)
+	require.Equal(t, 2, len(resumed))
+	for _, item := range resumed {
+		assert.Contains(t, []int64{200, 201}, item.ID)
+		assert.Equal(t, constants.StageRequested, item.Stage)
+		assert.Equal(t, constants.StatusPending, item.Status)
+		assert.Contains(t, item.Note, "300")
+	}
+
+	// Nothing is waiting any more, and the other object's item was
+	// left alone.
+	assert.Empty(t, restorer.WaitingItems(task))
+	assert.Equal(t, 2, len(standIn.savedItems()))
+}
+
+func TestGlacierRestorerFailsWaitingItems(t *testing.T) {
+	standIn := newRegistryStandIn(waitingItem(200, "test.edu/bag"), waitingItem(202, "test.edu/other-bag"))
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	restorer := &workers.GlacierRestorer{}
+	restorer.Context = registryStandInContext(t, server)
+	task := &workers.Task{
+		WorkItem: &registry.WorkItem{ID: 300, Action: constants.ActionGlacierRestore, ObjectIdentifier: "test.edu/bag", Note: "Access denied"},
+	}
+	restorer.FailWaitingItems(task)
+	saved := standIn.savedItems()
+	require.Equal(t, 1, len(saved))
+	assert.Equal(t, int64(200), saved[0].ID)
+	assert.Equal(t, constants.StatusFailed, saved[0].Status)
+	assert.False(t, saved[0].Retry)
+	assert.True(t, saved[0].NeedsAdminReview)
+	assert.Contains(t, saved[0].Note, "Access denied")
+}
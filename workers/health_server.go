@@ -0,0 +1,282 @@
+package workers
+
+import (
+	ctx "context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/network/queue"
+)
+
+// ReadinessTimeout is how long /readyz waits for each service to
+// respond. It's the default for HealthServer.CheckTimeout.
+const ReadinessTimeout = 5 * time.Second
+
+// ReadinessCacheTime is how long /readyz reuses the results of its last
+// check, so frequent probes don't put load on Registry, Redis, NSQ and
+// S3.
+const ReadinessCacheTime = 5 * time.Second
+
+// DefaultHealthServerAddress is the address the health server binds to
+// when Config.HealthServerAddress is empty.
+const DefaultHealthServerAddress = "127.0.0.1"
+
+// HealthServer serves /healthz, /readyz and /metrics for the workers
+// in this process. There's one per process, so apt_ingest_all's nine
+// workers share it. Workers start it by calling StartHealthServer when
+// Config.HealthServerPort is set. It binds to Config.HealthServerAddress,
+// which defaults to localhost.
+//
+// /healthz returns 200 as long as the process is up. /readyz returns 200
+// if Registry, Redis, NSQ and S3 are reachable and no worker is shutting
+// down, and 503 otherwise. Both return JSON. /readyz reports only whether
+// each service is ok or unavailable. The reasons go to the log, so the
+// response doesn't reveal hostnames or bucket names. /metrics returns
+// metrics in the Prometheus text format. See WriteMetrics.
+//
+// apt_fixity doesn't use this. See FixityChecker.
+type HealthServer struct {
+	Context *common.Context
+	Server  *http.Server
+
+	// CheckTimeout is how long /readyz waits for each service before
+	// reporting it unavailable.
+	CheckTimeout time.Duration
+
+	mutex   sync.Mutex
+	workers []*Base
+
+	checkMutex sync.Mutex
+	checks     map[string]string
+	checkedAt  time.Time
+}
+
+var healthServer *HealthServer
+var healthServerMutex sync.Mutex
+
+// StartHealthServer registers worker with the process's health server,
+// starting the server first if this is the first worker to register.
+// It does nothing if Config.HealthServerPort is zero.
+func StartHealthServer(worker *Base) {
+	port := worker.Context.Config.HealthServerPort
+	if port <= 0 {
+		return
+	}
+	healthServerMutex.Lock()
+	defer healthServerMutex.Unlock()
+	if healthServer == nil {
+		healthServer = NewHealthServer(worker.Context, port)
+		go func(s *HealthServer) {
+			err := s.Server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				s.Context.Logger.Errorf("Health server stopped: %v", err)
+			}
+		}(healthServer)
+		worker.Context.Logger.Infof("Health server listening on %s", healthServer.Server.Addr)
+	}
+	healthServer.Register(worker)
+}
+
+// NewHealthServer returns a HealthServer that will listen on port at
+// Config.HealthServerAddress. Call Server.ListenAndServe to start it.
+func NewHealthServer(context *common.Context, port int) *HealthServer {
+	address := context.Config.HealthServerAddress
+	if address == "" {
+		address = DefaultHealthServerAddress
+	}
+	s := &HealthServer{
+		Context:      context,
+		CheckTimeout: ReadinessTimeout,
+		workers:      make([]*Base, 0),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.Healthz)
+	mux.HandleFunc("/readyz", s.Readyz)
+	mux.HandleFunc("/metrics", s.Metrics)
+	s.Server = &http.Server{
+		Addr:              net.JoinHostPort(address, strconv.Itoa(port)),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Register adds worker to the workers whose state the server reports.
+func (s *HealthServer) Register(worker *Base) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.workers = append(s.workers, worker)
+}
+
+// Workers returns the registered workers.
+func (s *HealthServer) Workers() []*Base {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*Base{}, s.workers...)
+}
+
+// Healthz reports that the process is alive.
+func (s *HealthServer) Healthz(w http.ResponseWriter, r *http.Request) {
+	topics := make([]string, 0)
+	for _, worker := range s.Workers() {
+		topics = append(topics, worker.Settings.NSQTopic)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"topics": topics,
+	})
+}
+
+// Readyz reports whether the process can do its work: whether the
+// services it depends on are reachable and whether it's shutting down.
+func (s *HealthServer) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := s.CheckReadiness()
+	status := http.StatusOK
+	for _, result := range checks {
+		if result != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, checks)
+}
+
+// Metrics writes metrics for all stages in this process.
+func (s *HealthServer) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WriteMetrics(w, s.Workers())
+}
+
+// CheckReadiness checks each of the services the workers depend on.
+// It returns a map of service name to "ok" or "unavailable", and logs
+// the error from each unavailable service. It reuses the results of
+// the last check for ReadinessCacheTime. Whether a worker is shutting
+// down is checked every time.
+func (s *HealthServer) CheckReadiness() map[string]string {
+	checks := s.checkServices()
+	results := make(map[string]string, len(checks)+1)
+	for name, result := range checks {
+		results[name] = result
+	}
+	results["worker"] = "ok"
+	for _, worker := range s.Workers() {
+		if worker.GetSigTermState().Received {
+			results["worker"] = fmt.Sprintf("%s is shutting down", worker.Settings.NSQTopic)
+		}
+	}
+	return results
+}
+
+// checkServices returns the results of the last check of each service,
+// or checks them again if the results are older than
+// ReadinessCacheTime. Probes that arrive during a check wait for it
+// rather than starting their own. No check takes longer than
+// CheckTimeout, so a hung service can't hold up the probes.
+func (s *HealthServer) checkServices() map[string]string {
+	s.checkMutex.Lock()
+	defer s.checkMutex.Unlock()
+	if s.checks != nil && time.Since(s.checkedAt) < ReadinessCacheTime {
+		return s.checks
+	}
+	checks := map[string]func() error{
+		"registry": s.checkRegistry,
+		"redis":    s.checkRedis,
+		"nsq":      s.checkNSQ,
+		"s3":       s.checkS3,
+	}
+	results := make(map[string]string, len(checks))
+	var resultsMutex sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func() error) {
+			defer wg.Done()
+			result := "ok"
+			if err := s.checkWithTimeout(check); err != nil {
+				s.Context.Logger.Warningf("Readiness check: %s is unavailable: %v", name, err)
+				result = "unavailable"
+			}
+			resultsMutex.Lock()
+			results[name] = result
+			resultsMutex.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	s.checks = results
+	s.checkedAt = time.Now()
+	return results
+}
+
+// checkWithTimeout returns the result of check, or an error if check
+// doesn't return within CheckTimeout. A check that times out keeps
+// running in the background until its service responds or gives up.
+func (s *HealthServer) checkWithTimeout(check func() error) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- check()
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(s.CheckTimeout):
+		return fmt.Errorf("no response after %s", s.CheckTimeout)
+	}
+}
+
+func (s *HealthServer) checkRegistry() error {
+	params := url.Values{}
+	params.Set("per_page", "1")
+	return s.Context.RegistryClient.InstitutionList(params).Error
+}
+
+func (s *HealthServer) checkRedis() error {
+	_, err := s.Context.RedisClient.Ping()
+	return err
+}
+
+func (s *HealthServer) checkNSQ() error {
+	if s.Context.Config.QueueBackend == queue.BackendMemory {
+		return nil
+	}
+	return s.Context.NSQClient.Ping()
+}
+
+// checkS3 checks that we can reach the staging bucket and one
+// preservation bucket from each provider.
+func (s *HealthServer) checkS3() error {
+	buckets := map[string]string{constants.StorageProviderAWS: s.Context.Config.StagingBucket}
+	for _, b := range s.Context.Config.PreservationBuckets {
+		if buckets[b.Provider] == "" {
+			buckets[b.Provider] = b.Bucket
+		}
+	}
+	for provider, bucket := range buckets {
+		client := s.Context.S3Clients[provider]
+		if client == nil {
+			return fmt.Errorf("No S3 client for provider %s", provider)
+		}
+		timeoutCtx, cancel := ctx.WithTimeout(ctx.Background(), s.CheckTimeout)
+		exists, err := client.BucketExists(timeoutCtx, bucket)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("%s bucket %s: %v", provider, bucket, err)
+		}
+		if !exists {
+			return fmt.Errorf("%s bucket %s does not exist", provider, bucket)
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
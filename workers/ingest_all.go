@@ -0,0 +1,59 @@
+package workers
+
+import (
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/network/queue"
+)
+
+// IngestAll runs all nine ingest workers, from pre-fetch through
+// cleanup, in one process. Each stage gets the number of workers,
+// buffer size and max attempts set for it in Config.WorkerSettings, and
+// each updates WorkItem stage and status in Registry just as it does
+// when running on its own.
+//
+// Unless you ask for NSQ handoff, items pass from one stage to the next
+// through an in-process queue, so they don't make a round trip through
+// nsqd. Each stage also consumes its NSQ topic, so it still gets items
+// from the bucket reader and from anything else that queues ingests.
+// Note that items waiting in the in-process queue are lost if the
+// process exits. Their WorkItems stay pending in Registry, and you'll
+// have to requeue them.
+type IngestAll struct {
+	Context *common.Context
+	Workers []*IngestBase
+}
+
+// NewIngestAll starts all of the ingest workers. They start handling
+// messages as soon as they're created. If nsqHandoff is true, items go
+// from one stage to the next through NSQ, as they do when the workers
+// run as separate processes. If Config.QueueBackend is memory, all
+// messages stay in process regardless.
+func NewIngestAll(nsqHandoff bool) *IngestAll {
+	context := common.NewContext()
+	if !nsqHandoff && context.Config.QueueBackend != queue.BackendMemory {
+		context.Queue = queue.NewRouter(context.Queue, queue.SharedMemory(), constants.IngestTopicNames...)
+	}
+
+	// Passing -1 tells each worker to use its settings from the config.
+	all := &IngestAll{Context: context}
+	all.Workers = []*IngestBase{
+		newIngestPreFetch(context, -1, -1, -1).IngestBase,
+		newIngestValidator(context, -1, -1, -1).IngestBase,
+		newReingestManager(context, -1, -1, -1).IngestBase,
+		newStagingUploader(context, -1, -1, -1).IngestBase,
+		newIngestFormatIdentifier(context, -1, -1, -1).IngestBase,
+		newIngestPreservationUploader(context, -1, -1, -1).IngestBase,
+		newIngestPreservationVerifier(context, -1, -1, -1).IngestBase,
+		newIngestRecorder(context, -1, -1, -1).IngestBase,
+		newIngestCleanup(context, -1, -1, -1).IngestBase,
+	}
+	return all
+}
+
+// Wait blocks until all of the workers have stopped consuming messages.
+func (all *IngestAll) Wait() {
+	for _, worker := range all.Workers {
+		<-worker.NSQConsumer.StopChan()
+	}
+}
@@ -16,8 +16,8 @@ import (
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network/queue"
 	"github.com/APTrust/preservation-services/util"
-	"github.com/nsqio/go-nsq"
 )
 
 // IngestBase contains the fundamental structures common to all ingest workers.
@@ -36,6 +36,7 @@ func NewIngestBase(context *common.Context, processorConstructor ingest.BaseCons
 			Settings:             settings,
 			ItemsInProcess:       service.NewRingList(settings.ChannelBufferSize * settings.NumberOfWorkers),
 			ProcessChannel:       make(chan *Task, settings.ChannelBufferSize),
+			PriorityChannel:      make(chan *Task, settings.ChannelBufferSize),
 			SuccessChannel:       make(chan *Task, settings.ChannelBufferSize),
 			ErrorChannel:         make(chan *Task, settings.ChannelBufferSize),
 			FatalErrorChannel:    make(chan *Task, settings.ChannelBufferSize),
@@ -139,6 +140,7 @@ func (b *IngestBase) ProcessErrorChannel() {
 			task.WorkItem.Retry = false
 			task.WorkItem.NeedsAdminReview = true
 			shouldRequeue = false
+			b.DeadLetter(task.WorkItem, task.WorkResult, fmt.Sprintf("Failed %d times", task.WorkResult.Attempt))
 
 			// Clear this, so if it's manually requeued,
 			// it will get a new set of attempts.
@@ -184,6 +186,7 @@ func (b *IngestBase) ProcessFatalErrorChannel() {
 		task.WorkItem.NeedsAdminReview = true
 		task.WorkItem.Status = constants.StatusFailed
 		task.WorkItem.Outcome = "Ingest failed due to fatal error."
+		b.DeadLetter(task.WorkItem, task.WorkResult, "Fatal error")
 
 		// NSQ
 		if b.Settings.PushToCleanupOnFatalError && task.WorkItem.Stage != constants.StageCleanup {
@@ -209,7 +212,7 @@ func (b *IngestBase) ProcessFatalErrorChannel() {
 
 // GetTaskObject returns an object representing the task to be implemented.
 // This object will be passed from channel to channel during processing.
-func (b *IngestBase) GetTaskObject(message *nsq.Message, workItem *registry.WorkItem, workResult *service.WorkResult) (*Task, error) {
+func (b *IngestBase) GetTaskObject(message queue.Message, workItem *registry.WorkItem, workResult *service.WorkResult) (*Task, error) {
 	ingestObject, err := b.IngestObjectGet(workItem)
 	if err != nil {
 		message := fmt.Sprintf("WorkItem %d: %v", workItem.ID, err)
@@ -18,6 +18,7 @@ import (
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network/queue"
 	"github.com/APTrust/preservation-services/util/testutil"
 	"github.com/APTrust/preservation-services/workers"
 	"github.com/minio/minio-go/v7"
@@ -36,7 +37,7 @@ var testWorkItem *registry.WorkItem
 var olderWorkItemID = int64(0)
 var olderStillIngestingID = int64(0)
 var newerWorkItemID = int64(0)
-var copyOfNsqMessage *nsq.Message
+var copyOfNsqMessage queue.Message
 var testInstitution *registry.Institution
 
 func putBagInS3(t *testing.T, context *common.Context, key, pathToBagFile string) {
@@ -168,7 +169,7 @@ func doSetup(t *testing.T, key, pathToBagFile string) int64 {
 		msgBody := []byte(strconv.FormatInt(testWorkItem.ID, 10))
 		var msgId [16]byte
 		copy(msgId[:], []byte("9999"))
-		copyOfNsqMessage = nsq.NewMessage(msgId, msgBody)
+		copyOfNsqMessage = queue.NewNSQMessage(nsq.NewMessage(msgId, msgBody))
 		saveSimilarWorkItems(t, context, testWorkItem)
 		putIngestObjectInRedis(t, context, testWorkItem)
 		putWorkResultInRedis(t, context, testWorkItem)
@@ -479,7 +480,7 @@ func TestIngestBase_FinishItem(t *testing.T) {
 	msgBody := []byte(strconv.FormatInt(copyOfWorkItem.ID, 10))
 	var msgId [16]byte
 	copy(msgId[:], []byte("3333"))
-	testNSQMessage := nsq.NewMessage(msgId, msgBody)
+	testNSQMessage := queue.NewNSQMessage(nsq.NewMessage(msgId, msgBody))
 
 	task := &workers.Task{
 		NSQMessage: testNSQMessage,
@@ -170,7 +170,7 @@ func (r *IngestBucketReader) CreateAndQueueItem(institution *registry.Institutio
 		return
 	}
 	savedItem := resp.WorkItem() // item now has an ID
-	err := r.Context.NSQClient.Enqueue(constants.IngestPreFetch, savedItem.ID)
+	err := r.Context.Queue.Enqueue(constants.IngestPreFetch, savedItem.ID)
 	if err != nil {
 		r.Context.Logger.Errorf("Error queueing WorkItem %d: %v", savedItem.ID, err)
 		return
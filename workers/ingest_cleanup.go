@@ -16,7 +16,10 @@ type IngestCleanup struct {
 
 // NewIngestCleanup creates a new IngestCleanup worker.
 func NewIngestCleanup(bufSize, numWorkers, maxAttempts int) *IngestCleanup {
-	_context := common.NewContext()
+	return newIngestCleanup(common.NewContext(), bufSize, numWorkers, maxAttempts)
+}
+
+func newIngestCleanup(_context *common.Context, bufSize, numWorkers, maxAttempts int) *IngestCleanup {
 	bufSize, numWorkers, maxAttempts = _context.Config.GetWorkerSettings(constants.IngestCleanup, bufSize, numWorkers, maxAttempts)
 	settings := &Settings{
 		ChannelBufferSize:                         bufSize,
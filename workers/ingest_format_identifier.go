@@ -16,7 +16,10 @@ type FormatIdentifier struct {
 
 // NewFormatIdentifier creates a new FormatIdentifier worker.
 func NewIngestFormatIdentifier(bufSize, numWorkers, maxAttempts int) *FormatIdentifier {
-	_context := common.NewContext()
+	return newIngestFormatIdentifier(common.NewContext(), bufSize, numWorkers, maxAttempts)
+}
+
+func newIngestFormatIdentifier(_context *common.Context, bufSize, numWorkers, maxAttempts int) *FormatIdentifier {
 	bufSize, numWorkers, maxAttempts = _context.Config.GetWorkerSettings(constants.IngestFormatIdentification, bufSize, numWorkers, maxAttempts)
 	settings := &Settings{
 		ChannelBufferSize:                         bufSize,
@@ -17,7 +17,10 @@ type IngestPreFetch struct {
 // NewIngestPreFetch creates a new IngestPreFetch worker. The worker starts
 // handling NSQ messages as soon as it's instantiated.
 func NewIngestPreFetch(bufSize, numWorkers, maxAttempts int) *IngestPreFetch {
-	_context := common.NewContext()
+	return newIngestPreFetch(common.NewContext(), bufSize, numWorkers, maxAttempts)
+}
+
+func newIngestPreFetch(_context *common.Context, bufSize, numWorkers, maxAttempts int) *IngestPreFetch {
 	bufSize, numWorkers, maxAttempts = _context.Config.GetWorkerSettings(constants.IngestPreFetch, bufSize, numWorkers, maxAttempts)
 	settings := &Settings{
 		ChannelBufferSize:                         bufSize,
@@ -16,7 +16,10 @@ type PreservationUploader struct {
 
 // NewIngestPreservationUploader creates a new PreservationUploader worker.
 func NewIngestPreservationUploader(bufSize, numWorkers, maxAttempts int) *PreservationUploader {
-	_context := common.NewContext()
+	return newIngestPreservationUploader(common.NewContext(), bufSize, numWorkers, maxAttempts)
+}
+
+func newIngestPreservationUploader(_context *common.Context, bufSize, numWorkers, maxAttempts int) *PreservationUploader {
 	bufSize, numWorkers, maxAttempts = _context.Config.GetWorkerSettings(constants.IngestStorage, bufSize, numWorkers, maxAttempts)
 	settings := &Settings{
 		ChannelBufferSize:                         bufSize,
@@ -18,7 +18,10 @@ type PreservationVerifier struct {
 // to verify that files have been correctly copied to preservation
 // (and replication) storage.
 func NewIngestPreservationVerifier(bufSize, numWorkers, maxAttempts int) *PreservationVerifier {
-	_context := common.NewContext()
+	return newIngestPreservationVerifier(common.NewContext(), bufSize, numWorkers, maxAttempts)
+}
+
+func newIngestPreservationVerifier(_context *common.Context, bufSize, numWorkers, maxAttempts int) *PreservationVerifier {
 	bufSize, numWorkers, maxAttempts = _context.Config.GetWorkerSettings(constants.IngestStorageValidation, bufSize, numWorkers, maxAttempts)
 	settings := &Settings{
 		ChannelBufferSize:                         bufSize,
@@ -16,7 +16,10 @@ type IngestRecorder struct {
 
 // NewIngestRecorder creates a new IngestRecorder worker.
 func NewIngestRecorder(bufSize, numWorkers, maxAttempts int) *IngestRecorder {
-	_context := common.NewContext()
+	return newIngestRecorder(common.NewContext(), bufSize, numWorkers, maxAttempts)
+}
+
+func newIngestRecorder(_context *common.Context, bufSize, numWorkers, maxAttempts int) *IngestRecorder {
 	bufSize, numWorkers, maxAttempts = _context.Config.GetWorkerSettings(constants.IngestRecord, bufSize, numWorkers, maxAttempts)
 	settings := &Settings{
 		ChannelBufferSize:                         bufSize,
@@ -16,7 +16,10 @@ type StagingUploader struct {
 
 // NewStagingUploader creates a new StagingUploader worker.
 func NewStagingUploader(bufSize, numWorkers, maxAttempts int) *StagingUploader {
-	_context := common.NewContext()
+	return newStagingUploader(common.NewContext(), bufSize, numWorkers, maxAttempts)
+}
+
+func newStagingUploader(_context *common.Context, bufSize, numWorkers, maxAttempts int) *StagingUploader {
 	bufSize, numWorkers, maxAttempts = _context.Config.GetWorkerSettings(constants.IngestStaging, bufSize, numWorkers, maxAttempts)
 	settings := &Settings{
 		ChannelBufferSize:                         bufSize,
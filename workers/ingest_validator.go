@@ -16,7 +16,10 @@ type IngestValidator struct {
 
 // NewIngestValidator creates a new IngestValidator worker.
 func NewIngestValidator(bufSize, numWorkers, maxAttempts int) *IngestValidator {
-	_context := common.NewContext()
+	return newIngestValidator(common.NewContext(), bufSize, numWorkers, maxAttempts)
+}
+
+func newIngestValidator(_context *common.Context, bufSize, numWorkers, maxAttempts int) *IngestValidator {
 	bufSize, numWorkers, maxAttempts = _context.Config.GetWorkerSettings(constants.IngestValidation, bufSize, numWorkers, maxAttempts)
 	settings := &Settings{
 		ChannelBufferSize:                         bufSize,
@@ -0,0 +1,200 @@
+package workers
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RunTimeBuckets are the upper bounds, in seconds, of the buckets in the
+// WorkResult.RunTime histogram. Most items take seconds, but large bags
+// can take many hours.
+var RunTimeBuckets = []float64{1, 5, 15, 60, 300, 900, 3600, 4 * 3600, 12 * 3600}
+
+// StageMetrics are the counters for one stage, that is, for the
+// workers consuming one topic. They cover the life of the process.
+type StageMetrics struct {
+	mutex sync.Mutex
+
+	// Succeeded, Failed and Fatal count items that came out of the
+	// ProcessChannel with no errors, with non-fatal errors, and with
+	// fatal errors. Items that fail and are retried count once for
+	// each attempt.
+	Succeeded int64
+	Failed    int64
+	Fatal     int64
+
+	// Bytes is the total size of the WorkItems processed successfully.
+	Bytes int64
+
+	// Deferred counts items put back in the queue because their
+	// institution was at its in-flight limit. See Base.AdmitItem.
+	Deferred int64
+
+	// runTimeCounts[i] counts run times no longer than RunTimeBuckets[i].
+	// The counts are not cumulative. We add them up when we write them.
+	runTimeCounts []int64
+	runTimeCount  int64
+	runTimeSum    float64
+}
+
+var stageMetrics = make(map[string]*StageMetrics)
+var stageMetricsMutex sync.Mutex
+
+// MetricsFor returns the metrics for the stage whose workers consume
+// topic, creating them if necessary.
+func MetricsFor(topic string) *StageMetrics {
+	stageMetricsMutex.Lock()
+	defer stageMetricsMutex.Unlock()
+	m := stageMetrics[topic]
+	if m == nil {
+		m = &StageMetrics{runTimeCounts: make([]int64, len(RunTimeBuckets))}
+		stageMetrics[topic] = m
+	}
+	return m
+}
+
+// RecordSuccess counts an item that was processed successfully.
+func (m *StageMetrics) RecordSuccess(bytes int64, runTime time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Succeeded++
+	m.Bytes += bytes
+	m.observe(runTime)
+}
+
+// RecordError counts an item that had non-fatal errors.
+func (m *StageMetrics) RecordError(runTime time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Failed++
+	m.observe(runTime)
+}
+
+// RecordFatalError counts an item that had fatal errors.
+func (m *StageMetrics) RecordFatalError(runTime time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Fatal++
+	m.observe(runTime)
+}
+
+// RecordDeferred counts an item held back for its institution's limit.
+func (m *StageMetrics) RecordDeferred() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Deferred++
+}
+
+// observe adds runTime to the histogram. Caller must hold m.mutex.
+func (m *StageMetrics) observe(runTime time.Duration) {
+	seconds := runTime.Seconds()
+	m.runTimeCount++
+	m.runTimeSum += seconds
+	for i, bound := range RunTimeBuckets {
+		if seconds <= bound {
+			m.runTimeCounts[i]++
+			break
+		}
+	}
+}
+
+// stageGauges are point-in-time readings from a worker.
+type stageGauges struct {
+	itemsInProcess int
+	channels       map[string]int
+}
+
+// WriteMetrics writes the metrics for all stages in this process to w,
+// in the Prometheus text format. Workers with a health server serve
+// this at /metrics.
+func WriteMetrics(w io.Writer, workers []*Base) {
+	gauges := make(map[string]*stageGauges)
+	for _, b := range workers {
+		gauges[b.Settings.NSQTopic] = &stageGauges{
+			itemsInProcess: len(b.ItemsInProcess.Items()),
+			channels: map[string]int{
+				"process":  len(b.ProcessChannel),
+				"priority": len(b.PriorityChannel),
+				"success":  len(b.SuccessChannel),
+				"error":    len(b.ErrorChannel),
+				"fatal":    len(b.FatalErrorChannel),
+			},
+		}
+	}
+	stageMetricsMutex.Lock()
+	topics := make([]string, 0, len(stageMetrics))
+	metrics := make(map[string]*StageMetrics, len(stageMetrics))
+	for topic, m := range stageMetrics {
+		topics = append(topics, topic)
+		metrics[topic] = m
+	}
+	stageMetricsMutex.Unlock()
+	for topic := range gauges {
+		if metrics[topic] == nil {
+			topics = append(topics, topic)
+			metrics[topic] = MetricsFor(topic)
+		}
+	}
+	sort.Strings(topics)
+
+	writeHeader(w, "aptrust_worker_items_in_process", "gauge", "WorkItems the worker is processing.")
+	for _, topic := range topics {
+		if g := gauges[topic]; g != nil {
+			fmt.Fprintf(w, "aptrust_worker_items_in_process{topic=%q} %d\n", topic, g.itemsInProcess)
+		}
+	}
+	writeHeader(w, "aptrust_worker_channel_depth", "gauge", "Tasks waiting in the worker's internal channels.")
+	for _, topic := range topics {
+		if g := gauges[topic]; g != nil {
+			for _, channel := range []string{"process", "priority", "success", "error", "fatal"} {
+				fmt.Fprintf(w, "aptrust_worker_channel_depth{topic=%q,channel=%q} %d\n", topic, channel, g.channels[channel])
+			}
+		}
+	}
+
+	writeHeader(w, "aptrust_worker_items_total", "counter", "Items processed, by outcome. Retried items count once per attempt.")
+	for _, topic := range topics {
+		m := metrics[topic]
+		m.mutex.Lock()
+		fmt.Fprintf(w, "aptrust_worker_items_total{topic=%q,outcome=\"success\"} %d\n", topic, m.Succeeded)
+		fmt.Fprintf(w, "aptrust_worker_items_total{topic=%q,outcome=\"error\"} %d\n", topic, m.Failed)
+		fmt.Fprintf(w, "aptrust_worker_items_total{topic=%q,outcome=\"fatal\"} %d\n", topic, m.Fatal)
+		m.mutex.Unlock()
+	}
+	writeHeader(w, "aptrust_worker_bytes_total", "counter", "Total size of WorkItems processed successfully.")
+	for _, topic := range topics {
+		m := metrics[topic]
+		m.mutex.Lock()
+		fmt.Fprintf(w, "aptrust_worker_bytes_total{topic=%q} %d\n", topic, m.Bytes)
+		m.mutex.Unlock()
+	}
+	writeHeader(w, "aptrust_worker_items_deferred_total", "counter", "Items put back in the queue because their institution was at its in-flight limit.")
+	for _, topic := range topics {
+		m := metrics[topic]
+		m.mutex.Lock()
+		fmt.Fprintf(w, "aptrust_worker_items_deferred_total{topic=%q} %d\n", topic, m.Deferred)
+		m.mutex.Unlock()
+	}
+	writeHeader(w, "aptrust_worker_run_time_seconds", "histogram", "Time from the start of an attempt to the end of processing.")
+	for _, topic := range topics {
+		m := metrics[topic]
+		m.mutex.Lock()
+		cumulative := int64(0)
+		for i, bound := range RunTimeBuckets {
+			cumulative += m.runTimeCounts[i]
+			fmt.Fprintf(w, "aptrust_worker_run_time_seconds_bucket{topic=%q,le=%q} %d\n", topic, strconv.FormatFloat(bound, 'f', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "aptrust_worker_run_time_seconds_bucket{topic=%q,le=\"+Inf\"} %d\n", topic, m.runTimeCount)
+		fmt.Fprintf(w, "aptrust_worker_run_time_seconds_sum{topic=%q} %s\n", topic, strconv.FormatFloat(m.runTimeSum, 'f', -1, 64))
+		fmt.Fprintf(w, "aptrust_worker_run_time_seconds_count{topic=%q} %d\n", topic, m.runTimeCount)
+		m.mutex.Unlock()
+	}
+}
+
+func writeHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
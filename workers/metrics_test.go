@@ -0,0 +1,196 @@
+package workers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/network/queue"
+	"github.com/APTrust/preservation-services/util/logger"
+	"github.com/APTrust/preservation-services/util/testutil"
+	"github.com/APTrust/preservation-services/workers"
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func metricsTestWorker(topic string) *workers.Base {
+	return &workers.Base{
+		Settings:          &workers.Settings{NSQTopic: topic},
+		ItemsInProcess:    service.NewRingList(10),
+		ProcessChannel:    make(chan *workers.Task, 10),
+		PriorityChannel:   make(chan *workers.Task, 10),
+		SuccessChannel:    make(chan *workers.Task, 10),
+		ErrorChannel:      make(chan *workers.Task, 10),
+		FatalErrorChannel: make(chan *workers.Task, 10),
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	topic := "metrics_test_topic"
+	worker := metricsTestWorker(topic)
+	worker.AddToInProcessList(11)
+	worker.AddToInProcessList(12)
+	worker.ProcessChannel <- &workers.Task{}
+	worker.PriorityChannel <- &workers.Task{}
+
+	metrics := workers.MetricsFor(topic)
+	assert.Same(t, metrics, workers.MetricsFor(topic))
+	metrics.RecordSuccess(1000, 3*time.Second)
+	metrics.RecordSuccess(500, 90*time.Second)
+	metrics.RecordError(2 * time.Second)
+	metrics.RecordFatalError(24 * time.Hour)
+	metrics.RecordDeferred()
+
+	buf := &bytes.Buffer{}
+	workers.WriteMetrics(buf, []*workers.Base{worker})
+	text := buf.String()
+	expected := []string{
+		"# TYPE aptrust_worker_items_in_process gauge",
+		`aptrust_worker_items_in_process{topic="metrics_test_topic"} 2`,
+		`aptrust_worker_channel_depth{topic="metrics_test_topic",channel="process"} 1`,
+		`aptrust_worker_channel_depth{topic="metrics_test_topic",channel="priority"} 1`,
+		`aptrust_worker_channel_depth{topic="metrics_test_topic",channel="fatal"} 0`,
+		`aptrust_worker_items_total{topic="metrics_test_topic",outcome="success"} 2`,
+		`aptrust_worker_items_total{topic="metrics_test_topic",outcome="error"} 1`,
+		`aptrust_worker_items_total{topic="metrics_test_topic",outcome="fatal"} 1`,
+		`aptrust_worker_bytes_total{topic="metrics_test_topic"} 1500`,
+		`aptrust_worker_items_deferred_total{topic="metrics_test_topic"} 1`,
+		"# TYPE aptrust_worker_run_time_seconds histogram",
+		`aptrust_worker_run_time_seconds_bucket{topic="metrics_test_topic",le="1"} 0`,
+		`aptrust_worker_run_time_seconds_bucket{topic="metrics_test_topic",le="5"} 2`,
+		`aptrust_worker_run_time_seconds_bucket{topic="metrics_test_topic",le="300"} 3`,
+		`aptrust_worker_run_time_seconds_bucket{topic="metrics_test_topic",le="43200"} 3`,
+		`aptrust_worker_run_time_seconds_bucket{topic="metrics_test_topic",le="+Inf"} 4`,
+		`aptrust_worker_run_time_seconds_sum{topic="metrics_test_topic"} 86495`,
+		`aptrust_worker_run_time_seconds_count{topic="metrics_test_topic"} 4`,
+	}
+	for _, line := range expected {
+		assert.Contains(t, text, line+"\n")
+	}
+}
+
+func TestHealthServer(t *testing.T) {
+	context := &common.Context{
+		Config: common.NewConfig(),
+		Logger: logger.DiscardLogger("health_server_test"),
+	}
+	server := workers.NewHealthServer(context, 8999)
+	server.Register(metricsTestWorker("health_test_topic"))
+	require.Equal(t, 1, len(server.Workers()))
+	assert.Equal(t, "127.0.0.1:8999", server.Server.Addr)
+
+	recorder := httptest.NewRecorder()
+	server.Server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), `"health_test_topic"`)
+
+	recorder = httptest.NewRecorder()
+	server.Server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+	assert.Contains(t, recorder.Body.String(), `aptrust_worker_items_in_process{topic="health_test_topic"} 0`)
+}
+
+func TestHealthServerAddress(t *testing.T) {
+	context := &common.Context{
+		Config: common.NewConfig(),
+		Logger: logger.DiscardLogger("health_server_test"),
+	}
+	context.Config.HealthServerAddress = "0.0.0.0"
+	server := workers.NewHealthServer(context, 8999)
+	assert.Equal(t, "0.0.0.0:8999", server.Server.Addr)
+}
+
+func TestHealthServerReadiness(t *testing.T) {
+	var registryCalls atomic.Int32
+	registryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryCalls.Add(1)
+		http.Error(w, "database registry-db.internal:5432 is down", http.StatusInternalServerError)
+	}))
+	defer registryServer.Close()
+	fakeRedis, err := testutil.NewFakeRedis()
+	require.Nil(t, err)
+	defer fakeRedis.Close()
+
+	log := logger.DiscardLogger("health_server_test")
+	registryClient, err := network.NewRegistryClient(registryServer.URL, "v3", "user", "key", constants.AdminAPIPrefix, log)
+	require.Nil(t, err)
+	context := &common.Context{
+		Config:         common.NewConfig(),
+		Logger:         log,
+		RedisClient:    network.NewRedisClient(fakeRedis.Addr(), "", 0),
+		RegistryClient: registryClient,
+		S3Clients:      map[string]*minio.Client{},
+	}
+	context.Config.QueueBackend = queue.BackendMemory
+	server := workers.NewHealthServer(context, 8999)
+	server.Register(metricsTestWorker("health_test_topic"))
+
+	recorder := httptest.NewRecorder()
+	server.Server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	checks := make(map[string]string)
+	require.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &checks))
+	assert.Equal(t, map[string]string{
+		"registry": "unavailable",
+		"redis":    "ok",
+		"nsq":      "ok",
+		"s3":       "unavailable",
+		"worker":   "ok",
+	}, checks)
+
+	// The response doesn't say why Registry is down.
+	assert.NotContains(t, recorder.Body.String(), "registry-db")
+
+	// Probes within ReadinessCacheTime reuse the last check.
+	calls := registryCalls.Load()
+	assert.True(t, calls > 0)
+	checks = server.CheckReadiness()
+	assert.Equal(t, calls, registryCalls.Load())
+	assert.Equal(t, "unavailable", checks["registry"])
+}
+
+// Regression test: only the S3 check had a timeout, so a hung Registry
+// held up every readiness probe.
+func TestHealthServerReadinessTimeout(t *testing.T) {
+	release := make(chan struct{})
+	registryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer registryServer.Close()
+	defer close(release)
+	fakeRedis, err := testutil.NewFakeRedis()
+	require.Nil(t, err)
+	defer fakeRedis.Close()
+
+	log := logger.DiscardLogger("health_server_test")
+	registryClient, err := network.NewRegistryClient(registryServer.URL, "v3", "user", "key", constants.AdminAPIPrefix, log)
+	require.Nil(t, err)
+	context := &common.Context{
+		Config:         common.NewConfig(),
+		Logger:         log,
+		RedisClient:    network.NewRedisClient(fakeRedis.Addr(), "", 0),
+		RegistryClient: registryClient,
+		S3Clients:      map[string]*minio.Client{},
+	}
+	context.Config.QueueBackend = queue.BackendMemory
+	server := workers.NewHealthServer(context, 8999)
+	server.CheckTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	checks := server.CheckReadiness()
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, "unavailable", checks["registry"])
+	assert.Equal(t, "ok", checks["redis"])
+}
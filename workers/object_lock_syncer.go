@@ -0,0 +1,201 @@
+package workers
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/deletion"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+)
+
+// defaultObjectLockSyncInterval is used when
+// Config.ObjectLockSyncInterval is not set.
+const defaultObjectLockSyncInterval = 60 * time.Minute
+
+// ObjectLockSyncer keeps S3 Object Lock on preservation copies in step
+// with the legal holds and retention settings in Registry. When an
+// admin places or lifts a hold, or changes an object's RetainUntil
+// date or an institution's RetentionDays, this applies the change to
+// every preservation copy of the affected objects. See
+// deletion.ApplyObjectLock.
+//
+// This relies on Registry updating an object's or institution's
+// updated_at when its hold or retention settings change. Objects whose
+// settings couldn't be applied are recorded in Redis and retried on
+// every scan until they succeed.
+type ObjectLockSyncer struct {
+	Context *common.Context
+}
+
+// NewObjectLockSyncer creates a new ObjectLockSyncer.
+//
+// This relies on these config settings:
+//
+// ObjectLockRetentionDays must be greater than zero, meaning the
+// preservation buckets have Object Lock enabled. Otherwise, this
+// does nothing.
+//
+// ObjectLockSyncInterval specifies how often this should look for
+// changed holds and retention settings. Each scan looks back two
+// intervals, so a scan that fails or runs late doesn't miss changes.
+// Objects that fail are retried until they succeed, however old the
+// change.
+func NewObjectLockSyncer() *ObjectLockSyncer {
+	return &ObjectLockSyncer{
+		Context: common.NewContext(),
+	}
+}
+
+func (s *ObjectLockSyncer) logStartup() {
+	s.Context.Logger.Info("Starting with config settings:")
+	s.Context.Logger.Info(s.Context.Config.ToJSON())
+	s.Context.Logger.Infof("Scan interval: %s", s.interval().String())
+}
+
+func (s *ObjectLockSyncer) RunOnce() {
+	s.logStartup()
+	s.run()
+}
+
+func (s *ObjectLockSyncer) RunAsService() {
+	s.logStartup()
+	for {
+		s.run()
+		time.Sleep(s.interval())
+	}
+}
+
+func (s *ObjectLockSyncer) interval() time.Duration {
+	if s.Context.Config.ObjectLockSyncInterval > 0 {
+		return s.Context.Config.ObjectLockSyncInterval
+	}
+	return defaultObjectLockSyncInterval
+}
+
+// run applies Object Lock settings to all objects of institutions
+// whose settings changed since the last scan, to all objects whose
+// own settings changed, and to all objects that failed before.
+func (s *ObjectLockSyncer) run() {
+	if s.Context.Config.ObjectLockRetentionDays <= 0 {
+		s.Context.Logger.Info("OBJECT_LOCK_RETENTION_DAYS is zero, so preservation copies are not locked. Nothing to do.")
+		return
+	}
+	since := time.Now().UTC().Add(-2 * s.interval())
+	institutions, err := s.loadInstitutions()
+	if err != nil {
+		s.Context.Logger.Errorf("Error getting institutions from Registry: %v", err)
+		return
+	}
+	synced := make(map[int64]bool)
+	s.retryFailed(institutions, synced)
+	for _, inst := range institutions {
+		if inst.UpdatedAt.Before(since) {
+			continue
+		}
+		params := url.Values{}
+		params.Set("institution_id", strconv.FormatInt(inst.ID, 10))
+		s.syncObjects(params, institutions, synced)
+	}
+	params := url.Values{}
+	params.Set("updated_at__gteq", since.Format(time.RFC3339))
+	s.syncObjects(params, institutions, synced)
+	s.Context.Logger.Infof("Applied Object Lock settings to %d objects changed since %s", len(synced), since.Format(time.RFC3339))
+}
+
+// syncObjects applies Object Lock settings to each object matching
+// params that isn't already in synced.
+func (s *ObjectLockSyncer) syncObjects(params url.Values, institutions map[int64]*registry.Institution, synced map[int64]bool) {
+	params.Set("page", "1")
+	params.Set("per_page", "100")
+	for {
+		resp := s.Context.RegistryClient.IntellectualObjectList(params)
+		if resp.Error != nil {
+			s.Context.Logger.Errorf("Error getting objects from Registry: %v", resp.Error)
+			return
+		}
+		for _, obj := range resp.IntellectualObjects() {
+			if synced[obj.ID] || obj.State == constants.StateDeleted {
+				continue
+			}
+			s.syncObject(obj, institutions, synced)
+		}
+		if !resp.HasNextPage() {
+			break
+		}
+		params = resp.ParamsForNextPage()
+	}
+}
+
+// retryFailed applies Object Lock settings to each object that failed
+// in an earlier scan.
+func (s *ObjectLockSyncer) retryFailed(institutions map[int64]*registry.Institution, synced map[int64]bool) {
+	ids, err := s.Context.RedisClient.ObjectLockRetryList()
+	if err != nil {
+		s.Context.Logger.Errorf("Error getting objects to retry from Redis: %v", err)
+		return
+	}
+	for _, id := range ids {
+		resp := s.Context.RegistryClient.IntellectualObjectByID(id)
+		if resp.ObjectNotFound() {
+			s.clearRetry(id)
+			continue
+		}
+		if resp.Error != nil {
+			s.Context.Logger.Errorf("Error getting object %d from Registry: %v", id, resp.Error)
+			continue
+		}
+		obj := resp.IntellectualObject()
+		if obj.State == constants.StateDeleted {
+			s.clearRetry(id)
+			continue
+		}
+		s.syncObject(obj, institutions, synced)
+	}
+}
+
+// syncObject applies Object Lock settings to obj. If that fails, it
+// records obj in Redis so later scans retry it.
+func (s *ObjectLockSyncer) syncObject(obj *registry.IntellectualObject, institutions map[int64]*registry.Institution, synced map[int64]bool) {
+	count, err := deletion.ApplyObjectLock(s.Context, institutions[obj.InstitutionID], obj)
+	if err != nil {
+		s.Context.Logger.Errorf("Error applying Object Lock to %s: %v", obj.Identifier, err)
+		if err = s.Context.RedisClient.ObjectLockRetrySave(obj.ID, err.Error()); err != nil {
+			s.Context.Logger.Errorf("Error saving %s for retry: %v", obj.Identifier, err)
+		}
+		return
+	}
+	synced[obj.ID] = true
+	s.clearRetry(obj.ID)
+	s.Context.Logger.Infof("Applied Object Lock settings to %d copies of %s", count, obj.Identifier)
+}
+
+func (s *ObjectLockSyncer) clearRetry(objectID int64) {
+	if err := s.Context.RedisClient.ObjectLockRetryDelete(objectID); err != nil {
+		s.Context.Logger.Errorf("Error clearing retry of object %d: %v", objectID, err)
+	}
+}
+
+// loadInstitutions returns all institutions, keyed by ID.
+func (s *ObjectLockSyncer) loadInstitutions() (map[int64]*registry.Institution, error) {
+	institutions := make(map[int64]*registry.Institution)
+	params := url.Values{}
+	params.Set("page", "1")
+	params.Set("per_page", "100")
+	for {
+		resp := s.Context.RegistryClient.InstitutionList(params)
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		for _, inst := range resp.Institutions() {
+			institutions[inst.ID] = inst
+		}
+		if !resp.HasNextPage() {
+			break
+		}
+		params = resp.ParamsForNextPage()
+	}
+	return institutions, nil
+}
@@ -0,0 +1,87 @@
+package workers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/network"
+	"github.com/APTrust/preservation-services/util/testutil"
+	"github.com/APTrust/preservation-services/workers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockStandIn serves one object with no files. The object shows up in
+// the list of changed objects only while changed is true.
+type lockStandIn struct {
+	mutex   sync.Mutex
+	obj     *registry.IntellectualObject
+	changed bool
+}
+
+func (s *lockStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	var results interface{}
+	switch {
+	case strings.Contains(r.URL.Path, "/objects/show/"):
+		json.NewEncoder(w).Encode(s.obj)
+		return
+	case strings.HasSuffix(path, "/objects") && s.changed:
+		results = []*registry.IntellectualObject{s.obj}
+	case strings.HasSuffix(path, "/institutions"):
+		results = []*registry.Institution{}
+	case strings.HasSuffix(path, "/objects"), strings.HasSuffix(path, "/files"):
+		results = []interface{}{}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":   1,
+		"next":    nil,
+		"results": results,
+	})
+}
+
+// Regression test: the syncer used to retry a failed object only while
+// its change was within the two-interval lookback, so a failure that
+// outlasted the lookback was never applied.
+func TestObjectLockSyncerRetriesFailures(t *testing.T) {
+	// Without legal_hold, ApplyObjectLock refuses the object.
+	standIn := &lockStandIn{
+		obj:     &registry.IntellectualObject{ID: 11, Identifier: "test.edu/bag11"},
+		changed: true,
+	}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	fakeRedis, err := testutil.NewFakeRedis()
+	require.Nil(t, err)
+	defer func() { fakeRedis.Close() }()
+
+	syncer := &workers.ObjectLockSyncer{Context: registryStandInContext(t, server)}
+	syncer.Context.RedisClient = network.NewRedisClient(fakeRedis.Addr(), "", 0)
+	syncer.Context.Config.ObjectLockRetentionDays = 30
+	syncer.RunOnce()
+	ids, err := syncer.Context.RedisClient.ObjectLockRetryList()
+	require.Nil(t, err)
+	assert.Equal(t, []int64{11}, ids)
+
+	// The change has aged out of the lookback, but the object still
+	// needs its settings.
+	noHold := false
+	standIn.mutex.Lock()
+	standIn.obj.LegalHold = &noHold
+	standIn.changed = false
+	standIn.mutex.Unlock()
+	syncer.RunOnce()
+	ids, err = syncer.Context.RedisClient.ObjectLockRetryList()
+	require.Nil(t, err)
+	assert.Empty(t, ids)
+}
@@ -0,0 +1,248 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/deletion"
+	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+)
+
+// Purger finishes deletions that the Deleter has marked pending
+// deletion. Once a deletion's retention period has passed, it removes
+// the files from preservation storage and marks them deleted. If an
+// admin cancels the deletion WorkItem before then, it puts the files
+// back in active state.
+type Purger struct {
+	Context *common.Context
+}
+
+// NewPurger creates a new Purger.
+//
+// This relies on these config settings:
+//
+// DeletionRetentionDays is the number of days files stay in storage
+// after they're marked pending deletion. The Deleter records the
+// resulting date in WorkItem.PurgeAfter.
+//
+// PurgeInterval specifies how often this should look for deletions
+// to purge or cancel.
+//
+// PurgeStaleAfter is how long a deletion may stay Started before we
+// assume the purger working on it died and purge it again. It
+// defaults to defaultPurgeStaleAfter.
+func NewPurger() *Purger {
+	return &Purger{
+		Context: common.NewContext(),
+	}
+}
+
+// defaultPurgeStaleAfter is used when Config.PurgeStaleAfter is not set.
+const defaultPurgeStaleAfter = 6 * time.Hour
+
+func (p *Purger) logStartup() {
+	p.Context.Logger.Info("Starting with config settings:")
+	p.Context.Logger.Info(p.Context.Config.ToJSON())
+	p.Context.Logger.Infof("Scan interval: %s",
+		p.Context.Config.PurgeInterval.String())
+}
+
+func (p *Purger) RunOnce() {
+	p.logStartup()
+	p.run()
+}
+
+func (p *Purger) RunAsService() {
+	p.logStartup()
+	for {
+		p.run()
+		time.Sleep(p.Context.Config.PurgeInterval)
+	}
+}
+
+// run purges or cancels each deletion WorkItem awaiting purge.
+func (p *Purger) run() {
+	items, err := p.itemsAwaitingPurge()
+	if err != nil {
+		p.Context.Logger.Errorf("Error getting WorkItem list from Registry: %v", err)
+		return
+	}
+	p.Context.Logger.Infof("Found %d deletions awaiting purge", len(items))
+	now := time.Now().UTC()
+	for _, item := range items {
+		switch {
+		case item.Status == constants.StatusCancelled:
+			p.cancel(item)
+		case p.isStale(item, now):
+			// Purging is safe to repeat. Copies deleted on the
+			// earlier attempt are recorded in Redis, and files
+			// already marked deleted are skipped.
+			p.Context.Logger.Warningf("WorkItem %d has been Started on %s (pid %d) since %s. Purging it again.", item.ID, item.Node, item.Pid, item.StageStartedAt.Format(time.RFC3339))
+			p.purge(item)
+		case item.Status != constants.StatusPending:
+			p.Context.Logger.Infof("Skipping WorkItem %d: status is %s", item.ID, item.Status)
+		case now.Before(p.purgeAfter(item)):
+			p.Context.Logger.Infof("Skipping WorkItem %d: not due for purge until %s", item.ID, p.purgeAfter(item).Format(time.RFC3339))
+		default:
+			p.purge(item)
+		}
+	}
+}
+
+// itemsAwaitingPurge returns all deletion WorkItems awaiting purge. We
+// get the whole list before processing any of them, because processing
+// moves items out of the list and would throw off paging.
+func (p *Purger) itemsAwaitingPurge() ([]*registry.WorkItem, error) {
+	items := make([]*registry.WorkItem, 0)
+	params := url.Values{}
+	params.Set("action", constants.ActionDelete)
+	params.Set("stage", constants.StageAwaitingPurge)
+	params.Set("page", "1")
+	params.Set("per_page", "100")
+	for {
+		resp := p.Context.RegistryClient.WorkItemList(params)
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		items = append(items, resp.WorkItems()...)
+		if !resp.HasNextPage() {
+			break
+		}
+		params = resp.ParamsForNextPage()
+	}
+	return items, nil
+}
+
+// purgeAfter returns the time after which item's files may be purged.
+// Older items may not have PurgeAfter, so we work it out from the time
+// the item entered the awaiting purge stage.
+func (p *Purger) purgeAfter(item *registry.WorkItem) time.Time {
+	if item.PurgeAfter != nil {
+		return *item.PurgeAfter
+	}
+	return item.StageStartedAt.AddDate(0, 0, p.Context.Config.DeletionRetentionDays)
+}
+
+// isStale returns true if item has been Started for longer than
+// Config.PurgeStaleAfter, which means the purger that started it most
+// likely crashed or was killed before it could finish.
+func (p *Purger) isStale(item *registry.WorkItem, now time.Time) bool {
+	if item.Status != constants.StatusStarted {
+		return false
+	}
+	staleAfter := p.Context.Config.PurgeStaleAfter
+	if staleAfter <= 0 {
+		staleAfter = defaultPurgeStaleAfter
+	}
+	return now.Sub(item.StageStartedAt) > staleAfter
+}
+
+// purge removes item's files from preservation storage.
+func (p *Purger) purge(item *registry.WorkItem) {
+	if !p.markStarted(item, "Purging files from preservation storage.") {
+		return
+	}
+	manager := newDeletionManager(p.Context, item)
+	err := manager.CheckPolicy()
+	var policyErr *deletion.PolicyError
+	if errors.As(err, &policyErr) {
+		// A legal hold or retention policy now forbids the deletion.
+		// The files stay pending deletion until an admin cancels it
+		// or requeues it once the hold is lifted.
+		item.MarkNoLongerInProgress(constants.StageAwaitingPurge, constants.StatusFailed, policyErr.Error())
+		item.Outcome = "Deletion blocked"
+		item.Retry = false
+		item.NeedsAdminReview = true
+		p.save(item)
+		return
+	}
+	if err != nil {
+		note := fmt.Sprintf("Could not check deletion policy. Will retry in %s. %v", p.Context.Config.PurgeInterval.String(), err)
+		item.MarkNoLongerInProgress(constants.StageAwaitingPurge, constants.StatusPending, note)
+		p.save(item)
+		return
+	}
+	count, processingErrors := manager.Purge()
+	if hasFatalError(processingErrors) {
+		// A copy is still there after repeated deletions. Retrying
+		// on schedule won't help.
+		note := fmt.Sprintf("Purge failed. %s", errorMessages(processingErrors))
+		item.MarkNoLongerInProgress(constants.StageAwaitingPurge, constants.StatusFailed, note)
+		item.Retry = false
+		item.NeedsAdminReview = true
+		p.save(item)
+		return
+	}
+	if len(processingErrors) > 0 {
+		note := fmt.Sprintf("Purge failed. Will retry in %s. %s", p.Context.Config.PurgeInterval.String(), errorMessages(processingErrors))
+		item.MarkNoLongerInProgress(constants.StageAwaitingPurge, constants.StatusPending, note)
+		p.save(item)
+		return
+	}
+	item.MarkNoLongerInProgress(constants.StageResolve, constants.StatusSuccess, deletionCompletedNote(item, manager))
+	item.Outcome = deletionCompletedOutcome(item)
+	item.Retry = false
+	item.NeedsAdminReview = false
+	p.Context.Logger.Infof("WorkItem %d: purged %d files", item.ID, count)
+	p.save(item)
+}
+
+// cancel puts item's files back in active state.
+func (p *Purger) cancel(item *registry.WorkItem) {
+	manager := newDeletionManager(p.Context, item)
+	count, processingErrors := manager.Cancel()
+	if len(processingErrors) > 0 {
+		// Leave the item as it is, so we try again next time.
+		item.Note = fmt.Sprintf("Could not cancel deletion. Will retry in %s. %s", p.Context.Config.PurgeInterval.String(), errorMessages(processingErrors))
+		p.save(item)
+		return
+	}
+	note := fmt.Sprintf("Deletion cancelled before files were purged. %d files restored to active state.", count)
+	item.MarkNoLongerInProgress(constants.StageResolve, constants.StatusCancelled, note)
+	item.Outcome = "Deletion cancelled"
+	item.Retry = false
+	p.Context.Logger.Infof("WorkItem %d: %s", item.ID, note)
+	p.save(item)
+}
+
+// markStarted marks item as in progress, so no other purger picks it
+// up. It returns false if it can't save the item.
+func (p *Purger) markStarted(item *registry.WorkItem, note string) bool {
+	item.MarkInProgress(constants.StageAwaitingPurge, constants.StatusStarted, note)
+	return p.save(item)
+}
+
+func (p *Purger) save(item *registry.WorkItem) bool {
+	resp := p.Context.RegistryClient.WorkItemSave(item)
+	if resp.Error != nil {
+		p.Context.Logger.Errorf("Error saving WorkItem %d: %v", item.ID, resp.Error)
+		return false
+	}
+	return true
+}
+
+// hasFatalError returns true if any of errors is fatal.
+func hasFatalError(errors []*service.ProcessingError) bool {
+	for _, err := range errors {
+		if err.IsFatal {
+			return true
+		}
+	}
+	return false
+}
+
+// errorMessages returns the messages of errors as a single
+// pipe-delimited string.
+func errorMessages(errors []*service.ProcessingError) string {
+	messages := make([]string, len(errors))
+	for i, err := range errors {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, " | ")
+}
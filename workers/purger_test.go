@@ -0,0 +1,215 @@
+package workers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/workers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// purgeStandIn adds the object, institution and file endpoints the
+// deletion manager uses to registryStandIn. Objects have no files left
+// to delete, so a purge only updates Registry.
+type purgeStandIn struct {
+	*registryStandIn
+	objects map[int64]*registry.IntellectualObject
+	deleted []int64
+}
+
+func newPurgeStandIn(objects []*registry.IntellectualObject, items ...*registry.WorkItem) *purgeStandIn {
+	s := &purgeStandIn{
+		registryStandIn: newRegistryStandIn(items...),
+		objects:         make(map[int64]*registry.IntellectualObject),
+	}
+	for _, obj := range objects {
+		s.objects[obj.ID] = obj
+	}
+	return s
+}
+
+func (s *purgeStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.URL.Path, "/items") {
+		s.registryStandIn.ServeHTTP(w, r)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id, _ := strconv.ParseInt(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], 10, 64)
+	switch {
+	case strings.Contains(r.URL.Path, "/objects/show/"):
+		json.NewEncoder(w).Encode(s.objects[id])
+	case strings.Contains(r.URL.Path, "/objects/update/"):
+		obj := &registry.IntellectualObject{}
+		if err := json.NewDecoder(r.Body).Decode(obj); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[id] = obj
+		json.NewEncoder(w).Encode(obj)
+	case strings.Contains(r.URL.Path, "/objects/delete/"):
+		s.deleted = append(s.deleted, id)
+		w.WriteHeader(http.StatusNoContent)
+	case strings.Contains(r.URL.Path, "/institutions/show/"):
+		noHold := false
+		json.NewEncoder(w).Encode(&registry.Institution{ID: id, Identifier: "test.edu", LegalHold: &noHold})
+	case strings.HasSuffix(r.URL.Path, "/files"):
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"count":   0,
+			"next":    nil,
+			"results": []*registry.GenericFile{},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *purgeStandIn) objectState(id int64) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.objects[id].State
+}
+
+func (s *purgeStandIn) deletedObjects() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]int64{}, s.deleted...)
+}
+
+// lastSaved returns the last version of the WorkItem with id that the
+// purger saved, or nil if it saved none.
+func (s *purgeStandIn) lastSaved(id int64) *registry.WorkItem {
+	var last *registry.WorkItem
+	for _, item := range s.savedItems() {
+		if item.ID == id {
+			last = item
+		}
+	}
+	return last
+}
+
+func awaitingPurge(id, objID int64, status string, purgeAfter time.Time) *registry.WorkItem {
+	return &registry.WorkItem{
+		ID:                   id,
+		Action:               constants.ActionDelete,
+		IntellectualObjectID: objID,
+		InstApprover:         "approver@test.edu",
+		ObjectIdentifier:     "test.edu/bag" + strconv.FormatInt(objID, 10),
+		PurgeAfter:           &purgeAfter,
+		Stage:                constants.StageAwaitingPurge,
+		Status:               status,
+		User:                 "requester@test.edu",
+	}
+}
+
+func pendingObject(id int64) *registry.IntellectualObject {
+	noHold := false
+	return &registry.IntellectualObject{
+		ID:            id,
+		Identifier:    "test.edu/bag" + strconv.FormatInt(id, 10),
+		InstitutionID: 1,
+		LegalHold:     &noHold,
+		State:         constants.StatePendingDeletion,
+	}
+}
+
+func runPurger(t *testing.T, standIn *purgeStandIn) {
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	purger := &workers.Purger{Context: registryStandInContext(t, server)}
+	purger.Context.Config.DeletionCertificateBucket = ""
+	purger.Context.Config.PurgeStaleAfter = time.Hour
+	purger.RunOnce()
+}
+
+func TestPurgerPurgesDueItems(t *testing.T) {
+	due := awaitingPurge(1, 11, constants.StatusPending, time.Now().UTC().Add(-time.Minute))
+	standIn := newPurgeStandIn([]*registry.IntellectualObject{pendingObject(11)}, due)
+	runPurger(t, standIn)
+
+	saved := standIn.lastSaved(1)
+	require.NotNil(t, saved)
+	assert.Equal(t, constants.StageResolve, saved.Stage)
+	assert.Equal(t, constants.StatusSuccess, saved.Status)
+	assert.False(t, saved.Retry)
+	assert.Empty(t, saved.Node)
+	assert.Equal(t, []int64{11}, standIn.deletedObjects())
+}
+
+func TestPurgerSkipsItemsNotDue(t *testing.T) {
+	notDue := awaitingPurge(1, 11, constants.StatusPending, time.Now().UTC().Add(24*time.Hour))
+	standIn := newPurgeStandIn([]*registry.IntellectualObject{pendingObject(11)}, notDue)
+	runPurger(t, standIn)
+
+	assert.Empty(t, standIn.savedItems())
+	assert.Empty(t, standIn.deletedObjects())
+	assert.Equal(t, constants.StatePendingDeletion, standIn.objectState(11))
+}
+
+func TestPurgerCancelsItems(t *testing.T) {
+	// Cancelling doesn't wait for the retention period to pass.
+	cancelled := awaitingPurge(1, 11, constants.StatusCancelled, time.Now().UTC().Add(24*time.Hour))
+	standIn := newPurgeStandIn([]*registry.IntellectualObject{pendingObject(11)}, cancelled)
+	runPurger(t, standIn)
+
+	saved := standIn.lastSaved(1)
+	require.NotNil(t, saved)
+	assert.Equal(t, constants.StageResolve, saved.Stage)
+	assert.Equal(t, constants.StatusCancelled, saved.Status)
+	assert.Equal(t, "Deletion cancelled", saved.Outcome)
+	assert.Equal(t, constants.StateActive, standIn.objectState(11))
+	assert.Empty(t, standIn.deletedObjects())
+}
+
+func TestPurgerStopsAtPolicyBlock(t *testing.T) {
+	due := awaitingPurge(1, 11, constants.StatusPending, time.Now().UTC().Add(-time.Minute))
+	obj := pendingObject(11)
+	onHold := true
+	obj.LegalHold = &onHold
+	obj.LegalHoldReason = "litigation"
+	standIn := newPurgeStandIn([]*registry.IntellectualObject{obj}, due)
+	runPurger(t, standIn)
+
+	saved := standIn.lastSaved(1)
+	require.NotNil(t, saved)
+	assert.Equal(t, constants.StageAwaitingPurge, saved.Stage)
+	assert.Equal(t, constants.StatusFailed, saved.Status)
+	assert.Equal(t, "Deletion blocked", saved.Outcome)
+	assert.Contains(t, saved.Note, "litigation")
+	assert.False(t, saved.Retry)
+	assert.True(t, saved.NeedsAdminReview)
+	assert.Empty(t, standIn.deletedObjects())
+}
+
+func TestPurgerReclaimsStaleItems(t *testing.T) {
+	// A purger crashed two hours into purging item 1. Another purger
+	// started item 2 a minute ago and is still working on it.
+	purgeAfter := time.Now().UTC().Add(-24 * time.Hour)
+	stale := awaitingPurge(1, 11, constants.StatusStarted, purgeAfter)
+	stale.Node = "crashed-host"
+	stale.Pid = 1234
+	stale.StageStartedAt = time.Now().UTC().Add(-2 * time.Hour)
+	running := awaitingPurge(2, 12, constants.StatusStarted, purgeAfter)
+	running.Node = "busy-host"
+	running.Pid = 5678
+	running.StageStartedAt = time.Now().UTC().Add(-time.Minute)
+	standIn := newPurgeStandIn([]*registry.IntellectualObject{pendingObject(11), pendingObject(12)}, stale, running)
+	runPurger(t, standIn)
+
+	saved := standIn.lastSaved(1)
+	require.NotNil(t, saved)
+	assert.Equal(t, constants.StageResolve, saved.Stage)
+	assert.Equal(t, constants.StatusSuccess, saved.Status)
+	assert.Equal(t, []int64{11}, standIn.deletedObjects())
+
+	assert.Nil(t, standIn.lastSaved(2))
+	assert.Equal(t, constants.StatePendingDeletion, standIn.objectState(12))
+}
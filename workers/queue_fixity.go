@@ -1,11 +1,13 @@
 package workers
 
 import (
+	"fmt"
 	"net/url"
 	"strconv"
 	"time"
 
 	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/fixity"
 	"github.com/APTrust/preservation-services/models/common"
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/util"
@@ -36,6 +38,9 @@ type QueueFixity struct {
 // to queue per run. In production, this is usually 2500, though
 // it could be set higher when we have the bandwidth and want to
 // clear out backlogs.
+//
+// MaxFixityBytesPerRun specifies the maximum total size of the files
+// queued per run. Zero means no limit.
 func NewQueueFixity(identifier string) *QueueFixity {
 	return &QueueFixity{
 		Context:    common.NewContext(),
@@ -74,61 +79,364 @@ func (q *QueueFixity) run() {
 	}
 }
 
+// fixityStorageOptions are the storage options whose files get
+// regular fixity checks. Glacier-only files are not checked.
+var fixityStorageOptions = []string{
+	constants.StorageStandard,
+	constants.StorageWasabiVA,
+	constants.StorageWasabiOR,
+	constants.StorageWasabiTX,
+}
+
+// priorFailureLookback is how far back we look for failed fixity
+// checks when deciding which files to prioritize.
+const priorFailureLookback = 365 * 24 * time.Hour
+
+// fixityQueryWindow is the widest range of last fixity check dates we
+// ask Registry for in one query. Without a lower bound on that date,
+// Postgres skips the index and scans the whole table. See
+// https://trello.com/c/KlrtsAXo. The unbounded query took around 14
+// seconds in production, and the bounded one took 82 milliseconds.
+const fixityQueryWindow = 30 * 24 * time.Hour
+
+// fixityBacklogWindows is the number of query windows, before the
+// most recent one, that we look through for overdue files before
+// asking Registry whether any files are overdue by more than that.
+// If there are, we walk back as far as the oldest of them. See
+// countOverdue.
+const fixityBacklogWindows = 12
+
+// queueList queues files that are past their fixity interval. It
+// builds one queue per active institution and lets the scheduler
+// pick files from each in turn, so that no one institution can use
+// up the whole run. Within each institution, files that failed a
+// recent fixity check come first, then files that were reingested
+// after their check came due, then everything else in order of
+// last fixity check.
 func (q *QueueFixity) queueList() {
+	hours := q.Context.Config.MaxDaysSinceFixityCheck * 24 * -1
+	sinceWhen := time.Now().Add(time.Duration(hours) * time.Hour).UTC()
+	q.Context.Logger.Infof("Queuing up to %d files (%d bytes) not checked since %s to topic %s", q.Context.Config.MaxFixityItemsPerRun, q.Context.Config.MaxFixityBytesPerRun, sinceWhen.Format(time.RFC3339), constants.TopicFixity)
+
+	queues := make([]*fixity.InstitutionQueue, 0)
+	for _, inst := range q.loadInstitutions() {
+		queues = append(queues, q.institutionQueue(inst, sinceWhen))
+	}
+
+	scheduler := fixity.NewScheduler(q.Context.Config.MaxFixityItemsPerRun, q.Context.Config.MaxFixityBytesPerRun)
+	for _, candidate := range scheduler.Select(queues) {
+		if q.addToNSQ(candidate.GenericFile) {
+			q.Context.Logger.Infof("Queued '%s' for fixity: %s", candidate.GenericFile.Identifier, candidate.Reason)
+		}
+	}
+	q.reportOverdue(queues)
+}
+
+// reportOverdue logs the number of files past their fixity interval
+// for each institution, along with the number queued in this run.
+func (q *QueueFixity) reportOverdue(queues []*fixity.InstitutionQueue) {
+	totalOverdue := 0
+	for _, iq := range queues {
+		if iq.Err() != nil {
+			q.Context.Logger.Errorf("%s: error getting fixity candidates: %v", iq.InstitutionIdentifier, iq.Err())
+		}
+		if iq.Overdue == 0 {
+			continue
+		}
+		totalOverdue += iq.Overdue
+		q.Context.Logger.Warningf("%s: %d files are overdue for fixity. Oldest overdue check is %s. Queued %d files (%d bytes) this run.",
+			iq.InstitutionIdentifier, iq.Overdue, iq.OldestCheck.Format(time.RFC3339), iq.Queued, iq.QueuedBytes)
+	}
+	q.Context.Logger.Infof("%d files are overdue for fixity", totalOverdue)
+}
+
+func (q *QueueFixity) loadInstitutions() []*registry.Institution {
+	institutions := make([]*registry.Institution, 0)
+	params := url.Values{}
+	params.Set("page", "1")
+	params.Set("per_page", "100")
+	for {
+		resp := q.Context.RegistryClient.InstitutionList(params)
+		if resp.Error != nil {
+			q.Context.Logger.Errorf("Error getting institutions from Registry: %v", resp.Error)
+			break
+		}
+		for _, inst := range resp.Institutions() {
+			if inst.State != constants.StateActive {
+				continue
+			}
+			institutions = append(institutions, inst)
+		}
+		if !resp.HasNextPage() {
+			break
+		}
+		params = resp.ParamsForNextPage()
+	}
+	return institutions
+}
+
+// institutionQueue returns a queue of fixity candidates for one
+// institution. Candidate lists are fetched lazily, a page at a time,
+// so institutions with huge backlogs cost no more than small ones.
+func (q *QueueFixity) institutionQueue(inst *registry.Institution, sinceWhen time.Time) *fixity.InstitutionQueue {
+	iq := fixity.NewInstitutionQueue(inst.ID, inst.Identifier)
 	perPage := util.Min(500, q.Context.Config.MaxFixityItemsPerRun)
+	floor := sinceWhen.Add(-time.Duration(fixityBacklogWindows+1) * fixityQueryWindow)
+	oldestCheck := q.countOverdue(iq, inst, floor, sinceWhen)
+	if !oldestCheck.IsZero() && oldestCheck.Before(floor) {
+		floor = oldestCheck
+	}
+
+	// Files that failed fixity in the past year. We don't re-check
+	// these any sooner than other files, but when they are due, they
+	// go first.
+	iq.AddFetcher(q.priorFailureFetcher(inst, sinceWhen, perPage))
+
+	// Files reingested since their fixity check came due. These
+	// are overdue files whose Registry record changed recently.
+	reingestParams := q.dueFileParams(inst, perPage)
+	reingestParams.Set("updated_at__gteq", sinceWhen.Format(time.RFC3339))
+	iq.AddFetcher(q.windowedFetcher(iq, reingestParams, floor, sinceWhen, fixity.ReasonReingested))
+
+	// Everything else that's due, oldest first.
+	iq.AddFetcher(q.windowedFetcher(iq, q.dueFileParams(inst, perPage), floor, sinceWhen, fixity.ReasonOverdue))
+	return iq
+}
+
+// countOverdue records the number of files that are past their fixity
+// interval, and returns the oldest last fixity check among them. It
+// counts one fixityQueryWindow at a time from floor to sinceWhen, so
+// each query stays on the index. Then it counts the files checked
+// before floor in one more query. That query has no lower bound, but
+// it matches only files more than a year overdue, which should be few
+// enough for Postgres to use the index anyway. We log those files
+// separately, because they mean something is wrong.
+//
+// This costs a few small queries per institution, and lets us report
+// on every institution's backlog, even those whose turn in this run
+// is taken up by higher priority files.
+func (q *QueueFixity) countOverdue(iq *fixity.InstitutionQueue, inst *registry.Institution, floor, sinceWhen time.Time) time.Time {
+	var oldestCheck time.Time
+	params := q.dueFileParams(inst, 1)
+	params.Set("last_fixity_check__lteq", floor.Add(-time.Second).Format(time.RFC3339))
+	resp := q.Context.RegistryClient.GenericFileList(params)
+	if resp.Error != nil {
+		q.Context.Logger.Errorf("Error counting overdue files for %s: %v", iq.InstitutionIdentifier, resp.Error)
+	} else if resp.Count > 0 {
+		iq.Overdue += resp.Count
+		if gf := resp.GenericFile(); gf != nil {
+			oldestCheck = gf.LastFixityCheck
+		}
+		q.Context.Logger.Warningf("%s: %d files have not had a fixity check since before %s. Oldest check is %s.",
+			iq.InstitutionIdentifier, resp.Count, floor.Format(time.RFC3339), oldestCheck.Format(time.RFC3339))
+	}
+	for windowStart := floor; windowStart.Before(sinceWhen); windowStart = windowStart.Add(fixityQueryWindow) {
+		windowEnd := windowStart.Add(fixityQueryWindow)
+		if windowEnd.After(sinceWhen) {
+			windowEnd = sinceWhen
+		}
+		params = q.dueFileParams(inst, 1)
+		setFixityWindow(params, windowStart, windowEnd)
+		resp = q.Context.RegistryClient.GenericFileList(params)
+		if resp.Error != nil {
+			q.Context.Logger.Errorf("Error counting overdue files for %s: %v", iq.InstitutionIdentifier, resp.Error)
+			continue
+		}
+		iq.Overdue += resp.Count
+		if gf := resp.GenericFile(); gf != nil && oldestCheck.IsZero() {
+			oldestCheck = gf.LastFixityCheck
+		}
+	}
+	if !oldestCheck.IsZero() {
+		iq.OldestCheck = oldestCheck
+	}
+	return oldestCheck
+}
+
+func (q *QueueFixity) dueFileParams(inst *registry.Institution, perPage int) url.Values {
 	params := url.Values{}
-	itemsAdded := 0
 	params.Set("per_page", strconv.Itoa(perPage))
 	params.Set("page", "1")
-	params.Add("storage_option__in", constants.StorageStandard)
-	params.Add("storage_option__in", constants.StorageWasabiVA)
-	params.Add("storage_option__in", constants.StorageWasabiOR)
-	params.Add("storage_option__in", constants.StorageWasabiTX)
+	params.Set("institution_id", strconv.FormatInt(inst.ID, 10))
+	for _, option := range fixityStorageOptions {
+		params.Add("storage_option__in", option)
+	}
 	params.Add("state", constants.StateActive)
 	params.Set("sort", "last_fixity_check")
+	return params
+}
 
-	hours := q.Context.Config.MaxDaysSinceFixityCheck * 24 * -1
-	sinceWhen := time.Now().Add(time.Duration(hours) * time.Hour).UTC()
-	params.Set("last_fixity_check__lteq", sinceWhen.Format(time.RFC3339))
+// setFixityWindow limits params to files whose last fixity check falls
+// between from and to.
+func setFixityWindow(params url.Values, from, to time.Time) {
+	params.Set("last_fixity_check__gteq", from.Format(time.RFC3339))
+	params.Set("last_fixity_check__lteq", to.Format(time.RFC3339))
+}
 
-	// This seemingly unnecessary filter address the long-standing
-	// slow query problem described in ticket https://trello.com/c/KlrtsAXo
-	//
-	// Unless we put a lower bound in the last fixity check date range,
-	// we miss the index scan and do a full table scan. Without earliestDate,
-	// this query takes around 14 seconds in production. With it, it takes
-	// 82 milliseconds.
-	//
-	// See the comment from Goyal at
-	// https://stackoverflow.com/questions/5203755/why-does-postgresql-perform-sequential-scan-on-indexed-column
-	earliestDate := sinceWhen.Add(-30 * 24 * time.Hour)
-	params.Set("last_fixity_check__gteq", earliestDate.Format(time.RFC3339))
-
-	q.Context.Logger.Infof("Queuing up to %d files not checked since %s to topic %s", q.Context.Config.MaxFixityItemsPerRun, sinceWhen.Format(time.RFC3339), constants.TopicFixity)
-	q.Context.Logger.Infof("Set dates for fixity query %s - %s", earliestDate.Format(time.RFC3339), sinceWhen.Format(time.RFC3339))
+// windowedFetcher returns a fetcher that pages through the files
+// matching params whose last fixity check falls between floor and
+// sinceWhen. It queries one fixityQueryWindow at a time, oldest window
+// first, so each query stays on the index and the longest overdue
+// files come first. It also records the oldest check it sees on iq.
+func (q *QueueFixity) windowedFetcher(iq *fixity.InstitutionQueue, params url.Values, floor, sinceWhen time.Time, reason string) fixity.CandidateFetcher {
+	windowStart := floor
+	var windowParams url.Values
+	return func() ([]*fixity.Candidate, error) {
+		for {
+			if windowParams == nil {
+				if !windowStart.Before(sinceWhen) {
+					return nil, nil
+				}
+				windowEnd := windowStart.Add(fixityQueryWindow)
+				if windowEnd.After(sinceWhen) {
+					windowEnd = sinceWhen
+				}
+				windowParams = url.Values{}
+				for key, values := range params {
+					windowParams[key] = append([]string{}, values...)
+				}
+				setFixityWindow(windowParams, windowStart, windowEnd)
+				windowStart = windowEnd
+			}
+			resp := q.Context.RegistryClient.GenericFileList(windowParams)
+			if resp.Error != nil {
+				return nil, fmt.Errorf("error getting GenericFile list from Registry: %v", resp.Error)
+			}
+			if resp.HasNextPage() {
+				windowParams = resp.ParamsForNextPage()
+			} else {
+				windowParams = nil
+			}
+			files := resp.GenericFiles()
+			if len(files) == 0 {
+				continue
+			}
+			candidates := make([]*fixity.Candidate, 0, len(files))
+			for _, gf := range files {
+				if iq.OldestCheck.IsZero() || gf.LastFixityCheck.Before(iq.OldestCheck) {
+					iq.OldestCheck = gf.LastFixityCheck
+				}
+				if q.awaitingChecksumReview(gf) {
+					continue
+				}
+				candidates = append(candidates, &fixity.Candidate{GenericFile: gf, Reason: reason})
+			}
+			if len(candidates) == 0 {
+				continue
+			}
+			return candidates, nil
+		}
+	}
+}
 
-	for {
-		resp := q.Context.RegistryClient.GenericFileList(params)
-		if resp == nil {
-			q.Context.Logger.Errorf("Registry response is nil for params %v", params)
-			continue
+// priorFailureFetcher returns a fetcher for overdue files that have
+// failed a fixity check within priorFailureLookback. For each page of
+// failed events, it looks up the files in one id__in query. The query
+// doesn't need a fixity date window, because the id filter keeps it
+// on the primary key.
+func (q *QueueFixity) priorFailureFetcher(inst *registry.Institution, sinceWhen time.Time, perPage int) fixity.CandidateFetcher {
+	params := url.Values{}
+	params.Set("per_page", strconv.Itoa(perPage))
+	params.Set("page", "1")
+	params.Set("institution_id", strconv.FormatInt(inst.ID, 10))
+	params.Set("event_type", constants.EventFixityCheck)
+	params.Set("outcome", constants.OutcomeFailure)
+	params.Set("date_time__gteq", time.Now().Add(-priorFailureLookback).UTC().Format(time.RFC3339))
+	done := false
+	return func() ([]*fixity.Candidate, error) {
+		candidates := make([]*fixity.Candidate, 0)
+		for !done && len(candidates) == 0 {
+			resp := q.Context.RegistryClient.PremisEventList(params)
+			if resp.Error != nil {
+				return nil, fmt.Errorf("error getting failed fixity events from Registry: %v", resp.Error)
+			}
+			if resp.HasNextPage() {
+				params = resp.ParamsForNextPage()
+			} else {
+				done = true
+			}
+			files, err := q.failedFiles(inst, resp.PremisEvents(), sinceWhen)
+			if err != nil {
+				return nil, err
+			}
+			for _, gf := range files {
+				candidates = append(candidates, &fixity.Candidate{GenericFile: gf, Reason: fixity.ReasonPriorFailure})
+			}
 		}
-		if resp.Request != nil && resp.Request.URL != nil {
-			q.Context.Logger.Infof("GET %s", resp.Request.URL)
+		return candidates, nil
+	}
+}
+
+// failedFiles returns the active, overdue files that the failed fixity
+// events refer to.
+func (q *QueueFixity) failedFiles(inst *registry.Institution, events []*registry.PremisEvent, sinceWhen time.Time) ([]*registry.GenericFile, error) {
+	wanted := make(map[int64]bool)
+	for _, event := range events {
+		if event.GenericFileID > 0 {
+			wanted[event.GenericFileID] = true
 		}
+	}
+	files := make([]*registry.GenericFile, 0)
+	if len(wanted) == 0 {
+		return files, nil
+	}
+	params := q.dueFileParams(inst, len(wanted))
+	params.Set("last_fixity_check__lteq", sinceWhen.Format(time.RFC3339))
+	for id := range wanted {
+		params.Add("id__in", strconv.FormatInt(id, 10))
+	}
+	for {
+		resp := q.Context.RegistryClient.GenericFileList(params)
 		if resp.Error != nil {
-			q.Context.Logger.Errorf("Error getting GenericFile list from Registry: %s", resp.Error)
+			return nil, fmt.Errorf("error getting GenericFiles with failed fixity checks from Registry: %v", resp.Error)
 		}
 		for _, gf := range resp.GenericFiles() {
-			if q.addToNSQ(gf) {
-				itemsAdded += 1
+			// Check the filters here as well, in case Registry
+			// ignores any of them.
+			if wanted[gf.ID] && gf.State == constants.StateActive &&
+				util.StringListContains(fixityStorageOptions, gf.StorageOption) &&
+				!gf.LastFixityCheck.After(sinceWhen) &&
+				!q.awaitingChecksumReview(gf) {
+				files = append(files, gf)
+				delete(wanted, gf.ID)
 			}
 		}
-		if !resp.HasNextPage() || itemsAdded >= q.Context.Config.MaxFixityItemsPerRun {
+		if !resp.HasNextPage() || len(wanted) == 0 {
 			break
 		}
 		params = resp.ParamsForNextPage()
 	}
+	return files, nil
+}
+
+// awaitingChecksumReview returns true if gf's Registry checksum is
+// suspect and awaiting review by APTrust staff. The fixity checker
+// skips these files without updating their last fixity check, so if
+// we queued them, they would come back in every run. We look up the
+// file's checksums only if Redis has a suspect comparison for it,
+// which is rare.
+func (q *QueueFixity) awaitingChecksumReview(gf *registry.GenericFile) bool {
+	if q.Context.RedisClient == nil {
+		return false
+	}
+	comparison, err := q.Context.RedisClient.FixityComparisonGet(gf.ID)
+	if err != nil || comparison == nil || comparison.Verdict != fixity.VerdictRegistryChecksumSuspect {
+		return false
+	}
+	checksum := gf.GetLatestChecksum(constants.AlgSha256)
+	if checksum == nil {
+		resp := q.Context.RegistryClient.GenericFileByID(gf.ID)
+		if resp.Error != nil || resp.GenericFile() == nil {
+			q.Context.Logger.Errorf("Error getting checksums for %s (%d) from Registry: %v", gf.Identifier, gf.ID, resp.Error)
+			return false
+		}
+		checksum = resp.GenericFile().GetLatestChecksum(constants.AlgSha256)
+	}
+	if checksum == nil || !fixity.AwaitingChecksumReview(q.Context, gf.ID, checksum.Digest) {
+		return false
+	}
+	q.Context.Logger.Infof("Not queuing %s (%d) because its Registry checksum is suspect and awaiting review", gf.Identifier, gf.ID)
+	return true
 }
 
 func (q *QueueFixity) queueOne() {
@@ -141,7 +449,7 @@ func (q *QueueFixity) queueOne() {
 }
 
 func (q *QueueFixity) addToNSQ(gf *registry.GenericFile) bool {
-	err := q.Context.NSQClient.Enqueue(constants.TopicFixity, gf.ID)
+	err := q.Context.Queue.Enqueue(constants.TopicFixity, gf.ID)
 	if err != nil {
 		q.Context.Logger.Errorf("Error sending '%s' (%d) to %s: %v", gf.Identifier, gf.ID, constants.TopicFixity, err)
 		return false
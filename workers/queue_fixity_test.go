@@ -0,0 +1,223 @@
+package workers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/APTrust/preservation-services/constants"
+	"github.com/APTrust/preservation-services/fixity"
+	"github.com/APTrust/preservation-services/models/registry"
+	"github.com/APTrust/preservation-services/models/service"
+	"github.com/APTrust/preservation-services/network/queue"
+	"github.com/APTrust/preservation-services/workers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixityRegistry serves one institution, one failed fixity event and a
+// few files, and records the file list queries QueueFixity makes.
+type fixityRegistry struct {
+	mutex   sync.Mutex
+	files   []*registry.GenericFile
+	queries []url.Values
+}
+
+func (f *fixityRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var results interface{}
+	count := 1
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/institutions"):
+		results = []*registry.Institution{{ID: 1, Identifier: "test.edu", State: constants.StateActive}}
+	case strings.HasSuffix(r.URL.Path, "/events"):
+		results = []*registry.PremisEvent{{GenericFileID: 7, Outcome: constants.OutcomeFailure}}
+	case strings.HasSuffix(r.URL.Path, "/files"):
+		files := f.listFiles(r.URL.Query())
+		results = files
+		count = len(files)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"count":    count,
+		"next":     nil,
+		"previous": nil,
+		"results":  results,
+	})
+	w.Write(data)
+}
+
+// listFiles returns the files matching the id__in filter, if there is
+// one, or the files whose last fixity check is in the requested range.
+func (f *fixityRegistry) listFiles(params url.Values) []*registry.GenericFile {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.queries = append(f.queries, params)
+	files := make([]*registry.GenericFile, 0)
+	if ids := params["id__in"]; len(ids) > 0 {
+		// Return an extra file, to make sure QueueFixity checks the
+		// results against what it asked for.
+		for _, gf := range f.files {
+			if gf.ID == 7 || gf.ID == 8 {
+				files = append(files, gf)
+			}
+		}
+		return files
+	}
+	from, _ := time.Parse(time.RFC3339, params.Get("last_fixity_check__gteq"))
+	to, _ := time.Parse(time.RFC3339, params.Get("last_fixity_check__lteq"))
+	for _, gf := range f.files {
+		if gf.ID != 7 && gf.ID != 8 && !gf.LastFixityCheck.Before(from) && !gf.LastFixityCheck.After(to) {
+			files = append(files, gf)
+		}
+	}
+	return files
+}
+
+func TestQueueFixityBoundsQueries(t *testing.T) {
+	now := time.Now().UTC()
+	file := func(id int64, daysSinceCheck int) *registry.GenericFile {
+		return &registry.GenericFile{
+			ID:              id,
+			Identifier:      fmt.Sprintf("test.edu/bag/file%d", id),
+			State:           constants.StateActive,
+			StorageOption:   constants.StorageStandard,
+			Size:            100,
+			LastFixityCheck: now.Add(-time.Duration(daysSinceCheck) * 24 * time.Hour),
+		}
+	}
+	standIn := &fixityRegistry{
+		files: []*registry.GenericFile{
+			file(7, 100),  // failed a check last year
+			file(8, 100),  // not asked for
+			file(9, 400),  // long overdue
+			file(12, 900), // overdue by more than the backlog windows
+			file(10, 95),  // just overdue
+			file(11, 10),  // not due
+		},
+	}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	context := registryStandInContext(t, server)
+	context.Config.MaxDaysSinceFixityCheck = 90
+	context.Config.MaxFixityItemsPerRun = 10
+	context.Config.MaxFixityBytesPerRun = 0
+	context.Queue = queue.NewMemory()
+	worker := &workers.QueueFixity{Context: context}
+	worker.RunOnce()
+
+	// Every date query has a lower bound, and covers no more than 30
+	// days, so Registry can use its index. The one exception is the
+	// query for files checked before the backlog windows, which
+	// matches only files more than a year overdue.
+	backlogFloor := now.Add(-90 * 24 * time.Hour).Add(-13 * 30 * 24 * time.Hour)
+	standIn.mutex.Lock()
+	for _, params := range standIn.queries {
+		if len(params["id__in"]) > 0 {
+			assert.Equal(t, []string{"7"}, params["id__in"])
+			continue
+		}
+		to, err := time.Parse(time.RFC3339, params.Get("last_fixity_check__lteq"))
+		require.Nil(t, err, "query has no upper bound: %v", params)
+		if params.Get("last_fixity_check__gteq") == "" {
+			assert.True(t, to.Before(backlogFloor), "query has no lower bound: %v", params)
+			continue
+		}
+		from, err := time.Parse(time.RFC3339, params.Get("last_fixity_check__gteq"))
+		require.Nil(t, err)
+		assert.True(t, to.Sub(from) <= 30*24*time.Hour, "query covers %s", to.Sub(from))
+	}
+	standIn.mutex.Unlock()
+
+	// The prior failure goes first, then the oldest check, even when
+	// it's older than the backlog windows. Files that weren't asked
+	// for or aren't due stay out.
+	handler := &deferredHandler{bodies: make(chan string, 10)}
+	consumer, err := context.Queue.Consume(constants.TopicFixity, "test", 1, handler)
+	require.Nil(t, err)
+	defer consumer.Stop()
+	queued := make([]string, 0)
+	for len(queued) < 4 {
+		select {
+		case body := <-handler.bodies:
+			queued = append(queued, body)
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "Timed out waiting for queued files", "got %v", queued)
+		}
+	}
+	assert.Equal(t, []string{"7", "12", "9", "10"}, queued)
+	select {
+	case body := <-handler.bodies:
+		assert.Fail(t, "Queued an extra file", body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Files whose Registry checksum is awaiting review are not queued,
+// because the fixity checker would skip them without updating their
+// last fixity check, and they would come back in every run.
+func TestQueueFixitySkipsSuspectChecksums(t *testing.T) {
+	now := time.Now().UTC()
+	file := func(id int64, digest string) *registry.GenericFile {
+		return &registry.GenericFile{
+			ID:              id,
+			Identifier:      fmt.Sprintf("test.edu/bag/file%d", id),
+			State:           constants.StateActive,
+			StorageOption:   constants.StorageStandard,
+			Size:            100,
+			LastFixityCheck: now.Add(-100 * 24 * time.Hour),
+			Checksums:       []*registry.Checksum{{Algorithm: constants.AlgSha256, Digest: digest, DateTime: now}},
+		}
+	}
+	standIn := &fixityRegistry{
+		files: []*registry.GenericFile{
+			file(9, "suspect-digest"),
+			file(10, "corrected-digest"),
+		},
+	}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	context, closeRedis := deadLetterContext(t)
+	defer closeRedis()
+	context.RegistryClient = registryStandInContext(t, server).RegistryClient
+	context.Config.MaxDaysSinceFixityCheck = 90
+	context.Config.MaxFixityItemsPerRun = 10
+	context.Config.MaxFixityBytesPerRun = 0
+
+	// File 9 is awaiting review. File 10 was reviewed, and staff
+	// corrected its checksum.
+	for _, gf := range standIn.files {
+		require.Nil(t, context.RedisClient.FixityComparisonSave(&service.FixityComparison{
+			GenericFileID:  gf.ID,
+			ExpectedDigest: "suspect-digest",
+			Verdict:        fixity.VerdictRegistryChecksumSuspect,
+		}))
+	}
+	worker := &workers.QueueFixity{Context: context}
+	worker.RunOnce()
+
+	handler := &deferredHandler{bodies: make(chan string, 10)}
+	consumer, err := context.Queue.Consume(constants.TopicFixity, "test", 1, handler)
+	require.Nil(t, err)
+	defer consumer.Stop()
+	select {
+	case body := <-handler.bodies:
+		assert.Equal(t, "10", body)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Timed out waiting for queued file")
+	}
+	select {
+	case body := <-handler.bodies:
+		assert.Fail(t, "Queued a file awaiting checksum review", body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
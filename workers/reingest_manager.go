@@ -16,7 +16,10 @@ type ReingestManager struct {
 
 // NewReingestManager creates a new ReingestManager worker.
 func NewReingestManager(bufSize, numWorkers, maxAttempts int) *ReingestManager {
-	_context := common.NewContext()
+	return newReingestManager(common.NewContext(), bufSize, numWorkers, maxAttempts)
+}
+
+func newReingestManager(_context *common.Context, bufSize, numWorkers, maxAttempts int) *ReingestManager {
 	bufSize, numWorkers, maxAttempts = _context.Config.GetWorkerSettings(constants.IngestReingestCheck, bufSize, numWorkers, maxAttempts)
 	settings := &Settings{
 		ChannelBufferSize:                         bufSize,
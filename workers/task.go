@@ -6,7 +6,7 @@ import (
 	"github.com/APTrust/preservation-services/ingest"
 	"github.com/APTrust/preservation-services/models/registry"
 	"github.com/APTrust/preservation-services/models/service"
-	"github.com/nsqio/go-nsq"
+	"github.com/APTrust/preservation-services/network/queue"
 )
 
 // Task encapsulates everything that a worker will need to
@@ -30,8 +30,9 @@ type Task struct {
 	// should not be pushed into any NSQ topic.
 	NextQueueTopic string
 
-	// NSQMessage is the NSQ message the worker is processing.
-	NSQMessage *nsq.Message
+	// NSQMessage is the queue message the worker is processing. Despite
+	// the name, it may come from NSQ or from the in-process queue.
+	NSQMessage queue.Message
 
 	// Processor is handles whatever phase of the ingest process
 	// this worker is responsible for (validation, storage, recording, etc.)
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/APTrust/preservation-services/models/common"
+	"github.com/APTrust/preservation-services/network/queue"
 	"github.com/APTrust/preservation-services/workers"
 	"github.com/nsqio/go-nsq"
 	"github.com/stretchr/testify/assert"
@@ -29,11 +30,11 @@ type TaskTester struct {
 func (tester *TaskTester) HandleMessage(message *nsq.Message) error {
 	workItemId, _ := strconv.Atoi(string(message.Body))
 	task := &workers.Task{
-		NSQMessage: message,
+		NSQMessage: queue.NewNSQMessage(message),
 	}
 	task.NSQStart()
 	if workItemId == 1111 {
-		assert.True(tester.T, task.NSQMessage.IsAutoResponseDisabled())
+		assert.True(tester.T, message.IsAutoResponseDisabled())
 		assert.True(tester.T, task.StartCalled())
 		assert.False(tester.T, task.TickerStopped())
 		wg.Done()